@@ -30,14 +30,119 @@ var (
 	enableLogConfig           = &configuration{flag: "enable-logging", envFlag: "enable_logging", defaultValue: "true"}
 	regionConfig              = &configuration{flag: "region", envFlag: "region", defaultValue: "us-east-1"}
 	maxRetriesConfig          = &configuration{flag: "max-retries", envFlag: "max_retries", defaultValue: strconv.Itoa(awsClient.DefaultRetryerMaxNumRetries)}
+	retryBaseDelayConfig      = &configuration{flag: "retry-base-delay", envFlag: "retry_base_delay", defaultValue: "0s"}
+	retryMaxBackoffConfig     = &configuration{flag: "retry-max-backoff", envFlag: "retry_max_backoff", defaultValue: "0s"}
 	defaultDatabaseConfig     = &configuration{flag: "default-database", envFlag: "default_database", defaultValue: ""}
 	defaultTableConfig        = &configuration{flag: "default-table", envFlag: "default_table", defaultValue: ""}
 	listenAddrConfig          = &configuration{flag: "web.listen-address", envFlag: "", defaultValue: ":9201"}
 	telemetryPathConfig       = &configuration{flag: "web.telemetry-path", envFlag: "", defaultValue: "/metrics"}
 	failOnLabelConfig         = &configuration{flag: "fail-on-long-label", envFlag: "fail_on_long_label", defaultValue: "false"}
 	failOnInvalidSampleConfig = &configuration{flag: "fail-on-invalid-sample-value", envFlag: "fail_on_invalid_sample_value", defaultValue: "false"}
+	retryOnAuthErrorConfig    = &configuration{flag: "retry-on-auth-error", envFlag: "retry_on_auth_error", defaultValue: "true"}
 	promlogLevelConfig        = &configuration{flag: "log.level", envFlag: "log_level", defaultValue: "info"}
 	promlogFormatConfig       = &configuration{flag: "log.format", envFlag: "log_format", defaultValue: "logfmt"}
-	certificateConfig         = &configuration{flag: "tls-certificate", envFlag: "", defaultValue: ""}
-	keyConfig                 = &configuration{flag: "tls-key", envFlag: "", defaultValue: ""}
+	logRequestIDConfig        = &configuration{flag: "log-request-id", envFlag: "log_request_id", defaultValue: "false"}
+	cloudWatchMetricsConfig   = &configuration{flag: "cloudwatch-metrics", envFlag: "cloudwatch_metrics", defaultValue: "false"}
+	certificateConfig         = &configuration{flag: "tls-certificate", envFlag: "tls_certificate", defaultValue: ""}
+	keyConfig                 = &configuration{flag: "tls-key", envFlag: "tls_key", defaultValue: ""}
+	maxSamplesPerSeriesConfig = &configuration{flag: "max-samples-per-series", envFlag: "max_samples_per_series", defaultValue: "0"}
+	cardinalityTrackingConfig = &configuration{flag: "cardinality-tracking", envFlag: "cardinality_tracking", defaultValue: "0"}
+	maxIngestRateConfig       = &configuration{flag: "max-ingest-rate", envFlag: "max_ingest_rate", defaultValue: "0"}
+	writeConcurrencyConfig    = &configuration{flag: "write-concurrency", envFlag: "write_concurrency", defaultValue: "1"}
+	missingDestinationConfig  = &configuration{flag: "missing-destination-status", envFlag: "missing_destination_status", defaultValue: "400"}
+	dimensionOnlyReadsConfig  = &configuration{flag: "dimension-only-reads", envFlag: "dimension_only_reads", defaultValue: "allow"}
+	lambdaDimensionsConfig    = &configuration{flag: "", envFlag: "lambda_context_dimensions", defaultValue: ""}
+	lambdaReadEncodingConfig  = &configuration{flag: "", envFlag: "lambda_read_encoding", defaultValue: "snappy"}
+	credentialRetriesConfig   = &configuration{flag: "", envFlag: "lambda_credential_retries", defaultValue: "0"}
+	readTablesConfig          = &configuration{flag: "read-tables", envFlag: "read_tables", defaultValue: ""}
+	readDatabasesConfig       = &configuration{flag: "read-databases", envFlag: "read_databases", defaultValue: ""}
+	crossDatabaseReadsConfig  = &configuration{flag: "cross-database-reads", envFlag: "cross_database_reads", defaultValue: "false"}
+	dumpRecordsFileConfig     = &configuration{flag: "dump-records-file", envFlag: "dump_records_file", defaultValue: ""}
+	deadLetterDirConfig       = &configuration{flag: "dead-letter-dir", envFlag: "dead_letter_dir", defaultValue: ""}
+	defaultMeasureNameConfig  = &configuration{flag: "default-measure-name", envFlag: "default_measure_name", defaultValue: ""}
+	normalizeNamesConfig      = &configuration{flag: "normalize-measure-names", envFlag: "normalize_measure_names", defaultValue: "false"}
+	numericQuantilesConfig    = &configuration{flag: "numeric-quantiles", envFlag: "numeric_quantiles", defaultValue: "false"}
+	emitSampleCountConfig     = &configuration{flag: "emit-sample-count", envFlag: "emit_sample_count", defaultValue: "false"}
+	emitSchemaVersionConfig   = &configuration{flag: "emit-schema-version", envFlag: "emit_schema_version", defaultValue: "false"}
+	rejectEmptyWritesConfig   = &configuration{flag: "reject-empty-writes", envFlag: "reject_empty_writes", defaultValue: "false"}
+	clampTimestampsConfig     = &configuration{flag: "clamp-timestamps", envFlag: "clamp_timestamps", defaultValue: "false"}
+	multiMeasureConfig        = &configuration{flag: "enable-multi-measure", envFlag: "enable_multi_measure", defaultValue: "false"}
+	autoCreateConfig          = &configuration{flag: "auto-create-destinations", envFlag: "auto_create_destinations", defaultValue: "false"}
+	memoryRetentionConfig     = &configuration{flag: "memory-store-retention", envFlag: "memory_store_retention", defaultValue: "0s"}
+	magneticRetentionConfig   = &configuration{flag: "magnetic-store-retention", envFlag: "magnetic_store_retention", defaultValue: "0s"}
+	magneticTimeoutConfig     = &configuration{flag: "magnetic-read-timeout", envFlag: "magnetic_read_timeout", defaultValue: "0s"}
+	readTotalDeadlineConfig   = &configuration{flag: "read-total-deadline", envFlag: "read_total_deadline", defaultValue: "0s"}
+	maxReadRangeConfig        = &configuration{flag: "max-read-range", envFlag: "max_read_range", defaultValue: "0s"}
+	maxReadCostConfig         = &configuration{flag: "max-read-cost", envFlag: "max_read_cost", defaultValue: "0"}
+	defaultLookbackConfig     = &configuration{flag: "default-lookback", envFlag: "default_lookback", defaultValue: "0s"}
+	preferRecentConfig        = &configuration{flag: "prefer-recent", envFlag: "prefer_recent", defaultValue: "false"}
+	readPageSizeConfig        = &configuration{flag: "read-page-size", envFlag: "read_page_size", defaultValue: "0"}
+	schemaLagRetriesConfig    = &configuration{flag: "schema-lag-retries", envFlag: "schema_lag_retries", defaultValue: "0"}
+	caseInsensitiveConfig     = &configuration{flag: "case-insensitive-matchers", envFlag: "case_insensitive_matchers", defaultValue: "false"}
+	partialReadsConfig        = &configuration{flag: "return-partial-reads", envFlag: "return_partial_reads", defaultValue: "false"}
+	requireMatcherConfig      = &configuration{flag: "require-matcher", envFlag: "require_matcher", defaultValue: "false"}
+	combineReadQueriesConfig  = &configuration{flag: "combine-read-queries", envFlag: "combine_read_queries", defaultValue: "false"}
+	readDebugColumnsConfig    = &configuration{flag: "read-debug-columns", envFlag: "read_debug_columns", defaultValue: "false"}
+	nonFiniteReadsConfig      = &configuration{flag: "read-non-finite-values", envFlag: "read_non_finite_values", defaultValue: "pass"}
+	timestampColumnsConfig    = &configuration{flag: "read-timestamp-columns", envFlag: "read_timestamp_columns", defaultValue: "raw"}
+	enableAdminConfig         = &configuration{flag: "web.enable-admin", envFlag: "", defaultValue: "false"}
+	enableOpenMetricsConfig   = &configuration{flag: "web.enable-openmetrics", envFlag: "", defaultValue: "false"}
+	maxConcurrencyConfig      = &configuration{flag: "max-timestream-concurrency", envFlag: "", defaultValue: "0"}
+	maxRPSConfig              = &configuration{flag: "timestream-max-rps", envFlag: "", defaultValue: "0"}
+	maxInFlightBytesConfig    = &configuration{flag: "max-in-flight-bytes", envFlag: "", defaultValue: "0"}
+	readHandlerTimeoutConfig  = &configuration{flag: "read-handler-timeout", envFlag: "", defaultValue: "0s"}
+	writeBatchWindowConfig    = &configuration{flag: "write-batch-window", envFlag: "", defaultValue: "0s"}
+	healthErrorRatioConfig    = &configuration{flag: "health-max-error-ratio", envFlag: "", defaultValue: "0"}
+	warmupConnectionsConfig   = &configuration{flag: "warmup-connections", envFlag: "", defaultValue: "0"}
+	mirrorWriteURLConfig      = &configuration{flag: "mirror-write-url", envFlag: "", defaultValue: ""}
+	expectedMemoryConfig      = &configuration{flag: "expected-memory-retention", envFlag: "", defaultValue: "0s"}
+	expectedMagneticConfig    = &configuration{flag: "expected-magnetic-retention", envFlag: "", defaultValue: "0s"}
+	reservedLabelsConfig      = &configuration{flag: "reserved-label-names", envFlag: "reserved_label_names", defaultValue: "rename"}
+	auditLogConfig            = &configuration{flag: "audit-log", envFlag: "audit_log", defaultValue: ""}
+	recordVersionConfig       = &configuration{flag: "record-version-strategy", envFlag: "record_version_strategy", defaultValue: "none"}
+	orderedSamplesConfig      = &configuration{flag: "require-ordered-samples", envFlag: "require_ordered_samples", defaultValue: "off"}
+	conflictingRecordsConfig  = &configuration{flag: "conflicting-records", envFlag: "conflicting_records", defaultValue: "off"}
+	duplicateSamplesConfig    = &configuration{flag: "duplicate-samples", envFlag: "duplicate_samples", defaultValue: "off"}
+	instanceIDConfig          = &configuration{flag: "instance-id", envFlag: "instance_id", defaultValue: ""}
+	requiredDimensionsConfig  = &configuration{flag: "required-dimensions", envFlag: "required_dimensions", defaultValue: ""}
+	perMetricStatsConfig      = &configuration{flag: "per-metric-stats", envFlag: "per_metric_stats", defaultValue: ""}
+	missingDimensionsConfig   = &configuration{flag: "missing-dimensions", envFlag: "missing_dimensions", defaultValue: "ignore"}
+	validateLabelsConfig      = &configuration{flag: "validate-label-names", envFlag: "validate_label_names", defaultValue: "off"}
+	expandJSONLabelConfig     = &configuration{flag: "expand-json-label", envFlag: "expand_json_label", defaultValue: ""}
+	infBucketValueConfig      = &configuration{flag: "inf-bucket-value", envFlag: "inf_bucket_value", defaultValue: ""}
+	databaseLabelConfig       = &configuration{flag: "database-label", envFlag: "database_label", defaultValue: ""}
+	tableLabelConfig          = &configuration{flag: "table-label", envFlag: "table_label", defaultValue: ""}
+	stripNamespaceConfig      = &configuration{flag: "measure-name-namespace-strip", envFlag: "measure_name_namespace_strip", defaultValue: ""}
+	addNamespaceConfig        = &configuration{flag: "measure-name-namespace-add", envFlag: "measure_name_namespace_add", defaultValue: ""}
+	booleanMetricsConfig      = &configuration{flag: "boolean-metrics", envFlag: "boolean_metrics", defaultValue: ""}
+	measureValueTypeConfig    = &configuration{flag: "measure-value-type", envFlag: "measure_value_type", defaultValue: "double"}
+	credentialProviderConfig  = &configuration{flag: "credential-provider", envFlag: "credential_provider", defaultValue: ""}
+	writeRoleARNsConfig       = &configuration{flag: "write-role-arns", envFlag: "write_role_arns", defaultValue: ""}
+	costTagsConfig            = &configuration{flag: "cost-tags", envFlag: "cost_tags", defaultValue: ""}
+	rollupTableConfig         = &configuration{flag: "rollup-table", envFlag: "", defaultValue: ""}
+	rollupWindowConfig        = &configuration{flag: "rollup-window", envFlag: "", defaultValue: "1m"}
+	awsTLSMinVersionConfig    = &configuration{flag: "aws-tls-min-version", envFlag: "aws_tls_min_version", defaultValue: ""}
 )
+
+// lambdaConfigurations are the options read from the environment variables on AWS Lambda, which identify the
+// configuration of the cached connector state reused by the warm invocations.
+var lambdaConfigurations = []*configuration{
+	enableLogConfig, regionConfig, maxRetriesConfig, retryBaseDelayConfig, retryMaxBackoffConfig,
+	defaultDatabaseConfig, defaultTableConfig, failOnLabelConfig, failOnInvalidSampleConfig,
+	retryOnAuthErrorConfig, promlogLevelConfig, promlogFormatConfig, logRequestIDConfig, cloudWatchMetricsConfig,
+	certificateConfig, keyConfig, maxSamplesPerSeriesConfig, cardinalityTrackingConfig, maxIngestRateConfig,
+	writeConcurrencyConfig, missingDestinationConfig, dimensionOnlyReadsConfig, lambdaDimensionsConfig,
+	lambdaReadEncodingConfig, credentialRetriesConfig, readTablesConfig, readDatabasesConfig,
+	crossDatabaseReadsConfig, dumpRecordsFileConfig, deadLetterDirConfig, defaultMeasureNameConfig,
+	normalizeNamesConfig, numericQuantilesConfig, emitSampleCountConfig, emitSchemaVersionConfig,
+	rejectEmptyWritesConfig, clampTimestampsConfig, multiMeasureConfig, autoCreateConfig, memoryRetentionConfig,
+	magneticRetentionConfig, magneticTimeoutConfig, readTotalDeadlineConfig, maxReadRangeConfig, maxReadCostConfig,
+	defaultLookbackConfig, preferRecentConfig, readPageSizeConfig, schemaLagRetriesConfig, caseInsensitiveConfig,
+	combineReadQueriesConfig, requireMatcherConfig, partialReadsConfig, readDebugColumnsConfig,
+	nonFiniteReadsConfig, timestampColumnsConfig, reservedLabelsConfig, auditLogConfig, recordVersionConfig,
+	orderedSamplesConfig, conflictingRecordsConfig, duplicateSamplesConfig, instanceIDConfig,
+	requiredDimensionsConfig, perMetricStatsConfig, missingDimensionsConfig, validateLabelsConfig,
+	expandJSONLabelConfig, infBucketValueConfig, databaseLabelConfig, tableLabelConfig, stripNamespaceConfig,
+	addNamespaceConfig, booleanMetricsConfig, measureValueTypeConfig, credentialProviderConfig,
+	writeRoleARNsConfig, costTagsConfig, awsTLSMinVersionConfig,
+}
@@ -18,8 +18,14 @@ import (
 	"fmt"
 	"github.com/prometheus/prometheus/prompb"
 	"net/http"
+	"reflect"
+	"strings"
+	"time"
 )
 
+// connectorErrorPkgPath is the import path of the package defining the connector errors.
+var connectorErrorPkgPath = reflect.TypeOf(baseConnectorError{}).PkgPath()
+
 type baseConnectorError struct {
 	statusCode int
 	errorMsg   string
@@ -38,6 +44,16 @@ func (e *baseConnectorError) Message() string {
 	return e.message
 }
 
+// Type returns the name of the concrete connector error type without the Error suffix, such as MissingDatabase for a
+// MissingDatabaseError. The second return value is false if err is not one of the connector errors.
+func Type(err error) (string, bool) {
+	errorType := reflect.TypeOf(err)
+	if errorType == nil || errorType.Kind() != reflect.Ptr || errorType.Elem().PkgPath() != connectorErrorPkgPath {
+		return "", false
+	}
+	return strings.TrimSuffix(errorType.Elem().Name(), "Error"), true
+}
+
 type MissingDestinationError struct {
 	baseConnectorError
 }
@@ -91,6 +107,19 @@ func NewParseSampleOptionError(failOnInvalidSample string) error {
 	}}
 }
 
+type ParseRetryOnAuthError struct {
+	baseConnectorError
+}
+
+func NewParseRetryOnAuthError(retryOnAuthError string) error {
+	return &ParseRetryOnAuthError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing retry-on-auth-error, expected true or false, but received '%s'", retryOnAuthError),
+		message: "The value specified in the retry-on-auth-error option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
 type ParseRetriesError struct {
 	baseConnectorError
 }
@@ -104,6 +133,409 @@ func NewParseRetriesError(retries string) error {
 	}}
 }
 
+type ParseMaxSamplesPerSeriesError struct {
+	baseConnectorError
+}
+
+func NewParseMaxSamplesPerSeriesError(maxSamplesPerSeries string) error {
+	return &ParseMaxSamplesPerSeriesError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing max-samples-per-series, expected a non-negative integer, but received '%s'", maxSamplesPerSeries),
+		message: "The value specified in the max-samples-per-series option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseReadPageSizeError struct {
+	baseConnectorError
+}
+
+func NewParseReadPageSizeError(readPageSize string) error {
+	return &ParseReadPageSizeError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing read-page-size, expected an integer between 0 and 1000, but received '%s'", readPageSize),
+		message: "The value specified in the read-page-size option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseSchemaLagRetriesError struct {
+	baseConnectorError
+}
+
+func NewParseSchemaLagRetriesError(schemaLagRetries string) error {
+	return &ParseSchemaLagRetriesError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing schema-lag-retries, expected an integer between 0 and 5, but received '%s'", schemaLagRetries),
+		message: "The value specified in the schema-lag-retries option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseCardinalityTrackingError struct {
+	baseConnectorError
+}
+
+func NewParseCardinalityTrackingError(cardinalityTracking string) error {
+	return &ParseCardinalityTrackingError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing cardinality-tracking, expected a non-negative integer, but received '%s'", cardinalityTracking),
+		message: "The value specified in the cardinality-tracking option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseMaxIngestRateError struct {
+	baseConnectorError
+}
+
+func NewParseMaxIngestRateError(maxIngestRate string) error {
+	return &ParseMaxIngestRateError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing max-ingest-rate, expected a non-negative integer, but received '%s'", maxIngestRate),
+		message: "The value specified in the max-ingest-rate option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseWriteConcurrencyError struct {
+	baseConnectorError
+}
+
+func NewParseWriteConcurrencyError(writeConcurrency string) error {
+	return &ParseWriteConcurrencyError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing write-concurrency, expected a positive integer, but received '%s'", writeConcurrency),
+		message: "The value specified in the write-concurrency option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseMaxReadCostError struct {
+	baseConnectorError
+}
+
+func NewParseMaxReadCostError(maxReadCost string) error {
+	return &ParseMaxReadCostError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing max-read-cost, expected a non-negative number, but received '%s'", maxReadCost),
+		message: "The value specified in the max-read-cost option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseMissingDestinationStatusError struct {
+	baseConnectorError
+}
+
+func NewParseMissingDestinationStatusError(missingDestinationStatus string) error {
+	return &ParseMissingDestinationStatusError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing missing-destination-status, expected a 4xx or 5xx HTTP status code, but received '%s'", missingDestinationStatus),
+		message: "The value specified in the missing-destination-status option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseLambdaReadEncodingError struct {
+	baseConnectorError
+}
+
+func NewParseLambdaReadEncodingError(lambdaReadEncoding string) error {
+	return &ParseLambdaReadEncodingError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing lambda_read_encoding, expected snappy or gzip, but received '%s'", lambdaReadEncoding),
+		message: "The value specified in the lambda_read_encoding option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseCredentialRetriesError struct {
+	baseConnectorError
+}
+
+func NewParseCredentialRetriesError(credentialRetries string) error {
+	return &ParseCredentialRetriesError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing lambda_credential_retries, expected an integer between 0 and 5, but received '%s'", credentialRetries),
+		message: "The value specified in the lambda_credential_retries option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseDuplicateSamplesError struct {
+	baseConnectorError
+}
+
+func NewParseDuplicateSamplesError(duplicateSamples string) error {
+	return &ParseDuplicateSamplesError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing duplicate-samples, expected off, first, last, max or error, but received '%s'", duplicateSamples),
+		message: "The value specified in the duplicate-samples option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseDimensionOnlyReadsError struct {
+	baseConnectorError
+}
+
+func NewParseDimensionOnlyReadsError(dimensionOnlyReads string) error {
+	return &ParseDimensionOnlyReadsError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing dimension-only-reads, expected allow, empty or reject, but received '%s'", dimensionOnlyReads),
+		message: "The value specified in the dimension-only-reads option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseNonFiniteReadsError struct {
+	baseConnectorError
+}
+
+func NewParseNonFiniteReadsError(nonFiniteReads string) error {
+	return &ParseNonFiniteReadsError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing read-non-finite-values, expected pass or skip, but received '%s'", nonFiniteReads),
+		message: "The value specified in the read-non-finite-values option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseTimestampColumnsError struct {
+	baseConnectorError
+}
+
+func NewParseTimestampColumnsError(timestampColumns string) error {
+	return &ParseTimestampColumnsError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing read-timestamp-columns, expected raw, unix-ms, rfc3339 or drop, but received '%s'", timestampColumns),
+		message: "The value specified in the read-timestamp-columns option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseReservedLabelsError struct {
+	baseConnectorError
+}
+
+func NewParseReservedLabelsError(reservedLabels string) error {
+	return &ParseReservedLabelsError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing reserved-label-names, expected rename or fail, but received '%s'", reservedLabels),
+		message: "The value specified in the reserved-label-names option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseRecordVersionStrategyError struct {
+	baseConnectorError
+}
+
+func NewParseRecordVersionStrategyError(recordVersionStrategy string) error {
+	return &ParseRecordVersionStrategyError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing record-version-strategy, expected none, timestamp, counter or sample, but received '%s'", recordVersionStrategy),
+		message: "The value specified in the record-version-strategy option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseRequireOrderedSamplesError struct {
+	baseConnectorError
+}
+
+func NewParseRequireOrderedSamplesError(requireOrderedSamples string) error {
+	return &ParseRequireOrderedSamplesError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing require-ordered-samples, expected off, warn or reject, but received '%s'", requireOrderedSamples),
+		message: "The value specified in the require-ordered-samples option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseConflictingRecordsError struct {
+	baseConnectorError
+}
+
+func NewParseConflictingRecordsError(conflictingRecords string) error {
+	return &ParseConflictingRecordsError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing conflicting-records, expected off, first, last or error, but received '%s'", conflictingRecords),
+		message: "The value specified in the conflicting-records option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseMissingDimensionsError struct {
+	baseConnectorError
+}
+
+func NewParseMissingDimensionsError(missingDimensions string) error {
+	return &ParseMissingDimensionsError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing missing-dimensions, expected fail or ignore, but received '%s'", missingDimensions),
+		message: "The value specified in the missing-dimensions option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseValidateLabelNamesError struct {
+	baseConnectorError
+}
+
+func NewParseValidateLabelNamesError(validateLabelNames string) error {
+	return &ParseValidateLabelNamesError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing validate-label-names, expected off, fail, sanitize or ignore, but received '%s'", validateLabelNames),
+		message: "The value specified in the validate-label-names option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseCredentialProviderError struct {
+	baseConnectorError
+}
+
+func NewParseCredentialProviderError(credentialProviders string) error {
+	return &ParseCredentialProviderError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing credential-provider, expected a comma-separated list of env, shared, imds, process or sso, but received '%s'", credentialProviders),
+		message: "The value specified in the credential-provider option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseTLSMinVersionError struct {
+	baseConnectorError
+}
+
+func NewParseTLSMinVersionError(tlsMinVersion string) error {
+	return &ParseTLSMinVersionError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing aws-tls-min-version, expected 1.0, 1.1, 1.2 or 1.3, but received '%s'", tlsMinVersion),
+		message: "The value specified in the aws-tls-min-version option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseWriteRoleARNsError struct {
+	baseConnectorError
+}
+
+func NewParseWriteRoleARNsError(writeRoleARNs string) error {
+	return &ParseWriteRoleARNsError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing write-role-arns, expected a comma-separated list of table=role-arn pairs, but received '%s'", writeRoleARNs),
+		message: "The value specified in the write-role-arns option is not a valid list of table and IAM role ARN pairs. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseCostTagsError struct {
+	baseConnectorError
+}
+
+func NewParseCostTagsError(costTags string) error {
+	return &ParseCostTagsError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing cost-tags, expected a comma-separated list of key=value pairs, but received '%s'", costTags),
+		message: "The value specified in the cost-tags option is not a valid list of tag keys and values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseBoolError struct {
+	baseConnectorError
+}
+
+func NewParseBoolError(option string, value string) error {
+	return &ParseBoolError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing %s, expected true or false, but received '%s'", option, value),
+		message: fmt.Sprintf("The value specified in the %s option is not one of the accepted values. ", option) +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseMeasureNameNamespaceError struct {
+	baseConnectorError
+}
+
+func NewParseMeasureNameNamespaceError(option string, value string) error {
+	return &ParseMeasureNameNamespaceError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing %s, expected a valid metric name prefix, but received '%s'", option, value),
+		message: fmt.Sprintf("The value specified in the %s option is not one of the accepted values. ", option) +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseDurationError struct {
+	baseConnectorError
+}
+
+func NewParseDurationError(option string, duration string) error {
+	return &ParseDurationError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing %s, expected a non-negative duration such as 12h, but received '%s'", option, duration),
+		message: fmt.Sprintf("The value specified in the %s option is not one of the accepted values. ", option) +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseLambdaContextDimensionsError struct {
+	baseConnectorError
+}
+
+func NewParseLambdaContextDimensionsError(lambdaContextDimensions string) error {
+	return &ParseLambdaContextDimensionsError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing lambda_context_dimensions, expected a comma-separated list of aws_request_id, function_name and function_version, but received '%s'", lambdaContextDimensions),
+		message: "The value specified in the lambda_context_dimensions option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseBooleanMetricsError struct {
+	baseConnectorError
+}
+
+func NewParseBooleanMetricsError(booleanMetrics string) error {
+	return &ParseBooleanMetricsError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing boolean-metrics, expected a regular expression, but received '%s'", booleanMetrics),
+		message: "The value specified in the boolean-metrics option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ParseMeasureValueTypeError struct {
+	baseConnectorError
+}
+
+func NewParseMeasureValueTypeError(measureValueType string) error {
+	return &ParseMeasureValueTypeError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while parsing measure-value-type, expected double, bigint or auto, but received '%s'", measureValueType),
+		message: "The value specified in the measure-value-type option is not one of the accepted values. " +
+			acceptedValueErrorMessage,
+	}}
+}
+
+type ConflictingOptionsError struct {
+	baseConnectorError
+}
+
+func NewConflictingOptionsError(option string, conflict string) error {
+	return &ConflictingOptionsError{baseConnectorError: baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("error occurred while validating %s, the option %s", option, conflict),
+		message: fmt.Sprintf("The value specified in the %s option conflicts with the other configuration options. ", option) +
+			acceptedValueErrorMessage,
+	}}
+}
+
 type ParseBasicAuthHeaderError struct {
 	baseConnectorError
 }
@@ -185,6 +617,21 @@ func NewMissingTableError(defaultTable string) error {
 	return &MissingTableError{baseConnectorError: base}
 }
 
+type MissingMatcherError struct {
+	baseConnectorError
+}
+
+func NewMissingMatcherError() error {
+	base := baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   "the query does not have any label matcher",
+		message: "Queries without any label matcher scan every row of the table within the time range, and require-matcher is set to true. " +
+			"Add a metric name or a label matcher to the PromQL query, or set require-matcher to false. " +
+			detailsErrorMessage,
+	}
+	return &MissingMatcherError{baseConnectorError: base}
+}
+
 type UnknownMatcherError struct {
 	baseConnectorError
 }
@@ -198,6 +645,101 @@ func NewUnknownMatcherError() error {
 	return &UnknownMatcherError{baseConnectorError: base}
 }
 
+type InvalidMatcherNameError struct {
+	baseConnectorError
+}
+
+func NewInvalidMatcherNameError(labelName string) error {
+	base := baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("matcher label name '%s' does not match the Prometheus label name pattern [a-zA-Z_][a-zA-Z0-9_]*", labelName),
+		message: "The label name of a matcher in the query contains characters not allowed in a Prometheus label name, and cannot be used as a Timestream column in the query. " +
+			detailsErrorMessage,
+	}
+	return &InvalidMatcherNameError{baseConnectorError: base}
+}
+
+type DimensionOnlyReadError struct {
+	baseConnectorError
+}
+
+func NewDimensionOnlyReadError() error {
+	base := baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   "the query does not contain a metric name matcher and dimension-only-reads is set to reject",
+		message: "Queries filtering on labels only may scan every metric in the table and return mixed metrics. " +
+			"Add a metric name to the PromQL, for example {__name__=\"metric\", job=\"prometheus\"}, or set dimension-only-reads to allow or empty. " +
+			detailsErrorMessage,
+	}
+	return &DimensionOnlyReadError{baseConnectorError: base}
+}
+
+type MaxReadRangeError struct {
+	baseConnectorError
+}
+
+func NewMaxReadRangeError(readRange time.Duration, maxReadRange time.Duration) error {
+	base := baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("the query time range of %s exceeds the max-read-range of %s", readRange, maxReadRange),
+		message: "The time range of the query exceeds the maximum time range allowed by the max-read-range option. " +
+			"Narrow down the time range of the PromQL query, or increase max-read-range. " +
+			detailsErrorMessage,
+	}
+	return &MaxReadRangeError{baseConnectorError: base}
+}
+
+type MaxReadCostError struct {
+	baseConnectorError
+}
+
+func NewMaxReadCostError(cost float64, maxReadCost float64) error {
+	base := baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("the estimated read cost of %.2f exceeds the max-read-cost of %.2f", cost, maxReadCost),
+		message: "The estimated cost of the read request exceeds the maximum cost allowed by the max-read-cost option. " +
+			"Narrow down the time range of the PromQL query, add a metric name or label matchers, or increase max-read-cost. " +
+			detailsErrorMessage,
+	}
+	return &MaxReadCostError{baseConnectorError: base}
+}
+
+type ReadDeadlineExceededError struct {
+	baseConnectorError
+}
+
+func NewReadDeadlineExceededError(readTotalDeadline time.Duration) error {
+	base := baseConnectorError{
+		statusCode: http.StatusGatewayTimeout,
+		errorMsg:   fmt.Sprintf("the read request did not complete within the read-total-deadline of %s", readTotalDeadline),
+		message: "The queries and pages of the read request took longer than the read-total-deadline allows. " +
+			"Narrow down the time range or the matchers of the PromQL query, or increase read-total-deadline. " +
+			detailsErrorMessage,
+	}
+	return &ReadDeadlineExceededError{baseConnectorError: base}
+}
+
+type IngestRateExceededError struct {
+	baseConnectorError
+	retryAfter time.Duration
+}
+
+func NewIngestRateExceededError(maxIngestRate int, retryAfter time.Duration) error {
+	base := baseConnectorError{
+		statusCode: http.StatusTooManyRequests,
+		errorMsg:   fmt.Sprintf("the rate of the received samples exceeds the max-ingest-rate of %d samples per second, retry after %s", maxIngestRate, retryAfter),
+		message: "The rate of the samples received by the connector exceeds the max-ingest-rate, and the write request is rejected until the rate subsides. " +
+			"Reduce the samples sent by Prometheus, or increase max-ingest-rate. " +
+			detailsErrorMessage,
+	}
+	return &IngestRateExceededError{baseConnectorError: base, retryAfter: retryAfter}
+}
+
+// RetryAfter returns the duration after which the write request may be retried.
+func (e *IngestRateExceededError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
 type LongLabelNameError struct {
 	baseConnectorError
 }
@@ -212,6 +754,119 @@ func NewLongLabelNameError(measureValueName string, maxMeasureNameLength int) er
 	return &LongLabelNameError{baseConnectorError: base}
 }
 
+type ReservedLabelNameError struct {
+	baseConnectorError
+}
+
+func NewReservedLabelNameError(labelName string) error {
+	base := baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("label name '%s' collides with a column name reserved by Timestream", labelName),
+		message: "The label name collides with a Timestream reserved column name such as time, measure_name or measure_value, and the `reserved-label-names` is set to `fail`. " +
+			detailsErrorMessage,
+	}
+	return &ReservedLabelNameError{baseConnectorError: base}
+}
+
+type InvalidLabelNameError struct {
+	baseConnectorError
+}
+
+func NewInvalidLabelNameError(labelName string) error {
+	base := baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("label name '%s' does not match the Prometheus label name pattern [a-zA-Z_][a-zA-Z0-9_]*", labelName),
+		message: "The label name contains characters not allowed in a Prometheus label name, and the `validate-label-names` is set to `fail`, or the label name sanitized by `sanitize` collides with another label. " +
+			detailsErrorMessage,
+	}
+	return &InvalidLabelNameError{baseConnectorError: base}
+}
+
+type AmbiguousLabelNameError struct {
+	baseConnectorError
+}
+
+func NewAmbiguousLabelNameError(labelName string) error {
+	base := baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("label name '%s' is ambiguous with a renamed reserved label name", labelName),
+		message: "The label name is the name of a Timestream reserved column such as time, measure_name or measure_value prefixed with `label_`, " +
+			"which is used to store the labels colliding with the reserved column names and restored to the reserved name on reads. " +
+			detailsErrorMessage,
+	}
+	return &AmbiguousLabelNameError{baseConnectorError: base}
+}
+
+type UnorderedSamplesError struct {
+	baseConnectorError
+}
+
+func NewUnorderedSamplesError(measureValueName string, previousTimestamp int64, timestamp int64) error {
+	base := baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("samples of metric '%s' are not in ascending timestamp order, timestamp %d follows %d", measureValueName, timestamp, previousTimestamp),
+		message: "The samples of a time series are not in ascending timestamp order, and the `require-ordered-samples` is set to `reject`. " +
+			detailsErrorMessage,
+	}
+	return &UnorderedSamplesError{baseConnectorError: base}
+}
+
+type ConflictingRecordsError struct {
+	baseConnectorError
+}
+
+func NewConflictingRecordsError(measureValueName string, timestamp string) error {
+	base := baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("samples of metric '%s' with the same labels and timestamp %s have different values", measureValueName, timestamp),
+		message: "The write request contains samples with the same labels and timestamp but different values, and the `conflicting-records` is set to `error`. " +
+			detailsErrorMessage,
+	}
+	return &ConflictingRecordsError{baseConnectorError: base}
+}
+
+type DuplicateSamplesError struct {
+	baseConnectorError
+}
+
+func NewDuplicateSamplesError(measureValueName string, timestamp int64) error {
+	base := baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("the time series of metric '%s' has samples with the same timestamp %d but different values", measureValueName, timestamp),
+		message: "The write request contains a time series with samples of the same timestamp but different values, and the `duplicate-samples` is set to `error`. " +
+			detailsErrorMessage,
+	}
+	return &DuplicateSamplesError{baseConnectorError: base}
+}
+
+type MissingRequiredDimensionError struct {
+	baseConnectorError
+}
+
+func NewMissingRequiredDimensionError(measureValueName string, dimension string) error {
+	base := baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   fmt.Sprintf("a time series of metric '%s' is missing the required dimension '%s'", measureValueName, dimension),
+		message: "A time series is missing one of the labels listed in the `required-dimensions`, and the `missing-dimensions` is set to `fail`. " +
+			detailsErrorMessage,
+	}
+	return &MissingRequiredDimensionError{baseConnectorError: base}
+}
+
+type EmptyWriteRequestError struct {
+	baseConnectorError
+}
+
+func NewEmptyWriteRequestError() error {
+	base := baseConnectorError{
+		statusCode: http.StatusBadRequest,
+		errorMsg:   "the write request does not contain any time series",
+		message: "The write request does not contain any time series, and the `reject-empty-writes` is set to `true`. " +
+			detailsErrorMessage,
+	}
+	return &EmptyWriteRequestError{baseConnectorError: base}
+}
+
 type InvalidSampleValueError struct {
 	baseConnectorError
 }
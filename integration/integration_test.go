@@ -241,10 +241,16 @@ func createReadHints() *prompb.ReadHints {
 // createClient creates a new Timestream client containing a Timestream query client and a Timestream write client.
 func createClient(t *testing.T, logger log.Logger, database, table string, configs *aws.Config, failOnLongMetricLabelName bool, failOnInvalidSample bool) *timestream.Client {
 	client := timestream.NewBaseClient(database, table)
-	client.NewQueryClient(logger, configs)
+	client.NewQueryClient(logger, configs, timestream.QueryClientOptions{DimensionOnlyReads: timestream.AllowDimensionOnlyReads, NonFiniteReads: timestream.PassNonFiniteReads})
 
 	configs.MaxRetries = aws.Int(awsClient.DefaultRetryerMaxNumRetries)
-	client.NewWriteClient(logger, configs, failOnLongMetricLabelName, failOnInvalidSample)
+	client.NewWriteClient(logger, configs, timestream.WriteClientOptions{
+		FailOnLongMetricLabelName: failOnLongMetricLabelName,
+		FailOnInvalidSample:       failOnInvalidSample,
+		RetryOnAuthError:          true,
+		ReservedLabels:            timestream.RenameReservedLabels,
+		RecordVersionStrategy:     timestream.NoRecordVersion,
+	})
 	return client
 }
 
@@ -17,28 +17,52 @@ and limitations under the License.
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	awsClient "github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/processcreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/go-kit/log"
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/snappy"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/promlog"
 	"github.com/prometheus/common/promlog/flag"
 	"github.com/prometheus/prometheus/prompb"
 	"github.com/alecthomas/kingpin/v2"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 	"timestream-prometheus-connector/errors"
 	"timestream-prometheus-connector/timestream"
 )
@@ -47,35 +71,145 @@ const (
 	readHeader            = "x-prometheus-remote-read-version"
 	writeHeader           = "x-prometheus-remote-write-version"
 	basicAuthHeader       = "authorization"
+	errorTypeHeader       = "X-Connector-Error-Type"
+	requestIDHeader       = "X-Request-ID"
+	retryAfterHeader      = "Retry-After"
 	writeClientMaxRetries = 10
+	maxReadPageSize       = 1000
+	maxSchemaLagRetries   = 5
+	maxCredentialRetries  = 5
+	mirrorWriteTimeout    = 30 * time.Second
+	readinessTimeout      = 5 * time.Second
+	warmupTimeout         = 10 * time.Second
+)
+
+// cloudWatchMetricsInterval is the interval the standalone connector publishes its metrics to CloudWatch at.
+const cloudWatchMetricsInterval = time.Minute
+
+// credentialRetryBackoff is the backoff before the first retry of a failed credential load on AWS Lambda, doubled with
+// every retry.
+var credentialRetryBackoff = 100 * time.Millisecond
+
+// regionPattern matches the AWS Region codes, such as us-east-1 or us-gov-west-1.
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// mirrorWrites records the write requests forwarded to the mirror-write-url, partitioned by the result.
+var mirrorWrites = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "timestream_connector_mirror_writes_total",
+		Help: "The total number of write requests forwarded to the secondary remote-write endpoint, partitioned by the result.",
+	},
+	[]string{"result"},
+)
+
+// mirrorWriteHeaders are the headers of a write request forwarded to the mirror-write-url. The basic authentication
+// header is never forwarded as it holds the AWS credentials.
+var mirrorWriteHeaders = []string{"Content-Encoding", "Content-Type", "User-Agent", writeHeader}
+
+// requestIDPattern matches the request IDs sent by the clients which are safe to add to the log lines.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// tlsVersions maps the accepted values of the aws-tls-min-version option to the TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// The accepted credential providers of the credential provider chain.
+const (
+	envCredentialProvider     = "env"
+	sharedCredentialProvider  = "shared"
+	imdsCredentialProvider    = "imds"
+	processCredentialProvider = "process"
+	ssoCredentialProvider     = "sso"
+)
+
+// The accepted encodings of the read responses returned on AWS Lambda.
+const (
+	snappyReadEncoding = "snappy"
+	gzipReadEncoding   = "gzip"
+)
+
+// hostnameInstanceID is the value of the instance-id option using the hostname as the ID of the connector instance.
+const hostnameInstanceID = "hostname"
+
+// The Lambda context values that can be attached as dimensions on the ingested records.
+const (
+	awsRequestIDDimension    = "aws_request_id"
+	functionNameDimension    = "function_name"
+	functionVersionDimension = "function_version"
 )
 
 var (
 	// Store the initialization function calls and client retrieval calls to allow unit tests to mock the creation of real clients.
-	createWriteClient = func(timestreamClient *timestream.Client, logger log.Logger, configs *aws.Config, failOnLongMetricLabelName bool, failOnInvalidSample bool) {
-		timestreamClient.NewWriteClient(logger, configs, failOnLongMetricLabelName, failOnInvalidSample)
+	createWriteClient = func(timestreamClient *timestream.Client, logger log.Logger, configs *aws.Config, options timestream.WriteClientOptions) {
+		timestreamClient.NewWriteClient(logger, configs, options)
 	}
-	createQueryClient = func(timestreamClient *timestream.Client, logger log.Logger, configs *aws.Config, maxRetries int) {
-		configs.MaxRetries = aws.Int(maxRetries)
-		timestreamClient.NewQueryClient(logger, configs)
+	createQueryClient = func(timestreamClient *timestream.Client, logger log.Logger, configs *aws.Config, maxRetries int, options timestream.QueryClientOptions) {
+		setMaxRetries(configs, maxRetries)
+		timestreamClient.NewQueryClient(logger, configs, options)
 	}
 	getWriteClient = func(timestreamClient *timestream.Client) writer { return timestreamClient.WriteClient() }
 	getQueryClient = func(timestreamClient *timestream.Client) reader { return timestreamClient.QueryClient() }
 	halt           = os.Exit
 )
 
+// lambdaState is the connector state built from the environment variables of the AWS Lambda function, which is reused
+// by the warm invocations of the function until the environment variables change.
+type lambdaState struct {
+	key                 string
+	cfg                 *connectionConfig
+	logger              log.Logger
+	writeConfigs        *aws.Config
+	queryConfigs        *aws.Config
+	timestreamClient    *timestream.Client
+	cloudWatchPublisher *timestream.CloudWatchPublisher
+}
+
+// cloudWatchMetricsWriter is where the metrics in the CloudWatch embedded metric format are written to, allowing unit
+// tests to capture them.
+var cloudWatchMetricsWriter io.Writer = os.Stdout
+
+var (
+	cachedLambdaState *lambdaState
+	lambdaStateMutex  sync.Mutex
+)
+
 type writer interface {
-	Write(req *prompb.WriteRequest, credentials *credentials.Credentials) error
+	WriteWithContext(ctx context.Context, req *prompb.WriteRequest, credentials *credentials.Credentials) error
 	Name() string
 }
 
 type reader interface {
-	Read(req *prompb.ReadRequest, credentials *credentials.Credentials) (*prompb.ReadResponse, error)
+	ReadWithContext(ctx context.Context, req *prompb.ReadRequest, credentials *credentials.Credentials) (*prompb.ReadResponse, error)
 	Name() string
 }
 
+type metricsResetter interface {
+	ResetMetrics()
+}
+
+type errorRatioReporter interface {
+	RecentErrorRatio() (float64, int)
+}
+
+type readinessChecker interface {
+	CheckReady(ctx context.Context) error
+}
+
+// connectorError is implemented by the errors of the connector, which define the HTTP status code of their responses.
+type connectorError interface {
+	error
+	StatusCode() int
+}
+
 type clientConfig struct {
-	region string
+	region          string
+	tlsMinVersion   string
+	retryBaseDelay  time.Duration
+	retryMaxBackoff time.Duration
 }
 
 type connectionConfig struct {
@@ -85,12 +219,92 @@ type connectionConfig struct {
 	enableLogging             bool
 	failOnLongMetricLabelName bool
 	failOnInvalidSample       bool
+	retryOnAuthError          bool
 	listenAddr                string
 	promlogConfig             promlog.Config
 	telemetryPath             string
 	maxRetries                int
+	maxSamplesPerSeries       int
+	cardinalityTracking       int
+	maxIngestRate             int
+	writeConcurrency          int
+	missingDestinationStatus  int
 	certificate               string
 	key                       string
+	rollupTable               string
+	rollupWindow              time.Duration
+	dimensionOnlyReads        string
+	lambdaContextDimensions   []string
+	lambdaReadEncoding        string
+	credentialRetries         int
+	readTables                []string
+	readDatabases             []string
+	crossDatabaseReads        bool
+	dumpRecordsFile           string
+	deadLetterDir             string
+	defaultMeasureName        string
+	normalizeMeasureNames     bool
+	numericQuantiles          bool
+	emitSampleCount           bool
+	emitSchemaVersion         bool
+	rejectEmptyWrites         bool
+	clampTimestamps           bool
+	enableMultiMeasure        bool
+	autoCreateDestinations    bool
+	logRequestID              bool
+	cloudWatchMetrics         bool
+	memoryStoreRetention      time.Duration
+	magneticStoreRetention    time.Duration
+	expectedMemoryRetention   time.Duration
+	expectedMagneticRetention time.Duration
+	magneticReadTimeout       time.Duration
+	readTotalDeadline         time.Duration
+	maxReadRange              time.Duration
+	maxReadCost               float64
+	defaultLookback           time.Duration
+	preferRecent              bool
+	caseInsensitive           bool
+	combineReadQueries        bool
+	requireMatcher            bool
+	returnPartialReads        bool
+	readDebugColumns          bool
+	readPageSize              int
+	schemaLagRetries          int
+	nonFiniteReads            string
+	timestampColumns          string
+	enableAdmin               bool
+	features                  map[string]string
+	enableOpenMetrics         bool
+	maxConcurrency            int
+	maxRPS                    int
+	maxInFlightBytes          int64
+	mirrorWriteURL            string
+	readHandlerTimeout        time.Duration
+	writeBatchWindow          time.Duration
+	warmupConnections         int
+	healthMaxErrorRatio       float64
+	reservedLabels            string
+	auditLog                  string
+	recordVersionStrategy     string
+	requireOrderedSamples     string
+	conflictingRecords        string
+	duplicateSamples          string
+	instanceID                string
+	requiredDimensions        []string
+	perMetricStats            []string
+	missingDimensions         string
+	validateLabelNames        string
+	expandJSONLabel           string
+	infBucketValue            string
+	databaseLabel             string
+	tableLabel                string
+	stripNamespace            string
+	addNamespace              string
+	booleanMetrics            *regexp.Regexp
+	measureValueType          string
+	credentialProviders       []string
+	writeRoleARNs             map[string]string
+	costTags                  map[string]string
 }
 
 func main() {
@@ -103,29 +317,73 @@ func main() {
 
 		cfg := parseFlags()
 
-		http.Handle(cfg.telemetryPath, promhttp.Handler())
+		http.Handle(cfg.telemetryPath, createTelemetryHandler(cfg.enableOpenMetrics))
 
 		logger := cfg.createLogger()
 		awsQueryConfigs := cfg.buildAWSConfig()
 		awsWriteConfigs := cfg.buildAWSConfig()
 
 		timestreamClient := timestream.NewBaseClient(cfg.defaultDatabase, cfg.defaultTable)
+		timestreamClient.LimitConcurrency(cfg.maxConcurrency)
+		timestreamClient.LimitRate(cfg.maxRPS)
+
+		setMaxRetries(awsQueryConfigs, cfg.maxRetries)
+		timestreamClient.NewQueryClient(logger, awsQueryConfigs, cfg.queryClientOptions())
+
+		setMaxRetries(awsWriteConfigs, writeClientMaxRetries)
+		timestreamClient.NewWriteClient(logger, awsWriteConfigs, cfg.writeClientOptions())
+		if err := timestreamClient.WriteClient().ValidateRetention(cfg.expectedMemoryRetention, cfg.expectedMagneticRetention); err != nil {
+			timestream.LogError(logger, "Unable to validate the retention of the default table.", err)
+		}
+		if cfg.warmupConnections > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+			if err := timestreamClient.WriteClient().Warmup(ctx, cfg.warmupConnections); err != nil {
+				timestream.LogError(logger, "Unable to warm up the connections to Timestream.", err)
+			} else {
+				timestream.LogInfo(logger, fmt.Sprintf("Warmed up %d connections to Timestream.", cfg.warmupConnections))
+			}
+			cancel()
+		}
+
+		if len(cfg.rollupTable) != 0 {
+			timestreamClient.NewRollupClient(logger, cfg.buildAWSConfig(), cfg.rollupTable, cfg.rollupWindow)
+			timestreamClient.RollupClient().Start()
+			go flushOnShutdown(logger, timestreamClient.RollupClient())
+			timestream.LogInfo(logger, fmt.Sprintf("Rollups are enabled (Table: %s, Window: %s)", cfg.rollupTable, cfg.rollupWindow))
+		}
 
-		awsQueryConfigs.MaxRetries = aws.Int(cfg.maxRetries)
-		timestreamClient.NewQueryClient(logger, awsQueryConfigs)
+		http.HandleFunc("/health", createHealthHandler(timestreamClient.WriteClient(), cfg.healthMaxErrorRatio))
 
-		awsWriteConfigs.MaxRetries = aws.Int(writeClientMaxRetries)
-		timestreamClient.NewWriteClient(logger, awsWriteConfigs, cfg.failOnLongMetricLabelName, cfg.failOnInvalidSample)
+		if cfg.enableAdmin {
+			http.HandleFunc("/admin/reset-metrics", createResetMetricsHandler(logger, timestreamClient))
+			http.HandleFunc("/admin/features", createFeaturesHandler(cfg.features))
+			timestream.LogInfo(logger, "The admin endpoints are enabled, they are intended for test environments only.")
+		}
 
 		timestream.LogInfo(logger, fmt.Sprintf("Timestream connection is initialized (Database: %s, Table: %s, Region: %s)", cfg.defaultDatabase, cfg.defaultTable, cfg.clientConfig.region))
 		// Register TimestreamClient to Prometheus for it to scrape metrics
 		prometheus.MustRegister(timestreamClient)
+		if len(cfg.mirrorWriteURL) != 0 {
+			prometheus.MustRegister(mirrorWrites)
+		}
+		if cfg.cloudWatchMetrics {
+			publisher := timestream.NewCloudWatchPublisher(timestreamClient, cloudWatchMetricsWriter)
+			go func() {
+				for range time.Tick(cloudWatchMetricsInterval) {
+					publishCloudWatchMetrics(logger, publisher)
+				}
+			}()
+		}
 
-		writers = append(writers, timestreamClient.WriteClient())
+		if cfg.writeBatchWindow > 0 {
+			writers = append(writers, newBatchingWriter(timestreamClient.WriteClient(), cfg.writeBatchWindow))
+		} else {
+			writers = append(writers, timestreamClient.WriteClient())
+		}
 		readers = append(readers, timestreamClient.QueryClient())
 
 		timestream.LogInfo(logger, "The Prometheus Connector is now ready to begin serving ingestion and query requests.")
-		if err := serve(logger, cfg.listenAddr, writers, readers, cfg.certificate, cfg.key); err != nil {
+		if err := serve(logger, cfg, writers, readers, timestreamClient.WriteClient()); err != nil {
 			timestream.LogError(logger, "Error occurred while listening for requests.", err)
 			os.Exit(1)
 		}
@@ -133,26 +391,28 @@ func main() {
 }
 
 // lambdaHandler receives Prometheus read or write requests sent by API Gateway.
-func lambdaHandler(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func lambdaHandler(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	if (len(os.Getenv(defaultDatabaseConfig.envFlag)) == 0 || len(os.Getenv(defaultTableConfig.envFlag)) == 0) {
-		return createErrorResponse(errors.NewMissingDestinationError().(*errors.MissingDestinationError).Message())
+		err := errors.NewMissingDestinationError()
+		return createConnectorErrorResponse(err, err.(*errors.MissingDestinationError).Message())
 	}
 
-	cfg, err := parseEnvironmentVariables()
+	state, err := loadLambdaState()
 	if err != nil {
-		return createErrorResponse(err.Error())
+		return createConnectorErrorResponse(err, err.Error())
+	}
+	cfg := state.cfg
+	if state.cloudWatchPublisher != nil {
+		// The metrics are published once the request is handled, as no Prometheus scrapes the connector on AWS Lambda.
+		defer publishCloudWatchMetrics(state.logger, state.cloudWatchPublisher)
 	}
-
-	logger := cfg.createLogger()
 
 	awsCredentials, ok := parseBasicAuth(req.Headers[basicAuthHeader])
-	if !ok {
-		return createErrorResponse(errors.NewParseBasicAuthHeaderError().(*errors.ParseBasicAuthHeaderError).Message())
+	if !ok && !(len(req.Headers[basicAuthHeader]) == 0 && len(cfg.credentialProviders) != 0) {
+		err := errors.NewParseBasicAuthHeaderError()
+		return createConnectorErrorResponse(err, err.(*errors.ParseBasicAuthHeaderError).Message())
 	}
 
-	awsConfigs := cfg.buildAWSConfig()
-	timestreamClient := timestream.NewBaseClient(cfg.defaultDatabase, cfg.defaultTable)
-
 	requestBody, err := base64.StdEncoding.DecodeString(req.Body)
 	if err != nil {
 		return createErrorResponse("Error occurred while decoding the API Gateway request body: " + err.Error())
@@ -163,17 +423,99 @@ func lambdaHandler(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRes
 		return createErrorResponse("Error occurred while reading the write request sent by Prometheus: " + err.Error())
 	}
 
+	logger := state.logger
+	if cfg.logRequestID {
+		// Fall back to the ID API Gateway assigned to the request before generating one.
+		requestID := req.Headers[strings.ToLower(requestIDHeader)]
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = req.RequestContext.RequestID
+		}
+		ctx = timestream.ContextWithRequestID(ctx, resolveRequestID(requestID))
+		logger = timestream.ContextLogger(ctx, logger)
+	}
+
+	if awsCredentials == nil && cfg.credentialRetries > 0 {
+		awsConfigs := state.queryConfigs
+		if len(req.Headers[writeHeader]) != 0 {
+			awsConfigs = state.writeConfigs
+		}
+		// The request proceeds even if the credentials cannot be loaded, failing as it would without the retries.
+		if err := loadCredentials(ctx, logger, awsConfigs.Credentials, cfg.credentialRetries); err != nil {
+			timestream.LogError(logger, "Unable to load the AWS credentials from the credential providers.", err)
+		}
+	}
+
 	if len(req.Headers[writeHeader]) != 0 {
-		return handleWriteRequest(reqBuf, timestreamClient, awsConfigs, cfg, logger, awsCredentials)
+		return handleWriteRequest(ctx, reqBuf, state.timestreamClient, state.writeConfigs, cfg, logger, awsCredentials)
 	} else if len(req.Headers[readHeader]) != 0 {
-		return handleReadRequest(reqBuf, timestreamClient, awsConfigs, cfg, logger, awsCredentials)
+		return handleReadRequest(ctx, reqBuf, state.timestreamClient, state.queryConfigs, cfg, logger, awsCredentials)
+	}
+
+	err = errors.NewMissingHeaderError(readHeader, writeHeader)
+	return createConnectorErrorResponse(err, err.(*errors.MissingHeaderError).Message())
+}
+
+// loadCredentials retrieves the credentials, retrying up to retries times with an exponential backoff, so a transient
+// failure of a credential provider, such as on a cold start, does not fail the request.
+func loadCredentials(ctx context.Context, logger log.Logger, awsCredentials *credentials.Credentials, retries int) error {
+	for attempt := 0; ; attempt++ {
+		_, err := awsCredentials.GetWithContext(ctx)
+		if err == nil || attempt >= retries {
+			return err
+		}
+		backoff := credentialRetryBackoff << attempt
+		timestream.LogInfo(logger, fmt.Sprintf("Unable to load the AWS credentials, retrying in %s.", backoff), "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// loadLambdaState returns the connector state cached by a previous invocation if the environment variables are
+// unchanged; otherwise the configuration is parsed again and the clients are recreated on their first use.
+func loadLambdaState() (*lambdaState, error) {
+	lambdaStateMutex.Lock()
+	defer lambdaStateMutex.Unlock()
+
+	key := lambdaConfigurationKey()
+	if cachedLambdaState != nil && cachedLambdaState.key == key {
+		return cachedLambdaState, nil
+	}
+
+	cfg, err := parseEnvironmentVariables()
+	if err != nil {
+		return nil, err
 	}
 
-	return createErrorResponse(errors.NewMissingHeaderError(readHeader, writeHeader).(*errors.MissingHeaderError).Message())
+	cachedLambdaState = &lambdaState{
+		key:              key,
+		cfg:              cfg,
+		logger:           cfg.createLogger(),
+		writeConfigs:     cfg.buildAWSConfig(),
+		queryConfigs:     cfg.buildAWSConfig(),
+		timestreamClient: timestream.NewBaseClient(cfg.defaultDatabase, cfg.defaultTable),
+	}
+	if cfg.cloudWatchMetrics {
+		cachedLambdaState.cloudWatchPublisher = timestream.NewCloudWatchPublisher(cachedLambdaState.timestreamClient, cloudWatchMetricsWriter)
+	}
+	return cachedLambdaState, nil
+}
+
+// lambdaConfigurationKey hashes the environment variables of the Lambda configuration options, distinguishing unset
+// variables from variables set to an empty string.
+func lambdaConfigurationKey() string {
+	hash := sha256.New()
+	for _, config := range lambdaConfigurations {
+		value, exists := os.LookupEnv(config.envFlag)
+		fmt.Fprintf(hash, "%s=%t:%q;", config.envFlag, exists, value)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
 }
 
 // handleWriteRequest handles a Prometheus write request.
-func handleWriteRequest(reqBuf []byte, timestreamClient *timestream.Client, awsConfigs *aws.Config, cfg *connectionConfig, logger log.Logger, credentials *credentials.Credentials) (events.APIGatewayProxyResponse, error) {
+func handleWriteRequest(ctx context.Context, reqBuf []byte, timestreamClient *timestream.Client, awsConfigs *aws.Config, cfg *connectionConfig, logger log.Logger, credentials *credentials.Credentials) (events.APIGatewayProxyResponse, error) {
 	var writeRequest prompb.WriteRequest
 	if err := proto.Unmarshal(reqBuf, &writeRequest); err != nil {
 		return events.APIGatewayProxyResponse{
@@ -182,20 +524,35 @@ func handleWriteRequest(reqBuf []byte, timestreamClient *timestream.Client, awsC
 		}, nil
 	}
 
-	createWriteClient(timestreamClient, logger, awsConfigs, cfg.failOnLongMetricLabelName, cfg.failOnInvalidSample)
+	if len(cfg.lambdaContextDimensions) != 0 {
+		addLambdaContextLabels(ctx, &writeRequest, cfg.lambdaContextDimensions)
+	}
 
-	timestream.LogInfo(logger, fmt.Sprintf("Timestream write connection is initialized (Database: %s, Table: %s, Region: %s)", cfg.defaultDatabase, cfg.defaultTable, cfg.clientConfig.region))
-	if err := getWriteClient(timestreamClient).Write(&writeRequest, credentials); err != nil {
+	if timestreamClient.WriteClient() == nil {
+		createWriteClient(timestreamClient, logger, awsConfigs, cfg.writeClientOptions())
+		timestream.LogInfo(logger, fmt.Sprintf("Timestream write connection is initialized (Database: %s, Table: %s, Region: %s)", cfg.defaultDatabase, cfg.defaultTable, cfg.clientConfig.region))
+	}
+	if err := getWriteClient(timestreamClient).WriteWithContext(ctx, &writeRequest, credentials); err != nil {
 		errorCode := http.StatusBadRequest
 
 		if requestError, ok := err.(awserr.RequestFailure); ok {
 			errorCode = requestError.StatusCode()
+		} else if isMissingDestinationError(err) {
+			errorCode = cfg.missingDestinationStatus
 		}
 
-		return events.APIGatewayProxyResponse{
+		response := events.APIGatewayProxyResponse{
 			StatusCode: errorCode,
 			Body:       err.Error(),
-		}, nil
+		}
+		if errorType, ok := errors.Type(err); ok {
+			response.Headers = map[string]string{errorTypeHeader: errorType}
+		}
+		if rateError, ok := err.(*errors.IngestRateExceededError); ok {
+			response.StatusCode = rateError.StatusCode()
+			response.Headers[retryAfterHeader] = retryAfterSeconds(rateError.RetryAfter())
+		}
+		return response, nil
 	}
 
 	return events.APIGatewayProxyResponse{
@@ -203,19 +560,59 @@ func handleWriteRequest(reqBuf []byte, timestreamClient *timestream.Client, awsC
 	}, nil
 }
 
+// addLambdaContextLabels adds the selected Lambda context values as labels to every time series in the write request,
+// which are then ingested as dimensions. Existing labels with the same name are overwritten.
+func addLambdaContextLabels(ctx context.Context, writeRequest *prompb.WriteRequest, dimensions []string) {
+	contextLabels := make([]*prompb.Label, 0, len(dimensions))
+	for _, dimension := range dimensions {
+		var value string
+		switch dimension {
+		case awsRequestIDDimension:
+			if lambdaContext, ok := lambdacontext.FromContext(ctx); ok {
+				value = lambdaContext.AwsRequestID
+			}
+		case functionNameDimension:
+			value = lambdacontext.FunctionName
+		case functionVersionDimension:
+			value = lambdacontext.FunctionVersion
+		}
+
+		if len(value) != 0 {
+			contextLabels = append(contextLabels, &prompb.Label{Name: dimension, Value: value})
+		}
+	}
+
+	for _, timeSeries := range writeRequest.Timeseries {
+		for _, contextLabel := range contextLabels {
+			overwritten := false
+			for _, label := range timeSeries.Labels {
+				if label.Name == contextLabel.Name {
+					label.Value = contextLabel.Value
+					overwritten = true
+					break
+				}
+			}
+			if !overwritten {
+				timeSeries.Labels = append(timeSeries.Labels, &prompb.Label{Name: contextLabel.Name, Value: contextLabel.Value})
+			}
+		}
+	}
+}
+
 // handleReadRequest handles a Prometheus read request.
-func handleReadRequest(reqBuf []byte, timestreamClient *timestream.Client, awsConfigs *aws.Config, cfg *connectionConfig, logger log.Logger, credentials *credentials.Credentials) (events.APIGatewayProxyResponse, error) {
+func handleReadRequest(ctx context.Context, reqBuf []byte, timestreamClient *timestream.Client, awsConfigs *aws.Config, cfg *connectionConfig, logger log.Logger, credentials *credentials.Credentials) (events.APIGatewayProxyResponse, error) {
 	var readRequest prompb.ReadRequest
 	if err := proto.Unmarshal(reqBuf, &readRequest); err != nil {
 		timestream.LogError(logger, "Error occurred while unmarshalling the decoded read request from Prometheus.", err)
 		return createErrorResponse(err.Error())
 	}
 
-	createQueryClient(timestreamClient, logger, awsConfigs, cfg.maxRetries)
-
-	timestream.LogInfo(logger, fmt.Sprintf("Timestream query connection is initialized (Database: %s, Table: %s, Region: %s)", cfg.defaultDatabase, cfg.defaultTable, cfg.clientConfig.region))
+	if timestreamClient.QueryClient() == nil {
+		createQueryClient(timestreamClient, logger, awsConfigs, cfg.maxRetries, cfg.queryClientOptions())
+		timestream.LogInfo(logger, fmt.Sprintf("Timestream query connection is initialized (Database: %s, Table: %s, Region: %s)", cfg.defaultDatabase, cfg.defaultTable, cfg.clientConfig.region))
+	}
 
-	response, err := getQueryClient(timestreamClient).Read(&readRequest, credentials)
+	response, err := getQueryClient(timestreamClient).ReadWithContext(ctx, &readRequest, credentials)
 	if err != nil {
 		timestream.LogError(logger, "Error occurred while reading the data back from Timestream.", err)
 		if requestError, ok := err.(awserr.RequestFailure); ok {
@@ -225,7 +622,13 @@ func handleReadRequest(reqBuf []byte, timestreamClient *timestream.Client, awsCo
 			}, nil
 		}
 
-		return createErrorResponse(err.Error())
+		response, _ := createConnectorErrorResponse(err, err.Error())
+		if deadlineError, ok := err.(*errors.ReadDeadlineExceededError); ok {
+			response.StatusCode = deadlineError.StatusCode()
+		} else if isMissingDestinationError(err) {
+			response.StatusCode = cfg.missingDestinationStatus
+		}
+		return response, nil
 	}
 
 	data, err := proto.Marshal(response)
@@ -234,22 +637,44 @@ func handleReadRequest(reqBuf []byte, timestreamClient *timestream.Client, awsCo
 		return createErrorResponse(err.Error())
 	}
 
-	snappyEncodeData := snappy.Encode(nil, data)
-	base64EncodeData := make([]byte, base64.StdEncoding.EncodedLen(len(snappyEncodeData)))
-	base64.StdEncoding.Encode(base64EncodeData, snappyEncodeData)
+	encodedData, err := encodeReadResponse(data, cfg.lambdaReadEncoding)
+	if err != nil {
+		timestream.LogError(logger, "Error occurred while encoding the Prometheus ReadResponse.", err)
+		return createErrorResponse(err.Error())
+	}
+	base64EncodeData := make([]byte, base64.StdEncoding.EncodedLen(len(encodedData)))
+	base64.StdEncoding.Encode(base64EncodeData, encodedData)
 
 	return events.APIGatewayProxyResponse{
 		StatusCode:      http.StatusOK,
 		IsBase64Encoded: true,
 		Headers: map[string]string{
 			"Content-Type":     "application/x-protobuf",
-			"Content-Encoding": "snappy",
+			"Content-Encoding": cfg.lambdaReadEncoding,
 		},
 		Body: string(base64EncodeData),
 	}, nil
 }
 
-// parseBasicAuth parses the encoded HTTP Basic Authentication Header.
+// encodeReadResponse compresses the marshalled read response with the given encoding, snappy or gzip.
+func encodeReadResponse(data []byte, encoding string) ([]byte, error) {
+	if encoding != gzipReadEncoding {
+		return snappy.Encode(nil, data), nil
+	}
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// parseBasicAuth parses the encoded HTTP Basic Authentication Header. The password may carry the session token of
+// temporary security credentials after the secret access key, separated by a colon.
 func parseBasicAuth(encoded string) (awsCredentials *credentials.Credentials, ok bool) {
 	auth := strings.SplitN(encoded, " ", 2)
 	if len(auth) != 2 || auth[0] != "Basic" {
@@ -260,11 +685,15 @@ func parseBasicAuth(encoded string) (awsCredentials *credentials.Credentials, ok
 	if err != nil {
 		return nil, false
 	}
-	credentialsSlice := strings.SplitN(string(credentialsBytes), ":", 2)
-	if len(credentialsSlice) != 2 {
+	credentialsSlice := strings.SplitN(string(credentialsBytes), ":", 3)
+	if len(credentialsSlice) < 2 {
 		return nil, false
 	}
-	return credentials.NewStaticCredentials(credentialsSlice[0], credentialsSlice[1], ""), true
+	var sessionToken string
+	if len(credentialsSlice) == 3 {
+		sessionToken = credentialsSlice[2]
+	}
+	return credentials.NewStaticCredentials(credentialsSlice[0], credentialsSlice[1], sessionToken), true
 }
 
 // createLogger creates a new logger for the clients.
@@ -280,7 +709,7 @@ func (cfg *connectionConfig) createLogger() (logger log.Logger) {
 }
 
 // parseBoolFromStrings parses the boolean configuration options from the strings in connectionConfig.
-func (cfg *connectionConfig) parseBoolFromStrings(enableLogging, failOnLongMetricLabelName, failOnInvalidSample string) error {
+func (cfg *connectionConfig) parseBoolFromStrings(enableLogging, failOnLongMetricLabelName, failOnInvalidSample, retryOnAuthError string) error {
 	var err error
 
 	cfg.enableLogging, err = strconv.ParseBool(enableLogging)
@@ -304,9 +733,82 @@ func (cfg *connectionConfig) parseBoolFromStrings(enableLogging, failOnLongMetri
 		return timestreamError
 	}
 
+	cfg.retryOnAuthError, err = strconv.ParseBool(retryOnAuthError)
+	if err != nil {
+		timestreamError := errors.NewParseRetryOnAuthError(retryOnAuthError)
+		fmt.Println(timestreamError.Error())
+		return timestreamError
+	}
+
 	return nil
 }
 
+// parseList parses a comma-separated option into a slice of trimmed, non-empty values.
+func parseList(value string) []string {
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) != 0 {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// parseRoleARNs parses a comma-separated list of table=role-arn pairs into a map of the role ARN of each table. It
+// returns false if a pair is malformed or a table is listed more than once.
+func parseRoleARNs(value string) (map[string]string, bool) {
+	var roleARNs map[string]string
+	for _, pair := range parseList(value) {
+		table, roleARN, found := strings.Cut(pair, "=")
+		table, roleARN = strings.TrimSpace(table), strings.TrimSpace(roleARN)
+		if !found || len(table) == 0 || !strings.HasPrefix(roleARN, "arn:") {
+			return nil, false
+		}
+		if _, exists := roleARNs[table]; exists {
+			return nil, false
+		}
+		if roleARNs == nil {
+			roleARNs = make(map[string]string)
+		}
+		roleARNs[table] = roleARN
+	}
+	return roleARNs, true
+}
+
+// parseCostTags parses a comma-separated list of key=value pairs, returning false if a key is not a valid label name or
+// is repeated. The values must not be empty, as Timestream does not accept empty dimension values.
+func parseCostTags(value string) (map[string]string, bool) {
+	var costTags map[string]string
+	for _, pair := range parseList(value) {
+		key, tag, found := strings.Cut(pair, "=")
+		key, tag = strings.TrimSpace(key), strings.TrimSpace(tag)
+		if !found || !model.LabelName(key).IsValid() || len(tag) == 0 {
+			return nil, false
+		}
+		if _, exists := costTags[key]; exists {
+			return nil, false
+		}
+		if costTags == nil {
+			costTags = make(map[string]string)
+		}
+		costTags[key] = tag
+	}
+	return costTags, true
+}
+
+// resolveInstanceID returns the ID of the connector instance, which is the hostname if the value is hostname.
+func resolveInstanceID(value string) (string, error) {
+	if value != hostnameInstanceID {
+		return value, nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("unable to use the hostname as the instance ID: %w", err)
+	}
+	return hostname, nil
+}
+
 // getOrDefault returns the value if the key exists as an environment variable; returns the default value otherwise.
 func getOrDefault(key *configuration) string {
 	if value, exists := os.LookupEnv(key.envFlag); exists {
@@ -324,11 +826,17 @@ func parseEnvironmentVariables() (*connectionConfig, error) {
 	}
 
 	cfg.clientConfig.region = getOrDefault(regionConfig)
+	cfg.clientConfig.tlsMinVersion = getOrDefault(awsTLSMinVersionConfig)
+	if _, ok := tlsVersions[cfg.clientConfig.tlsMinVersion]; cfg.clientConfig.tlsMinVersion != "" && !ok {
+		return nil, errors.NewParseTLSMinVersionError(cfg.clientConfig.tlsMinVersion)
+	}
 	cfg.defaultDatabase = getOrDefault(defaultDatabaseConfig)
 	cfg.defaultTable = getOrDefault(defaultTableConfig)
+	cfg.certificate = getOrDefault(certificateConfig)
+	cfg.key = getOrDefault(keyConfig)
 
 	var err error
-	err = cfg.parseBoolFromStrings(getOrDefault(enableLogConfig), getOrDefault(failOnLabelConfig), getOrDefault(failOnInvalidSampleConfig))
+	err = cfg.parseBoolFromStrings(getOrDefault(enableLogConfig), getOrDefault(failOnLabelConfig), getOrDefault(failOnInvalidSampleConfig), getOrDefault(retryOnAuthErrorConfig))
 	if err != nil {
 		return nil, err
 	}
@@ -339,152 +847,1363 @@ func parseEnvironmentVariables() (*connectionConfig, error) {
 		return nil, errors.NewParseRetriesError(retries)
 	}
 
-	cfg.promlogConfig = promlog.Config{Level: &promlog.AllowedLevel{}, Format: &promlog.AllowedFormat{}}
-	cfg.promlogConfig.Level.Set(getOrDefault(promlogLevelConfig))
-	cfg.promlogConfig.Format.Set(getOrDefault(promlogFormatConfig))
+	retryBaseDelay := getOrDefault(retryBaseDelayConfig)
+	cfg.clientConfig.retryBaseDelay, err = time.ParseDuration(retryBaseDelay)
+	if err != nil || cfg.clientConfig.retryBaseDelay < 0 {
+		return nil, errors.NewParseDurationError(retryBaseDelayConfig.flag, retryBaseDelay)
+	}
 
-	return cfg, nil
-}
+	retryMaxBackoff := getOrDefault(retryMaxBackoffConfig)
+	cfg.clientConfig.retryMaxBackoff, err = time.ParseDuration(retryMaxBackoff)
+	if err != nil || cfg.clientConfig.retryMaxBackoff < 0 {
+		return nil, errors.NewParseDurationError(retryMaxBackoffConfig.flag, retryMaxBackoff)
+	}
 
-// parseFlags parses command line flags and return a connectionConfig pointer.
-func parseFlags() *connectionConfig {
-	a := kingpin.New(filepath.Base(os.Args[0]), "Remote storage adapter")
-	a.HelpFlag.Short('h')
+	maxSamplesPerSeries := getOrDefault(maxSamplesPerSeriesConfig)
+	cfg.maxSamplesPerSeries, err = strconv.Atoi(maxSamplesPerSeries)
+	if err != nil || cfg.maxSamplesPerSeries < 0 {
+		return nil, errors.NewParseMaxSamplesPerSeriesError(maxSamplesPerSeries)
+	}
 
-	cfg := &connectionConfig{
-		clientConfig:  &clientConfig{},
-		promlogConfig: promlog.Config{},
+	cardinalityTracking := getOrDefault(cardinalityTrackingConfig)
+	cfg.cardinalityTracking, err = strconv.Atoi(cardinalityTracking)
+	if err != nil || cfg.cardinalityTracking < 0 {
+		return nil, errors.NewParseCardinalityTrackingError(cardinalityTracking)
 	}
 
-	var enableLogging string
-	var failOnLongMetricLabelName string
-	var failOnInvalidSample string
+	maxIngestRate := getOrDefault(maxIngestRateConfig)
+	cfg.maxIngestRate, err = strconv.Atoi(maxIngestRate)
+	if err != nil || cfg.maxIngestRate < 0 {
+		return nil, errors.NewParseMaxIngestRateError(maxIngestRate)
+	}
 
-	a.Flag(enableLogConfig.flag, "Enables or disables logging in the connector. Default to 'true'.").Default(enableLogConfig.defaultValue).StringVar(&enableLogging)
-	a.Flag(regionConfig.flag, "The signing region for the Timestream service. Default to 'us-east-1'.").Default(regionConfig.defaultValue).StringVar(&cfg.clientConfig.region)
-	a.Flag(maxRetriesConfig.flag, "The maximum number of times the read request will be retried for failures. Default to 3.").Default(maxRetriesConfig.defaultValue).IntVar(&cfg.maxRetries)
-	a.Flag(defaultDatabaseConfig.flag, "The Prometheus label containing the database name for data ingestion.").Default(defaultDatabaseConfig.defaultValue).StringVar(&cfg.defaultDatabase)
-	a.Flag(defaultTableConfig.flag, "The Prometheus label containing the table name for data ingestion.").Default(defaultTableConfig.defaultValue).StringVar(&cfg.defaultTable)
-	a.Flag(listenAddrConfig.flag, "Address to listen on for web endpoints.").Default(listenAddrConfig.defaultValue).StringVar(&cfg.listenAddr)
-	a.Flag(telemetryPathConfig.flag, "Address to listen on for web endpoints.").Default(telemetryPathConfig.defaultValue).StringVar(&cfg.telemetryPath)
-	a.Flag(failOnLabelConfig.flag, "Enables or disables the option to halt the program immediately when a Prometheus Label name exceeds 256 bytes. Default to 'false'.").
-		Default(failOnLabelConfig.defaultValue).StringVar(&failOnLongMetricLabelName)
-	a.Flag(failOnInvalidSampleConfig.flag, "Enables or disables the option to halt the program immediately when a Sample contains a non-finite float value. Default to 'false'.").
-		Default(failOnInvalidSampleConfig.defaultValue).StringVar(&failOnInvalidSample)
-	a.Flag(certificateConfig.flag, "TLS server certificate file.").Default(certificateConfig.defaultValue).StringVar(&cfg.certificate)
-	a.Flag(keyConfig.flag, "TLS server private key file.").Default(keyConfig.defaultValue).StringVar(&cfg.key)
+	writeConcurrency := getOrDefault(writeConcurrencyConfig)
+	cfg.writeConcurrency, err = strconv.Atoi(writeConcurrency)
+	if err != nil || cfg.writeConcurrency < 1 {
+		return nil, errors.NewParseWriteConcurrencyError(writeConcurrency)
+	}
 
-	flag.AddFlags(a, &cfg.promlogConfig)
+	missingDestinationStatus := getOrDefault(missingDestinationConfig)
+	cfg.missingDestinationStatus, err = strconv.Atoi(missingDestinationStatus)
+	if err != nil || !isErrorStatus(cfg.missingDestinationStatus) {
+		return nil, errors.NewParseMissingDestinationStatusError(missingDestinationStatus)
+	}
 
-	if _, err := a.Parse(os.Args[1:]); err != nil {
-		kingpin.Errorf("error occurred while parsing command line flags: '%s'", err)
-		os.Exit(1)
+	lambdaContextDimensions := getOrDefault(lambdaDimensionsConfig)
+	if len(lambdaContextDimensions) != 0 {
+		for _, dimension := range strings.Split(lambdaContextDimensions, ",") {
+			dimension = strings.TrimSpace(dimension)
+			switch dimension {
+			case awsRequestIDDimension, functionNameDimension, functionVersionDimension:
+				cfg.lambdaContextDimensions = append(cfg.lambdaContextDimensions, dimension)
+			default:
+				return nil, errors.NewParseLambdaContextDimensionsError(lambdaContextDimensions)
+			}
+		}
 	}
 
-	if err := cfg.parseBoolFromStrings(enableLogging, failOnLongMetricLabelName, failOnInvalidSample); err != nil {
-		os.Exit(1)
+	cfg.lambdaReadEncoding = getOrDefault(lambdaReadEncodingConfig)
+	switch cfg.lambdaReadEncoding {
+	case snappyReadEncoding, gzipReadEncoding:
+	default:
+		return nil, errors.NewParseLambdaReadEncodingError(cfg.lambdaReadEncoding)
 	}
 
-	if cfg.defaultDatabase == "" {
-		kingpin.Errorf("The default database value must be set through the flag --default-database")
-		os.Exit(1)
+	credentialRetries := getOrDefault(credentialRetriesConfig)
+	cfg.credentialRetries, err = strconv.Atoi(credentialRetries)
+	if err != nil || cfg.credentialRetries < 0 || cfg.credentialRetries > maxCredentialRetries {
+		return nil, errors.NewParseCredentialRetriesError(credentialRetries)
 	}
 
-	if cfg.defaultTable == "" {
-		kingpin.Errorf("The default table value must be set through the flag --default-table")
-		os.Exit(1)
+	cfg.readTables = parseList(getOrDefault(readTablesConfig))
+	cfg.readDatabases = parseList(getOrDefault(readDatabasesConfig))
+
+	crossDatabaseReads := getOrDefault(crossDatabaseReadsConfig)
+	cfg.crossDatabaseReads, err = strconv.ParseBool(crossDatabaseReads)
+	if err != nil {
+		return nil, errors.NewParseBoolError(crossDatabaseReadsConfig.flag, crossDatabaseReads)
+	}
+	if cfg.crossDatabaseReads && len(cfg.readDatabases) == 0 {
+		return nil, errors.NewConflictingOptionsError(crossDatabaseReadsConfig.envFlag, "requires the read databases to be set through "+readDatabasesConfig.envFlag)
 	}
 
-	return cfg
-}
+	credentialProviders := getOrDefault(credentialProviderConfig)
+	cfg.credentialProviders = parseList(credentialProviders)
+	if !validCredentialProviders(cfg.credentialProviders) {
+		return nil, errors.NewParseCredentialProviderError(credentialProviders)
+	}
 
-// buildAWSConfig builds a aws.Config and return the pointer of the config.
-func (cfg *connectionConfig) buildAWSConfig() *aws.Config {
-	clientConfig := cfg.clientConfig
-	awsConfig := &aws.Config{
-		Region: aws.String(clientConfig.region),
+	writeRoleARNs := getOrDefault(writeRoleARNsConfig)
+	var ok bool
+	if cfg.writeRoleARNs, ok = parseRoleARNs(writeRoleARNs); !ok {
+		return nil, errors.NewParseWriteRoleARNsError(writeRoleARNs)
 	}
-	return awsConfig
-}
 
-// serve listens for requests and remote writes and reads to Timestream.
-func serve(logger log.Logger, address string, writers []writer, readers []reader, certificate string, key string) error {
-	http.HandleFunc("/write", createWriteHandler(logger, writers))
-	http.HandleFunc("/read", createReadHandler(logger, readers))
+	costTags := getOrDefault(costTagsConfig)
+	if cfg.costTags, ok = parseCostTags(costTags); !ok {
+		return nil, errors.NewParseCostTagsError(costTags)
+	}
 
-	server := http.Server{
-		Addr: address,
+	cfg.dumpRecordsFile = getOrDefault(dumpRecordsFileConfig)
+	if cfg.instanceID, err = resolveInstanceID(getOrDefault(instanceIDConfig)); err != nil {
+		return nil, err
+	}
+	cfg.deadLetterDir = getOrDefault(deadLetterDirConfig)
+	cfg.defaultMeasureName = getOrDefault(defaultMeasureNameConfig)
+	cfg.auditLog = getOrDefault(auditLogConfig)
+
+	memoryStoreRetention := getOrDefault(memoryRetentionConfig)
+	cfg.memoryStoreRetention, err = time.ParseDuration(memoryStoreRetention)
+	if err != nil || cfg.memoryStoreRetention < 0 {
+		return nil, errors.NewParseDurationError(memoryRetentionConfig.flag, memoryStoreRetention)
 	}
 
-	if certificate == "" || key == "" {
-		return server.ListenAndServe()
-	} else {
-		return server.ListenAndServeTLS(certificate, key)
+	magneticStoreRetention := getOrDefault(magneticRetentionConfig)
+	cfg.magneticStoreRetention, err = time.ParseDuration(magneticStoreRetention)
+	if err != nil || cfg.magneticStoreRetention < 0 {
+		return nil, errors.NewParseDurationError(magneticRetentionConfig.flag, magneticStoreRetention)
 	}
-}
 
-// createWriteHandler creates a handler func(ResponseWriter, *Request) to handle Prometheus write requests.
-func createWriteHandler(logger log.Logger, writers []writer) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		awsCredentials, authOk := parseBasicAuth(r.Header.Get(basicAuthHeader))
-		if !authOk {
-			err := errors.NewParseBasicAuthHeaderError()
-			timestream.LogError(logger, "Error occurred while parsing the basic authentication header.", err)
-			http.Error(w, err.(*errors.ParseBasicAuthHeaderError).Message(), http.StatusBadRequest)
-			return
-		}
+	magneticReadTimeout := getOrDefault(magneticTimeoutConfig)
+	cfg.magneticReadTimeout, err = time.ParseDuration(magneticReadTimeout)
+	if err != nil || cfg.magneticReadTimeout < 0 {
+		return nil, errors.NewParseDurationError(magneticTimeoutConfig.flag, magneticReadTimeout)
+	}
 
-		compressed, err := io.ReadAll(r.Body)
-		if err != nil {
-			timestream.LogError(logger, "Error occurred while reading the write request sent by Prometheus.", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	readTotalDeadline := getOrDefault(readTotalDeadlineConfig)
+	cfg.readTotalDeadline, err = time.ParseDuration(readTotalDeadline)
+	if err != nil || cfg.readTotalDeadline < 0 {
+		return nil, errors.NewParseDurationError(readTotalDeadlineConfig.flag, readTotalDeadline)
+	}
 
-		reqBuf, err := snappy.Decode(nil, compressed)
-		if err != nil {
-			timestream.LogError(logger, "Error occurred while decoding the write request from Prometheus.", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
+	maxReadRange := getOrDefault(maxReadRangeConfig)
+	cfg.maxReadRange, err = time.ParseDuration(maxReadRange)
+	if err != nil || cfg.maxReadRange < 0 {
+		return nil, errors.NewParseDurationError(maxReadRangeConfig.flag, maxReadRange)
+	}
 
-		var req prompb.WriteRequest
-		if err := proto.Unmarshal(reqBuf, &req); err != nil {
-			timestream.LogError(logger, "Error occurred while unmarshalling the decoded write request from Prometheus.", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
+	maxReadCost := getOrDefault(maxReadCostConfig)
+	cfg.maxReadCost, err = strconv.ParseFloat(maxReadCost, 64)
+	if err != nil || cfg.maxReadCost < 0 || math.IsNaN(cfg.maxReadCost) || math.IsInf(cfg.maxReadCost, 0) {
+		return nil, errors.NewParseMaxReadCostError(maxReadCost)
+	}
 
-		if err := writers[0].Write(&req, awsCredentials); err != nil {
-			switch err := err.(type) {
-			case awserr.RequestFailure:
-				http.Error(w, err.Error(), err.StatusCode())
-			case *errors.SDKNonRequestError:
-				http.Error(w, err.Error(), http.StatusBadRequest)
-			case *errors.MissingDatabaseWithWriteError:
-				http.Error(w, err.Error(), http.StatusBadRequest)
-			case *errors.MissingTableWithWriteError:
-				http.Error(w, err.Error(), http.StatusBadRequest)
-			default:
-				// Others will halt the program.
-				halt(1)
-			}
-		}
+	defaultLookback := getOrDefault(defaultLookbackConfig)
+	cfg.defaultLookback, err = time.ParseDuration(defaultLookback)
+	if err != nil || cfg.defaultLookback < 0 {
+		return nil, errors.NewParseDurationError(defaultLookbackConfig.flag, defaultLookback)
 	}
-}
 
-// createReadHandler creates a handler func(ResponseWriter, *Request) to handle Prometheus read requests.
-func createReadHandler(logger log.Logger, readers []reader) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		awsCredentials, authOk := parseBasicAuth(r.Header.Get(basicAuthHeader))
-		if !authOk {
-			err := errors.NewParseBasicAuthHeaderError()
-			timestream.LogError(logger, "Error occurred while parsing the basic authentication header.", err)
-			http.Error(w, err.(*errors.ParseBasicAuthHeaderError).Message(), http.StatusBadRequest)
-			return
-		}
+	preferRecent := getOrDefault(preferRecentConfig)
+	cfg.preferRecent, err = strconv.ParseBool(preferRecent)
+	if err != nil {
+		return nil, errors.NewParseBoolError(preferRecentConfig.flag, preferRecent)
+	}
+	if cfg.preferRecent && cfg.memoryStoreRetention == 0 {
+		return nil, errors.NewConflictingOptionsError(preferRecentConfig.envFlag, "requires the memory store retention to be set through "+memoryRetentionConfig.envFlag)
+	}
 
-		compressed, err := io.ReadAll(r.Body)
+	readPageSize := getOrDefault(readPageSizeConfig)
+	cfg.readPageSize, err = strconv.Atoi(readPageSize)
+	if err != nil || cfg.readPageSize < 0 || cfg.readPageSize > maxReadPageSize {
+		return nil, errors.NewParseReadPageSizeError(readPageSize)
+	}
+
+	schemaLagRetries := getOrDefault(schemaLagRetriesConfig)
+	cfg.schemaLagRetries, err = strconv.Atoi(schemaLagRetries)
+	if err != nil || cfg.schemaLagRetries < 0 || cfg.schemaLagRetries > maxSchemaLagRetries {
+		return nil, errors.NewParseSchemaLagRetriesError(schemaLagRetries)
+	}
+
+	normalizeMeasureNames := getOrDefault(normalizeNamesConfig)
+	cfg.normalizeMeasureNames, err = strconv.ParseBool(normalizeMeasureNames)
+	if err != nil {
+		return nil, errors.NewParseBoolError(normalizeNamesConfig.flag, normalizeMeasureNames)
+	}
+
+	numericQuantiles := getOrDefault(numericQuantilesConfig)
+	cfg.numericQuantiles, err = strconv.ParseBool(numericQuantiles)
+	if err != nil {
+		return nil, errors.NewParseBoolError(numericQuantilesConfig.flag, numericQuantiles)
+	}
+
+	emitSampleCount := getOrDefault(emitSampleCountConfig)
+	cfg.emitSampleCount, err = strconv.ParseBool(emitSampleCount)
+	if err != nil {
+		return nil, errors.NewParseBoolError(emitSampleCountConfig.flag, emitSampleCount)
+	}
+
+	emitSchemaVersion := getOrDefault(emitSchemaVersionConfig)
+	cfg.emitSchemaVersion, err = strconv.ParseBool(emitSchemaVersion)
+	if err != nil {
+		return nil, errors.NewParseBoolError(emitSchemaVersionConfig.flag, emitSchemaVersion)
+	}
+
+	rejectEmptyWrites := getOrDefault(rejectEmptyWritesConfig)
+	cfg.rejectEmptyWrites, err = strconv.ParseBool(rejectEmptyWrites)
+	if err != nil {
+		return nil, errors.NewParseBoolError(rejectEmptyWritesConfig.flag, rejectEmptyWrites)
+	}
+
+	clampTimestamps := getOrDefault(clampTimestampsConfig)
+	cfg.clampTimestamps, err = strconv.ParseBool(clampTimestamps)
+	if err != nil {
+		return nil, errors.NewParseBoolError(clampTimestampsConfig.flag, clampTimestamps)
+	}
+
+	enableMultiMeasure := getOrDefault(multiMeasureConfig)
+	cfg.enableMultiMeasure, err = strconv.ParseBool(enableMultiMeasure)
+	if err != nil {
+		return nil, errors.NewParseBoolError(multiMeasureConfig.flag, enableMultiMeasure)
+	}
+	if cfg.numericQuantiles && cfg.enableMultiMeasure {
+		return nil, errors.NewConflictingOptionsError(numericQuantilesConfig.envFlag, "cannot be used together with "+multiMeasureConfig.envFlag)
+	}
+
+	autoCreateDestinations := getOrDefault(autoCreateConfig)
+	cfg.autoCreateDestinations, err = strconv.ParseBool(autoCreateDestinations)
+	if err != nil {
+		return nil, errors.NewParseBoolError(autoCreateConfig.flag, autoCreateDestinations)
+	}
+	if cfg.autoCreateDestinations && !cfg.validAutoCreateRetention() {
+		return nil, errors.NewConflictingOptionsError(autoCreateConfig.envFlag, "requires a memory store retention of at least one hour and a magnetic store retention of at least one day")
+	}
+
+	cloudWatchMetrics := getOrDefault(cloudWatchMetricsConfig)
+	cfg.cloudWatchMetrics, err = strconv.ParseBool(cloudWatchMetrics)
+	if err != nil {
+		return nil, errors.NewParseBoolError(cloudWatchMetricsConfig.flag, cloudWatchMetrics)
+	}
+
+	logRequestID := getOrDefault(logRequestIDConfig)
+	cfg.logRequestID, err = strconv.ParseBool(logRequestID)
+	if err != nil {
+		return nil, errors.NewParseBoolError(logRequestIDConfig.flag, logRequestID)
+	}
+
+	caseInsensitive := getOrDefault(caseInsensitiveConfig)
+	cfg.caseInsensitive, err = strconv.ParseBool(caseInsensitive)
+	if err != nil {
+		return nil, errors.NewParseBoolError(caseInsensitiveConfig.flag, caseInsensitive)
+	}
+
+	combineReadQueries := getOrDefault(combineReadQueriesConfig)
+	cfg.combineReadQueries, err = strconv.ParseBool(combineReadQueries)
+	if err != nil {
+		return nil, errors.NewParseBoolError(combineReadQueriesConfig.flag, combineReadQueries)
+	}
+
+	requireMatcher := getOrDefault(requireMatcherConfig)
+	cfg.requireMatcher, err = strconv.ParseBool(requireMatcher)
+	if err != nil {
+		return nil, errors.NewParseBoolError(requireMatcherConfig.flag, requireMatcher)
+	}
+
+	returnPartialReads := getOrDefault(partialReadsConfig)
+	cfg.returnPartialReads, err = strconv.ParseBool(returnPartialReads)
+	if err != nil {
+		return nil, errors.NewParseBoolError(partialReadsConfig.flag, returnPartialReads)
+	}
+
+	readDebugColumns := getOrDefault(readDebugColumnsConfig)
+	cfg.readDebugColumns, err = strconv.ParseBool(readDebugColumns)
+	if err != nil {
+		return nil, errors.NewParseBoolError(readDebugColumnsConfig.flag, readDebugColumns)
+	}
+
+	cfg.dimensionOnlyReads = getOrDefault(dimensionOnlyReadsConfig)
+	switch cfg.dimensionOnlyReads {
+	case timestream.AllowDimensionOnlyReads, timestream.EmptyDimensionOnlyReads, timestream.RejectDimensionOnlyReads:
+	default:
+		return nil, errors.NewParseDimensionOnlyReadsError(cfg.dimensionOnlyReads)
+	}
+
+	cfg.reservedLabels = getOrDefault(reservedLabelsConfig)
+	switch cfg.reservedLabels {
+	case timestream.RenameReservedLabels, timestream.FailReservedLabels:
+	default:
+		return nil, errors.NewParseReservedLabelsError(cfg.reservedLabels)
+	}
+
+	cfg.recordVersionStrategy = getOrDefault(recordVersionConfig)
+	switch cfg.recordVersionStrategy {
+	case timestream.NoRecordVersion, timestream.TimestampRecordVersion, timestream.CounterRecordVersion, timestream.SampleRecordVersion:
+	default:
+		return nil, errors.NewParseRecordVersionStrategyError(cfg.recordVersionStrategy)
+	}
+
+	cfg.requireOrderedSamples = getOrDefault(orderedSamplesConfig)
+	switch cfg.requireOrderedSamples {
+	case timestream.OffOrderedSamples, timestream.WarnOrderedSamples, timestream.RejectOrderedSamples:
+	default:
+		return nil, errors.NewParseRequireOrderedSamplesError(cfg.requireOrderedSamples)
+	}
+
+	cfg.conflictingRecords = getOrDefault(conflictingRecordsConfig)
+	switch cfg.conflictingRecords {
+	case timestream.OffConflictingRecords, timestream.FirstConflictingRecords, timestream.LastConflictingRecords, timestream.ErrorConflictingRecords:
+	default:
+		return nil, errors.NewParseConflictingRecordsError(cfg.conflictingRecords)
+	}
+
+	cfg.duplicateSamples = getOrDefault(duplicateSamplesConfig)
+	switch cfg.duplicateSamples {
+	case timestream.OffDuplicateSamples, timestream.FirstDuplicateSamples, timestream.LastDuplicateSamples, timestream.MaxDuplicateSamples, timestream.ErrorDuplicateSamples:
+	default:
+		return nil, errors.NewParseDuplicateSamplesError(cfg.duplicateSamples)
+	}
+
+	cfg.requiredDimensions = parseList(getOrDefault(requiredDimensionsConfig))
+	cfg.perMetricStats = parseList(getOrDefault(perMetricStatsConfig))
+	cfg.missingDimensions = getOrDefault(missingDimensionsConfig)
+	switch cfg.missingDimensions {
+	case timestream.FailMissingDimensions, timestream.IgnoreMissingDimensions:
+	default:
+		return nil, errors.NewParseMissingDimensionsError(cfg.missingDimensions)
+	}
+	cfg.validateLabelNames = getOrDefault(validateLabelsConfig)
+	switch cfg.validateLabelNames {
+	case timestream.OffLabelNameValidation, timestream.FailLabelNameValidation, timestream.SanitizeLabelNameValidation, timestream.IgnoreLabelNameValidation:
+	default:
+		return nil, errors.NewParseValidateLabelNamesError(cfg.validateLabelNames)
+	}
+	cfg.expandJSONLabel = getOrDefault(expandJSONLabelConfig)
+	cfg.infBucketValue = getOrDefault(infBucketValueConfig)
+	cfg.databaseLabel = getOrDefault(databaseLabelConfig)
+	cfg.tableLabel = getOrDefault(tableLabelConfig)
+
+	cfg.stripNamespace = getOrDefault(stripNamespaceConfig)
+	if !isValidNamespace(cfg.stripNamespace) {
+		return nil, errors.NewParseMeasureNameNamespaceError(stripNamespaceConfig.envFlag, cfg.stripNamespace)
+	}
+
+	cfg.addNamespace = getOrDefault(addNamespaceConfig)
+	if !isValidNamespace(cfg.addNamespace) {
+		return nil, errors.NewParseMeasureNameNamespaceError(addNamespaceConfig.envFlag, cfg.addNamespace)
+	}
+
+	booleanMetrics := getOrDefault(booleanMetricsConfig)
+	if cfg.booleanMetrics, err = parseBooleanMetrics(booleanMetrics); err != nil {
+		return nil, errors.NewParseBooleanMetricsError(booleanMetrics)
+	}
+
+	cfg.measureValueType = getOrDefault(measureValueTypeConfig)
+	switch cfg.measureValueType {
+	case timestream.DoubleMeasureValueType, timestream.BigintMeasureValueType, timestream.AutoMeasureValueType:
+	default:
+		return nil, errors.NewParseMeasureValueTypeError(cfg.measureValueType)
+	}
+
+	cfg.nonFiniteReads = getOrDefault(nonFiniteReadsConfig)
+	switch cfg.nonFiniteReads {
+	case timestream.PassNonFiniteReads, timestream.SkipNonFiniteReads:
+	default:
+		return nil, errors.NewParseNonFiniteReadsError(cfg.nonFiniteReads)
+	}
+
+	cfg.timestampColumns = getOrDefault(timestampColumnsConfig)
+	switch cfg.timestampColumns {
+	case timestream.RawTimestampColumns, timestream.UnixTimestampColumns, timestream.RFC3339TimestampColumns, timestream.DropTimestampColumns:
+	default:
+		return nil, errors.NewParseTimestampColumnsError(cfg.timestampColumns)
+	}
+
+	cfg.promlogConfig = promlog.Config{Level: &promlog.AllowedLevel{}, Format: &promlog.AllowedFormat{}}
+	cfg.promlogConfig.Level.Set(getOrDefault(promlogLevelConfig))
+	cfg.promlogConfig.Format.Set(getOrDefault(promlogFormatConfig))
+
+	return cfg, nil
+}
+
+// parseFlags parses command line flags and return a connectionConfig pointer.
+func parseFlags() *connectionConfig {
+	a := kingpin.New(filepath.Base(os.Args[0]), "Remote storage adapter")
+	a.HelpFlag.Short('h')
+
+	cfg := &connectionConfig{
+		clientConfig:  &clientConfig{},
+		promlogConfig: promlog.Config{},
+	}
+
+	var enableLogging string
+	var failOnLongMetricLabelName string
+	var failOnInvalidSample string
+	var retryOnAuthError string
+	var readTables string
+	var readDatabases string
+	var writeRoleARNs string
+	var costTags string
+	var instanceID string
+	var requiredDimensions string
+	var perMetricStats string
+	var credentialProviders string
+	var booleanMetrics string
+
+	a.Flag(enableLogConfig.flag, "Enables or disables logging in the connector. Default to 'true'.").Default(enableLogConfig.defaultValue).StringVar(&enableLogging)
+	a.Flag(regionConfig.flag, "The signing region for the Timestream service. Default to 'us-east-1'.").Default(regionConfig.defaultValue).StringVar(&cfg.clientConfig.region)
+	a.Flag(awsTLSMinVersionConfig.flag, "The minimum TLS version of the connections to Timestream, one of '1.0', '1.1', '1.2' or '1.3'. Default to the minimum version of the AWS SDK.").Default(awsTLSMinVersionConfig.defaultValue).StringVar(&cfg.clientConfig.tlsMinVersion)
+	a.Flag(maxRetriesConfig.flag, "The maximum number of times the read request will be retried for failures. Default to 3.").Default(maxRetriesConfig.defaultValue).IntVar(&cfg.maxRetries)
+	a.Flag(retryBaseDelayConfig.flag, "The base delay of the exponential backoff with jitter between the retries of the Timestream requests, for both the failed and the throttled requests. Default to '0s', which uses the delays of the AWS SDK.").Default(retryBaseDelayConfig.defaultValue).DurationVar(&cfg.clientConfig.retryBaseDelay)
+	a.Flag(retryMaxBackoffConfig.flag, "The maximum delay between the retries of the Timestream requests, for both the failed and the throttled requests. Default to '0s', which uses the delays of the AWS SDK.").Default(retryMaxBackoffConfig.defaultValue).DurationVar(&cfg.clientConfig.retryMaxBackoff)
+	a.Flag(maxSamplesPerSeriesConfig.flag, "The maximum number of samples ingested per time series in a write request. Samples beyond the limit are ignored. Default to 0, which is unlimited.").Default(maxSamplesPerSeriesConfig.defaultValue).IntVar(&cfg.maxSamplesPerSeries)
+	a.Flag(cardinalityTrackingConfig.flag, "The maximum number of distinct measure names tracked per table every hour and exposed by the timestream_connector_distinct_measures gauge. A warning is logged when a table reaches the limit. Default to 0, which disables the tracking.").Default(cardinalityTrackingConfig.defaultValue).IntVar(&cfg.cardinalityTracking)
+	a.Flag(maxIngestRateConfig.flag, "The maximum rate of the received samples per second, measured over a sliding window of 10 seconds. Write requests exceeding the rate are rejected with 429 and a Retry-After header until the rate subsides. Default to 0, which disables the limit.").Default(maxIngestRateConfig.defaultValue).IntVar(&cfg.maxIngestRate)
+	a.Flag(writeConcurrencyConfig.flag, "The maximum number of WriteRecords requests sent concurrently for the tables of a write request. Default to 1, which writes the tables sequentially.").Default(writeConcurrencyConfig.defaultValue).IntVar(&cfg.writeConcurrency)
+	a.Flag(missingDestinationConfig.flag, "The HTTP status code of the responses to requests failing because the destination database or table is not configured, such as 422 for Prometheus to drop the requests instead of retrying them. Must be a 4xx or 5xx status code. Default to 400.").Default(missingDestinationConfig.defaultValue).IntVar(&cfg.missingDestinationStatus)
+	a.Flag(defaultDatabaseConfig.flag, "The Prometheus label containing the database name for data ingestion.").Default(defaultDatabaseConfig.defaultValue).StringVar(&cfg.defaultDatabase)
+	a.Flag(defaultTableConfig.flag, "The Prometheus label containing the table name for data ingestion.").Default(defaultTableConfig.defaultValue).StringVar(&cfg.defaultTable)
+	a.Flag(listenAddrConfig.flag, "Address to listen on for web endpoints.").Default(listenAddrConfig.defaultValue).StringVar(&cfg.listenAddr)
+	a.Flag(telemetryPathConfig.flag, "Address to listen on for web endpoints.").Default(telemetryPathConfig.defaultValue).StringVar(&cfg.telemetryPath)
+	a.Flag(failOnLabelConfig.flag, "Enables or disables the option to reject the write request with 400 when a Prometheus Label name exceeds 256 bytes. Default to 'false'.").
+		Default(failOnLabelConfig.defaultValue).StringVar(&failOnLongMetricLabelName)
+	a.Flag(failOnInvalidSampleConfig.flag, "Enables or disables the option to reject the write request with 400 when a Sample contains a non-finite float value. Default to 'false'.").
+		Default(failOnInvalidSampleConfig.defaultValue).StringVar(&failOnInvalidSample)
+	a.Flag(retryOnAuthErrorConfig.flag, "Enables or disables retrying the write request once when Timestream rejects the credentials, such as while newly rotated credentials propagate. The credentials of the credential provider chain are refreshed before the retry, the credentials of the request are retried as is. Default to 'true'.").
+		Default(retryOnAuthErrorConfig.defaultValue).StringVar(&retryOnAuthError)
+	a.Flag(credentialProviderConfig.flag, "A comma-separated list of credential providers, tried in order, for the requests without a basic authentication header: 'env', 'shared', 'imds', 'process' or 'sso'. Requests without a basic authentication header are rejected if unset.").Default(credentialProviderConfig.defaultValue).StringVar(&credentialProviders)
+	a.Flag(writeRoleARNsConfig.flag, "A comma-separated list of table=role-arn pairs, the writes to a listed table assume the IAM role with the credentials of the request. Disabled by default.").Default(writeRoleARNsConfig.defaultValue).StringVar(&writeRoleARNs)
+	a.Flag(costTagsConfig.flag, "A comma-separated list of key=value pairs written as dimensions of every ingested record, to allocate the Timestream costs per team with queries grouped by these dimensions. The keys must be valid label names and overwrite the labels with the same names. Disabled by default.").Default(costTagsConfig.defaultValue).StringVar(&costTags)
+	// The TLS options fall back to the environment variables so containerized deployments can enable TLS without command line flags.
+	a.Flag(certificateConfig.flag, "TLS server certificate file.").Default(getOrDefault(certificateConfig)).StringVar(&cfg.certificate)
+	a.Flag(keyConfig.flag, "TLS server private key file.").Default(getOrDefault(keyConfig)).StringVar(&cfg.key)
+	a.Flag(reservedLabelsConfig.flag, "How to handle labels colliding with the column names reserved by Timestream: 'rename' prefixes them with 'label_' on ingestion and restores them on reads, 'fail' rejects the write request. Default to 'rename'.").
+		Default(reservedLabelsConfig.defaultValue).EnumVar(&cfg.reservedLabels, timestream.RenameReservedLabels, timestream.FailReservedLabels)
+	a.Flag(recordVersionConfig.flag, "The strategy of populating the version of the ingested records, so later records overwrite the existing records: 'none', 'timestamp', 'counter' or 'sample'. Default to 'none'.").
+		Default(recordVersionConfig.defaultValue).EnumVar(&cfg.recordVersionStrategy, timestream.NoRecordVersion, timestream.TimestampRecordVersion, timestream.CounterRecordVersion, timestream.SampleRecordVersion)
+	a.Flag(orderedSamplesConfig.flag, "How to handle time series with samples not in ascending timestamp order: 'off' does not validate the order, 'warn' logs a warning, 'reject' fails the write request. Default to 'off'.").
+		Default(orderedSamplesConfig.defaultValue).EnumVar(&cfg.requireOrderedSamples, timestream.OffOrderedSamples, timestream.WarnOrderedSamples, timestream.RejectOrderedSamples)
+	a.Flag(conflictingRecordsConfig.flag, "How to resolve samples of a write request with the same labels and timestamp but different values: 'off' writes all of them, 'first' or 'last' keeps the first or the last sample, 'error' fails the write request. Default to 'off'.").
+		Default(conflictingRecordsConfig.defaultValue).EnumVar(&cfg.conflictingRecords, timestream.OffConflictingRecords, timestream.FirstConflictingRecords, timestream.LastConflictingRecords, timestream.ErrorConflictingRecords)
+	a.Flag(duplicateSamplesConfig.flag, "How to resolve samples of a single time series with the same timestamp but different values: 'off' writes all of them, 'first', 'last' or 'max' keeps the first, the last or the largest sample, 'error' fails the write request. Default to 'off'.").
+		Default(duplicateSamplesConfig.defaultValue).EnumVar(&cfg.duplicateSamples, timestream.OffDuplicateSamples, timestream.FirstDuplicateSamples, timestream.LastDuplicateSamples, timestream.MaxDuplicateSamples, timestream.ErrorDuplicateSamples)
+	a.Flag(requiredDimensionsConfig.flag, "A comma-separated list of labels every time series must have, such as 'job,instance', to keep the dimensions of the tables consistent. Disabled by default.").Default(requiredDimensionsConfig.defaultValue).StringVar(&requiredDimensions)
+	a.Flag(perMetricStatsConfig.flag, "A comma-separated list of metric names, such as 'up,http_requests_total', whose ingested samples are counted in the timestream_connector_metric_ingested_samples_total counter with a metric label. Disabled by default.").Default(perMetricStatsConfig.defaultValue).StringVar(&perMetricStats)
+	a.Flag(missingDimensionsConfig.flag, "How to handle time series missing any of the required dimensions: 'ignore' drops the time series, 'fail' rejects the write request. Default to 'ignore'.").
+		Default(missingDimensionsConfig.defaultValue).EnumVar(&cfg.missingDimensions, timestream.FailMissingDimensions, timestream.IgnoreMissingDimensions)
+	a.Flag(validateLabelsConfig.flag, "How to handle label names not matching the Prometheus label name pattern [a-zA-Z_][a-zA-Z0-9_]*: 'off' writes them as they are, 'fail' rejects the write request, 'sanitize' replaces the invalid characters with underscores, 'ignore' drops the time series. Default to 'off'.").
+		Default(validateLabelsConfig.defaultValue).EnumVar(&cfg.validateLabelNames, timestream.OffLabelNameValidation, timestream.FailLabelNameValidation, timestream.SanitizeLabelNameValidation, timestream.IgnoreLabelNameValidation)
+	a.Flag(expandJSONLabelConfig.flag, "The name of a label holding a JSON object, whose keys are written as separate dimensions instead of the label. Labels with invalid JSON are kept as they are. Disabled by default.").Default(expandJSONLabelConfig.defaultValue).StringVar(&cfg.expandJSONLabel)
+	a.Flag(infBucketValueConfig.flag, "The value the 'le' label of the +Inf histogram buckets is stored as, such as 'inf'. Any 'le' value parsed as positive infinity, such as '+Inf' or 'Inf', is stored as this value, matched by the equality matchers of reads, and read back as '+Inf'. Disabled by default, which stores the 'le' labels as they are.").Default(infBucketValueConfig.defaultValue).StringVar(&cfg.infBucketValue)
+	a.Flag(databaseLabelConfig.flag, "The label holding the database to write each time series to, which is removed from the dimensions. The time series without the label are written to the default database. Disabled by default.").Default(databaseLabelConfig.defaultValue).StringVar(&cfg.databaseLabel)
+	a.Flag(tableLabelConfig.flag, "The label holding the table to write each time series to, which is removed from the dimensions. The time series without the label are written to the default table. Disabled by default.").Default(tableLabelConfig.defaultValue).StringVar(&cfg.tableLabel)
+	a.Flag(stripNamespaceConfig.flag, "A namespace prefix, such as 'federated_', stripped from the metric names starting with it before they are stored as measure names. The measure names must not exceed 60 bytes after the prefix is stripped. Disabled by default.").Default(stripNamespaceConfig.defaultValue).StringVar(&cfg.stripNamespace)
+	a.Flag(addNamespaceConfig.flag, "A namespace prefix, such as 'federated_', added to the measure names read back as metric names. The metric name matchers of reads are matched against the measure names with the prefix. Disabled by default.").Default(addNamespaceConfig.defaultValue).StringVar(&cfg.addNamespace)
+	a.Flag(booleanMetricsConfig.flag, "A regular expression matching the whole measure names of the boolean metrics, such as 'up|.*_info', whose samples are written as boolean measures and read back as 0 or 1. The samples of these metrics other than 0 or 1 are ignored. Disabled by default.").Default(booleanMetricsConfig.defaultValue).StringVar(&booleanMetrics)
+	a.Flag(measureValueTypeConfig.flag, "The measure value type of the ingested samples: 'double' writes every sample as DOUBLE, 'bigint' writes the samples as BIGINT and ignores the non-integral samples, 'auto' writes the integral samples as BIGINT and the others as DOUBLE. Default to 'double'.").
+		Default(measureValueTypeConfig.defaultValue).EnumVar(&cfg.measureValueType, timestream.DoubleMeasureValueType, timestream.BigintMeasureValueType, timestream.AutoMeasureValueType)
+	a.Flag(nonFiniteReadsConfig.flag, "How to handle NaN and infinite values read from Timestream: 'pass' returns them to Prometheus as is, 'skip' drops the samples. Default to 'pass'.").
+		Default(nonFiniteReadsConfig.defaultValue).EnumVar(&cfg.nonFiniteReads, timestream.PassNonFiniteReads, timestream.SkipNonFiniteReads)
+	a.Flag(timestampColumnsConfig.flag, "How to read the timestamp columns other than the time column as labels: 'raw' keeps the Timestream format, 'unix-ms' formats them as milliseconds since the epoch, 'rfc3339' formats them in RFC 3339, 'drop' removes them. Default to 'raw'.").
+		Default(timestampColumnsConfig.defaultValue).EnumVar(&cfg.timestampColumns, timestream.RawTimestampColumns, timestream.UnixTimestampColumns, timestream.RFC3339TimestampColumns, timestream.DropTimestampColumns)
+	a.Flag(dimensionOnlyReadsConfig.flag, "How to handle read requests without a metric name matcher: 'allow' queries by labels only, 'empty' returns no results, 'reject' returns an error. Default to 'allow'.").
+		Default(dimensionOnlyReadsConfig.defaultValue).EnumVar(&cfg.dimensionOnlyReads, timestream.AllowDimensionOnlyReads, timestream.EmptyDimensionOnlyReads, timestream.RejectDimensionOnlyReads)
+	a.Flag(readTablesConfig.flag, "A comma-separated list of tables in the default database to read from and merge the results of. Default to the default table.").Default(readTablesConfig.defaultValue).StringVar(&readTables)
+	a.Flag(readDatabasesConfig.flag, "A comma-separated list of databases to read from and merge the results of when cross-database reads are enabled. Each database is read from the read tables, or the default table.").Default(readDatabasesConfig.defaultValue).StringVar(&readDatabases)
+	a.Flag(crossDatabaseReadsConfig.flag, "Reads from every database of the read databases and merges the series matching the read request. Requires the read databases. Default to 'false'.").Default(crossDatabaseReadsConfig.defaultValue).BoolVar(&cfg.crossDatabaseReads)
+	a.Flag(defaultMeasureNameConfig.flag, "The measure name of the time series without a metric name. Time series without a metric name are rejected by Timestream if not set.").Default(defaultMeasureNameConfig.defaultValue).StringVar(&cfg.defaultMeasureName)
+	a.Flag(normalizeNamesConfig.flag, "Replaces the colons of the metric names, such as the names of recording rules, with periods in the measure names, and restores the colons on reads. Default to 'false'.").Default(normalizeNamesConfig.defaultValue).BoolVar(&cfg.normalizeMeasureNames)
+	a.Flag(numericQuantilesConfig.flag, "Writes the samples of the summary quantiles as multi-measure records with the sample as the 'sample_value' measure and the quantile as the 'quantile_value' double measure, in addition to the quantile dimension, and reads them back as the original samples. Cannot be used with enable-multi-measure. Default to 'false'.").Default(numericQuantilesConfig.defaultValue).BoolVar(&cfg.numericQuantiles)
+	a.Flag(emitSampleCountConfig.flag, "Writes a companion record with the number of samples ingested for each time series of a write request, under the measure name suffixed with '__sample_count__'. Default to 'false'.").Default(emitSampleCountConfig.defaultValue).BoolVar(&cfg.emitSampleCount)
+	a.Flag(emitSchemaVersionConfig.flag, "Adds the '__schema_version__' dimension with the version of the record layout of the connector to every record, so readers can distinguish the records written with different layouts. Default to 'false'.").Default(emitSchemaVersionConfig.defaultValue).BoolVar(&cfg.emitSchemaVersion)
+	a.Flag(rejectEmptyWritesConfig.flag, "Rejects the write requests without any time series with 400 instead of accepting them as a no-op. Default to 'false'.").Default(rejectEmptyWritesConfig.defaultValue).BoolVar(&cfg.rejectEmptyWrites)
+	a.Flag(clampTimestampsConfig.flag, "Moves the timestamps of the samples older than the memory-store-retention or more than 15 minutes in the future to the nearest boundary of the memory store window, instead of Timestream rejecting them. Default to 'false'.").Default(clampTimestampsConfig.defaultValue).BoolVar(&cfg.clampTimestamps)
+	a.Flag(multiMeasureConfig.flag, "Groups the samples with the same labels and timestamp into a single multi-measure record under the measure name 'prometheus_metrics', with a measure named after each metric name. Reading the multi-measure records is not supported. Default to 'false'.").Default(multiMeasureConfig.defaultValue).BoolVar(&cfg.enableMultiMeasure)
+	a.Flag(autoCreateConfig.flag, "Creates the database and table of a write request when Timestream rejects it because they do not exist, with the memory-store-retention and magnetic-store-retention, then retries the write request once. Default to 'false'.").Default(autoCreateConfig.defaultValue).BoolVar(&cfg.autoCreateDestinations)
+	a.Flag(logRequestIDConfig.flag, "Adds a request ID to every log line of a request, honouring the X-Request-ID header or otherwise generating one, and returns it in the X-Request-ID response header. Default to 'false'.").Default(logRequestIDConfig.defaultValue).BoolVar(&cfg.logRequestID)
+	a.Flag(cloudWatchMetricsConfig.flag, "Publishes the connector metrics to CloudWatch in the embedded metric format on the standard output, after every request on AWS Lambda and every minute otherwise. Default to 'false'.").Default(cloudWatchMetricsConfig.defaultValue).BoolVar(&cfg.cloudWatchMetrics)
+	a.Flag(auditLogConfig.flag, "The sink of the audit entries emitted for each successful write, either 'stdout' or the path of a file to append the entries to as JSON lines. Disabled by default.").Default(auditLogConfig.defaultValue).StringVar(&cfg.auditLog)
+	a.Flag(dumpRecordsFileConfig.flag, "The path of a file to append the Timestream Records converted from each write request to as JSON lines, for verifying the label to Record mapping. Disabled by default.").Default(dumpRecordsFileConfig.defaultValue).StringVar(&cfg.dumpRecordsFile)
+	a.Flag(instanceIDConfig.flag, "The ID of the connector instance added as the 'connector_instance_id' dimension on every record, or 'hostname' to use the hostname. Disabled by default.").Default(instanceIDConfig.defaultValue).StringVar(&instanceID)
+	a.Flag(deadLetterDirConfig.flag, "The directory to write the records of the write requests failed with an error Prometheus does not retry to, one JSON file per failed request that can be replayed with the AWS CLI. Disabled by default.").Default(deadLetterDirConfig.defaultValue).StringVar(&cfg.deadLetterDir)
+	a.Flag(memoryRetentionConfig.flag, "The memory store retention period of the tables, used to detect read requests only spanning data in the magnetic store. Default to '0s', which disables the detection.").Default(memoryRetentionConfig.defaultValue).DurationVar(&cfg.memoryStoreRetention)
+	a.Flag(magneticRetentionConfig.flag, "The magnetic store retention period of the tables created with auto-create-destinations. Default to '0s', which uses the default retention of Timestream.").Default(magneticRetentionConfig.defaultValue).DurationVar(&cfg.magneticStoreRetention)
+	a.Flag(magneticTimeoutConfig.flag, "The timeout of read requests only spanning data in the magnetic store. Default to '0s', which does not apply a timeout.").Default(magneticTimeoutConfig.defaultValue).DurationVar(&cfg.magneticReadTimeout)
+	a.Flag(readTotalDeadlineConfig.flag, "The maximum duration of all the queries and pages of a read request together, after which the read is cancelled and fails with 504. Unlike read-handler-timeout, the deadline also applies in AWS Lambda. Default to '0s', which does not apply a deadline.").Default(readTotalDeadlineConfig.defaultValue).DurationVar(&cfg.readTotalDeadline)
+	a.Flag(maxReadRangeConfig.flag, "The maximum time range of a read query, queries spanning a longer time range are rejected. Default to '0s', which is unlimited.").Default(maxReadRangeConfig.defaultValue).DurationVar(&cfg.maxReadRange)
+	a.Flag(maxReadCostConfig.flag, "The maximum estimated cost of a read request, read requests with a higher estimated cost are rejected before querying Timestream. The cost is estimated as the hours of the time range of each query for each table read, multiplied by 10 for queries without a metric name and halved for each other label matched by equality. Default to 0, which is unlimited.").Default(maxReadCostConfig.defaultValue).Float64Var(&cfg.maxReadCost)
+	a.Flag(defaultLookbackConfig.flag, "The time range ending now of a read query without a time range, such as a query with a zero start and end timestamp. Default to '0s', which queries the time range of the request as is.").Default(defaultLookbackConfig.defaultValue).DurationVar(&cfg.defaultLookback)
+	a.Flag(preferRecentConfig.flag, "Splits the read queries crossing the memory store retention, so the recent data in the memory store is queried first and the magnetic store only for the remainder of the range. Requires the memory store retention. Default to 'false'.").Default(preferRecentConfig.defaultValue).BoolVar(&cfg.preferRecent)
+	a.Flag(readPageSizeConfig.flag, "The maximum number of rows of each page of the read query results, between 1 and 1000. Larger pages need fewer round trips to Timestream but more memory. Default to 0, which uses the Timestream default.").Default(readPageSizeConfig.defaultValue).IntVar(&cfg.readPageSize)
+	a.Flag(schemaLagRetriesConfig.flag, "The maximum number of times, up to 5, a read query is retried with an exponential backoff starting at 1s when Timestream does not yet recognize a column of a newly written dimension. Default to 0, which does not retry.").Default(schemaLagRetriesConfig.defaultValue).IntVar(&cfg.schemaLagRetries)
+	a.Flag(caseInsensitiveConfig.flag, "Compares the values of the equality and inequality matchers of read requests case-insensitively. This prevents Timestream from using the values to prune the data scanned, which makes the queries slower and more expensive. Default to 'false'.").Default(caseInsensitiveConfig.defaultValue).BoolVar(&cfg.caseInsensitive)
+	a.Flag(combineReadQueriesConfig.flag, "Combines the queries of a read request with the same time range into a single Timestream query per table, matching the matchers of any of the queries. Default to 'false'.").Default(combineReadQueriesConfig.defaultValue).BoolVar(&cfg.combineReadQueries)
+	a.Flag(requireMatcherConfig.flag, "Rejects the queries of read requests without any label matcher, which scan every row of the table within the time range. Default to 'false'.").Default(requireMatcherConfig.defaultValue).BoolVar(&cfg.requireMatcher)
+	a.Flag(partialReadsConfig.flag, "Returns the time series converted before a query of a read request fails, such as on a later page, instead of failing the read request. Partial reads are logged and counted in timestream_connector_partial_reads_total. Default to 'false'.").Default(partialReadsConfig.defaultValue).BoolVar(&cfg.returnPartialReads)
+	a.Flag(readDebugColumnsConfig.flag, "Attaches the raw value of each Timestream column other than the time, measure name and measure value columns as a '__timestream_column_' prefixed meta-label on the series of read requests, to diagnose schema mismatches. Default to 'false'.").Default(readDebugColumnsConfig.defaultValue).BoolVar(&cfg.readDebugColumns)
+	a.Flag(enableAdminConfig.flag, "Enables the admin endpoints, such as /admin/reset-metrics. Intended for test environments only. Default to 'false'.").Default(enableAdminConfig.defaultValue).BoolVar(&cfg.enableAdmin)
+	a.Flag(enableOpenMetricsConfig.flag, "Serves the connector metrics in the OpenMetrics format with exemplars when requested by the scraper. Default to 'false'.").Default(enableOpenMetricsConfig.defaultValue).BoolVar(&cfg.enableOpenMetrics)
+	a.Flag(maxConcurrencyConfig.flag, "The maximum number of concurrent Timestream API calls shared by read and write requests. Default to 0, which is unlimited.").Default(maxConcurrencyConfig.defaultValue).IntVar(&cfg.maxConcurrency)
+	a.Flag(maxRPSConfig.flag, "The maximum number of Timestream API calls per second shared by read and write requests. The calls beyond the limit are queued. Default to 0, which is unlimited.").Default(maxRPSConfig.defaultValue).IntVar(&cfg.maxRPS)
+	a.Flag(maxInFlightBytesConfig.flag, "The maximum approximate size in bytes of the decoded write requests in progress, further write requests are rejected with 503 until the size drops. Default to 0, which is unlimited.").Default(maxInFlightBytesConfig.defaultValue).Int64Var(&cfg.maxInFlightBytes)
+	a.Flag(mirrorWriteURLConfig.flag, "The URL of a secondary remote-write endpoint every write request is also forwarded to, such as the previous backend while migrating to Timestream. Failures of the mirror do not fail the write request. Disabled by default.").Default(mirrorWriteURLConfig.defaultValue).StringVar(&cfg.mirrorWriteURL)
+	a.Flag(readHandlerTimeoutConfig.flag, "The maximum duration of a read request, after which the pagination of the query results is cancelled and 504 is returned. Should not exceed the remote read timeout of Prometheus. Default to '0s', which does not apply a timeout.").Default(readHandlerTimeoutConfig.defaultValue).DurationVar(&cfg.readHandlerTimeout)
+	a.Flag(writeBatchWindowConfig.flag, "The duration for which the concurrent write requests with the same credentials are coalesced into a single write to Timestream. The coalesced write requests are written again on their own if the combined write fails with a client error. Default to '0s', which disables the coalescing.").Default(writeBatchWindowConfig.defaultValue).DurationVar(&cfg.writeBatchWindow)
+	a.Flag(warmupConnectionsConfig.flag, "The number of connections to Timestream established at startup by describing the default table concurrently, so the first write requests do not pay for the TLS handshakes. The idle connections kept per host are raised to the same number. Default to 0, which disables the warmup.").Default(warmupConnectionsConfig.defaultValue).IntVar(&cfg.warmupConnections)
+	a.Flag(healthErrorRatioConfig.flag, "The maximum ratio, between 0 and 1, of the failed Timestream writes within the last minute before the /health endpoint responds 503. Default to 0, which disables the check.").Default(healthErrorRatioConfig.defaultValue).Float64Var(&cfg.healthMaxErrorRatio)
+	a.Flag(expectedMemoryConfig.flag, "The expected memory store retention period of the default table, validated against the table at startup with a warning logged on mismatch. Default to '0s', which disables the validation.").Default(expectedMemoryConfig.defaultValue).DurationVar(&cfg.expectedMemoryRetention)
+	a.Flag(expectedMagneticConfig.flag, "The expected magnetic store retention period of the default table, validated against the table at startup with a warning logged on mismatch. Default to '0s', which disables the validation.").Default(expectedMagneticConfig.defaultValue).DurationVar(&cfg.expectedMagneticRetention)
+	a.Flag(rollupTableConfig.flag, "The table to write the aggregated rollup records to. Rollups are disabled if unspecified.").Default(rollupTableConfig.defaultValue).StringVar(&cfg.rollupTable)
+	a.Flag(rollupWindowConfig.flag, "The duration of each rollup aggregation window. Default to '1m'.").Default(rollupWindowConfig.defaultValue).DurationVar(&cfg.rollupWindow)
+
+	flag.AddFlags(a, &cfg.promlogConfig)
+
+	if _, err := a.Parse(os.Args[1:]); err != nil {
+		kingpin.Errorf("error occurred while parsing command line flags: '%s'", err)
+		os.Exit(1)
+	}
+	cfg.features = enabledFeatures(a.Model().Flags)
+
+	if err := cfg.parseBoolFromStrings(enableLogging, failOnLongMetricLabelName, failOnInvalidSample, retryOnAuthError); err != nil {
+		os.Exit(1)
+	}
+
+	cfg.readTables = parseList(readTables)
+	cfg.readDatabases = parseList(readDatabases)
+	cfg.requiredDimensions = parseList(requiredDimensions)
+	cfg.perMetricStats = parseList(perMetricStats)
+
+	cfg.credentialProviders = parseList(credentialProviders)
+	var validationErrors []error
+	if !validCredentialProviders(cfg.credentialProviders) {
+		validationErrors = append(validationErrors, fmt.Errorf("the credential providers must be a comma-separated list of 'env', 'shared', 'imds', 'process' or 'sso', but received '%s'", credentialProviders))
+	}
+
+	var err error
+	if cfg.booleanMetrics, err = parseBooleanMetrics(booleanMetrics); err != nil {
+		validationErrors = append(validationErrors, fmt.Errorf("the boolean metrics must be a regular expression, but received '%s'", booleanMetrics))
+	}
+
+	var ok bool
+	if cfg.writeRoleARNs, ok = parseRoleARNs(writeRoleARNs); !ok {
+		validationErrors = append(validationErrors, fmt.Errorf("the write role ARNs must be a comma-separated list of table=role-arn pairs, but received '%s'", writeRoleARNs))
+	}
+
+	if cfg.costTags, ok = parseCostTags(costTags); !ok {
+		validationErrors = append(validationErrors, fmt.Errorf("the cost tags must be a comma-separated list of key=value pairs, but received '%s'", costTags))
+	}
+
+	if cfg.instanceID, err = resolveInstanceID(instanceID); err != nil {
+		validationErrors = append(validationErrors, err)
+	}
+
+	// Report all invalid options at once, rather than exiting on the first one.
+	if validationErrors = append(validationErrors, cfg.validate()...); len(validationErrors) != 0 {
+		messages := make([]string, 0, len(validationErrors))
+		for _, err := range validationErrors {
+			messages = append(messages, "  - "+err.Error())
+		}
+		kingpin.Errorf("found %d invalid configuration options:\n%s", len(validationErrors), strings.Join(messages, "\n"))
+		os.Exit(1)
+	}
+
+	return cfg
+}
+
+// validate returns the errors of all invalid or conflicting configuration options parsed from the command line flags.
+func (cfg *connectionConfig) validate() []error {
+	var validationErrors []error
+	if cfg.defaultDatabase == "" {
+		validationErrors = append(validationErrors, fmt.Errorf("the default database value must be set through the flag --default-database"))
+	}
+
+	if cfg.defaultTable == "" {
+		validationErrors = append(validationErrors, fmt.Errorf("the default table value must be set through the flag --default-table"))
+	}
+
+	if !regionPattern.MatchString(cfg.clientConfig.region) {
+		validationErrors = append(validationErrors, fmt.Errorf("the region must be an AWS Region code such as 'us-east-1', but received '%s'", cfg.clientConfig.region))
+	}
+
+	if _, ok := tlsVersions[cfg.clientConfig.tlsMinVersion]; cfg.clientConfig.tlsMinVersion != "" && !ok {
+		validationErrors = append(validationErrors, fmt.Errorf("the AWS TLS minimum version must be one of '1.0', '1.1', '1.2' or '1.3', but received '%s'", cfg.clientConfig.tlsMinVersion))
+	}
+
+	if (cfg.certificate == "") != (cfg.key == "") {
+		validationErrors = append(validationErrors, fmt.Errorf("the TLS certificate and key must be set together, but received certificate '%s' and key '%s'", cfg.certificate, cfg.key))
+	}
+
+	if len(cfg.deadLetterDir) != 0 {
+		if info, err := os.Stat(cfg.deadLetterDir); err != nil || !info.IsDir() {
+			validationErrors = append(validationErrors, fmt.Errorf("the dead-letter directory must be an existing directory, but received '%s'", cfg.deadLetterDir))
+		}
+	}
+
+	if cfg.maxSamplesPerSeries < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the maximum number of samples per series must not be negative, but received '%d'", cfg.maxSamplesPerSeries))
+	}
+
+	if cfg.cardinalityTracking < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the cardinality tracking limit must not be negative, but received '%d'", cfg.cardinalityTracking))
+	}
+
+	if cfg.maxIngestRate < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the maximum ingestion rate must not be negative, but received '%d'", cfg.maxIngestRate))
+	}
+
+	if !isValidNamespace(cfg.stripNamespace) || !isValidNamespace(cfg.addNamespace) {
+		validationErrors = append(validationErrors, fmt.Errorf("the measure name namespaces must be valid metric name prefixes, but received '%s' and '%s'", cfg.stripNamespace, cfg.addNamespace))
+	}
+
+	if !isErrorStatus(cfg.missingDestinationStatus) {
+		validationErrors = append(validationErrors, fmt.Errorf("the missing destination status must be a 4xx or 5xx HTTP status code, but received '%d'", cfg.missingDestinationStatus))
+	}
+
+	if cfg.magneticStoreRetention < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the magnetic store retention must not be negative, but received '%s'", cfg.magneticStoreRetention))
+	}
+	if cfg.autoCreateDestinations && !cfg.validAutoCreateRetention() {
+		validationErrors = append(validationErrors, fmt.Errorf("the auto-create-destinations option requires a memory store retention of at least one hour and a magnetic store retention of at least one day, but received '%s' and '%s'", cfg.memoryStoreRetention, cfg.magneticStoreRetention))
+	}
+	if cfg.memoryStoreRetention < 0 || cfg.magneticReadTimeout < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the memory store retention and the magnetic read timeout must not be negative, but received '%s' and '%s'", cfg.memoryStoreRetention, cfg.magneticReadTimeout))
+	}
+
+	if cfg.readTotalDeadline < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the read total deadline must not be negative, but received '%s'", cfg.readTotalDeadline))
+	}
+
+	if cfg.expectedMemoryRetention < 0 || cfg.expectedMagneticRetention < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the expected memory and magnetic store retention must not be negative, but received '%s' and '%s'", cfg.expectedMemoryRetention, cfg.expectedMagneticRetention))
+	}
+
+	if cfg.crossDatabaseReads && len(cfg.readDatabases) == 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the cross-database-reads option requires the read databases to be set through the flag --read-databases"))
+	}
+
+	if cfg.preferRecent && cfg.memoryStoreRetention == 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the prefer-recent option requires the memory store retention to be set through the flag --memory-store-retention"))
+	}
+
+	if cfg.maxConcurrency < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the maximum Timestream concurrency must not be negative, but received '%d'", cfg.maxConcurrency))
+	}
+
+	if cfg.maxRPS < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the maximum Timestream requests per second must not be negative, but received '%d'", cfg.maxRPS))
+	}
+
+	if cfg.writeConcurrency < 1 {
+		validationErrors = append(validationErrors, fmt.Errorf("the write concurrency must be at least 1, but received '%d'", cfg.writeConcurrency))
+	}
+	if cfg.warmupConnections < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the number of warmup connections must not be negative, but received '%d'", cfg.warmupConnections))
+	}
+	if cfg.writeBatchWindow < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the write batch window must not be negative, but received '%s'", cfg.writeBatchWindow))
+	}
+
+	if cfg.healthMaxErrorRatio < 0 || cfg.healthMaxErrorRatio > 1 {
+		validationErrors = append(validationErrors, fmt.Errorf("the health maximum error ratio must be between 0 and 1, but received '%g'", cfg.healthMaxErrorRatio))
+	}
+
+	if cfg.maxInFlightBytes < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the maximum in-flight bytes must not be negative, but received '%d'", cfg.maxInFlightBytes))
+	}
+
+	if len(cfg.mirrorWriteURL) != 0 {
+		if mirrorURL, err := url.Parse(cfg.mirrorWriteURL); err != nil || (mirrorURL.Scheme != "http" && mirrorURL.Scheme != "https") || len(mirrorURL.Host) == 0 {
+			validationErrors = append(validationErrors, fmt.Errorf("the mirror write URL must be an absolute http or https URL, but received '%s'", cfg.mirrorWriteURL))
+		}
+	}
+
+	if cfg.readHandlerTimeout < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the read handler timeout must not be negative, but received '%s'", cfg.readHandlerTimeout))
+	}
+
+	if cfg.readPageSize < 0 || cfg.readPageSize > maxReadPageSize {
+		validationErrors = append(validationErrors, fmt.Errorf("the read page size must be between 0 and %d, but received '%d'", maxReadPageSize, cfg.readPageSize))
+	}
+
+	if cfg.schemaLagRetries < 0 || cfg.schemaLagRetries > maxSchemaLagRetries {
+		validationErrors = append(validationErrors, fmt.Errorf("the schema lag retries must be between 0 and %d, but received '%d'", maxSchemaLagRetries, cfg.schemaLagRetries))
+	}
+
+	if cfg.numericQuantiles && cfg.enableMultiMeasure {
+		validationErrors = append(validationErrors, fmt.Errorf("the numeric-quantiles and enable-multi-measure options cannot be used together"))
+	}
+	if cfg.clientConfig.retryBaseDelay < 0 || cfg.clientConfig.retryMaxBackoff < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the retry base delay and the retry max backoff must not be negative, but received '%s' and '%s'", cfg.clientConfig.retryBaseDelay, cfg.clientConfig.retryMaxBackoff))
+	}
+	if cfg.maxReadRange < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the maximum read range must not be negative, but received '%s'", cfg.maxReadRange))
+	}
+	if cfg.maxReadCost < 0 || math.IsNaN(cfg.maxReadCost) || math.IsInf(cfg.maxReadCost, 0) {
+		validationErrors = append(validationErrors, fmt.Errorf("the maximum read cost must be a non-negative number, but received '%f'", cfg.maxReadCost))
+	}
+
+	if cfg.defaultLookback < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the default lookback must not be negative, but received '%s'", cfg.defaultLookback))
+	}
+
+	if cfg.rollupWindow <= 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("the rollup window must be a positive duration, but received '%s'", cfg.rollupWindow))
+	}
+
+	return validationErrors
+}
+
+// validAutoCreateRetention returns whether the store retention of the auto-created tables is accepted by Timestream,
+// which requires at least one hour in the memory store and one day in the magnetic store. Zero uses the defaults,
+// and negative retentions are reported separately.
+func (cfg *connectionConfig) validAutoCreateRetention() bool {
+	return (cfg.memoryStoreRetention <= 0 || cfg.memoryStoreRetention >= time.Hour) &&
+		(cfg.magneticStoreRetention <= 0 || cfg.magneticStoreRetention >= 24*time.Hour)
+}
+
+// writeClientOptions returns the options of the write client from the connector configuration.
+func (cfg *connectionConfig) writeClientOptions() timestream.WriteClientOptions {
+	return timestream.WriteClientOptions{
+		FailOnLongMetricLabelName: cfg.failOnLongMetricLabelName,
+		FailOnInvalidSample:       cfg.failOnInvalidSample,
+		MaxSamplesPerSeries:       cfg.maxSamplesPerSeries,
+		RetryOnAuthError:          cfg.retryOnAuthError,
+		DumpRecordsFile:           cfg.dumpRecordsFile,
+		DefaultMeasureName:        cfg.defaultMeasureName,
+		ReservedLabels:            cfg.reservedLabels,
+		AuditLog:                  cfg.auditLog,
+		RecordVersionStrategy:     cfg.recordVersionStrategy,
+		RequireOrderedSamples:     cfg.requireOrderedSamples,
+		WriteRoleARNs:             cfg.writeRoleARNs,
+		CostTags:                  cfg.costTags,
+		DeadLetterDir:             cfg.deadLetterDir,
+		ConflictingRecords:        cfg.conflictingRecords,
+		DuplicateSamples:          cfg.duplicateSamples,
+		InstanceID:                cfg.instanceID,
+		RequiredDimensions:        cfg.requiredDimensions,
+		PerMetricStats:            cfg.perMetricStats,
+		MissingDimensions:         cfg.missingDimensions,
+		ValidateLabelNames:        cfg.validateLabelNames,
+		ExpandJSONLabel:           cfg.expandJSONLabel,
+		InfBucketValue:            cfg.infBucketValue,
+		DatabaseLabel:             cfg.databaseLabel,
+		TableLabel:                cfg.tableLabel,
+		StripNamespace:            cfg.stripNamespace,
+		BooleanMetrics:            cfg.booleanMetrics,
+		MeasureValueType:          cfg.measureValueType,
+		NormalizeMeasureNames:     cfg.normalizeMeasureNames,
+		NumericQuantiles:          cfg.numericQuantiles,
+		EmitSampleCount:           cfg.emitSampleCount,
+		EmitSchemaVersion:         cfg.emitSchemaVersion,
+		RejectEmptyWrites:         cfg.rejectEmptyWrites,
+		ClampTimestamps:           cfg.clampTimestamps,
+		EnableMultiMeasure:        cfg.enableMultiMeasure,
+		MemoryStoreRetention:      cfg.memoryStoreRetention,
+		MagneticStoreRetention:    cfg.magneticStoreRetention,
+		AutoCreateDestinations:    cfg.autoCreateDestinations,
+		CardinalityTracking:       cfg.cardinalityTracking,
+		MaxIngestRate:             cfg.maxIngestRate,
+		WriteConcurrency:          cfg.writeConcurrency,
+	}
+}
+
+// queryClientOptions returns the options of the query client from the connector configuration.
+func (cfg *connectionConfig) queryClientOptions() timestream.QueryClientOptions {
+	return timestream.QueryClientOptions{
+		DimensionOnlyReads:    cfg.dimensionOnlyReads,
+		ReadTables:            cfg.readTables,
+		ReadDatabases:         cfg.readDatabases,
+		CrossDatabaseReads:    cfg.crossDatabaseReads,
+		MemoryStoreRetention:  cfg.memoryStoreRetention,
+		MagneticReadTimeout:   cfg.magneticReadTimeout,
+		ReadTotalDeadline:     cfg.readTotalDeadline,
+		MaxReadRange:          cfg.maxReadRange,
+		MaxReadCost:           cfg.maxReadCost,
+		NonFiniteReads:        cfg.nonFiniteReads,
+		TimestampColumns:      cfg.timestampColumns,
+		DefaultLookback:       cfg.defaultLookback,
+		PreferRecent:          cfg.preferRecent,
+		CaseInsensitive:       cfg.caseInsensitive,
+		CombineReadQueries:    cfg.combineReadQueries,
+		RequireMatcher:        cfg.requireMatcher,
+		ReturnPartialReads:    cfg.returnPartialReads,
+		ReadDebugColumns:      cfg.readDebugColumns,
+		ReadPageSize:          cfg.readPageSize,
+		SchemaLagRetries:      cfg.schemaLagRetries,
+		NormalizeMeasureNames: cfg.normalizeMeasureNames,
+		NumericQuantiles:      cfg.numericQuantiles,
+		InfBucketValue:        cfg.infBucketValue,
+		AddNamespace:          cfg.addNamespace,
+	}
+}
+
+// buildAWSConfig builds a aws.Config and return the pointer of the config.
+func (cfg *connectionConfig) buildAWSConfig() *aws.Config {
+	clientConfig := cfg.clientConfig
+	awsConfig := &aws.Config{
+		Region: aws.String(clientConfig.region),
+	}
+	if len(cfg.credentialProviders) != 0 {
+		awsConfig.Credentials = credentials.NewChainCredentials(credentialProviderChain(cfg.credentialProviders))
+	}
+	minVersion, hasMinVersion := tlsVersions[clientConfig.tlsMinVersion]
+	if hasMinVersion || cfg.warmupConnections > http.DefaultMaxIdleConnsPerHost {
+		// Clone the default transport to keep its proxy and timeout settings.
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if hasMinVersion {
+			if transport.TLSClientConfig == nil {
+				transport.TLSClientConfig = &tls.Config{}
+			}
+			transport.TLSClientConfig.MinVersion = minVersion
+		}
+		if cfg.warmupConnections > http.DefaultMaxIdleConnsPerHost {
+			// Keep the warmed up connections idle in the pool instead of closing all but the default two.
+			transport.MaxIdleConnsPerHost = cfg.warmupConnections
+		}
+		awsConfig.HTTPClient = &http.Client{Transport: transport}
+	}
+	if clientConfig.retryBaseDelay > 0 || clientConfig.retryMaxBackoff > 0 {
+		// The delays left to zero use the defaults of the AWS SDK.
+		awsConfig.Retryer = awsClient.DefaultRetryer{
+			NumMaxRetries:    awsClient.DefaultRetryerMaxNumRetries,
+			MinRetryDelay:    clientConfig.retryBaseDelay,
+			MinThrottleDelay: clientConfig.retryBaseDelay,
+			MaxRetryDelay:    clientConfig.retryMaxBackoff,
+			MaxThrottleDelay: clientConfig.retryMaxBackoff,
+		}
+	}
+	return awsConfig
+}
+
+// setMaxRetries sets the maximum number of retries of the AWS configuration, including of the retryer configured with
+// the retry delays.
+func setMaxRetries(awsConfig *aws.Config, maxRetries int) {
+	awsConfig.MaxRetries = aws.Int(maxRetries)
+	if retryer, ok := awsConfig.Retryer.(awsClient.DefaultRetryer); ok {
+		retryer.NumMaxRetries = maxRetries
+		awsConfig.Retryer = retryer
+	}
+}
+
+// publishCloudWatchMetrics publishes the connector metrics to CloudWatch, logging the errors.
+func publishCloudWatchMetrics(logger log.Logger, publisher *timestream.CloudWatchPublisher) {
+	if err := publisher.Publish(time.Now().UnixNano() / int64(time.Millisecond)); err != nil {
+		timestream.LogError(logger, "Unable to publish the connector metrics to CloudWatch.", err)
+	}
+}
+
+// credentialProviderChain returns the credential providers with the given names, in the same order.
+func credentialProviderChain(names []string) []credentials.Provider {
+	var providers []credentials.Provider
+	for _, name := range names {
+		switch name {
+		case envCredentialProvider:
+			providers = append(providers, &credentials.EnvProvider{})
+		case sharedCredentialProvider:
+			providers = append(providers, &credentials.SharedCredentialsProvider{})
+		case imdsCredentialProvider:
+			providers = append(providers, &ec2rolecreds.EC2RoleProvider{Client: ec2metadata.New(session.Must(session.NewSession()))})
+		case processCredentialProvider:
+			providers = append(providers, &profileCredentialsProvider{providerName: processcreds.ProviderName})
+		case ssoCredentialProvider:
+			providers = append(providers, &profileCredentialsProvider{providerName: ssocreds.ProviderName})
+		}
+	}
+	return providers
+}
+
+// profileCredentialsProvider retrieves the credentials configured by the profile of the shared config file, selected by
+// the AWS_PROFILE environment variable, such as the credential_process or sso_* settings. The shared config file is only
+// loaded on the first retrieval, and the credentials are rejected unless they come from the SDK provider with
+// providerName, so the chain moves on to the next provider when the profile configures other credentials.
+type profileCredentialsProvider struct {
+	providerName string
+	once         sync.Once
+	credentials  *credentials.Credentials
+	err          error
+}
+
+func (p *profileCredentialsProvider) Retrieve() (credentials.Value, error) {
+	p.once.Do(func() {
+		sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+		if err != nil {
+			p.err = err
+			return
+		}
+		p.credentials = sess.Config.Credentials
+	})
+	if p.err != nil {
+		return credentials.Value{ProviderName: p.providerName}, p.err
+	}
+
+	value, err := p.credentials.Get()
+	if err != nil {
+		return credentials.Value{ProviderName: p.providerName}, err
+	}
+	if value.ProviderName != p.providerName {
+		return credentials.Value{ProviderName: p.providerName}, fmt.Errorf("the shared config profile configures %s credentials rather than %s credentials", value.ProviderName, p.providerName)
+	}
+	return value, nil
+}
+
+func (p *profileCredentialsProvider) IsExpired() bool {
+	return p.credentials == nil || p.credentials.IsExpired()
+}
+
+// validCredentialProviders returns true if every credential provider name is one of the accepted names.
+func validCredentialProviders(names []string) bool {
+	for _, name := range names {
+		switch name {
+		case envCredentialProvider, sharedCredentialProvider, imdsCredentialProvider, processCredentialProvider, ssoCredentialProvider:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// flushOnShutdown flushes the remaining rollup windows to Timestream before the connector exits on SIGINT or SIGTERM.
+func flushOnShutdown(logger log.Logger, rollupClient *timestream.RollupClient) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	timestream.LogInfo(logger, "Flushing the remaining rollup records before shutting down.")
+	if err := rollupClient.Stop(); err != nil {
+		timestream.LogError(logger, "Error occurred while flushing the remaining rollup records.", err)
+		halt(1)
+	}
+	halt(0)
+}
+
+// createTelemetryHandler creates the handler serving the metrics of the connector. The OpenMetrics format, which exposes
+// the exemplars attached to the latency histograms, is negotiated with the scraper if enableOpenMetrics is set.
+func createTelemetryHandler(enableOpenMetrics bool) http.Handler {
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: enableOpenMetrics}),
+	)
+}
+
+// serve listens for requests and remote writes and reads to Timestream, with the handlers configured by cfg. Requests
+// without a basic authentication header use the credential provider chain if one is configured.
+func serve(logger log.Logger, cfg *connectionConfig, writers []writer, readers []reader, readiness readinessChecker) error {
+	allowDefaultCredentials := len(cfg.credentialProviders) != 0
+	// The write requests rejected by the in-flight bytes limit are retried by Prometheus, so they are not mirrored.
+	writeHandler := limitInFlightBytes(logger, cfg.maxInFlightBytes, mirrorWriteRequests(logger, cfg.mirrorWriteURL, &http.Client{Timeout: mirrorWriteTimeout}, createWriteHandler(logger, writers, allowDefaultCredentials, cfg.missingDestinationStatus)))
+	readHandler := createReadHandler(logger, readers, allowDefaultCredentials, cfg.readHandlerTimeout, cfg.missingDestinationStatus)
+	if cfg.logRequestID {
+		writeHandler = withRequestID(writeHandler)
+		readHandler = withRequestID(readHandler)
+	}
+	http.HandleFunc("/write", writeHandler)
+	http.HandleFunc("/read", readHandler)
+	http.HandleFunc("/-/healthy", healthyHandler)
+	http.HandleFunc("/-/ready", createReadyHandler(logger, readiness, readinessTimeout))
+
+	server := http.Server{
+		Addr: cfg.listenAddr,
+	}
+
+	if cfg.certificate == "" || cfg.key == "" {
+		return server.ListenAndServe()
+	} else {
+		return server.ListenAndServeTLS(cfg.certificate, cfg.key)
+	}
+}
+
+// createWriteHandler creates a handler func(ResponseWriter, *Request) to handle Prometheus write requests. Requests
+// without a basic authentication header use the credentials of the client configuration if allowDefaultCredentials is set.
+func createWriteHandler(logger log.Logger, writers []writer, allowDefaultCredentials bool, missingDestinationStatus int) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := timestream.ContextLogger(r.Context(), logger)
+		awsCredentials, authOk := parseBasicAuth(r.Header.Get(basicAuthHeader))
+		if !authOk && !(len(r.Header.Get(basicAuthHeader)) == 0 && allowDefaultCredentials) {
+			err := errors.NewParseBasicAuthHeaderError()
+			timestream.LogError(logger, "Error occurred while parsing the basic authentication header.", err)
+			setErrorTypeHeader(w.Header(), err)
+			http.Error(w, err.(*errors.ParseBasicAuthHeaderError).Message(), http.StatusBadRequest)
+			return
+		}
+
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			timestream.LogError(logger, "Error occurred while reading the write request sent by Prometheus.", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		reqBuf, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			timestream.LogError(logger, "Error occurred while decoding the write request from Prometheus.", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var req prompb.WriteRequest
+		if err := proto.Unmarshal(reqBuf, &req); err != nil {
+			timestream.LogError(logger, "Error occurred while unmarshalling the decoded write request from Prometheus.", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := writers[0].WriteWithContext(r.Context(), &req, awsCredentials); err != nil {
+			setErrorTypeHeader(w.Header(), err)
+			switch err := err.(type) {
+			case awserr.RequestFailure:
+				http.Error(w, err.Error(), err.StatusCode())
+			case *errors.SDKNonRequestError:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case *errors.MissingDatabaseWithWriteError:
+				http.Error(w, err.Error(), missingDestinationStatus)
+			case *errors.MissingTableWithWriteError:
+				http.Error(w, err.Error(), missingDestinationStatus)
+			case *errors.UnorderedSamplesError:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case *errors.ConflictingRecordsError:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case *errors.DuplicateSamplesError:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case *errors.MissingRequiredDimensionError:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case *errors.EmptyWriteRequestError:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case *errors.LongLabelNameError:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case *errors.InvalidSampleValueError:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case *errors.ReservedLabelNameError:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case *errors.AmbiguousLabelNameError:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case *errors.InvalidLabelNameError:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case *errors.IngestRateExceededError:
+				w.Header().Set(retryAfterHeader, retryAfterSeconds(err.RetryAfter()))
+				http.Error(w, err.Error(), err.StatusCode())
+			case connectorError:
+				// Other connector errors carry their own status code, rather than a 500 retried forever by Prometheus.
+				http.Error(w, err.Error(), err.StatusCode())
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		}
+	}
+}
+
+// limitInFlightBytes wraps a write handler to reject the requests with 503 while the approximate size of the decoded
+// write requests in progress would exceed maxBytes, so Prometheus backs off and retries them later. A request is always
+// accepted when no other request is in progress, otherwise a single request larger than maxBytes would never succeed.
+// A maxBytes of 0 disables the limit.
+func limitInFlightBytes(logger log.Logger, maxBytes int64, next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	if maxBytes <= 0 {
+		return next
+	}
+
+	var inFlightBytes int64
+	return func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			timestream.LogError(logger, "Error occurred while reading the write request sent by Prometheus.", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The size of an invalid request is left to the write handler to reject.
+		size, err := snappy.DecodedLen(compressed)
+		if err != nil {
+			size = len(compressed)
+		}
+
+		if total := atomic.AddInt64(&inFlightBytes, int64(size)); total > maxBytes && total != int64(size) {
+			atomic.AddInt64(&inFlightBytes, -int64(size))
+			timestream.LogDebug(logger, "Rejected the write request as the in-flight bytes limit is exceeded.", "size", size, "maxInFlightBytes", maxBytes)
+			http.Error(w, fmt.Sprintf("The write requests in progress exceed the maximum of %d bytes, retry later.", maxBytes), http.StatusServiceUnavailable)
+			return
+		}
+		defer atomic.AddInt64(&inFlightBytes, -int64(size))
+
+		r.Body = io.NopCloser(bytes.NewReader(compressed))
+		next(w, r)
+	}
+}
+
+// mirrorWriteRequests wraps a write handler to forward the original snappy-compressed payload of every write request to
+// the remote-write endpoint at mirrorURL alongside the Timestream write, such as to dual-write to the previous backend
+// while migrating to Timestream. The failures of the mirror are logged and counted separately and never fail the write
+// request, and the response does not wait for the mirror, which completes in the background bounded by the timeout of
+// the client. An empty mirrorURL disables the mirror.
+func mirrorWriteRequests(logger log.Logger, mirrorURL string, client *http.Client, next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	if len(mirrorURL) == 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			timestream.LogError(logger, "Error occurred while reading the write request sent by Prometheus.", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(compressed))
+
+		// The mirror outlives the request and its context, so it only keeps the logger and a copy of the headers.
+		go mirrorWriteRequest(timestream.ContextLogger(r.Context(), logger), client, mirrorURL, r.Header.Clone(), compressed)
+		next(w, r)
+	}
+}
+
+// batchingWriter coalesces the write requests with the same credentials arriving within a window into a single write
+// request, so the time series of many small write requests are converted and written to Timestream in a single pass.
+// Every coalesced write request waits for the end of its window and returns the outcome of the combined write request,
+// unless the combined write request failed with a client error, which may be caused by the time series of a single
+// write request. Each coalesced write request is then written on its own and returns its own outcome, so a valid write
+// request is never rejected for the time series of another one.
+type batchingWriter struct {
+	next    writer
+	window  time.Duration
+	mutex   sync.Mutex
+	batches map[string]*writeBatch
+}
+
+// writeBatch is the combined write request of the write requests coalesced within a window. The combined write request
+// is logged with the request IDs of all the coalesced write requests.
+type writeBatch struct {
+	req         prompb.WriteRequest
+	requestIDs  []string
+	requests    int
+	credentials *credentials.Credentials
+	done        chan struct{}
+	err         error
+}
+
+// newBatchingWriter creates a writer coalescing the write requests arriving within the window before writing them with
+// the next writer.
+func newBatchingWriter(next writer, window time.Duration) *batchingWriter {
+	return &batchingWriter{
+		next:    next,
+		window:  window,
+		batches: make(map[string]*writeBatch),
+	}
+}
+
+// WriteWithContext adds the time series of the write request to the batch of its credentials, starting a new batch
+// written after the window if there is none, and waits for the batch to be written. If the batch of several write
+// requests failed with a client error, the write request is written again on its own.
+func (bw *batchingWriter) WriteWithContext(ctx context.Context, req *prompb.WriteRequest, credentials *credentials.Credentials) error {
+	key := credentialsKey(credentials)
+
+	bw.mutex.Lock()
+	batch, ok := bw.batches[key]
+	if !ok {
+		batch = &writeBatch{credentials: credentials, done: make(chan struct{})}
+		bw.batches[key] = batch
+		time.AfterFunc(bw.window, func() { bw.flush(key, batch) })
+	}
+	batch.req.Timeseries = append(batch.req.Timeseries, req.Timeseries...)
+	if requestID := timestream.RequestID(ctx); len(requestID) != 0 {
+		batch.requestIDs = append(batch.requestIDs, requestID)
+	}
+	batch.requests++
+	bw.mutex.Unlock()
+
+	<-batch.done
+	if batch.err != nil && batch.requests > 1 && isClientError(batch.err) {
+		return bw.next.WriteWithContext(ctx, req, credentials)
+	}
+	return batch.err
+}
+
+// flush writes the batch once its window has ended, the write requests arriving meanwhile start a new batch.
+func (bw *batchingWriter) flush(key string, batch *writeBatch) {
+	bw.mutex.Lock()
+	delete(bw.batches, key)
+	bw.mutex.Unlock()
+
+	ctx := context.Background()
+	if len(batch.requestIDs) != 0 {
+		ctx = timestream.ContextWithRequestID(ctx, strings.Join(batch.requestIDs, ","))
+	}
+	batch.err = bw.next.WriteWithContext(ctx, &batch.req, batch.credentials)
+	close(batch.done)
+}
+
+func (bw *batchingWriter) Name() string {
+	return bw.next.Name()
+}
+
+// credentialsKey returns the identity of the credentials of a write request, so only the write requests with the same
+// credentials are coalesced. The write requests without credentials use the credentials of the client configuration.
+func credentialsKey(awsCredentials *credentials.Credentials) string {
+	if awsCredentials == nil {
+		return ""
+	}
+	value, err := awsCredentials.Get()
+	if err != nil {
+		return ""
+	}
+	return strings.Join([]string{value.AccessKeyID, value.SecretAccessKey, value.SessionToken}, ":")
+}
+
+// mirrorWriteRequest forwards the compressed payload of the write request to the mirror URL and records the result.
+func mirrorWriteRequest(logger log.Logger, client *http.Client, mirrorURL string, header http.Header, compressed []byte) {
+	mirrorRequest, err := http.NewRequest(http.MethodPost, mirrorURL, bytes.NewReader(compressed))
+	if err != nil {
+		mirrorWrites.WithLabelValues("failure").Inc()
+		timestream.LogError(logger, "Unable to create the write request to the mirror.", err)
+		return
+	}
+	for _, mirrorHeader := range mirrorWriteHeaders {
+		if value := header.Get(mirrorHeader); len(value) != 0 {
+			mirrorRequest.Header.Set(mirrorHeader, value)
+		}
+	}
+
+	response, err := client.Do(mirrorRequest)
+	if err != nil {
+		mirrorWrites.WithLabelValues("failure").Inc()
+		timestream.LogError(logger, "Unable to forward the write request to the mirror.", err)
+		return
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode/100 != 2 {
+		mirrorWrites.WithLabelValues("failure").Inc()
+		timestream.LogError(logger, "The mirror rejected the write request.", fmt.Errorf("the mirror returned status %d", response.StatusCode), "statusCode", response.StatusCode)
+		return
+	}
+	mirrorWrites.WithLabelValues("success").Inc()
+}
+
+// withRequestID wraps a handler to serve every request with a request ID in its context, which is added to the log
+// lines of the request. The ID sent by the client in the X-Request-ID header is used if it is safe to log, otherwise a
+// new ID is generated. The ID is returned in the X-Request-ID response header.
+func withRequestID(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := resolveRequestID(r.Header.Get(requestIDHeader))
+		w.Header().Set(requestIDHeader, requestID)
+		next(w, r.WithContext(timestream.ContextWithRequestID(r.Context(), requestID)))
+	}
+}
+
+// resolveRequestID returns the request ID sent by the client if it matches requestIDPattern, otherwise a new random ID.
+func resolveRequestID(requestID string) string {
+	if requestIDPattern.MatchString(requestID) {
+		return requestID
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(id)
+}
+
+// createResetMetricsHandler creates a handler func(ResponseWriter, *Request) to reset the metrics of the connector.
+func createResetMetricsHandler(logger log.Logger, resetter metricsResetter) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST requests are supported.", http.StatusMethodNotAllowed)
+			return
+		}
+
+		resetter.ResetMetrics()
+		timestream.LogInfo(logger, "The connector metrics have been reset.")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// healthyHandler responds 200 as long as the connector is serving requests.
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "Healthy.")
+}
+
+// createReadyHandler creates the handler of the readiness endpoint, which responds 503 until the connector can reach
+// Timestream within the timeout, and 200 from then on.
+func createReadyHandler(logger log.Logger, readiness readinessChecker, timeout time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	var ready int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&ready) == 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			if err := readiness.CheckReady(ctx); err != nil {
+				timestream.LogDebug(logger, "The connector is not ready to serve requests yet.", "error", err)
+				http.Error(w, "Not ready.", http.StatusServiceUnavailable)
+				return
+			}
+			atomic.StoreInt32(&ready, 1)
+		}
+		fmt.Fprintln(w, "Ready.")
+	}
+}
+
+// createHealthHandler creates the handler of the health endpoint, which responds 503 if the ratio of the failed
+// WriteRecords calls within the last minute exceeds maxErrorRatio, so load balancers route away from a connector with a
+// failing Timestream dependency, and 200 otherwise. A maxErrorRatio of 0 disables the check.
+func createHealthHandler(reporter errorRatioReporter, maxErrorRatio float64) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Only GET and HEAD requests are supported.", http.StatusMethodNotAllowed)
+			return
+		}
+
+		errorRatio, writes := reporter.RecentErrorRatio()
+		if maxErrorRatio > 0 && errorRatio > maxErrorRatio {
+			http.Error(w, fmt.Sprintf("Unhealthy: %.0f%% of the %d recent Timestream writes failed.", errorRatio*100, writes), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "Healthy.")
+	}
+}
+
+// enabledFeatures returns the value of every flag set to a value other than its default, keyed by the flag name. The
+// help and hidden flags added by kingpin are not connector features.
+func enabledFeatures(flags []*kingpin.FlagModel) map[string]string {
+	features := make(map[string]string)
+	for _, f := range flags {
+		if f.Hidden || f.Name == "help" {
+			continue
+		}
+		if value := f.String(); !isDefaultFlagValue(value, strings.Join(f.Default, ",")) {
+			features[f.Name] = value
+		}
+	}
+	return features
+}
+
+// isDefaultFlagValue reports whether value is the default value of a flag. Duration flags are formatted differently than
+// their defaults, such as '1m0s' for '1m', so durations are compared by value.
+func isDefaultFlagValue(value, defaultValue string) bool {
+	if value == defaultValue {
+		return true
+	}
+	valueDuration, err := time.ParseDuration(value)
+	if err != nil {
+		return false
+	}
+	defaultDuration, err := time.ParseDuration(defaultValue)
+	return err == nil && valueDuration == defaultDuration
+}
+
+// createFeaturesHandler creates a handler func(ResponseWriter, *Request) responding with the enabled optional features
+// of the connector and their configuration as a JSON object, to help support identify how the connector is set up.
+func createFeaturesHandler(features map[string]string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET requests are supported.", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(features)
+	}
+}
+
+// createReadHandler creates a handler func(ResponseWriter, *Request) to handle Prometheus read requests. Requests
+// without a basic authentication header use the credentials of the client configuration if allowDefaultCredentials is set.
+// Reads taking longer than a positive timeout are cancelled and answered with 504.
+func createReadHandler(logger log.Logger, readers []reader, allowDefaultCredentials bool, timeout time.Duration, missingDestinationStatus int) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := timestream.ContextLogger(r.Context(), logger)
+		awsCredentials, authOk := parseBasicAuth(r.Header.Get(basicAuthHeader))
+		if !authOk && !(len(r.Header.Get(basicAuthHeader)) == 0 && allowDefaultCredentials) {
+			err := errors.NewParseBasicAuthHeaderError()
+			timestream.LogError(logger, "Error occurred while parsing the basic authentication header.", err)
+			setErrorTypeHeader(w.Header(), err)
+			http.Error(w, err.(*errors.ParseBasicAuthHeaderError).Message(), http.StatusBadRequest)
+			return
+		}
+
+		compressed, err := io.ReadAll(r.Body)
 
 		if err != nil {
 			timestream.LogError(logger, "Error occurred while reading the read request sent by Prometheus.", err)
@@ -506,14 +2225,38 @@ func createReadHandler(logger log.Logger, readers []reader) func(w http.Response
 			return
 		}
 
-		response, err := readers[0].Read(&req, awsCredentials)
+		ctx := r.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		response, err := readers[0].ReadWithContext(ctx, &req, awsCredentials)
 		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				timestream.LogError(logger, fmt.Sprintf("The read request did not complete within the read handler timeout of %s.", timeout), err)
+				http.Error(w, fmt.Sprintf("The read request did not complete within %s.", timeout), http.StatusGatewayTimeout)
+				return
+			}
+
 			timestream.LogError(logger, "Error occurred while reading the data back from Timestream.", err)
+			setErrorTypeHeader(w.Header(), err)
 			if requestError, ok := err.(awserr.RequestFailure); ok {
 				http.Error(w, err.Error(), requestError.StatusCode())
 				return
 			}
 
+			if deadlineError, ok := err.(*errors.ReadDeadlineExceededError); ok {
+				http.Error(w, err.Error(), deadlineError.StatusCode())
+				return
+			}
+
+			if isMissingDestinationError(err) {
+				http.Error(w, err.Error(), missingDestinationStatus)
+				return
+			}
+
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -536,6 +2279,14 @@ func createReadHandler(logger log.Logger, readers []reader) func(w http.Response
 	}
 }
 
+// setErrorTypeHeader sets the X-Connector-Error-Type header to the type of the connector error, so the callers can
+// categorize the error without parsing the response body. Errors not defined by the connector do not set the header.
+func setErrorTypeHeader(header http.Header, err error) {
+	if errorType, ok := errors.Type(err); ok {
+		header.Set(errorTypeHeader, errorType)
+	}
+}
+
 // createErrorResponse creates an events.APIGatewayProxyResponse with a 400 Status Code and the given error message.
 func createErrorResponse(msg string) (events.APIGatewayProxyResponse, error) {
 	return events.APIGatewayProxyResponse{
@@ -543,3 +2294,68 @@ func createErrorResponse(msg string) (events.APIGatewayProxyResponse, error) {
 		Body:       msg,
 	}, nil
 }
+
+// isMissingDestinationError returns true if the error is caused by a destination database or table that is not
+// configured, whose responses carry the status code of the missing-destination-status option.
+func isMissingDestinationError(err error) bool {
+	switch err.(type) {
+	case *errors.MissingDatabaseWithWriteError, *errors.MissingTableWithWriteError, *errors.MissingDatabaseError, *errors.MissingTableError:
+		return true
+	}
+	return false
+}
+
+// isClientError returns true if the error responds with a 4xx status code other than 429, which Prometheus does not
+// retry.
+func isClientError(err error) bool {
+	var statusCode int
+	switch err := err.(type) {
+	case awserr.RequestFailure:
+		statusCode = err.StatusCode()
+	case connectorError:
+		statusCode = err.StatusCode()
+	default:
+		return false
+	}
+	return statusCode/100 == 4 && statusCode != http.StatusTooManyRequests
+}
+
+// retryAfterSeconds formats the duration as the value of a Retry-After header, in whole seconds rounded up and at least
+// one second.
+func retryAfterSeconds(retryAfter time.Duration) string {
+	seconds := int64((retryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
+// parseBooleanMetrics compiles the regular expression of the boolean-metrics option, anchored to match whole measure
+// names. An empty expression disables the boolean metrics.
+func parseBooleanMetrics(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) == 0 {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// isValidNamespace returns true if the namespace is empty or a valid prefix of a metric name, which is safe to add to the
+// read queries.
+func isValidNamespace(namespace string) bool {
+	return len(namespace) == 0 || model.IsValidLegacyMetricName(model.LabelValue(namespace))
+}
+
+// isErrorStatus returns true if the status is a client or server error HTTP status code.
+func isErrorStatus(status int) bool {
+	return status >= 400 && status <= 599
+}
+
+// createConnectorErrorResponse creates an events.APIGatewayProxyResponse with a 400 Status Code and the given error
+// message, with the X-Connector-Error-Type header set to the type of the connector error.
+func createConnectorErrorResponse(err error, msg string) (events.APIGatewayProxyResponse, error) {
+	response, _ := createErrorResponse(msg)
+	if errorType, ok := errors.Type(err); ok {
+		response.Headers = map[string]string{errorTypeHeader: errorType}
+	}
+	return response, nil
+}
@@ -14,12 +14,25 @@ and limitations under the License.
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	goErrors "errors"
 	"fmt"
+	"github.com/alecthomas/kingpin/v2"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awsClient "github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/processcreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
 	"github.com/aws/aws-sdk-go/private/protocol"
 	"github.com/aws/aws-sdk-go/service/timestreamquery"
 	"github.com/aws/aws-sdk-go/service/timestreamwrite"
@@ -28,17 +41,25 @@ import (
 	"github.com/golang/snappy"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	prometheusClientModel "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/promlog"
 	"github.com/prometheus/prometheus/prompb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 	"timestream-prometheus-connector/errors"
@@ -139,6 +160,68 @@ type mockWriter struct {
 	writer
 }
 
+// recordingWriter records the write requests and their credentials, and returns err for every write request.
+type recordingWriter struct {
+	writer
+	mutex       sync.Mutex
+	requests    []*prompb.WriteRequest
+	requestIDs  []string
+	credentials []*credentials.Credentials
+	err         error
+	validate    func(req *prompb.WriteRequest) error
+}
+
+func (w *recordingWriter) WriteWithContext(ctx context.Context, req *prompb.WriteRequest, credentials *credentials.Credentials) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.requests = append(w.requests, req)
+	w.requestIDs = append(w.requestIDs, timestream.RequestID(ctx))
+	w.credentials = append(w.credentials, credentials)
+	if w.validate != nil {
+		return w.validate(req)
+	}
+	return w.err
+}
+
+type mockMetricsResetter struct {
+	mock.Mock
+}
+
+func (m *mockMetricsResetter) ResetMetrics() {
+	m.Called()
+}
+
+type mockErrorRatioReporter struct {
+	mock.Mock
+}
+
+func (m *mockErrorRatioReporter) RecentErrorRatio() (float64, int) {
+	args := m.Called()
+	return args.Get(0).(float64), args.Int(1)
+}
+
+type mockReadinessChecker struct {
+	mock.Mock
+}
+
+func (m *mockReadinessChecker) CheckReady(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+type mockCredentialProvider struct {
+	mock.Mock
+}
+
+func (m *mockCredentialProvider) Retrieve() (credentials.Value, error) {
+	args := m.Called()
+	return args.Get(0).(credentials.Value), args.Error(1)
+}
+
+func (m *mockCredentialProvider) IsExpired() bool {
+	return m.Called().Bool(0)
+}
+
 type requestTestCase struct {
 	name               string
 	lambdaOptions      []lambdaEnvOptions
@@ -147,8 +230,8 @@ type requestTestCase struct {
 	expectedStatusCode int
 }
 
-func (m *mockWriter) Write(req *prompb.WriteRequest, credentials *credentials.Credentials) error {
-	args := m.Called(req, credentials)
+func (m *mockWriter) WriteWithContext(ctx context.Context, req *prompb.WriteRequest, credentials *credentials.Credentials) error {
+	args := m.Called(ctx, req, credentials)
 	return args.Error(0)
 }
 
@@ -157,8 +240,8 @@ type mockReader struct {
 	reader
 }
 
-func (m *mockReader) Read(req *prompb.ReadRequest, credentials *credentials.Credentials) (*prompb.ReadResponse, error) {
-	args := m.Called(req, credentials)
+func (m *mockReader) ReadWithContext(ctx context.Context, req *prompb.ReadRequest, credentials *credentials.Credentials) (*prompb.ReadResponse, error) {
+	args := m.Called(ctx, req, credentials)
 	return args.Get(0).(*prompb.ReadResponse), args.Error(1)
 }
 
@@ -170,14 +253,30 @@ func setUp() ([]string, *connectionConfig) {
 	promLogLevel.Set("info")
 
 	return []string{"cmd", "--default-database=foo", "--default-table=bar"}, &connectionConfig{
-		clientConfig:  &clientConfig{region: "us-east-1"},
-		promlogConfig: promlog.Config{Format: promLogFormat, Level: promLogLevel},
-		defaultDatabase: "foo",
-		defaultTable:    "bar",
-		enableLogging:   true,
-		listenAddr:      ":9201",
-		maxRetries:      3,
-		telemetryPath:   "/metrics",
+		clientConfig:             &clientConfig{region: "us-east-1"},
+		promlogConfig:            promlog.Config{Format: promLogFormat, Level: promLogLevel},
+		defaultDatabase:          "foo",
+		defaultTable:             "bar",
+		enableLogging:            true,
+		listenAddr:               ":9201",
+		maxRetries:               3,
+		telemetryPath:            "/metrics",
+		rollupWindow:             time.Minute,
+		features:                 map[string]string{"default-database": "foo", "default-table": "bar"},
+		dimensionOnlyReads:       "allow",
+		nonFiniteReads:           "pass",
+		timestampColumns:         "raw",
+		reservedLabels:           "rename",
+		recordVersionStrategy:    "none",
+		requireOrderedSamples:    "off",
+		conflictingRecords:       "off",
+		duplicateSamples:         "off",
+		measureValueType:         "double",
+		writeConcurrency:         1,
+		missingDestinationStatus: 400,
+		missingDimensions:        "ignore",
+		validateLabelNames:       "off",
+		retryOnAuthError:         true,
 	}
 }
 
@@ -194,6 +293,18 @@ func TestMainParseFlags(t *testing.T) {
 		{"error_from_invalid_label_flag", "--fail-on-long-label=2"},
 		{"error_from_invalid_sample_flag", "--fail-on-invalid-sample=invalid"},
 		{"error_from_invalid_enable_logging_flag", "--enable-logging=invalid"},
+		{"error_from_invalid_dimension_only_reads_flag", "--dimension-only-reads=invalid"},
+		{"error_from_negative_max_samples_per_series_flag", "--max-samples-per-series=-1"},
+		{"error_from_invalid_retry_on_auth_error_flag", "--retry-on-auth-error=invalid"},
+		{"error_from_invalid_require_ordered_samples_flag", "--require-ordered-samples=invalid"},
+		{"error_from_invalid_conflicting_records_flag", "--conflicting-records=invalid"},
+		{"error_from_invalid_missing_dimensions_flag", "--missing-dimensions=invalid"},
+		{"error_from_negative_max_in_flight_bytes_flag", "--max-in-flight-bytes=-1"},
+		{"error_from_negative_read_handler_timeout_flag", "--read-handler-timeout=-1s"},
+		{"error_from_cross_database_reads_without_read_databases_flag", "--cross-database-reads"},
+		{"error_from_invalid_read_page_size_flag", "--read-page-size=1001"},
+		{"error_from_invalid_write_role_arns_flag", "--write-role-arns=foo"},
+		{"error_from_missing_dead_letter_dir_flag", "--dead-letter-dir=/nonexistent/dead-letter"},
 	}
 
 	for _, test := range invalidFlagTestCases {
@@ -218,6 +329,26 @@ func TestMainParseFlags(t *testing.T) {
 		})
 	}
 
+	t.Run("error_from_multiple_invalid_flags", func(t *testing.T) {
+		if os.Getenv(envName) == envValue {
+			os.Args = []string{"cmd", "--default-table=bar", "--max-read-range=-1h", "--tls-key=serverPrivateKey.key"}
+			parseFlags()
+		}
+
+		cmd := exec.Command(os.Args[0], "-test.run=TestMainParseFlags/error_from_multiple_invalid_flags")
+		cmd.Env = append(os.Environ(), envString)
+		output, err := cmd.CombinedOutput()
+
+		_, ok := err.(*exec.ExitError)
+		assert.True(t, ok, "Error is not an os.Exit(1) error")
+		assert.Contains(t, string(output), "found 3 invalid configuration options")
+		assert.Contains(t, string(output), "the default database value must be set")
+		assert.Contains(t, string(output), "the maximum read range must not be negative")
+		assert.Contains(t, string(output), "the TLS certificate and key must be set together")
+
+		cleanUp()
+	})
+
 	t.Run("success parseFlags with default values", func(t *testing.T) {
 		var expectedConfig *connectionConfig
 		os.Args, expectedConfig = setUp()
@@ -233,6 +364,56 @@ func TestMainParseFlags(t *testing.T) {
 		cleanUp()
 	})
 
+	t.Run("success parseFlags with TLS options from environment variables", func(t *testing.T) {
+		var expectedConfig *connectionConfig
+		os.Args, expectedConfig = setUp()
+		expectedConfig.certificate = "serverCertificate.crt"
+		expectedConfig.key = "serverPrivateKey.key"
+
+		options := []lambdaEnvOptions{
+			{key: certificateConfig.envFlag, value: "serverCertificate.crt"},
+			{key: keyConfig.envFlag, value: "serverPrivateKey.key"},
+		}
+		setEnvironmentVariables(options)
+
+		actualConfig := parseFlags()
+		assert.True(
+			t,
+			cmp.Equal(expectedConfig, actualConfig, compareOptions...),
+			"The actual configuration options parsed from flags do not match the expected configuration.",
+		)
+
+		unsetEnvironmentVariables(options)
+		cleanUp()
+	})
+
+	t.Run("success parseFlags with TLS flags overriding environment variables", func(t *testing.T) {
+		var expectedConfig *connectionConfig
+		var args []string
+		args, expectedConfig = setUp()
+		os.Args = append(args, "--tls-certificate=flagCertificate.crt", "--tls-key=flagPrivateKey.key")
+		expectedConfig.certificate = "flagCertificate.crt"
+		expectedConfig.key = "flagPrivateKey.key"
+		expectedConfig.features["tls-certificate"] = "flagCertificate.crt"
+		expectedConfig.features["tls-key"] = "flagPrivateKey.key"
+
+		options := []lambdaEnvOptions{
+			{key: certificateConfig.envFlag, value: "serverCertificate.crt"},
+			{key: keyConfig.envFlag, value: "serverPrivateKey.key"},
+		}
+		setEnvironmentVariables(options)
+
+		actualConfig := parseFlags()
+		assert.True(
+			t,
+			cmp.Equal(expectedConfig, actualConfig, compareOptions...),
+			"The actual configuration options parsed from flags do not match the expected configuration.",
+		)
+
+		unsetEnvironmentVariables(options)
+		cleanUp()
+	})
+
 	t.Run("error from missing required flags", func(t *testing.T) {
 		if os.Getenv(envName) == envValue {
 			parseFlags()
@@ -265,6 +446,18 @@ func TestParseBasicAuth(t *testing.T) {
 			expectedCredentials: credentials.NewStaticCredentials("fakeUser", "fakePassword", ""),
 			expectedAuthOk:      true,
 		},
+		{
+			name:                "valid basic auth header with session token",
+			encodedCreds:        "Basic " + base64.StdEncoding.EncodeToString([]byte("fakeUser:fakePassword:fakeSessionToken")),
+			expectedCredentials: credentials.NewStaticCredentials("fakeUser", "fakePassword", "fakeSessionToken"),
+			expectedAuthOk:      true,
+		},
+		{
+			name:                "basic auth header without password",
+			encodedCreds:        "Basic " + base64.StdEncoding.EncodeToString([]byte("fakeUser")),
+			expectedCredentials: nil,
+			expectedAuthOk:      false,
+		},
 		{
 			name:                "empty basic auth header",
 			encodedCreds:        "",
@@ -313,6 +506,7 @@ func TestLambdaHandlerPrepareRequest(t *testing.T) {
 			},
 			expectedResponse: events.APIGatewayProxyResponse{
 				StatusCode: http.StatusBadRequest,
+				Headers:    map[string]string{errorTypeHeader: "MissingDestination"},
 				Body:       errors.NewMissingDestinationError().(*errors.MissingDestinationError).Message()},
 		},
 		{
@@ -358,6 +552,7 @@ func TestLambdaHandlerPrepareRequest(t *testing.T) {
 			},
 			expectedResponse: events.APIGatewayProxyResponse{
 				StatusCode: http.StatusBadRequest,
+				Headers:    map[string]string{errorTypeHeader: "MissingHeader"},
 				Body:       errors.NewMissingHeaderError(readHeader, writeHeader).(*errors.MissingHeaderError).Message()},
 		},
 		{
@@ -372,6 +567,7 @@ func TestLambdaHandlerPrepareRequest(t *testing.T) {
 			},
 			expectedResponse: events.APIGatewayProxyResponse{
 				StatusCode: http.StatusBadRequest,
+				Headers:    map[string]string{errorTypeHeader: "ParseBasicAuthHeader"},
 				Body:       errors.NewParseBasicAuthHeaderError().(*errors.ParseBasicAuthHeaderError).Message()},
 		},
 		{
@@ -387,6 +583,7 @@ func TestLambdaHandlerPrepareRequest(t *testing.T) {
 			},
 			expectedResponse: events.APIGatewayProxyResponse{
 				StatusCode: http.StatusBadRequest,
+				Headers:    map[string]string{errorTypeHeader: "ParseBasicAuthHeader"},
 				Body:       errors.NewParseBasicAuthHeaderError().(*errors.ParseBasicAuthHeaderError).Message()},
 		},
 		{
@@ -401,7 +598,10 @@ func TestLambdaHandlerPrepareRequest(t *testing.T) {
 				Body:            string(validWriteRequestBody),
 				Headers:         validBasicAuthHeader,
 			},
-			expectedResponse: events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest},
+			expectedResponse: events.APIGatewayProxyResponse{
+				StatusCode: http.StatusBadRequest,
+				Headers:    map[string]string{errorTypeHeader: "ParseEnableLogging"},
+			},
 		},
 	}
 
@@ -409,10 +609,11 @@ func TestLambdaHandlerPrepareRequest(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			setEnvironmentVariables(test.lambdaOptions)
 
-			actualResponse, _ := lambdaHandler(test.inputRequest)
+			actualResponse, _ := lambdaHandler(context.Background(), test.inputRequest)
 			if len(test.expectedResponse.Body) == 0 {
 				// Not a custom error from the connector, don't check check the error message.
 				assert.Equal(t, http.StatusBadRequest, actualResponse.StatusCode)
+				assert.Equal(t, test.expectedResponse.Headers, actualResponse.Headers)
 			} else {
 				assert.Equal(t, test.expectedResponse, actualResponse)
 			}
@@ -492,13 +693,36 @@ func TestLambdaHandlerWriteRequest(t *testing.T) {
 			mockSDKError:       errors.NewMissingTableWithWriteError(tableValue, emptyTimeSeries),
 			expectedStatusCode: http.StatusBadRequest,
 		},
+		{
+			name: "Missing table name from write with the configured missing destination status",
+			lambdaOptions: []lambdaEnvOptions{
+				{key: defaultTableConfig.envFlag, value: tableValue},
+				{key: defaultDatabaseConfig.envFlag, value: databaseValue},
+				{key: missingDestinationConfig.envFlag, value: "422"},
+			},
+			inputRequest:       events.APIGatewayProxyRequest{IsBase64Encoded: true, Body: string(validWriteRequestBody), Headers: validWriteHeader},
+			mockSDKError:       errors.NewMissingTableWithWriteError(tableValue, emptyTimeSeries),
+			expectedStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "SDK error during write with the configured missing destination status",
+			lambdaOptions: []lambdaEnvOptions{
+				{key: defaultTableConfig.envFlag, value: tableValue},
+				{key: defaultDatabaseConfig.envFlag, value: databaseValue},
+				{key: missingDestinationConfig.envFlag, value: "422"},
+			},
+			inputRequest:       events.APIGatewayProxyRequest{IsBase64Encoded: true, Body: string(validWriteRequestBody), Headers: validWriteHeader},
+			mockSDKError:       &timestreamwrite.RejectedRecordsException{},
+			expectedStatusCode: (&timestreamwrite.RejectedRecordsException{}).StatusCode(),
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			mockTimestreamWriter := new(mockWriter)
 			mockTimestreamWriter.On(
-				"Write",
+				"WriteWithContext",
+				mock.Anything,
 				mock.AnythingOfType(writeRequestType),
 				mock.AnythingOfType(awsCredentialsType)).Return(test.mockSDKError)
 
@@ -508,7 +732,7 @@ func TestLambdaHandlerWriteRequest(t *testing.T) {
 
 			setEnvironmentVariables(test.lambdaOptions)
 
-			res, _ := lambdaHandler(test.inputRequest)
+			res, _ := lambdaHandler(context.Background(), test.inputRequest)
 			assert.Equal(t, test.expectedStatusCode, res.StatusCode)
 
 			unsetEnvironmentVariables(test.lambdaOptions)
@@ -516,6 +740,229 @@ func TestLambdaHandlerWriteRequest(t *testing.T) {
 	}
 }
 
+func TestLambdaHandlerWriteRequestExceedingMaxIngestRate(t *testing.T) {
+	validWriteRequestBody, _ := prepareData(t)
+
+	mockTimestreamWriter := new(mockWriter)
+	mockTimestreamWriter.On(
+		"WriteWithContext",
+		mock.Anything,
+		mock.AnythingOfType(writeRequestType),
+		mock.AnythingOfType(awsCredentialsType)).Return(errors.NewIngestRateExceededError(100, 8*time.Second))
+	getWriteClient = func(timestreamClient *timestream.Client) writer { return mockTimestreamWriter }
+
+	lambdaOptions := []lambdaEnvOptions{
+		{key: defaultTableConfig.envFlag, value: tableValue},
+		{key: defaultDatabaseConfig.envFlag, value: databaseValue},
+		{key: maxIngestRateConfig.envFlag, value: "100"},
+	}
+	setEnvironmentVariables(lambdaOptions)
+	defer unsetEnvironmentVariables(lambdaOptions)
+
+	res, err := lambdaHandler(context.Background(), events.APIGatewayProxyRequest{IsBase64Encoded: true, Body: string(validWriteRequestBody), Headers: validWriteHeader})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "8", res.Headers[retryAfterHeader])
+	assert.Equal(t, "IngestRateExceeded", res.Headers[errorTypeHeader])
+}
+
+func TestLambdaHandlerWriteRequestWithContextDimensions(t *testing.T) {
+	validWriteRequestBody, _ := prepareData(t)
+	lambdaOptions := []lambdaEnvOptions{
+		{key: defaultTableConfig.envFlag, value: tableValue},
+		{key: defaultDatabaseConfig.envFlag, value: databaseValue},
+		{key: lambdaDimensionsConfig.envFlag, value: "aws_request_id, function_name,function_version"},
+	}
+
+	oldFunctionName, oldFunctionVersion := lambdacontext.FunctionName, lambdacontext.FunctionVersion
+	defer func() {
+		lambdacontext.FunctionName, lambdacontext.FunctionVersion = oldFunctionName, oldFunctionVersion
+	}()
+	lambdacontext.FunctionName = "PrometheusConnector"
+	lambdacontext.FunctionVersion = "$LATEST"
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "requestID"})
+
+	mockTimestreamWriter := new(mockWriter)
+	mockTimestreamWriter.On(
+		"WriteWithContext",
+		mock.Anything,
+		mock.MatchedBy(func(req *prompb.WriteRequest) bool {
+			labels := make(map[string]string)
+			for _, label := range req.Timeseries[0].Labels {
+				labels[label.Name] = label.Value
+			}
+			return labels[awsRequestIDDimension] == "requestID" &&
+				labels[functionNameDimension] == "PrometheusConnector" &&
+				labels[functionVersionDimension] == "$LATEST" &&
+				labels[model.MetricNameLabel] == "go_gc_duration_seconds"
+		}),
+		mock.AnythingOfType(awsCredentialsType)).Return(nil)
+
+	getWriteClient = func(timestreamClient *timestream.Client) writer {
+		return mockTimestreamWriter
+	}
+
+	setEnvironmentVariables(lambdaOptions)
+	defer unsetEnvironmentVariables(lambdaOptions)
+
+	res, _ := lambdaHandler(ctx, events.APIGatewayProxyRequest{IsBase64Encoded: true, Body: string(validWriteRequestBody), Headers: validWriteHeader})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	mockTimestreamWriter.AssertExpectations(t)
+}
+
+func TestLambdaHandlerReusesCachedState(t *testing.T) {
+	validWriteRequestBody, _ := prepareData(t)
+	lambdaOptions := []lambdaEnvOptions{
+		{key: defaultTableConfig.envFlag, value: tableValue},
+		{key: defaultDatabaseConfig.envFlag, value: databaseValue},
+	}
+
+	mockTimestreamWriter := new(mockWriter)
+	mockTimestreamWriter.On("WriteWithContext", mock.Anything, mock.Anything, mock.AnythingOfType(awsCredentialsType)).Return(nil)
+	getWriteClient = func(timestreamClient *timestream.Client) writer {
+		return mockTimestreamWriter
+	}
+
+	setEnvironmentVariables(lambdaOptions)
+	defer unsetEnvironmentVariables(lambdaOptions)
+
+	request := events.APIGatewayProxyRequest{IsBase64Encoded: true, Body: string(validWriteRequestBody), Headers: validWriteHeader}
+
+	res, _ := lambdaHandler(context.Background(), request)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	firstState := cachedLambdaState
+	assert.NotNil(t, firstState)
+	firstWriteClient := firstState.timestreamClient.WriteClient()
+	assert.NotNil(t, firstWriteClient)
+
+	res, _ = lambdaHandler(context.Background(), request)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Same(t, firstState, cachedLambdaState)
+	assert.Same(t, firstState.cfg, cachedLambdaState.cfg)
+	assert.Same(t, firstWriteClient, cachedLambdaState.timestreamClient.WriteClient())
+
+	changedOptions := []lambdaEnvOptions{{key: enableLogConfig.envFlag, value: "false"}}
+	setEnvironmentVariables(changedOptions)
+	defer unsetEnvironmentVariables(changedOptions)
+
+	res, _ = lambdaHandler(context.Background(), request)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NotSame(t, firstState, cachedLambdaState)
+	assert.False(t, cachedLambdaState.cfg.enableLogging)
+	assert.NotSame(t, firstWriteClient, cachedLambdaState.timestreamClient.WriteClient())
+
+	mockTimestreamWriter.AssertNumberOfCalls(t, "WriteWithContext", 3)
+}
+
+// TestLambdaConfigurations checks that every option read from the environment variables is part of the
+// configuration key of the cached Lambda state, so that changing it rebuilds the state.
+func TestLambdaConfigurations(t *testing.T) {
+	lambdaEnvFlags := make(map[string]bool)
+	for _, config := range lambdaConfigurations {
+		lambdaEnvFlags[config.envFlag] = true
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), "configuration.go", nil, 0)
+	assert.Nil(t, err)
+	ast.Inspect(file, func(node ast.Node) bool {
+		literal, ok := node.(*ast.CompositeLit)
+		if !ok || fmt.Sprint(literal.Type) != "configuration" {
+			return true
+		}
+		for _, element := range literal.Elts {
+			field := element.(*ast.KeyValueExpr)
+			if fmt.Sprint(field.Key) != "envFlag" {
+				continue
+			}
+			envFlag, err := strconv.Unquote(field.Value.(*ast.BasicLit).Value)
+			assert.Nil(t, err)
+			if envFlag != "" {
+				assert.True(t, lambdaEnvFlags[envFlag], "%s is missing from lambdaConfigurations", envFlag)
+			}
+		}
+		return true
+	})
+}
+
+func TestLambdaHandlerRetriesCredentialLoad(t *testing.T) {
+	validWriteRequestBody, _ := prepareData(t)
+	lambdaOptions := []lambdaEnvOptions{
+		{key: defaultTableConfig.envFlag, value: tableValue},
+		{key: defaultDatabaseConfig.envFlag, value: databaseValue},
+		{key: credentialProviderConfig.envFlag, value: "env"},
+		{key: credentialRetriesConfig.envFlag, value: "2"},
+	}
+	setEnvironmentVariables(lambdaOptions)
+	defer unsetEnvironmentVariables(lambdaOptions)
+
+	defer func(backoff time.Duration) { credentialRetryBackoff = backoff }(credentialRetryBackoff)
+	credentialRetryBackoff = time.Millisecond
+
+	mockTimestreamWriter := new(mockWriter)
+	mockTimestreamWriter.On("WriteWithContext", mock.Anything, mock.Anything, mock.AnythingOfType(awsCredentialsType)).Return(nil)
+	getWriteClient = func(timestreamClient *timestream.Client) writer {
+		return mockTimestreamWriter
+	}
+
+	// The first credential load fails, such as when the instance metadata service is not yet reachable on a cold start.
+	provider := new(mockCredentialProvider)
+	provider.On("Retrieve").Return(credentials.Value{}, fmt.Errorf("EC2RoleRequestError")).Once()
+	provider.On("Retrieve").Return(credentials.Value{AccessKeyID: "id", SecretAccessKey: "secret"}, nil).Once()
+	provider.On("IsExpired").Return(false)
+
+	state, err := loadLambdaState()
+	assert.Nil(t, err)
+	state.writeConfigs.Credentials = credentials.NewCredentials(provider)
+
+	headers := map[string]string{writeHeader: "0.1.0"}
+	res, err := lambdaHandler(context.Background(), events.APIGatewayProxyRequest{IsBase64Encoded: true, Body: string(validWriteRequestBody), Headers: headers})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	provider.AssertNumberOfCalls(t, "Retrieve", 2)
+	mockTimestreamWriter.AssertNumberOfCalls(t, "WriteWithContext", 1)
+}
+
+func TestLambdaHandlerPublishesCloudWatchMetrics(t *testing.T) {
+	validWriteRequestBody, _ := prepareData(t)
+	lambdaOptions := []lambdaEnvOptions{
+		{key: defaultTableConfig.envFlag, value: tableValue},
+		{key: defaultDatabaseConfig.envFlag, value: databaseValue},
+		{key: cloudWatchMetricsConfig.envFlag, value: "true"},
+	}
+
+	var output bytes.Buffer
+	defer func(writer io.Writer) { cloudWatchMetricsWriter = writer }(cloudWatchMetricsWriter)
+	cloudWatchMetricsWriter = &output
+
+	mockTimestreamWriter := new(mockWriter)
+	mockTimestreamWriter.On("WriteWithContext", mock.Anything, mock.Anything, mock.AnythingOfType(awsCredentialsType)).Return(nil)
+	getWriteClient = func(timestreamClient *timestream.Client) writer {
+		return mockTimestreamWriter
+	}
+
+	setEnvironmentVariables(lambdaOptions)
+	defer unsetEnvironmentVariables(lambdaOptions)
+
+	request := events.APIGatewayProxyRequest{IsBase64Encoded: true, Body: string(validWriteRequestBody), Headers: validWriteHeader}
+	res, _ := lambdaHandler(context.Background(), request)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// The metrics of the write client created by the invocation are published in the embedded metric format.
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var document map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &document))
+		metrics := document["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, timestream.CloudWatchNamespace, metrics["Namespace"])
+		names = append(names, metrics["Metrics"].([]interface{})[0].(map[string]interface{})["Name"].(string))
+	}
+	assert.Contains(t, names, "timestream_connector_write_requests_total")
+	assert.Contains(t, names, "timestream_connector_received_samples_total")
+	assert.NotContains(t, names, "timestream_connector_read_requests_total")
+}
+
 func TestLambdaHandlerReadRequest(t *testing.T) {
 	_, validReadRequestBody := prepareData(t)
 
@@ -585,13 +1032,25 @@ func TestLambdaHandlerReadRequest(t *testing.T) {
 			mockSDKError:       errors.NewMissingTableError(tableValue),
 			expectedStatusCode: http.StatusBadRequest,
 		},
+		{
+			name: "Missing database name from read with the configured missing destination status",
+			lambdaOptions: []lambdaEnvOptions{
+				{key: defaultTableConfig.envFlag, value: tableValue},
+				{key: defaultDatabaseConfig.envFlag, value: databaseValue},
+				{key: missingDestinationConfig.envFlag, value: "422"},
+			},
+			inputRequest:       events.APIGatewayProxyRequest{IsBase64Encoded: true, Body: string(validReadRequestBody), Headers: validReadHeader},
+			mockSDKError:       errors.NewMissingDatabaseError(databaseValue),
+			expectedStatusCode: http.StatusUnprocessableEntity,
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			mockTimestreamReader := new(mockReader)
 			mockTimestreamReader.On(
-				"Read",
+				"ReadWithContext",
+				mock.Anything,
 				mock.AnythingOfType(readRequestType),
 				mock.AnythingOfType(awsCredentialsType)).Return(&prompb.ReadResponse{}, test.mockSDKError)
 
@@ -599,7 +1058,7 @@ func TestLambdaHandlerReadRequest(t *testing.T) {
 
 			setEnvironmentVariables(test.lambdaOptions)
 
-			res, _ := lambdaHandler(test.inputRequest)
+			res, _ := lambdaHandler(context.Background(), test.inputRequest)
 			assert.Equal(t, test.expectedStatusCode, res.StatusCode)
 
 			unsetEnvironmentVariables(test.lambdaOptions)
@@ -607,6 +1066,43 @@ func TestLambdaHandlerReadRequest(t *testing.T) {
 	}
 }
 
+func TestLambdaHandlerReadRequestWithGzipEncoding(t *testing.T) {
+	_, validReadRequestBody := prepareData(t)
+	readResponse := &prompb.ReadResponse{Results: []*prompb.QueryResult{{Timeseries: []*prompb.TimeSeries{validTimeSeries}}}}
+
+	mockTimestreamReader := new(mockReader)
+	mockTimestreamReader.On(
+		"ReadWithContext",
+		mock.Anything,
+		mock.AnythingOfType(readRequestType),
+		mock.AnythingOfType(awsCredentialsType)).Return(readResponse, nil)
+	getQueryClient = func(timestreamClient *timestream.Client) reader { return mockTimestreamReader }
+
+	lambdaOptions := []lambdaEnvOptions{
+		{key: defaultTableConfig.envFlag, value: tableValue},
+		{key: defaultDatabaseConfig.envFlag, value: databaseValue},
+		{key: lambdaReadEncodingConfig.envFlag, value: gzipReadEncoding},
+	}
+	setEnvironmentVariables(lambdaOptions)
+	defer unsetEnvironmentVariables(lambdaOptions)
+
+	res, err := lambdaHandler(context.Background(), events.APIGatewayProxyRequest{IsBase64Encoded: true, Body: string(validReadRequestBody), Headers: validReadHeader})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "gzip", res.Headers["Content-Encoding"])
+	assert.True(t, res.IsBase64Encoded)
+
+	compressed, err := base64.StdEncoding.DecodeString(res.Body)
+	assert.Nil(t, err)
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	assert.Nil(t, err)
+	data, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+	var decoded prompb.ReadResponse
+	assert.Nil(t, proto.Unmarshal(data, &decoded))
+	assert.Equal(t, readResponse, &decoded)
+}
+
 func TestCreateLogger(t *testing.T) {
 	t.Run("success create no-op logger", func(t *testing.T) {
 		nopLogger := log.NewNopLogger()
@@ -639,6 +1135,194 @@ func TestBuildAWSConfig(t *testing.T) {
 		actualOutput := input.buildAWSConfig()
 
 		assert.Equal(t, expectedAWSConfig, actualOutput)
+
+		// Without retry delays, the retryer of the AWS SDK is used.
+		setMaxRetries(actualOutput, 10)
+		assert.Equal(t, aws.Int(10), actualOutput.MaxRetries)
+		assert.Nil(t, actualOutput.Retryer)
+	})
+
+	t.Run("success with credential providers", func(t *testing.T) {
+		input := &connectionConfig{clientConfig: &clientConfig{region: "region"}, credentialProviders: []string{"env", "shared"}}
+		actualOutput := input.buildAWSConfig()
+
+		assert.NotNil(t, actualOutput.Credentials)
+	})
+
+	t.Run("success with retry delays", func(t *testing.T) {
+		input := &connectionConfig{clientConfig: &clientConfig{region: "region", retryBaseDelay: 100 * time.Millisecond, retryMaxBackoff: 20 * time.Second}}
+		actualOutput := input.buildAWSConfig()
+		setMaxRetries(actualOutput, 10)
+
+		assert.Equal(t, aws.Int(10), actualOutput.MaxRetries)
+		assert.Equal(t, awsClient.DefaultRetryer{
+			NumMaxRetries:    10,
+			MinRetryDelay:    100 * time.Millisecond,
+			MinThrottleDelay: 100 * time.Millisecond,
+			MaxRetryDelay:    20 * time.Second,
+			MaxThrottleDelay: 20 * time.Second,
+		}, actualOutput.Retryer)
+	})
+
+	t.Run("success with warmup connections", func(t *testing.T) {
+		input := &connectionConfig{clientConfig: &clientConfig{region: "region"}, warmupConnections: 8}
+		actualOutput := input.buildAWSConfig()
+
+		transport := actualOutput.HTTPClient.Transport.(*http.Transport)
+		assert.Equal(t, 8, transport.MaxIdleConnsPerHost)
+		if transport.TLSClientConfig != nil {
+			assert.Equal(t, uint16(0), transport.TLSClientConfig.MinVersion)
+		}
+
+		// The default transport already keeps two idle connections per host.
+		input.warmupConnections = 2
+		assert.Nil(t, input.buildAWSConfig().HTTPClient)
+	})
+
+	t.Run("success with TLS minimum version", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+		server.StartTLS()
+		defer server.Close()
+
+		input := &connectionConfig{clientConfig: &clientConfig{region: "region", tlsMinVersion: "1.3"}}
+		actualOutput := input.buildAWSConfig()
+
+		transport := actualOutput.HTTPClient.Transport.(*http.Transport)
+		assert.Equal(t, uint16(tls.VersionTLS13), transport.TLSClientConfig.MinVersion)
+
+		// Trust the test server so the handshake fails only on the protocol version.
+		transport.TLSClientConfig.RootCAs = x509.NewCertPool()
+		transport.TLSClientConfig.RootCAs.AddCert(server.Certificate())
+		_, err := actualOutput.HTTPClient.Get(server.URL)
+		assert.ErrorContains(t, err, "protocol version")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	_, cfg := setUp()
+	assert.Empty(t, cfg.validate())
+
+	cfg.defaultDatabase = ""
+	cfg.clientConfig.region = "US East"
+	cfg.certificate = "serverCertificate.crt"
+	cfg.preferRecent = true
+	cfg.rollupWindow = 0
+	cfg.deadLetterDir = filepath.Join(t.TempDir(), "missing")
+	cfg.clientConfig.tlsMinVersion = "1.4"
+	cfg.mirrorWriteURL = "localhost:9090/api/v1/write"
+	cfg.expectedMagneticRetention = -time.Hour
+	cfg.missingDestinationStatus = http.StatusOK
+	validationErrors := cfg.validate()
+	assert.Len(t, validationErrors, 10)
+
+	cfg.clientConfig.region = "us-gov-west-1"
+	cfg.clientConfig.tlsMinVersion = "1.3"
+	cfg.mirrorWriteURL = "http://localhost:9090/api/v1/write"
+	cfg.expectedMagneticRetention = 365 * 24 * time.Hour
+	cfg.missingDestinationStatus = http.StatusUnprocessableEntity
+	assert.Len(t, cfg.validate(), 5)
+}
+
+func TestResolveInstanceID(t *testing.T) {
+	instanceID, err := resolveInstanceID("")
+	assert.Nil(t, err)
+	assert.Empty(t, instanceID)
+
+	instanceID, err = resolveInstanceID("connector-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "connector-1", instanceID)
+
+	hostname, err := os.Hostname()
+	assert.Nil(t, err)
+	instanceID, err = resolveInstanceID("hostname")
+	assert.Nil(t, err)
+	assert.Equal(t, hostname, instanceID)
+}
+
+func TestParseRoleARNs(t *testing.T) {
+	roleARNs, ok := parseRoleARNs("foo=arn:aws:iam::123456789012:role/foo, bar = arn:aws:iam::123456789012:role/bar")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"foo": "arn:aws:iam::123456789012:role/foo", "bar": "arn:aws:iam::123456789012:role/bar"}, roleARNs)
+
+	roleARNs, ok = parseRoleARNs("")
+	assert.True(t, ok)
+	assert.Nil(t, roleARNs)
+
+	for _, invalid := range []string{"foo", "foo=role", "=arn:aws:iam::123456789012:role/foo", "foo=arn:a,foo=arn:b"} {
+		_, ok = parseRoleARNs(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func TestParseCostTags(t *testing.T) {
+	costTags, ok := parseCostTags("team=observability, cost_center = 1234")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"team": "observability", "cost_center": "1234"}, costTags)
+
+	costTags, ok = parseCostTags("")
+	assert.True(t, ok)
+	assert.Nil(t, costTags)
+
+	for _, invalid := range []string{"team", "team=", "=observability", "cost-center=1234", "team=a,team=b"} {
+		_, ok = parseCostTags(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func TestCredentialProviderChain(t *testing.T) {
+	providers := credentialProviderChain([]string{"imds", "env", "shared"})
+
+	assert.Len(t, providers, 3)
+	assert.IsType(t, &ec2rolecreds.EC2RoleProvider{}, providers[0])
+	assert.IsType(t, &credentials.EnvProvider{}, providers[1])
+	assert.IsType(t, &credentials.SharedCredentialsProvider{}, providers[2])
+
+	providers = credentialProviderChain([]string{"shared", "env", "process", "sso"})
+	assert.IsType(t, &credentials.SharedCredentialsProvider{}, providers[0])
+	assert.IsType(t, &credentials.EnvProvider{}, providers[1])
+	assert.Equal(t, processcreds.ProviderName, providers[2].(*profileCredentialsProvider).providerName)
+	assert.Equal(t, ssocreds.ProviderName, providers[3].(*profileCredentialsProvider).providerName)
+}
+
+func TestProfileCredentialsProvider(t *testing.T) {
+	directory := t.TempDir()
+	processFile := filepath.Join(directory, "credentials.sh")
+	process := "#!/bin/sh\necho '{\"Version\": 1, \"AccessKeyId\": \"processId\", \"SecretAccessKey\": \"processSecret\"}'\n"
+	assert.Nil(t, os.WriteFile(processFile, []byte(process), 0700))
+
+	configFile := filepath.Join(directory, "config")
+	config := "[profile process]\ncredential_process = " + processFile + "\n" +
+		"[profile static]\naws_access_key_id = staticId\naws_secret_access_key = staticSecret\n"
+	assert.Nil(t, os.WriteFile(configFile, []byte(config), 0600))
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	t.Run("credentials of the credential_process setting", func(t *testing.T) {
+		t.Setenv("AWS_PROFILE", "process")
+		provider := &profileCredentialsProvider{providerName: processcreds.ProviderName}
+		assert.True(t, provider.IsExpired())
+
+		value, err := provider.Retrieve()
+		assert.Nil(t, err)
+		assert.Equal(t, "processId", value.AccessKeyID)
+		assert.Equal(t, "processSecret", value.SecretAccessKey)
+		assert.False(t, provider.IsExpired())
+	})
+
+	t.Run("error with a profile configuring other credentials", func(t *testing.T) {
+		t.Setenv("AWS_PROFILE", "static")
+		provider := &profileCredentialsProvider{providerName: ssocreds.ProviderName}
+
+		_, err := provider.Retrieve()
+		assert.NotNil(t, err)
+
+		chain := credentials.NewChainCredentials([]credentials.Provider{provider, &credentials.StaticProvider{Value: credentials.Value{AccessKeyID: "id", SecretAccessKey: "secret"}}})
+		value, err := chain.Get()
+		assert.Nil(t, err)
+		assert.Equal(t, "id", value.AccessKeyID)
 	})
 }
 
@@ -661,6 +1345,140 @@ func TestParseEnvironmentVariables(t *testing.T) {
 				failOnInvalidSample:       false,
 				failOnLongMetricLabelName: false,
 				maxRetries:                3,
+				dimensionOnlyReads:        "allow",
+				nonFiniteReads:            "pass",
+				timestampColumns:          "raw",
+				reservedLabels:            "rename",
+				recordVersionStrategy:     "none",
+				requireOrderedSamples:     "off",
+				conflictingRecords:        "off",
+				duplicateSamples:          "off",
+				measureValueType:          "double",
+				writeConcurrency:          1,
+				missingDestinationStatus:  400,
+				missingDimensions:         "ignore",
+				validateLabelNames:        "off",
+				lambdaReadEncoding:        "snappy",
+				retryOnAuthError:          true,
+			},
+			expectedError: nil,
+		},
+		{
+			name:          "test reject dimension_only_reads option",
+			lambdaOptions: []lambdaEnvOptions{{key: dimensionOnlyReadsConfig.envFlag, value: "reject"}},
+			expectedConfig: &connectionConfig{
+				clientConfig:             &clientConfig{region: "us-east-1"},
+				promlogConfig:            defaultLogConfig,
+				enableLogging:            true,
+				maxRetries:               3,
+				dimensionOnlyReads:       "reject",
+				nonFiniteReads:           "pass",
+				timestampColumns:         "raw",
+				reservedLabels:           "rename",
+				recordVersionStrategy:    "none",
+				requireOrderedSamples:    "off",
+				conflictingRecords:       "off",
+				duplicateSamples:         "off",
+				measureValueType:         "double",
+				writeConcurrency:         1,
+				missingDestinationStatus: 400,
+				missingDimensions:        "ignore",
+				validateLabelNames:       "off",
+				lambdaReadEncoding:       "snappy",
+				retryOnAuthError:         true,
+			},
+			expectedError: nil,
+		},
+		{
+			name: "test cross-database reads options",
+			lambdaOptions: []lambdaEnvOptions{
+				{key: readDatabasesConfig.envFlag, value: "database1,database2"},
+				{key: crossDatabaseReadsConfig.envFlag, value: "true"},
+			},
+			expectedConfig: &connectionConfig{
+				clientConfig:             &clientConfig{region: "us-east-1"},
+				promlogConfig:            defaultLogConfig,
+				enableLogging:            true,
+				maxRetries:               3,
+				dimensionOnlyReads:       "allow",
+				nonFiniteReads:           "pass",
+				timestampColumns:         "raw",
+				reservedLabels:           "rename",
+				recordVersionStrategy:    "none",
+				requireOrderedSamples:    "off",
+				conflictingRecords:       "off",
+				duplicateSamples:         "off",
+				measureValueType:         "double",
+				writeConcurrency:         1,
+				missingDestinationStatus: 400,
+				missingDimensions:        "ignore",
+				validateLabelNames:       "off",
+				lambdaReadEncoding:       "snappy",
+				readDatabases:            []string{"database1", "database2"},
+				crossDatabaseReads:       true,
+				retryOnAuthError:         true,
+			},
+			expectedError: nil,
+		},
+		{
+			name: "test required dimensions options",
+			lambdaOptions: []lambdaEnvOptions{
+				{key: requiredDimensionsConfig.envFlag, value: "job, instance"},
+				{key: missingDimensionsConfig.envFlag, value: "fail"},
+				{key: validateLabelsConfig.envFlag, value: "sanitize"},
+			},
+			expectedConfig: &connectionConfig{
+				clientConfig:             &clientConfig{region: "us-east-1"},
+				promlogConfig:            defaultLogConfig,
+				enableLogging:            true,
+				maxRetries:               3,
+				dimensionOnlyReads:       "allow",
+				nonFiniteReads:           "pass",
+				timestampColumns:         "raw",
+				reservedLabels:           "rename",
+				recordVersionStrategy:    "none",
+				requireOrderedSamples:    "off",
+				conflictingRecords:       "off",
+				duplicateSamples:         "off",
+				measureValueType:         "double",
+				writeConcurrency:         1,
+				missingDestinationStatus: 400,
+				requiredDimensions:       []string{"job", "instance"},
+				missingDimensions:        "fail",
+				validateLabelNames:       "sanitize",
+				lambdaReadEncoding:       "snappy",
+				retryOnAuthError:         true,
+			},
+			expectedError: nil,
+		},
+		{
+			name: "test TLS options",
+			lambdaOptions: []lambdaEnvOptions{
+				{key: certificateConfig.envFlag, value: "serverCertificate.crt"},
+				{key: keyConfig.envFlag, value: "serverPrivateKey.key"},
+			},
+			expectedConfig: &connectionConfig{
+				clientConfig:             &clientConfig{region: "us-east-1"},
+				promlogConfig:            defaultLogConfig,
+				enableLogging:            true,
+				maxRetries:               3,
+				dimensionOnlyReads:       "allow",
+				nonFiniteReads:           "pass",
+				timestampColumns:         "raw",
+				reservedLabels:           "rename",
+				recordVersionStrategy:    "none",
+				requireOrderedSamples:    "off",
+				conflictingRecords:       "off",
+				duplicateSamples:         "off",
+				measureValueType:         "double",
+				writeConcurrency:         1,
+				missingDestinationStatus: 400,
+				missingDimensions:        "ignore",
+				validateLabelNames:       "off",
+				lambdaReadEncoding:       "snappy",
+				retryOnAuthError:         true,
+				certificate:              "serverCertificate.crt",
+				key:                      "serverPrivateKey.key",
 			},
 			expectedError: nil,
 		},
@@ -683,11 +1501,341 @@ func TestParseEnvironmentVariables(t *testing.T) {
 			expectedError:  errors.NewParseSampleOptionError("foo"),
 		},
 		{
-			name:           "error invalid max_retries option",
+			name:           "error invalid retry_on_auth_error option",
+			lambdaOptions:  []lambdaEnvOptions{{key: retryOnAuthErrorConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseRetryOnAuthError("foo"),
+		},
+		{
+			name:           "error invalid max_retries option",
 			lambdaOptions:  []lambdaEnvOptions{{key: maxRetriesConfig.envFlag, value: "foo"}},
 			expectedConfig: nil,
 			expectedError:  errors.NewParseRetriesError("foo"),
 		},
+		{
+			name:           "error invalid max_samples_per_series option",
+			lambdaOptions:  []lambdaEnvOptions{{key: maxSamplesPerSeriesConfig.envFlag, value: "-1"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseMaxSamplesPerSeriesError("-1"),
+		},
+		{
+			name:           "error invalid lambda_read_encoding option",
+			lambdaOptions:  []lambdaEnvOptions{{key: lambdaReadEncodingConfig.envFlag, value: "deflate"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseLambdaReadEncodingError("deflate"),
+		},
+		{
+			name:           "error invalid lambda_credential_retries option",
+			lambdaOptions:  []lambdaEnvOptions{{key: credentialRetriesConfig.envFlag, value: "6"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseCredentialRetriesError("6"),
+		},
+		{
+			name:           "error invalid measure_name_namespace_strip option",
+			lambdaOptions:  []lambdaEnvOptions{{key: stripNamespaceConfig.envFlag, value: "federated'"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseMeasureNameNamespaceError(stripNamespaceConfig.envFlag, "federated'"),
+		},
+		{
+			name:           "error invalid measure_name_namespace_add option",
+			lambdaOptions:  []lambdaEnvOptions{{key: addNamespaceConfig.envFlag, value: "1federated_"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseMeasureNameNamespaceError(addNamespaceConfig.envFlag, "1federated_"),
+		},
+		{
+			name:           "error invalid boolean_metrics option",
+			lambdaOptions:  []lambdaEnvOptions{{key: booleanMetricsConfig.envFlag, value: "up|(.*_info"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBooleanMetricsError("up|(.*_info"),
+		},
+		{
+			name:           "error invalid validate_label_names option",
+			lambdaOptions:  []lambdaEnvOptions{{key: validateLabelsConfig.envFlag, value: "rename"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseValidateLabelNamesError("rename"),
+		},
+		{
+			name:           "error invalid read_timestamp_columns option",
+			lambdaOptions:  []lambdaEnvOptions{{key: timestampColumnsConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseTimestampColumnsError("foo"),
+		},
+		{
+			name:           "error invalid measure_value_type option",
+			lambdaOptions:  []lambdaEnvOptions{{key: measureValueTypeConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseMeasureValueTypeError("foo"),
+		},
+		{
+			name:           "error invalid cardinality_tracking option",
+			lambdaOptions:  []lambdaEnvOptions{{key: cardinalityTrackingConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseCardinalityTrackingError("foo"),
+		},
+		{
+			name:           "error invalid lambda_context_dimensions option",
+			lambdaOptions:  []lambdaEnvOptions{{key: lambdaDimensionsConfig.envFlag, value: "aws_request_id,foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseLambdaContextDimensionsError("aws_request_id,foo"),
+		},
+		{
+			name:           "error invalid memory_store_retention option",
+			lambdaOptions:  []lambdaEnvOptions{{key: memoryRetentionConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseDurationError(memoryRetentionConfig.flag, "foo"),
+		},
+		{
+			name:           "error invalid magnetic_store_retention option",
+			lambdaOptions:  []lambdaEnvOptions{{key: magneticRetentionConfig.envFlag, value: "-1h"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseDurationError(magneticRetentionConfig.flag, "-1h"),
+		},
+		{
+			name:           "error invalid magnetic_read_timeout option",
+			lambdaOptions:  []lambdaEnvOptions{{key: magneticTimeoutConfig.envFlag, value: "-1m"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseDurationError(magneticTimeoutConfig.flag, "-1m"),
+		},
+		{
+			name:           "error invalid max_ingest_rate option",
+			lambdaOptions:  []lambdaEnvOptions{{key: maxIngestRateConfig.envFlag, value: "-1"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseMaxIngestRateError("-1"),
+		},
+		{
+			name:           "error invalid write_concurrency option",
+			lambdaOptions:  []lambdaEnvOptions{{key: writeConcurrencyConfig.envFlag, value: "0"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseWriteConcurrencyError("0"),
+		},
+		{
+			name:           "error invalid missing_destination_status option",
+			lambdaOptions:  []lambdaEnvOptions{{key: missingDestinationConfig.envFlag, value: "200"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseMissingDestinationStatusError("200"),
+		},
+		{
+			name:           "error invalid read_total_deadline option",
+			lambdaOptions:  []lambdaEnvOptions{{key: readTotalDeadlineConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseDurationError(readTotalDeadlineConfig.flag, "foo"),
+		},
+		{
+			name:           "error invalid max_read_range option",
+			lambdaOptions:  []lambdaEnvOptions{{key: maxReadRangeConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseDurationError(maxReadRangeConfig.flag, "foo"),
+		},
+		{
+			name:           "error invalid retry_base_delay option",
+			lambdaOptions:  []lambdaEnvOptions{{key: retryBaseDelayConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseDurationError(retryBaseDelayConfig.flag, "foo"),
+		},
+		{
+			name:           "error invalid retry_max_backoff option",
+			lambdaOptions:  []lambdaEnvOptions{{key: retryMaxBackoffConfig.envFlag, value: "-1s"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseDurationError(retryMaxBackoffConfig.flag, "-1s"),
+		},
+		{
+			name:           "error invalid max_read_cost option",
+			lambdaOptions:  []lambdaEnvOptions{{key: maxReadCostConfig.envFlag, value: "-1"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseMaxReadCostError("-1"),
+		},
+		{
+			name:           "error invalid default_lookback option",
+			lambdaOptions:  []lambdaEnvOptions{{key: defaultLookbackConfig.envFlag, value: "-1h"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseDurationError(defaultLookbackConfig.flag, "-1h"),
+		},
+		{
+			name:           "error invalid credential_provider option",
+			lambdaOptions:  []lambdaEnvOptions{{key: credentialProviderConfig.envFlag, value: "env,foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseCredentialProviderError("env,foo"),
+		},
+		{
+			name:           "error invalid conflicting_records option",
+			lambdaOptions:  []lambdaEnvOptions{{key: conflictingRecordsConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseConflictingRecordsError("foo"),
+		},
+		{
+			name:           "error invalid duplicate_samples option",
+			lambdaOptions:  []lambdaEnvOptions{{key: duplicateSamplesConfig.envFlag, value: "min"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseDuplicateSamplesError("min"),
+		},
+		{
+			name:           "error invalid missing_dimensions option",
+			lambdaOptions:  []lambdaEnvOptions{{key: missingDimensionsConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseMissingDimensionsError("foo"),
+		},
+		{
+			name:           "error invalid aws_tls_min_version option",
+			lambdaOptions:  []lambdaEnvOptions{{key: awsTLSMinVersionConfig.envFlag, value: "1.4"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseTLSMinVersionError("1.4"),
+		},
+		{
+			name:           "error invalid write_role_arns option",
+			lambdaOptions:  []lambdaEnvOptions{{key: writeRoleARNsConfig.envFlag, value: "foo=bar"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseWriteRoleARNsError("foo=bar"),
+		},
+		{
+			name:           "error invalid cost_tags option",
+			lambdaOptions:  []lambdaEnvOptions{{key: costTagsConfig.envFlag, value: "team"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseCostTagsError("team"),
+		},
+		{
+			name:           "error invalid read_page_size option",
+			lambdaOptions:  []lambdaEnvOptions{{key: readPageSizeConfig.envFlag, value: "1001"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseReadPageSizeError("1001"),
+		},
+		{
+			name:           "error invalid schema_lag_retries option",
+			lambdaOptions:  []lambdaEnvOptions{{key: schemaLagRetriesConfig.envFlag, value: "6"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseSchemaLagRetriesError("6"),
+		},
+		{
+			name:           "error invalid normalize_measure_names option",
+			lambdaOptions:  []lambdaEnvOptions{{key: normalizeNamesConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBoolError(normalizeNamesConfig.flag, "foo"),
+		},
+		{
+			name:           "error invalid numeric_quantiles option",
+			lambdaOptions:  []lambdaEnvOptions{{key: numericQuantilesConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBoolError(numericQuantilesConfig.flag, "foo"),
+		},
+		{
+			name:           "error numeric_quantiles with enable_multi_measure",
+			lambdaOptions:  []lambdaEnvOptions{{key: numericQuantilesConfig.envFlag, value: "true"}, {key: multiMeasureConfig.envFlag, value: "true"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewConflictingOptionsError(numericQuantilesConfig.envFlag, "cannot be used together with "+multiMeasureConfig.envFlag),
+		},
+		{
+			name:           "error invalid cross_database_reads option",
+			lambdaOptions:  []lambdaEnvOptions{{key: crossDatabaseReadsConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBoolError(crossDatabaseReadsConfig.flag, "foo"),
+		},
+		{
+			name:           "error cross_database_reads without read_databases",
+			lambdaOptions:  []lambdaEnvOptions{{key: crossDatabaseReadsConfig.envFlag, value: "true"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewConflictingOptionsError(crossDatabaseReadsConfig.envFlag, "requires the read databases to be set through "+readDatabasesConfig.envFlag),
+		},
+		{
+			name:           "error invalid reject_empty_writes option",
+			lambdaOptions:  []lambdaEnvOptions{{key: rejectEmptyWritesConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBoolError(rejectEmptyWritesConfig.flag, "foo"),
+		},
+		{
+			name:           "error invalid enable_multi_measure option",
+			lambdaOptions:  []lambdaEnvOptions{{key: multiMeasureConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBoolError(multiMeasureConfig.flag, "foo"),
+		},
+		{
+			name:           "error invalid auto_create_destinations option",
+			lambdaOptions:  []lambdaEnvOptions{{key: autoCreateConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBoolError(autoCreateConfig.flag, "foo"),
+		},
+		{
+			name:           "error auto_create_destinations with a short memory_store_retention",
+			lambdaOptions:  []lambdaEnvOptions{{key: autoCreateConfig.envFlag, value: "true"}, {key: memoryRetentionConfig.envFlag, value: "30m"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewConflictingOptionsError(autoCreateConfig.envFlag, "requires a memory store retention of at least one hour and a magnetic store retention of at least one day"),
+		},
+		{
+			name:           "error invalid emit_sample_count option",
+			lambdaOptions:  []lambdaEnvOptions{{key: emitSampleCountConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBoolError(emitSampleCountConfig.flag, "foo"),
+		},
+		{
+			name:           "error invalid return_partial_reads option",
+			lambdaOptions:  []lambdaEnvOptions{{key: partialReadsConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBoolError(partialReadsConfig.flag, "foo"),
+		},
+		{
+			name:           "error invalid require_matcher option",
+			lambdaOptions:  []lambdaEnvOptions{{key: requireMatcherConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBoolError(requireMatcherConfig.flag, "foo"),
+		},
+		{
+			name:           "error invalid combine_read_queries option",
+			lambdaOptions:  []lambdaEnvOptions{{key: combineReadQueriesConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBoolError(combineReadQueriesConfig.flag, "foo"),
+		},
+		{
+			name:           "error invalid case_insensitive_matchers option",
+			lambdaOptions:  []lambdaEnvOptions{{key: caseInsensitiveConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBoolError(caseInsensitiveConfig.flag, "foo"),
+		},
+		{
+			name:           "error invalid read_debug_columns option",
+			lambdaOptions:  []lambdaEnvOptions{{key: readDebugColumnsConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBoolError(readDebugColumnsConfig.flag, "foo"),
+		},
+		{
+			name:           "error invalid prefer_recent option",
+			lambdaOptions:  []lambdaEnvOptions{{key: preferRecentConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseBoolError(preferRecentConfig.flag, "foo"),
+		},
+		{
+			name:           "error prefer_recent without memory_store_retention",
+			lambdaOptions:  []lambdaEnvOptions{{key: preferRecentConfig.envFlag, value: "true"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewConflictingOptionsError(preferRecentConfig.envFlag, "requires the memory store retention to be set through "+memoryRetentionConfig.envFlag),
+		},
+		{
+			name:           "error invalid read_non_finite_values option",
+			lambdaOptions:  []lambdaEnvOptions{{key: nonFiniteReadsConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseNonFiniteReadsError("foo"),
+		},
+		{
+			name:           "error invalid reserved_label_names option",
+			lambdaOptions:  []lambdaEnvOptions{{key: reservedLabelsConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseReservedLabelsError("foo"),
+		},
+		{
+			name:           "error invalid record_version_strategy option",
+			lambdaOptions:  []lambdaEnvOptions{{key: recordVersionConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseRecordVersionStrategyError("foo"),
+		},
+		{
+			name:           "error invalid require_ordered_samples option",
+			lambdaOptions:  []lambdaEnvOptions{{key: orderedSamplesConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseRequireOrderedSamplesError("foo"),
+		},
+		{
+			name:           "error invalid dimension_only_reads option",
+			lambdaOptions:  []lambdaEnvOptions{{key: dimensionOnlyReadsConfig.envFlag, value: "foo"}},
+			expectedConfig: nil,
+			expectedError:  errors.NewParseDimensionOnlyReadsError("foo"),
+		},
 	}
 
 	for _, test := range tests {
@@ -716,6 +1864,7 @@ func TestWriteHandler(t *testing.T) {
 		basicAuthHeader       string
 		encodedBasicAuth      string
 		expectedStatusCode    int
+		expectedErrorType     string
 	}{
 		{
 			name:                  "success write",
@@ -734,6 +1883,7 @@ func TestWriteHandler(t *testing.T) {
 			basicAuthHeader:       basicAuthHeader,
 			encodedBasicAuth:      "",
 			expectedStatusCode:    http.StatusBadRequest,
+			expectedErrorType:     "ParseBasicAuthHeader",
 		},
 		{
 			name:                  "error no basic auth header",
@@ -743,6 +1893,7 @@ func TestWriteHandler(t *testing.T) {
 			basicAuthHeader:       "",
 			encodedBasicAuth:      "",
 			expectedStatusCode:    http.StatusBadRequest,
+			expectedErrorType:     "ParseBasicAuthHeader",
 		},
 		{
 			name:        "error reading request body",
@@ -794,6 +1945,7 @@ func TestWriteHandler(t *testing.T) {
 			basicAuthHeader:       basicAuthHeader,
 			encodedBasicAuth:      encodedBasicAuth,
 			expectedStatusCode:    http.StatusBadRequest,
+			expectedErrorType:     "SDKNonRequest",
 		},
 		{
 			name:                  "Missing database name from write",
@@ -803,6 +1955,26 @@ func TestWriteHandler(t *testing.T) {
 			basicAuthHeader:       basicAuthHeader,
 			encodedBasicAuth:      encodedBasicAuth,
 			expectedStatusCode:    http.StatusBadRequest,
+			expectedErrorType:     "MissingDatabaseWithWrite",
+		},
+		{
+			name:                  "empty write request",
+			request:               &prompb.WriteRequest{},
+			returnError:           nil,
+			getWriteRequestReader: getReaderHelper,
+			basicAuthHeader:       basicAuthHeader,
+			encodedBasicAuth:      encodedBasicAuth,
+			expectedStatusCode:    http.StatusOK,
+		},
+		{
+			name:                  "empty write request rejected",
+			request:               &prompb.WriteRequest{},
+			returnError:           errors.NewEmptyWriteRequestError(),
+			getWriteRequestReader: getReaderHelper,
+			basicAuthHeader:       basicAuthHeader,
+			encodedBasicAuth:      encodedBasicAuth,
+			expectedStatusCode:    http.StatusBadRequest,
+			expectedErrorType:     "EmptyWriteRequest",
 		},
 		{
 			name:                  "Missing table name from write",
@@ -812,6 +1984,76 @@ func TestWriteHandler(t *testing.T) {
 			basicAuthHeader:       basicAuthHeader,
 			encodedBasicAuth:      encodedBasicAuth,
 			expectedStatusCode:    http.StatusBadRequest,
+			expectedErrorType:     "MissingTableWithWrite",
+		},
+		{
+			name:                  "long label name error from write",
+			request:               validWriteRequest,
+			returnError:           errors.NewLongLabelNameError("", 0),
+			getWriteRequestReader: getReaderHelper,
+			basicAuthHeader:       basicAuthHeader,
+			encodedBasicAuth:      encodedBasicAuth,
+			expectedStatusCode:    http.StatusBadRequest,
+			expectedErrorType:     "LongLabelName",
+		},
+		{
+			name:                  "invalid sample value error from write",
+			request:               validWriteRequest,
+			returnError:           errors.NewInvalidSampleValueError(math.NaN()),
+			getWriteRequestReader: getReaderHelper,
+			basicAuthHeader:       basicAuthHeader,
+			encodedBasicAuth:      encodedBasicAuth,
+			expectedStatusCode:    http.StatusBadRequest,
+			expectedErrorType:     "InvalidSampleValue",
+		},
+		{
+			name:                  "duplicate samples error from write",
+			request:               validWriteRequest,
+			returnError:           errors.NewDuplicateSamplesError("go_gc_duration_seconds", 0),
+			getWriteRequestReader: getReaderHelper,
+			basicAuthHeader:       basicAuthHeader,
+			encodedBasicAuth:      encodedBasicAuth,
+			expectedStatusCode:    http.StatusBadRequest,
+			expectedErrorType:     "DuplicateSamples",
+		},
+		{
+			name:                  "reserved label name error from write",
+			request:               validWriteRequest,
+			returnError:           errors.NewReservedLabelNameError("time"),
+			getWriteRequestReader: getReaderHelper,
+			basicAuthHeader:       basicAuthHeader,
+			encodedBasicAuth:      encodedBasicAuth,
+			expectedStatusCode:    http.StatusBadRequest,
+			expectedErrorType:     "ReservedLabelName",
+		},
+		{
+			name:                  "invalid label name error from write",
+			request:               validWriteRequest,
+			returnError:           errors.NewInvalidLabelNameError("label-name"),
+			getWriteRequestReader: getReaderHelper,
+			basicAuthHeader:       basicAuthHeader,
+			encodedBasicAuth:      encodedBasicAuth,
+			expectedStatusCode:    http.StatusBadRequest,
+			expectedErrorType:     "InvalidLabelName",
+		},
+		{
+			name:                  "other connector error from write",
+			request:               validWriteRequest,
+			returnError:           errors.NewMissingDestinationError(),
+			getWriteRequestReader: getReaderHelper,
+			basicAuthHeader:       basicAuthHeader,
+			encodedBasicAuth:      encodedBasicAuth,
+			expectedStatusCode:    http.StatusBadRequest,
+			expectedErrorType:     "MissingDestination",
+		},
+		{
+			name:                  "unexpected error from write",
+			request:               validWriteRequest,
+			returnError:           goErrors.New("unexpected"),
+			getWriteRequestReader: getReaderHelper,
+			basicAuthHeader:       basicAuthHeader,
+			encodedBasicAuth:      encodedBasicAuth,
+			expectedStatusCode:    http.StatusInternalServerError,
 		},
 	}
 
@@ -819,7 +2061,8 @@ func TestWriteHandler(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			mockTimestreamWriter := new(mockWriter)
 			mockTimestreamWriter.On(
-				"Write",
+				"WriteWithContext",
+				mock.Anything,
 				mock.AnythingOfType(writeRequestType),
 				mock.AnythingOfType(awsCredentialsType)).Return(test.returnError)
 
@@ -830,7 +2073,7 @@ func TestWriteHandler(t *testing.T) {
 			logger := log.NewNopLogger()
 			writers := []writer{mockTimestreamWriter}
 
-			writeHandler := createWriteHandler(logger, writers)
+			writeHandler := createWriteHandler(logger, writers, false, http.StatusBadRequest)
 			recorder := httptest.NewRecorder()
 			handler := http.HandlerFunc(writeHandler)
 			handler.ServeHTTP(recorder, request)
@@ -842,41 +2085,356 @@ func TestWriteHandler(t *testing.T) {
 				test.expectedStatusCode,
 				resp.StatusCode,
 				fmt.Sprintf("Expected status code %d, received %d", test.expectedStatusCode, resp.StatusCode))
+			assert.Equal(t, test.expectedErrorType, resp.Header.Get(errorTypeHeader))
 		})
 	}
 
-	t.Run("long label name error from write", func(t *testing.T) {
-		oldHalt := halt
-		defer func() { halt = oldHalt }()
-		got := 0
-		mockHalt := func(code int) {
-			got = code
+	t.Run("write without basic auth header using the default credentials", func(t *testing.T) {
+		mockTimestreamWriter := new(mockWriter)
+		mockTimestreamWriter.On("WriteWithContext", mock.Anything, mock.AnythingOfType(writeRequestType), (*credentials.Credentials)(nil)).Return(nil)
+
+		writeData, err := proto.Marshal(validWriteRequest)
+		assert.Nil(t, err, assertInputMessage)
+		request, err := http.NewRequest("POST", "/write", strings.NewReader(string(snappy.Encode(nil, writeData))))
+		assert.Nil(t, err)
+
+		recorder := httptest.NewRecorder()
+		http.HandlerFunc(createWriteHandler(log.NewNopLogger(), []writer{mockTimestreamWriter}, false, http.StatusBadRequest)).ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+		request, err = http.NewRequest("POST", "/write", strings.NewReader(string(snappy.Encode(nil, writeData))))
+		assert.Nil(t, err)
+		recorder = httptest.NewRecorder()
+		http.HandlerFunc(createWriteHandler(log.NewNopLogger(), []writer{mockTimestreamWriter}, true, http.StatusBadRequest)).ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		mockTimestreamWriter.AssertNumberOfCalls(t, "WriteWithContext", 1)
+	})
+
+	t.Run("write to a missing destination with the configured missing destination status", func(t *testing.T) {
+		var emptyTimeSeries *prompb.TimeSeries
+		for _, err := range []error{errors.NewMissingDatabaseWithWriteError(databaseValue, emptyTimeSeries), errors.NewMissingTableWithWriteError(tableValue, emptyTimeSeries)} {
+			mockTimestreamWriter := new(mockWriter)
+			mockTimestreamWriter.On("WriteWithContext", mock.Anything, mock.AnythingOfType(writeRequestType), mock.AnythingOfType(awsCredentialsType)).Return(err)
+
+			request, requestErr := http.NewRequest("POST", "/write", getReaderHelper(t, validWriteRequest))
+			assert.Nil(t, requestErr)
+			request.Header.Set(basicAuthHeader, encodedBasicAuth)
+
+			recorder := httptest.NewRecorder()
+			http.HandlerFunc(createWriteHandler(log.NewNopLogger(), []writer{mockTimestreamWriter}, false, http.StatusUnprocessableEntity)).ServeHTTP(recorder, request)
+			assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
 		}
-		halt = mockHalt
+	})
 
+	t.Run("write exceeding the max ingest rate", func(t *testing.T) {
 		mockTimestreamWriter := new(mockWriter)
-		mockTimestreamWriter.On(
-			"Write",
-			mock.AnythingOfType(writeRequestType),
-			mock.AnythingOfType(awsCredentialsType)).Return(errors.NewLongLabelNameError("", 0))
-		getWriteRequestClient := func(t *testing.T) io.Reader {
-			writeData, err := proto.Marshal(validWriteRequest)
-			assert.Nil(t, err, assertInputMessage)
-			return strings.NewReader(string(snappy.Encode(nil, writeData)))
+		mockTimestreamWriter.On("WriteWithContext", mock.Anything, mock.AnythingOfType(writeRequestType), mock.AnythingOfType(awsCredentialsType)).
+			Return(errors.NewIngestRateExceededError(100, 2500*time.Millisecond))
+
+		request, err := http.NewRequest("POST", "/write", getReaderHelper(t, validWriteRequest))
+		assert.Nil(t, err)
+		request.Header.Set(basicAuthHeader, encodedBasicAuth)
+
+		recorder := httptest.NewRecorder()
+		http.HandlerFunc(createWriteHandler(log.NewNopLogger(), []writer{mockTimestreamWriter}, false, http.StatusBadRequest)).ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.Equal(t, "3", recorder.Header().Get(retryAfterHeader))
+		assert.Equal(t, "IngestRateExceeded", recorder.Header().Get(errorTypeHeader))
+	})
+}
+
+func TestLimitInFlightBytes(t *testing.T) {
+	writeData, err := proto.Marshal(validWriteRequest)
+	assert.Nil(t, err, assertInputMessage)
+	compressed := snappy.Encode(nil, writeData)
+
+	t.Run("reject concurrent write requests exceeding the limit", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		blockingWriter := new(mockWriter)
+		blockingWriter.On("WriteWithContext", mock.Anything, mock.AnythingOfType(writeRequestType), mock.AnythingOfType(awsCredentialsType)).Return(nil).Run(func(args mock.Arguments) {
+			started <- struct{}{}
+			<-release
+		})
+		handler := http.HandlerFunc(limitInFlightBytes(log.NewNopLogger(), int64(len(writeData)+1), createWriteHandler(log.NewNopLogger(), []writer{blockingWriter}, false, http.StatusBadRequest)))
+
+		newRequest := func() *http.Request {
+			request, err := http.NewRequest("POST", "/write", bytes.NewReader(compressed))
+			assert.Nil(t, err)
+			request.Header.Set(basicAuthHeader, encodedBasicAuth)
+			return request
 		}
-		request, err := http.NewRequest("POST", "/write", getWriteRequestClient(t))
+
+		inFlight := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			handler.ServeHTTP(inFlight, newRequest())
+			close(done)
+		}()
+		<-started
+
+		rejected := httptest.NewRecorder()
+		handler.ServeHTTP(rejected, newRequest())
+		assert.Equal(t, http.StatusServiceUnavailable, rejected.Code)
+
+		close(release)
+		<-done
+		assert.Equal(t, http.StatusOK, inFlight.Code)
+
+		// The in-flight bytes are released once the first request completes.
+		go func() { <-started }()
+		accepted := httptest.NewRecorder()
+		handler.ServeHTTP(accepted, newRequest())
+		assert.Equal(t, http.StatusOK, accepted.Code)
+		blockingWriter.AssertNumberOfCalls(t, "WriteWithContext", 2)
+	})
+
+	t.Run("accept a single write request larger than the limit", func(t *testing.T) {
+		mockTimestreamWriter := new(mockWriter)
+		mockTimestreamWriter.On("WriteWithContext", mock.Anything, mock.AnythingOfType(writeRequestType), mock.AnythingOfType(awsCredentialsType)).Return(nil)
+		handler := http.HandlerFunc(limitInFlightBytes(log.NewNopLogger(), 1, createWriteHandler(log.NewNopLogger(), []writer{mockTimestreamWriter}, false, http.StatusBadRequest)))
+
+		request, err := http.NewRequest("POST", "/write", bytes.NewReader(compressed))
+		assert.Nil(t, err)
 		request.Header.Set(basicAuthHeader, encodedBasicAuth)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		mockTimestreamWriter.AssertNumberOfCalls(t, "WriteWithContext", 1)
+	})
+}
+
+func TestMirrorWriteRequests(t *testing.T) {
+	writeData, err := proto.Marshal(validWriteRequest)
+	assert.Nil(t, err, assertInputMessage)
+	compressed := snappy.Encode(nil, writeData)
+
+	tests := []struct {
+		name             string
+		mirrorStatusCode int
+		expectedResult   string
+	}{
+		{
+			name:             "success mirroring the write request",
+			mirrorStatusCode: http.StatusNoContent,
+			expectedResult:   "success",
+		},
+		{
+			name:             "mirror failure not failing the write request",
+			mirrorStatusCode: http.StatusInternalServerError,
+			expectedResult:   "failure",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mirrored []byte
+			var mirroredHeader http.Header
+			done := make(chan struct{})
+			mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mirrored, _ = io.ReadAll(r.Body)
+				mirroredHeader = r.Header
+				close(done)
+				w.WriteHeader(test.mirrorStatusCode)
+			}))
+			defer mirror.Close()
+
+			mockTimestreamWriter := new(mockWriter)
+			mockTimestreamWriter.On("WriteWithContext", mock.Anything, mock.AnythingOfType(writeRequestType), mock.AnythingOfType(awsCredentialsType)).Return(nil)
+			handler := http.HandlerFunc(mirrorWriteRequests(log.NewNopLogger(), mirror.URL, mirror.Client(), createWriteHandler(log.NewNopLogger(), []writer{mockTimestreamWriter}, false, http.StatusBadRequest)))
+
+			request, err := http.NewRequest("POST", "/write", bytes.NewReader(compressed))
+			assert.Nil(t, err)
+			request.Header.Set(basicAuthHeader, encodedBasicAuth)
+			request.Header.Set(writeHeader, "0.1.0")
+			request.Header.Set("Content-Encoding", "snappy")
+
+			before := mirrorWritesValue(t, test.expectedResult)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			mockTimestreamWriter.AssertNumberOfCalls(t, "WriteWithContext", 1)
+			<-done
+			assert.Equal(t, compressed, mirrored)
+			assert.Equal(t, "0.1.0", mirroredHeader.Get(writeHeader))
+			assert.Equal(t, "snappy", mirroredHeader.Get("Content-Encoding"))
+			assert.Empty(t, mirroredHeader.Get(basicAuthHeader))
+			assert.Eventually(t, func() bool { return mirrorWritesValue(t, test.expectedResult) == before+1 }, time.Second, 10*time.Millisecond)
+		})
+	}
+
+	t.Run("unreachable mirror not failing the write request", func(t *testing.T) {
+		mirror := httptest.NewServer(http.NotFoundHandler())
+		mirror.Close()
+
+		mockTimestreamWriter := new(mockWriter)
+		mockTimestreamWriter.On("WriteWithContext", mock.Anything, mock.AnythingOfType(writeRequestType), mock.AnythingOfType(awsCredentialsType)).Return(nil)
+		handler := http.HandlerFunc(mirrorWriteRequests(log.NewNopLogger(), mirror.URL, mirror.Client(), createWriteHandler(log.NewNopLogger(), []writer{mockTimestreamWriter}, false, http.StatusBadRequest)))
+
+		request, err := http.NewRequest("POST", "/write", bytes.NewReader(compressed))
 		assert.Nil(t, err)
-		logger := log.NewNopLogger()
-		writers := []writer{mockTimestreamWriter}
-		writeHandler := createWriteHandler(logger, writers)
+		request.Header.Set(basicAuthHeader, encodedBasicAuth)
+
+		before := mirrorWritesValue(t, "failure")
 		recorder := httptest.NewRecorder()
-		handler := http.HandlerFunc(writeHandler)
 		handler.ServeHTTP(recorder, request)
-		assert.Equal(t, 1, got)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		mockTimestreamWriter.AssertNumberOfCalls(t, "WriteWithContext", 1)
+		assert.Eventually(t, func() bool { return mirrorWritesValue(t, "failure") == before+1 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("slow mirror not delaying the write response", func(t *testing.T) {
+		release := make(chan struct{})
+		mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer mirror.Close()
+		defer close(release)
+
+		mockTimestreamWriter := new(mockWriter)
+		mockTimestreamWriter.On("WriteWithContext", mock.Anything, mock.AnythingOfType(writeRequestType), mock.AnythingOfType(awsCredentialsType)).Return(nil)
+		handler := http.HandlerFunc(mirrorWriteRequests(log.NewNopLogger(), mirror.URL, mirror.Client(), createWriteHandler(log.NewNopLogger(), []writer{mockTimestreamWriter}, false, http.StatusBadRequest)))
+
+		request, err := http.NewRequest("POST", "/write", bytes.NewReader(compressed))
+		assert.Nil(t, err)
+		request.Header.Set(basicAuthHeader, encodedBasicAuth)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		mockTimestreamWriter.AssertNumberOfCalls(t, "WriteWithContext", 1)
+	})
+}
+
+func TestBatchingWriter(t *testing.T) {
+	writeConcurrently := func(bw *batchingWriter, requestCredentials []*credentials.Credentials) []error {
+		errs := make([]error, len(requestCredentials))
+		var wg sync.WaitGroup
+		for i, requestCredentials := range requestCredentials {
+			wg.Add(1)
+			go func(i int, requestCredentials *credentials.Credentials) {
+				defer wg.Done()
+				req := &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+					Labels:  []*prompb.Label{{Name: model.MetricNameLabel, Value: fmt.Sprintf("metric_%d", i)}},
+					Samples: []prompb.Sample{{Timestamp: 1, Value: float64(i)}},
+				}}}
+				errs[i] = bw.WriteWithContext(context.Background(), req, requestCredentials)
+			}(i, requestCredentials)
+		}
+		wg.Wait()
+		return errs
+	}
+
+	t.Run("success coalescing the concurrent write requests", func(t *testing.T) {
+		recorder := &recordingWriter{}
+		requestCredentials := make([]*credentials.Credentials, 10)
+		for i := range requestCredentials {
+			requestCredentials[i] = credentials.NewStaticCredentials("accessKey", "secretKey", "")
+		}
+		errs := writeConcurrently(newBatchingWriter(recorder, 100*time.Millisecond), requestCredentials)
+
+		for _, err := range errs {
+			assert.Nil(t, err)
+		}
+		assert.Len(t, recorder.requests, 1)
+		var metricNames []string
+		for _, series := range recorder.requests[0].Timeseries {
+			metricNames = append(metricNames, series.Labels[0].Value)
+		}
+		assert.ElementsMatch(t, []string{"metric_0", "metric_1", "metric_2", "metric_3", "metric_4", "metric_5", "metric_6", "metric_7", "metric_8", "metric_9"}, metricNames)
+	})
+
+	t.Run("success writing the write requests with different credentials separately", func(t *testing.T) {
+		recorder := &recordingWriter{}
+		errs := writeConcurrently(newBatchingWriter(recorder, 100*time.Millisecond), []*credentials.Credentials{
+			credentials.NewStaticCredentials("accessKey", "secretKey", ""),
+			credentials.NewStaticCredentials("otherAccessKey", "secretKey", ""),
+			credentials.NewStaticCredentials("accessKey", "secretKey", ""),
+		})
+
+		for _, err := range errs {
+			assert.Nil(t, err)
+		}
+		assert.Len(t, recorder.requests, 2)
+		writtenSeries := make(map[string]int)
+		for i, req := range recorder.requests {
+			value, err := recorder.credentials[i].Get()
+			assert.Nil(t, err)
+			writtenSeries[value.AccessKeyID] += len(req.Timeseries)
+		}
+		assert.Equal(t, map[string]int{"accessKey": 2, "otherAccessKey": 1}, writtenSeries)
+	})
+
+	t.Run("server error returned to every coalesced write request", func(t *testing.T) {
+		writeError := awserr.NewRequestFailure(awserr.New(timestreamwrite.ErrCodeInternalServerException, "write failed", nil), http.StatusInternalServerError, "")
+		recorder := &recordingWriter{err: writeError}
+		errs := writeConcurrently(newBatchingWriter(recorder, 100*time.Millisecond), []*credentials.Credentials{nil, nil, nil})
+
+		for _, err := range errs {
+			assert.Equal(t, writeError, err)
+		}
+		assert.Len(t, recorder.requests, 1)
+	})
+
+	t.Run("client error only returned to the invalid coalesced write request", func(t *testing.T) {
+		writeError := errors.NewLongLabelNameError("metric_1", 256)
+		recorder := &recordingWriter{validate: func(req *prompb.WriteRequest) error {
+			for _, series := range req.Timeseries {
+				if series.Labels[0].Value == "metric_1" {
+					return writeError
+				}
+			}
+			return nil
+		}}
+		errs := writeConcurrently(newBatchingWriter(recorder, 100*time.Millisecond), []*credentials.Credentials{nil, nil, nil})
+
+		assert.Nil(t, errs[0])
+		assert.Equal(t, writeError, errs[1])
+		assert.Nil(t, errs[2])
+		// The combined write request, then each coalesced write request on its own.
+		assert.Len(t, recorder.requests, 4)
+		assert.Len(t, recorder.requests[0].Timeseries, 3)
+	})
+
+	t.Run("success logging the combined write request with the coalesced request IDs", func(t *testing.T) {
+		recorder := &recordingWriter{}
+		bw := newBatchingWriter(recorder, 100*time.Millisecond)
+
+		var wg sync.WaitGroup
+		for _, requestID := range []string{"request-1", "request-2"} {
+			wg.Add(1)
+			go func(requestID string) {
+				defer wg.Done()
+				assert.Nil(t, bw.WriteWithContext(timestream.ContextWithRequestID(context.Background(), requestID), validWriteRequest, nil))
+			}(requestID)
+		}
+		wg.Wait()
+
+		assert.Len(t, recorder.requestIDs, 1)
+		assert.ElementsMatch(t, []string{"request-1", "request-2"}, strings.Split(recorder.requestIDs[0], ","))
+	})
+
+	t.Run("success starting a new batch after the window", func(t *testing.T) {
+		recorder := &recordingWriter{}
+		bw := newBatchingWriter(recorder, 10*time.Millisecond)
+
+		assert.Nil(t, bw.WriteWithContext(context.Background(), validWriteRequest, nil))
+		assert.Nil(t, bw.WriteWithContext(context.Background(), validWriteRequest, nil))
+		assert.Len(t, recorder.requests, 2)
 	})
 }
 
+// mirrorWritesValue returns the number of mirrored write requests with the given result.
+func mirrorWritesValue(t *testing.T, result string) float64 {
+	metric := &prometheusClientModel.Metric{}
+	assert.Nil(t, mirrorWrites.WithLabelValues(result).Write(metric))
+	return metric.GetCounter().GetValue()
+}
+
 func TestReadHandler(t *testing.T) {
 	tests := []struct {
 		name                 string
@@ -887,6 +2445,7 @@ func TestReadHandler(t *testing.T) {
 		basicAuthHeader      string
 		encodedBasicAuth     string
 		expectedStatusCode   int
+		expectedErrorType    string
 	}{
 		{
 			name:                 "success read",
@@ -907,6 +2466,7 @@ func TestReadHandler(t *testing.T) {
 			basicAuthHeader:      basicAuthHeader,
 			encodedBasicAuth:     "",
 			expectedStatusCode:   http.StatusBadRequest,
+			expectedErrorType:    "ParseBasicAuthHeader",
 		},
 		{
 			name:           "error reading request body",
@@ -973,6 +2533,7 @@ func TestReadHandler(t *testing.T) {
 			basicAuthHeader:      basicAuthHeader,
 			encodedBasicAuth:     encodedBasicAuth,
 			expectedStatusCode:   http.StatusBadRequest,
+			expectedErrorType:    "MissingDatabase",
 		},
 		{
 			name:                 "Missing table name from read",
@@ -983,6 +2544,18 @@ func TestReadHandler(t *testing.T) {
 			basicAuthHeader:      basicAuthHeader,
 			encodedBasicAuth:     encodedBasicAuth,
 			expectedStatusCode:   http.StatusBadRequest,
+			expectedErrorType:    "MissingTable",
+		},
+		{
+			name:                 "Read total deadline exceeded from read",
+			request:              validReadRequest,
+			returnError:          errors.NewReadDeadlineExceededError(time.Minute),
+			returnResponse:       nil,
+			getReadRequestReader: getReaderHelper,
+			basicAuthHeader:      basicAuthHeader,
+			encodedBasicAuth:     encodedBasicAuth,
+			expectedStatusCode:   http.StatusGatewayTimeout,
+			expectedErrorType:    "ReadDeadlineExceeded",
 		},
 	}
 
@@ -990,7 +2563,8 @@ func TestReadHandler(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			mockTimestreamReader := new(mockReader)
 			mockTimestreamReader.On(
-				"Read",
+				"ReadWithContext",
+				mock.Anything,
 				mock.AnythingOfType(readRequestType),
 				mock.AnythingOfType(awsCredentialsType)).Return(test.returnResponse, test.returnError)
 
@@ -1001,7 +2575,7 @@ func TestReadHandler(t *testing.T) {
 			logger := log.NewNopLogger()
 			readers := []reader{mockTimestreamReader}
 
-			readHandler := createReadHandler(logger, readers)
+			readHandler := createReadHandler(logger, readers, false, 0, http.StatusBadRequest)
 			recorder := httptest.NewRecorder()
 			handler := http.HandlerFunc(readHandler)
 			handler.ServeHTTP(recorder, request)
@@ -1013,6 +2587,7 @@ func TestReadHandler(t *testing.T) {
 				test.expectedStatusCode,
 				resp.StatusCode,
 				fmt.Sprintf("Expected status code %d, received %d", test.expectedStatusCode, resp.StatusCode))
+			assert.Equal(t, test.expectedErrorType, resp.Header.Get(errorTypeHeader))
 
 			// Check the response body if the read was successful.
 			if test.expectedStatusCode == http.StatusOK {
@@ -1035,6 +2610,285 @@ func TestReadHandler(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("slow read cancelled at the read handler timeout", func(t *testing.T) {
+		mockTimestreamReader := new(mockReader)
+		mockTimestreamReader.On(
+			"ReadWithContext",
+			mock.Anything,
+			mock.AnythingOfType(readRequestType),
+			mock.AnythingOfType(awsCredentialsType)).Run(func(args mock.Arguments) {
+			// The read only returns once the pagination is cancelled.
+			<-args.Get(0).(context.Context).Done()
+		}).Return((*prompb.ReadResponse)(nil), goErrors.New("request context canceled"))
+
+		request, err := http.NewRequest("POST", "/read", getReaderHelper(t, validReadRequest))
+		assert.Nil(t, err)
+		request.Header.Set(basicAuthHeader, encodedBasicAuth)
+
+		begin := time.Now()
+		recorder := httptest.NewRecorder()
+		http.HandlerFunc(createReadHandler(log.NewNopLogger(), []reader{mockTimestreamReader}, false, 50*time.Millisecond, http.StatusBadRequest)).ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+		assert.GreaterOrEqual(t, time.Since(begin), 50*time.Millisecond)
+		assert.Less(t, time.Since(begin), 5*time.Second)
+		mockTimestreamReader.AssertExpectations(t)
+	})
+
+	t.Run("read from a missing destination with the configured missing destination status", func(t *testing.T) {
+		for _, err := range []error{errors.NewMissingDatabaseError(databaseValue), errors.NewMissingTableError(tableValue)} {
+			mockTimestreamReader := new(mockReader)
+			mockTimestreamReader.On("ReadWithContext", mock.Anything, mock.AnythingOfType(readRequestType), mock.AnythingOfType(awsCredentialsType)).Return((*prompb.ReadResponse)(nil), err)
+
+			request, requestErr := http.NewRequest("POST", "/read", getReaderHelper(t, validReadRequest))
+			assert.Nil(t, requestErr)
+			request.Header.Set(basicAuthHeader, encodedBasicAuth)
+
+			recorder := httptest.NewRecorder()
+			http.HandlerFunc(createReadHandler(log.NewNopLogger(), []reader{mockTimestreamReader}, false, 0, http.StatusUnprocessableEntity)).ServeHTTP(recorder, request)
+			assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		}
+	})
+}
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		name              string
+		requestIDHeader   string
+		expectedRequestID string
+	}{
+		{
+			name:              "honour the request ID sent by the client",
+			requestIDHeader:   "request-1",
+			expectedRequestID: "request-1",
+		},
+		{
+			name:            "generate a request ID without the header",
+			requestIDHeader: "",
+		},
+		{
+			name:            "generate a request ID replacing an unsafe header",
+			requestIDHeader: "request 1\nlevel=error",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requestID string
+			mockTimestreamReader := new(mockReader)
+			mockTimestreamReader.On(
+				"ReadWithContext",
+				mock.Anything,
+				mock.AnythingOfType(readRequestType),
+				mock.AnythingOfType(awsCredentialsType)).Run(func(args mock.Arguments) {
+				requestID = timestream.RequestID(args.Get(0).(context.Context))
+			}).Return((*prompb.ReadResponse)(nil), goErrors.New("error"))
+
+			request, err := http.NewRequest("POST", "/read", getReaderHelper(t, validReadRequest))
+			assert.Nil(t, err)
+			request.Header.Set(basicAuthHeader, encodedBasicAuth)
+			request.Header.Set(requestIDHeader, test.requestIDHeader)
+
+			var logs bytes.Buffer
+			recorder := httptest.NewRecorder()
+			http.HandlerFunc(withRequestID(createReadHandler(log.NewLogfmtLogger(&logs), []reader{mockTimestreamReader}, false, 0, http.StatusBadRequest))).ServeHTTP(recorder, request)
+
+			assert.Equal(t, http.StatusBadRequest, recorder.Code)
+			if len(test.expectedRequestID) != 0 {
+				assert.Equal(t, test.expectedRequestID, requestID)
+			} else {
+				assert.Regexp(t, "^[0-9a-f]{32}$", requestID)
+			}
+			assert.Equal(t, requestID, recorder.Header().Get(requestIDHeader))
+
+			// Every log line of the request shares the request ID.
+			lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+			assert.NotEmpty(t, lines)
+			for _, line := range lines {
+				assert.Contains(t, line, "request_id="+requestID)
+			}
+		})
+	}
+}
+
+func TestTelemetryHandler(t *testing.T) {
+	const openMetricsAccept = "application/openmetrics-text; version=1.0.0"
+
+	t.Run("serve the OpenMetrics format when negotiated", func(t *testing.T) {
+		request, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+		assert.Nil(t, err)
+		request.Header.Set("Accept", openMetricsAccept)
+		recorder := httptest.NewRecorder()
+		createTelemetryHandler(true).ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/openmetrics-text"))
+		assert.True(t, strings.HasSuffix(recorder.Body.String(), "# EOF\n"))
+	})
+
+	t.Run("serve the text format when OpenMetrics is disabled", func(t *testing.T) {
+		request, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+		assert.Nil(t, err)
+		request.Header.Set("Accept", openMetricsAccept)
+		recorder := httptest.NewRecorder()
+		createTelemetryHandler(false).ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+	})
+}
+
+func TestResetMetricsHandler(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		resetter := new(mockMetricsResetter)
+		resetter.On("ResetMetrics").Return()
+
+		request, err := http.NewRequest(http.MethodPost, "/admin/reset-metrics", nil)
+		assert.Nil(t, err)
+		recorder := httptest.NewRecorder()
+		http.HandlerFunc(createResetMetricsHandler(log.NewNopLogger(), resetter)).ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusNoContent, recorder.Result().StatusCode)
+		resetter.AssertNumberOfCalls(t, "ResetMetrics", 1)
+	})
+
+	t.Run("error with unsupported method", func(t *testing.T) {
+		resetter := new(mockMetricsResetter)
+
+		request, err := http.NewRequest(http.MethodGet, "/admin/reset-metrics", nil)
+		assert.Nil(t, err)
+		recorder := httptest.NewRecorder()
+		http.HandlerFunc(createResetMetricsHandler(log.NewNopLogger(), resetter)).ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Result().StatusCode)
+		resetter.AssertNumberOfCalls(t, "ResetMetrics", 0)
+	})
+}
+
+func TestHealthyHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(healthyHandler))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/-/healthy")
+	assert.Nil(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+func TestReadyHandler(t *testing.T) {
+	readiness := new(mockReadinessChecker)
+	readiness.On("CheckReady", mock.Anything).Return(fmt.Errorf("unable to reach Timestream")).Once()
+	readiness.On("CheckReady", mock.Anything).Return(nil).Once()
+	server := httptest.NewServer(http.HandlerFunc(createReadyHandler(log.NewNopLogger(), readiness, time.Second)))
+	defer server.Close()
+
+	// The connector is not ready until Timestream is reachable, and stays ready without checking again.
+	for _, expectedStatusCode := range []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusOK} {
+		response, err := http.Get(server.URL + "/-/ready")
+		assert.Nil(t, err)
+		response.Body.Close()
+		assert.Equal(t, expectedStatusCode, response.StatusCode)
+	}
+	readiness.AssertNumberOfCalls(t, "CheckReady", 2)
+
+	// The check is given a context with the timeout.
+	ctx := readiness.Calls[0].Arguments.Get(0).(context.Context)
+	_, hasDeadline := ctx.Deadline()
+	assert.True(t, hasDeadline)
+}
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		maxErrorRatio  float64
+		errorRatio     float64
+		expectedStatus int
+	}{
+		{
+			name:           "healthy without failed writes",
+			method:         http.MethodGet,
+			maxErrorRatio:  0.5,
+			errorRatio:     0,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "healthy with the error ratio at the threshold",
+			method:         http.MethodGet,
+			maxErrorRatio:  0.5,
+			errorRatio:     0.5,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unhealthy with the error ratio above the threshold",
+			method:         http.MethodGet,
+			maxErrorRatio:  0.5,
+			errorRatio:     0.75,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "healthy with the check disabled",
+			method:         http.MethodHead,
+			maxErrorRatio:  0,
+			errorRatio:     1,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "error with unsupported method",
+			method:         http.MethodPost,
+			maxErrorRatio:  0.5,
+			errorRatio:     0,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reporter := new(mockErrorRatioReporter)
+			reporter.On("RecentErrorRatio").Return(test.errorRatio, 4)
+
+			request, err := http.NewRequest(test.method, "/health", nil)
+			assert.Nil(t, err)
+			recorder := httptest.NewRecorder()
+			http.HandlerFunc(createHealthHandler(reporter, test.maxErrorRatio)).ServeHTTP(recorder, request)
+
+			assert.Equal(t, test.expectedStatus, recorder.Result().StatusCode)
+		})
+	}
+}
+
+func TestFeaturesHandler(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		a := kingpin.New("test", "")
+		var requireMatcher, combineReadQueries bool
+		var maxIngestRate int
+		a.Flag(requireMatcherConfig.flag, "").Default(requireMatcherConfig.defaultValue).BoolVar(&requireMatcher)
+		a.Flag(combineReadQueriesConfig.flag, "").Default(combineReadQueriesConfig.defaultValue).BoolVar(&combineReadQueries)
+		a.Flag(maxIngestRateConfig.flag, "").Default(maxIngestRateConfig.defaultValue).IntVar(&maxIngestRate)
+		_, err := a.Parse([]string{"--require-matcher", "--max-ingest-rate=1000"})
+		assert.Nil(t, err)
+
+		request, err := http.NewRequest(http.MethodGet, "/admin/features", nil)
+		assert.Nil(t, err)
+		recorder := httptest.NewRecorder()
+		http.HandlerFunc(createFeaturesHandler(enabledFeatures(a.Model().Flags))).ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+		assert.Equal(t, "application/json", recorder.Result().Header.Get("Content-Type"))
+		var features map[string]string
+		assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&features))
+		assert.Equal(t, map[string]string{"require-matcher": "true", "max-ingest-rate": "1000"}, features)
+	})
+
+	t.Run("error with unsupported method", func(t *testing.T) {
+		request, err := http.NewRequest(http.MethodPost, "/admin/features", nil)
+		assert.Nil(t, err)
+		recorder := httptest.NewRecorder()
+		http.HandlerFunc(createFeaturesHandler(map[string]string{})).ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Result().StatusCode)
+	})
 }
 
 // prepareData marshals and encodes valid read and write requests for unit tests.
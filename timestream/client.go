@@ -18,10 +18,13 @@ and limitations under the License.
 package timestream
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
     "github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/timestreamquery"
@@ -33,10 +36,18 @@ import (
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/prompb"
 	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"timestream-prometheus-connector/errors"
+	"unicode/utf8"
 
 	prometheusClientModel "github.com/prometheus/client_model/go"
 )
@@ -49,6 +60,31 @@ var addUserAgent = request.NamedHandler {
     Fn: request.MakeAddToUserAgentHandler("Prometheus Connector", Version),
 }
 
+// requestAttempts records the number of attempts the SDK made for each Timestream API call, including the retries.
+var requestAttempts = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "timestream_connector_request_attempts",
+		Help:    "The number of attempts made for each Timestream API call, including the retries.",
+		Buckets: prometheus.LinearBuckets(1, 1, 11),
+	},
+	[]string{"operation"},
+)
+
+// concurrentCalls records the number of Timestream API calls currently in progress.
+var concurrentCalls = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "timestream_connector_concurrent_calls",
+		Help: "The number of Timestream API calls currently in progress.",
+	},
+)
+
+var recordAttempts = request.NamedHandler{
+	Name: "RequestAttemptsHandler",
+	Fn: func(r *request.Request) {
+		requestAttempts.WithLabelValues(r.Operation.Name).Observe(float64(r.RetryCount + 1))
+	},
+}
+
 // Store the initialization function calls to allow unit tests to mock the creation of real clients.
 var initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
 	sess, err := session.NewSession(config)
@@ -56,17 +92,40 @@ var initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamW
 		return nil, err
 	}
     sess.Handlers.Build.PushFrontNamed(addUserAgent)
+	sess.Handlers.Complete.PushBackNamed(recordAttempts)
 	return timestreamwrite.New(sess), nil
 }
+
+// assumeRole returns the credentials of the IAM role, assumed with the credentials of the configuration.
+var assumeRole = func(config *aws.Config, roleARN string) (*credentials.Credentials, error) {
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	return stscreds.NewCredentials(sess, roleARN), nil
+}
+
 var initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
 	sess, err := session.NewSession(config)
 	if err != nil {
 		return nil, err
 	}
     sess.Handlers.Build.PushFrontNamed(addUserAgent)
+	sess.Handlers.Complete.PushBackNamed(recordAttempts)
 	return timestreamquery.New(sess), nil
 }
 
+// timeNow returns the current time, allowing unit tests to mock the end of the default lookback window.
+var timeNow = time.Now
+
+// schemaLagBackoff is the backoff before the first retry of a query failed with a transient schema error, doubled with
+// every following retry, allowing unit tests to retry without waiting.
+var schemaLagBackoff = time.Second
+
+// transientSchemaErrorPattern matches the message of the ValidationException returned for a column Timestream does not
+// yet recognize, such as "line 1:8: Column 'region' does not exist".
+var transientSchemaErrorPattern = regexp.MustCompile(`(?i)column '[^']*' does not exist`)
+
 // recordDestinationMap is a nested map that stores slices of Records based on the ingestion destination.
 // Below is an example of the map structure:
 // records := map[string]map[string][]*timestreamwrite.Record{
@@ -81,6 +140,18 @@ var initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQ
 // }
 type recordDestinationMap map[string]map[string][]*timestreamwrite.Record
 
+// isEmpty returns whether the map holds no Records for any destination.
+func (m recordDestinationMap) isEmpty() bool {
+	for _, tableMap := range m {
+		for _, records := range tableMap {
+			if len(records) != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 const (
 	maxMeasureNameLength        int            = 60
 	ignored                     labelOperation = "Ignored"
@@ -89,18 +160,285 @@ const (
 	timeColumnName              string         = "time"
 	measureValueColumnName      string         = "measure_value::double"
 	measureNameColumnName       string         = "measure_name"
+	measureValuePrefix          string         = "measure_value"
 	timestampLayout             string         = "2006-01-02 15:04:05.000000000"
 	millisToSecConversionRate                  = int64(time.Second) / int64(time.Millisecond)
 	nanosToMillisConversionRate                = int64(time.Millisecond) / int64(time.Nanosecond)
 )
 
+// cardinalityWindow is the duration over which the distinct measure names written to each table are tracked.
+const cardinalityWindow = time.Hour
+
+// ingestRateWindow is the duration of the sliding window over which the rate of the received samples is measured.
+const ingestRateWindow = 10 * time.Second
+
+// healthWindow is the duration of the sliding window over which the outcomes of the recent WriteRecords calls are
+// measured.
+const healthWindow = time.Minute
+
+// Timestream rejects records with a time more than maxFutureTimestamp in the future. The clamped timestamps are kept
+// clampMargin within the memory store window to allow for the time taken to send the WriteRecords request.
+const (
+	maxFutureTimestamp = 15 * time.Minute
+	clampMargin        = time.Minute
+)
+
+// The stable label values of the rejected records counter.
+const (
+	duplicateRejectionReason = "duplicate"
+	versionRejectionReason   = "version"
+	retentionRejectionReason = "retention"
+	limitRejectionReason     = "limit"
+	otherRejectionReason     = "other"
+)
+
+// The accepted ways of handling read requests without a metric name matcher.
+const (
+	AllowDimensionOnlyReads  = "allow"
+	EmptyDimensionOnlyReads  = "empty"
+	RejectDimensionOnlyReads = "reject"
+)
+
+// The accepted ways of handling labels colliding with the column names reserved by Timestream.
+const (
+	RenameReservedLabels = "rename"
+	FailReservedLabels   = "fail"
+)
+
+// The accepted strategies of populating the version of the ingested Records.
+const (
+	NoRecordVersion        = "none"
+	TimestampRecordVersion = "timestamp"
+	CounterRecordVersion   = "counter"
+	SampleRecordVersion    = "sample"
+)
+
+// The sample record version strategy keeps the time elapsed since the sample, in milliseconds, in the lowest
+// sampleVersionBits of the version, and the sample timestamp in the remaining bits. The elapsed time is capped at
+// maxSampleVersionElapsed, about 17 minutes.
+const (
+	sampleVersionBits       = 20
+	maxSampleVersionElapsed = 1<<sampleVersionBits - 1
+)
+
+// The accepted ways of validating the timestamp order of the samples within a time series.
+const (
+	OffOrderedSamples    = "off"
+	WarnOrderedSamples   = "warn"
+	RejectOrderedSamples = "reject"
+)
+
+// The accepted ways of resolving the Records of a write request with the same destination, dimensions, measure name
+// and time but different measure values.
+const (
+	OffConflictingRecords   = "off"
+	FirstConflictingRecords = "first"
+	LastConflictingRecords  = "last"
+	ErrorConflictingRecords = "error"
+)
+
+// The accepted ways of resolving the samples of a time series with the same timestamp but different values.
+const (
+	OffDuplicateSamples   = "off"
+	FirstDuplicateSamples = "first"
+	LastDuplicateSamples  = "last"
+	MaxDuplicateSamples   = "max"
+	ErrorDuplicateSamples = "error"
+)
+
+// The accepted measure value types of the ingested samples. The auto measure value type writes the integral samples
+// within the range of a 64-bit integer as BIGINT and the others as DOUBLE.
+const (
+	DoubleMeasureValueType = "double"
+	BigintMeasureValueType = "bigint"
+	AutoMeasureValueType   = "auto"
+)
+
+// The accepted ways of handling time series missing any of the required dimensions.
+const (
+	FailMissingDimensions   = "fail"
+	IgnoreMissingDimensions = "ignore"
+)
+
+// The accepted ways of handling label names not matching the Prometheus label name pattern [a-zA-Z_][a-zA-Z0-9_]*.
+const (
+	OffLabelNameValidation      = "off"
+	FailLabelNameValidation     = "fail"
+	SanitizeLabelNameValidation = "sanitize"
+	IgnoreLabelNameValidation   = "ignore"
+)
+
+// SampleCountSuffix is appended to the measure name of the companion Records counting the samples of a time series.
+const SampleCountSuffix = "__sample_count__"
+
+// InstanceIDDimension is the dimension attributing the ingested Records to the connector instance writing them.
+const InstanceIDDimension = "connector_instance_id"
+
+// SchemaVersionDimension is the dimension carrying the version of the layout of the ingested Records, so readers can
+// distinguish the Records written with different layouts. SchemaVersion is the version of the current layout, with a
+// single measure per Record and the labels as dimensions.
+const (
+	SchemaVersionDimension = "__schema_version__"
+	SchemaVersion          = "1"
+)
+
+// MultiMeasureName is the measure name of the multi-measure Records written with EnableMultiMeasure, which group the
+// samples with the same dimensions and time as measures named after their metric names. Timestream accepts at most
+// maxMeasuresPerRecord measures in a multi-measure Record.
+const (
+	MultiMeasureName     = "prometheus_metrics"
+	maxMeasuresPerRecord = 256
+)
+
+// The retention of the tables created with AutoCreateDestinations, used for the store whose retention is not configured
+// while the other one is. These are the defaults of Timestream.
+const (
+	defaultMemoryStoreRetentionHours  = 6
+	defaultMagneticStoreRetentionDays = 73000
+)
+
+// unselectiveReadCostFactor is the factor of the estimated cost of the read queries not matching a single metric name.
+const unselectiveReadCostFactor = 10
+
+// The measure names of the multi-measure Records written for the summary quantiles with NumericQuantiles, holding the
+// sample and the quantile as a DOUBLE.
+const (
+	QuantileSampleMeasureName = "sample_value"
+	QuantileMeasureName       = "quantile_value"
+)
+
+// StdoutAuditLog is the audit log sink writing the audit entries to the standard output.
+const StdoutAuditLog = "stdout"
+
+// reservedLabelPrefix is prepended to the names of the labels colliding with the column names reserved by Timestream.
+const reservedLabelPrefix = "label_"
+
+// debugColumnLabelPrefix prefixes the names of the meta-labels holding the raw column values of the read query results.
+const debugColumnLabelPrefix = "__timestream_column_"
+
+// labelNamePattern matches the valid Prometheus label names.
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// invalidLabelNameCharacters matches the characters of a column name not allowed in a Prometheus label name.
+var invalidLabelNameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// The accepted ways of handling NaN and infinite values read from Timestream.
+const (
+	PassNonFiniteReads = "pass"
+	SkipNonFiniteReads = "skip"
+)
+
+// The accepted ways of reading the timestamp columns other than the time column, which are read as labels.
+const (
+	RawTimestampColumns     = "raw"
+	UnixTimestampColumns    = "unix-ms"
+	RFC3339TimestampColumns = "rfc3339"
+	DropTimestampColumns    = "drop"
+)
+
+// QueryClientOptions configures how the query client translates the Prometheus read requests and handles the results.
+type QueryClientOptions struct {
+	DimensionOnlyReads    string
+	ReadTables            []string
+	ReadDatabases         []string
+	CrossDatabaseReads    bool
+	MemoryStoreRetention  time.Duration
+	MagneticReadTimeout   time.Duration
+	ReadTotalDeadline     time.Duration
+	MaxReadRange          time.Duration
+	MaxReadCost           float64
+	NonFiniteReads        string
+	TimestampColumns      string
+	DefaultLookback       time.Duration
+	PreferRecent          bool
+	CaseInsensitive       bool
+	CombineReadQueries    bool
+	RequireMatcher        bool
+	ReturnPartialReads    bool
+	ReadPageSize          int
+	SchemaLagRetries      int
+	NormalizeMeasureNames bool
+	NumericQuantiles      bool
+	ReadDebugColumns      bool
+	InfBucketValue        string
+	AddNamespace          string
+}
+
+// WriteClientOptions configures how the write client converts and ingests the Prometheus time series.
+type WriteClientOptions struct {
+	FailOnLongMetricLabelName bool
+	FailOnInvalidSample       bool
+	MaxSamplesPerSeries       int
+	RetryOnAuthError          bool
+	DumpRecordsFile           string
+	DefaultMeasureName        string
+	ReservedLabels            string
+	AuditLog                  string
+	RecordVersionStrategy     string
+	RequireOrderedSamples     string
+	WriteRoleARNs             map[string]string
+	CostTags                  map[string]string
+	DeadLetterDir             string
+	ConflictingRecords        string
+	DuplicateSamples          string
+	InstanceID                string
+	RequiredDimensions        []string
+	PerMetricStats            []string
+	MissingDimensions         string
+	ValidateLabelNames        string
+	ExpandJSONLabel           string
+	InfBucketValue            string
+	DatabaseLabel             string
+	TableLabel                string
+	StripNamespace            string
+	BooleanMetrics            *regexp.Regexp
+	MeasureValueType          string
+	NormalizeMeasureNames     bool
+	NumericQuantiles          bool
+	EmitSampleCount           bool
+	EmitSchemaVersion         bool
+	RejectEmptyWrites         bool
+	ClampTimestamps           bool
+	EnableMultiMeasure        bool
+	MemoryStoreRetention      time.Duration
+	MagneticStoreRetention    time.Duration
+	AutoCreateDestinations    bool
+	CardinalityTracking       int
+	MaxIngestRate             int
+	WriteConcurrency          int
+}
+
 type QueryClient struct {
-	client            *Client
-	config            *aws.Config
-	logger            log.Logger
-	readExecutionTime prometheus.Histogram
-	readRequests      prometheus.Counter
-	timestreamQuery   timestreamqueryiface.TimestreamQueryAPI
+	client                *Client
+	config                *aws.Config
+	logger                log.Logger
+	readExecutionTime     prometheus.Histogram
+	readRequests          prometheus.Counter
+	partialReads          prometheus.Counter
+	dimensionOnlyReads    string
+	readTables            []string
+	readDatabases         []string
+	crossDatabaseReads    bool
+	memoryStoreRetention  time.Duration
+	magneticReadTimeout   time.Duration
+	readTotalDeadline     time.Duration
+	maxReadRange          time.Duration
+	maxReadCost           float64
+	nonFiniteReads        string
+	timestampColumns      string
+	defaultLookback       time.Duration
+	preferRecent          bool
+	caseInsensitive       bool
+	combineReadQueries    bool
+	requireMatcher        bool
+	returnPartialReads    bool
+	readPageSize          int
+	schemaLagRetries      int
+	normalizeMeasureNames bool
+	numericQuantiles      bool
+	readDebugColumns      bool
+	infBucketValue        string
+	addNamespace          string
 }
 
 type WriteClient struct {
@@ -109,18 +447,80 @@ type WriteClient struct {
 	logger                    log.Logger
 	ignoredSamples            prometheus.Counter
 	receivedSamples           prometheus.Counter
+	clampedSamples            prometheus.Counter
+	droppedDuplicateSamples   prometheus.Counter
+	distinctMeasures          *prometheus.GaugeVec
+	metricSamples             *prometheus.CounterVec
+	rejectedRecords           *prometheus.CounterVec
 	writeRequests             prometheus.Counter
 	writeExecutionTime        prometheus.Histogram
-	timestreamWrite           timestreamwriteiface.TimestreamWriteAPI
+	writeBatchSize            prometheus.Histogram
+	throttledRequests         prometheus.Counter
 	failOnLongMetricLabelName bool
 	failOnInvalidSample       bool
+	maxSamplesPerSeries       int
+	retryOnAuthError          bool
+	dumpRecordsFile           string
+	defaultMeasureName        string
+	reservedLabels            string
+	auditLog                  string
+	recordVersionStrategy     string
+	requireOrderedSamples     string
+	writeRoleARNs             map[string]string
+	costTags                  map[string]string
+	deadLetterDir             string
+	conflictingRecords        string
+	duplicateSamples          string
+	instanceID                string
+	requiredDimensions        []string
+	perMetricStats            map[string]struct{}
+	missingDimensions         string
+	validateLabelNames        string
+	expandJSONLabel           string
+	infBucketValue            string
+	databaseLabel             string
+	tableLabel                string
+	stripNamespace            string
+	booleanMetrics            *regexp.Regexp
+	measureValueType          string
+	normalizeMeasureNames     bool
+	numericQuantiles          bool
+	emitSampleCount           bool
+	emitSchemaVersion         bool
+	rejectEmptyWrites         bool
+	clampTimestamps           bool
+	enableMultiMeasure        bool
+	memoryStoreRetention      time.Duration
+	magneticStoreRetention    time.Duration
+	autoCreateDestinations    bool
+	createdDestinations       map[string]struct{}
+	createdDestinationsMutex  sync.Mutex
+	cardinalityTracking       int
+	measureNames              map[string]map[string]struct{}
+	measureNamesWindowStart   time.Time
+	measureNamesMutex         sync.Mutex
+	maxIngestRate             int
+	writeConcurrency          int
+	ingestedSamples           []ingestedSamples
+	ingestedSamplesMutex      sync.Mutex
+	writeOutcomes             []writeOutcomes
+	writeOutcomesMutex        sync.Mutex
+	roleCredentials           map[string]*credentials.Credentials
+	roleCredentialsMutex      sync.Mutex
+	versionCounter            int64
 }
 
 type Client struct {
 	queryClient     *QueryClient
 	writeClient     *WriteClient
+	rollupClient    *RollupClient
 	defaultDataBase string
 	defaultTable    string
+	semaphore       chan struct{}
+	callInterval    time.Duration
+	nextCall        time.Time
+	rateMutex       sync.Mutex
+	metricsMutex    sync.RWMutex
 }
 
 // NewBaseClient creates a Timestream Client object with the ingestion destination labels.
@@ -133,492 +533,2347 @@ func NewBaseClient(defaultDataBase, defaultTable string) *Client {
 	return client
 }
 
+// LimitConcurrency limits the number of concurrent Timestream API calls shared by the write, query and rollup clients.
+func (c *Client) LimitConcurrency(maxConcurrency int) {
+	if maxConcurrency > 0 {
+		c.semaphore = make(chan struct{}, maxConcurrency)
+	}
+}
+
+// LimitRate limits the Timestream API calls shared by the write, query and rollup clients to maxRPS calls per second,
+// queuing the calls beyond the limit so the connector does not get throttled by Timestream.
+func (c *Client) LimitRate(maxRPS int) {
+	if maxRPS > 0 {
+		c.callInterval = time.Second / time.Duration(maxRPS)
+	}
+}
+
+// waitForRate blocks until a Timestream API call is allowed by the rate limit, spacing the calls evenly.
+func (c *Client) waitForRate() {
+	if c.callInterval == 0 {
+		return
+	}
+	c.rateMutex.Lock()
+	now := time.Now()
+	call := c.nextCall
+	if call.Before(now) {
+		call = now
+	}
+	c.nextCall = call.Add(c.callInterval)
+	c.rateMutex.Unlock()
+	time.Sleep(call.Sub(now))
+}
+
+// acquire blocks until a Timestream API call is allowed by the rate and concurrency limits, and returns the function
+// releasing it.
+func (c *Client) acquire() func() {
+	c.waitForRate()
+	if c.semaphore != nil {
+		c.semaphore <- struct{}{}
+	}
+	concurrentCalls.Inc()
+	return func() {
+		concurrentCalls.Dec()
+		if c.semaphore != nil {
+			<-c.semaphore
+		}
+	}
+}
+
 // NewQueryClient creates a new Timestream query client with the given set of configuration.
-func (c *Client) NewQueryClient(logger log.Logger, configs *aws.Config) {
+func (c *Client) NewQueryClient(logger log.Logger, configs *aws.Config, options QueryClientOptions) {
 	c.queryClient = &QueryClient{
-		client: c,
-		logger: logger,
-		config: configs,
-		readRequests: prometheus.NewCounter(
-			prometheus.CounterOpts{
-				Name: "timestream_connector_read_requests_total",
-				Help: "The total number of query requests to Timestream.",
-			},
-		),
-		readExecutionTime: prometheus.NewHistogram(
-			prometheus.HistogramOpts{
-				Name:    "timestream_connector_read_duration_seconds",
-				Help:    "The total execution time for the read requests.",
-				Buckets: prometheus.DefBuckets,
-			},
-		),
+		client:                c,
+		logger:                logger,
+		config:                configs,
+		dimensionOnlyReads:    options.DimensionOnlyReads,
+		readTables:            options.ReadTables,
+		readDatabases:         options.ReadDatabases,
+		crossDatabaseReads:    options.CrossDatabaseReads,
+		memoryStoreRetention:  options.MemoryStoreRetention,
+		magneticReadTimeout:   options.MagneticReadTimeout,
+		readTotalDeadline:     options.ReadTotalDeadline,
+		maxReadRange:          options.MaxReadRange,
+		maxReadCost:           options.MaxReadCost,
+		nonFiniteReads:        options.NonFiniteReads,
+		timestampColumns:      options.TimestampColumns,
+		defaultLookback:       options.DefaultLookback,
+		preferRecent:          options.PreferRecent,
+		caseInsensitive:       options.CaseInsensitive,
+		combineReadQueries:    options.CombineReadQueries,
+		requireMatcher:        options.RequireMatcher,
+		returnPartialReads:    options.ReturnPartialReads,
+		readPageSize:          options.ReadPageSize,
+		schemaLagRetries:      options.SchemaLagRetries,
+		normalizeMeasureNames: options.NormalizeMeasureNames,
+		numericQuantiles:      options.NumericQuantiles,
+		readDebugColumns:      options.ReadDebugColumns,
+		infBucketValue:        options.InfBucketValue,
+		addNamespace:          options.AddNamespace,
 	}
+	c.queryClient.createMetrics()
+}
+
+// createMetrics creates the metrics collected from the query client.
+func (qc *QueryClient) createMetrics() {
+	qc.readRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "timestream_connector_read_requests_total",
+			Help: "The total number of query requests to Timestream.",
+		},
+	)
+	qc.readExecutionTime = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "timestream_connector_read_duration_seconds",
+			Help:    "The total execution time for the read requests.",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+	qc.partialReads = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "timestream_connector_partial_reads_total",
+			Help: "The total number of read requests answered with the partial results converted before a query failed.",
+		},
+	)
 }
 
 // NewWriteClient creates a new Timestream write client with a given set of configurations.
-func (c *Client) NewWriteClient(logger log.Logger, configs *aws.Config, failOnLongMetricLabelName bool, failOnInvalidSample bool) {
+func (c *Client) NewWriteClient(logger log.Logger, configs *aws.Config, options WriteClientOptions) {
 	c.writeClient = &WriteClient{
 		client:                    c,
 		logger:                    logger,
 		config:                    configs,
-		failOnLongMetricLabelName: failOnLongMetricLabelName,
-		failOnInvalidSample:       failOnInvalidSample,
-		ignoredSamples: prometheus.NewCounter(
-			prometheus.CounterOpts{
-				Name: "timestream_connector_ignored_samples_total",
-				Help: "The total number of samples not sent to Timestream due to long metric/label name and unsupported non-finite float values (Inf, -Inf, NaN).",
-			},
-		),
-		receivedSamples: prometheus.NewCounter(
-			prometheus.CounterOpts{
-				Name: "timestream_connector_received_samples_total",
-				Help: "The total number of samples received by the Prometheus connector.",
-			},
-		),
-		writeRequests: prometheus.NewCounter(
-			prometheus.CounterOpts{
-				Name: "timestream_connector_write_requests_total",
-				Help: "The total number of data ingestion requests to Timestream.",
-			},
-		),
-		writeExecutionTime: prometheus.NewHistogram(
-			prometheus.HistogramOpts{
-				Name:    "timestream_connector_write_duration_seconds",
-				Help:    "The total execution time for the write requests.",
-				Buckets: prometheus.DefBuckets,
-			},
-		),
+		failOnLongMetricLabelName: options.FailOnLongMetricLabelName,
+		failOnInvalidSample:       options.FailOnInvalidSample,
+		maxSamplesPerSeries:       options.MaxSamplesPerSeries,
+		retryOnAuthError:          options.RetryOnAuthError,
+		dumpRecordsFile:           options.DumpRecordsFile,
+		defaultMeasureName:        options.DefaultMeasureName,
+		reservedLabels:            options.ReservedLabels,
+		auditLog:                  options.AuditLog,
+		recordVersionStrategy:     options.RecordVersionStrategy,
+		requireOrderedSamples:     options.RequireOrderedSamples,
+		writeRoleARNs:             options.WriteRoleARNs,
+		costTags:                  options.CostTags,
+		deadLetterDir:             options.DeadLetterDir,
+		conflictingRecords:        options.ConflictingRecords,
+		duplicateSamples:          options.DuplicateSamples,
+		instanceID:                options.InstanceID,
+		requiredDimensions:        options.RequiredDimensions,
+		perMetricStats:            make(map[string]struct{}, len(options.PerMetricStats)),
+		missingDimensions:         options.MissingDimensions,
+		validateLabelNames:        options.ValidateLabelNames,
+		expandJSONLabel:           options.ExpandJSONLabel,
+		infBucketValue:            options.InfBucketValue,
+		databaseLabel:             options.DatabaseLabel,
+		tableLabel:                options.TableLabel,
+		stripNamespace:            options.StripNamespace,
+		booleanMetrics:            options.BooleanMetrics,
+		measureValueType:          options.MeasureValueType,
+		normalizeMeasureNames:     options.NormalizeMeasureNames,
+		numericQuantiles:          options.NumericQuantiles,
+		emitSampleCount:           options.EmitSampleCount,
+		emitSchemaVersion:         options.EmitSchemaVersion,
+		rejectEmptyWrites:         options.RejectEmptyWrites,
+		clampTimestamps:           options.ClampTimestamps,
+		enableMultiMeasure:        options.EnableMultiMeasure,
+		memoryStoreRetention:      options.MemoryStoreRetention,
+		magneticStoreRetention:    options.MagneticStoreRetention,
+		autoCreateDestinations:    options.AutoCreateDestinations,
+		createdDestinations:       make(map[string]struct{}),
+		cardinalityTracking:       options.CardinalityTracking,
+		maxIngestRate:             options.MaxIngestRate,
+		writeConcurrency:          options.WriteConcurrency,
+		measureNames:              make(map[string]map[string]struct{}),
+		roleCredentials:           make(map[string]*credentials.Credentials),
+	}
+	for _, metricName := range options.PerMetricStats {
+		c.writeClient.perMetricStats[metricName] = struct{}{}
+	}
+	c.writeClient.createMetrics()
+}
+
+// createMetrics creates the metrics collected from the write client.
+func (wc *WriteClient) createMetrics() {
+	wc.ignoredSamples = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "timestream_connector_ignored_samples_total",
+			Help: "The total number of samples not sent to Timestream due to long metric/label name, unsupported non-finite float values (Inf, -Inf, NaN), time series exceeding the maximum number of samples and conflicting samples.",
+		},
+	)
+	wc.receivedSamples = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "timestream_connector_received_samples_total",
+			Help: "The total number of samples received by the Prometheus connector.",
+		},
+	)
+	wc.clampedSamples = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "timestream_connector_clamped_samples_total",
+			Help: "The total number of samples whose timestamps were moved to the boundaries of the memory store window accepted by Timestream.",
+		},
+	)
+	wc.droppedDuplicateSamples = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "timestream_connector_duplicate_samples_total",
+			Help: "The total number of samples dropped for having the same timestamp as another sample of their time series.",
+		},
+	)
+	wc.distinctMeasures = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "timestream_connector_distinct_measures",
+			Help: "The number of distinct measure names written to each table during the current cardinality tracking window, up to the cardinality-tracking limit.",
+		},
+		[]string{"database", "table"},
+	)
+	wc.metricSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "timestream_connector_metric_ingested_samples_total",
+			Help: "The total number of samples ingested into Timestream for each metric name of the per-metric-stats allowlist.",
+		},
+		[]string{"metric"},
+	)
+	// The allowlisted metrics are exposed before their first samples are ingested, so their rates start from zero.
+	for metricName := range wc.perMetricStats {
+		wc.metricSamples.WithLabelValues(metricName)
+	}
+	wc.rejectedRecords = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "timestream_connector_rejected_records_total",
+			Help: "The total number of records rejected by Timestream, partitioned by the rejection reason.",
+		},
+		[]string{"reason"},
+	)
+	wc.writeRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "timestream_connector_write_requests_total",
+			Help: "The total number of data ingestion requests to Timestream.",
+		},
+	)
+	wc.writeExecutionTime = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "timestream_connector_write_duration_seconds",
+			Help:    "The total execution time for the write requests.",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+	wc.writeBatchSize = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "timestream_connector_write_batch_size",
+			Help:    "The number of records sent in each WriteRecords call to Timestream.",
+			Buckets: []float64{1, 5, 10, 25, 50, 75, 100},
+		},
+	)
+	wc.throttledRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "timestream_connector_throttled_write_requests_total",
+			Help: "The total number of write requests rejected because the rate of the received samples exceeds the max-ingest-rate.",
+		},
+	)
+}
+
+// ResetMetrics re-creates every metric collected from the client, resetting the counters and histograms to zero.
+// This is intended for load testing, the reset waits for the in-flight requests to complete and holds back new requests
+// until the metrics are re-created.
+func (c *Client) ResetMetrics() {
+	c.metricsMutex.Lock()
+	defer c.metricsMutex.Unlock()
+	if c.writeClient != nil {
+		c.writeClient.createMetrics()
+	}
+	if c.queryClient != nil {
+		c.queryClient.createMetrics()
 	}
+	if c.rollupClient != nil {
+		c.rollupClient.createMetrics()
+	}
+	requestAttempts.Reset()
 }
 
-// Write sends the prompb.WriteRequest to timestreamwriteiface.TimestreamWriteAPI
+// Write sends the prompb.WriteRequest to timestreamwriteiface.TimestreamWriteAPI, with a WriteRecords request per
+// table sent concurrently up to the write concurrency.
 func (wc *WriteClient) Write(req *prompb.WriteRequest, credentials *credentials.Credentials) error {
-	wc.config.Credentials = credentials
-	var err error
-	wc.timestreamWrite, err = initWriteClient(wc.config)
-	if err != nil {
-		LogError(wc.logger, "Unable to construct a new session with the given credentials.", err)
+	return wc.WriteWithContext(context.Background(), req, credentials)
+}
+
+// WriteWithContext is the same as Write, but logs with the request ID carried by the context.
+func (wc *WriteClient) WriteWithContext(ctx context.Context, req *prompb.WriteRequest, credentials *credentials.Credentials) error {
+	logger := ContextLogger(ctx, wc.logger)
+	wc.client.metricsMutex.RLock()
+	defer wc.client.metricsMutex.RUnlock()
+
+	if wc.rejectEmptyWrites && len(req.Timeseries) == 0 {
+		err := errors.NewEmptyWriteRequestError()
+		LogError(logger, "The write request does not contain any time series.", err)
 		return err
 	}
-	LogInfo(wc.logger, fmt.Sprintf("%d records requested for ingestion from Prometheus.", len(req.Timeseries)))
+
+	if wc.maxIngestRate > 0 {
+		if retryAfter, ok := wc.admitSamples(req.Timeseries); !ok {
+			wc.throttledRequests.Inc()
+			err := errors.NewIngestRateExceededError(wc.maxIngestRate, retryAfter)
+			LogError(logger, "The rate of the received samples exceeds the maximum ingestion rate.", err)
+			return err
+		}
+	}
+
+	LogInfo(logger, fmt.Sprintf("%d records requested for ingestion from Prometheus.", len(req.Timeseries)))
 	recordMap := make(recordDestinationMap)
-	recordMap, err = wc.convertToRecords(req.Timeseries, recordMap)
+	recordMap, err := wc.convertToRecords(logger, req.Timeseries, recordMap)
+	if err != nil {
+		LogError(logger, "Unable to convert the received Prometheus write request to Timestream Records.", err)
+		return err
+	}
+
+	if len(wc.dumpRecordsFile) != 0 {
+		if err := wc.dumpRecords(recordMap); err != nil {
+			LogError(logger, fmt.Sprintf("Unable to dump the converted Timestream Records to %s.", wc.dumpRecordsFile), err)
+		}
+	}
+
+	if recordMap.isEmpty() {
+		// All the time series were filtered out, there is nothing to send to Timestream.
+		LogDebug(logger, "No Timestream Records to ingest, skipping the write request.")
+		return nil
+	}
+
+	config := wc.config.Copy()
+	if credentials != nil {
+		config.Credentials = credentials
+	}
+	timestreamWrite, err := initWriteClient(config)
 	if err != nil {
-		LogError(wc.logger, "Unable to convert the received Prometheus write request to Timestream Records.", err)
+		LogError(logger, "Unable to construct a new session with the given credentials.", err)
 		return err
 	}
 
 	var sdkErr error
+	var sdkErrMutex sync.Mutex
+	var retried int32
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, max(wc.writeConcurrency, 1))
 	for database, tableMap := range recordMap {
 		for table, records := range tableMap {
-			writeRecordsInput := &timestreamwrite.WriteRecordsInput{
-				DatabaseName: aws.String(database),
-				TableName:    aws.String(table),
-				Records:      records,
-			}
-			begin := time.Now()
-			_, err = wc.timestreamWrite.WriteRecords(writeRecordsInput)
-			duration := time.Since(begin).Seconds()
-			if err != nil {
-				sdkErr = wc.handleSDKErr(req, err, sdkErr)
-			} else {
-				LogInfo(wc.logger, fmt.Sprintf("Successfully wrote %d records to database: %s table: %s", len(writeRecordsInput.Records), database, table))
-				recordsIgnored := getCounterValue(wc.ignoredSamples)
-				if (recordsIgnored > 0) {
-					LogInfo(wc.logger, fmt.Sprintf("%d number of records were rejected for ingestion to Timestream. See Troubleshooting in the README for why these may be rejected, or turn on debug logging for additional info.", recordsIgnored))
+			write := func() {
+				if err := wc.writeTable(logger, config, timestreamWrite, credentials, database, table, records, &retried); err != nil {
+					sdkErrMutex.Lock()
+					sdkErr = wc.handleSDKErr(logger, req, err, sdkErr)
+					sdkErrMutex.Unlock()
 				}
 			}
-			wc.writeExecutionTime.Observe(duration)
-			wc.writeRequests.Inc()
+			if wc.writeConcurrency <= 1 {
+				write()
+				continue
+			}
+			semaphore <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+				write()
+			}()
 		}
 	}
+	wg.Wait()
 
 	return sdkErr
 }
 
+// writeTable writes the Records of a table with a single WriteRecords request and returns its error. The request is
+// retried once per write request after an auth error, shared by the tables through retried, and once after creating
+// the missing destination if enabled.
+func (wc *WriteClient) writeTable(logger log.Logger, config *aws.Config, timestreamWrite timestreamwriteiface.TimestreamWriteAPI, credentials *credentials.Credentials, database string, table string, records []*timestreamwrite.Record, retried *int32) error {
+	writeRecordsInput := &timestreamwrite.WriteRecordsInput{
+		DatabaseName: aws.String(database),
+		TableName:    aws.String(table),
+		Records:      records,
+	}
+	tableWrite, err := wc.destinationClient(config, timestreamWrite, table)
+	if err != nil {
+		LogError(logger, fmt.Sprintf("Unable to construct a new session with the IAM role of table %s.", table), err)
+		return err
+	}
+	begin := time.Now()
+	release := wc.client.acquire()
+	_, err = tableWrite.WriteRecords(writeRecordsInput)
+	release()
+	if err != nil && wc.retryOnAuthError && isAuthError(err) && atomic.CompareAndSwapInt32(retried, 0, 1) {
+		// Newly rotated credentials may still be propagating, retry once before returning the error.
+		err = wc.retryOnAuthFailure(logger, tableWrite, config, credentials, writeRecordsInput)
+	}
+	if err != nil && wc.autoCreateDestinations && isResourceNotFound(err) {
+		err = wc.retryOnMissingDestination(logger, tableWrite, writeRecordsInput)
+	}
+	duration := time.Since(begin).Seconds()
+	wc.recordWriteOutcome(err)
+	if err != nil {
+		if len(wc.deadLetterDir) != 0 && !isRetryable(err) {
+			if err := wc.deadLetter(logger, writeRecordsInput, err); err != nil {
+				LogError(logger, fmt.Sprintf("Unable to write the failed records to the dead-letter directory %s.", wc.deadLetterDir), err)
+			}
+		}
+	} else {
+		LogInfo(logger, fmt.Sprintf("Successfully wrote %d records to database: %s table: %s", len(writeRecordsInput.Records), database, table))
+		if wc.client.rollupClient != nil {
+			wc.client.rollupClient.add(database, table, records, credentials)
+		}
+		if len(wc.perMetricStats) != 0 {
+			wc.countMetricSamples(records)
+		}
+		if len(wc.auditLog) != 0 {
+			if err := wc.audit(database, table, records); err != nil {
+				LogError(logger, fmt.Sprintf("Unable to write the audit entry to %s.", wc.auditLog), err)
+			}
+		}
+		recordsIgnored := getCounterValue(wc.ignoredSamples)
+		if (recordsIgnored > 0) {
+			LogInfo(logger, fmt.Sprintf("%d number of records were rejected for ingestion to Timestream. See Troubleshooting in the README for why these may be rejected, or turn on debug logging for additional info.", recordsIgnored))
+		}
+	}
+	observeWithExemplar(wc.writeExecutionTime, duration, prometheus.Labels{"table": table})
+	wc.writeBatchSize.Observe(float64(len(records)))
+	wc.writeRequests.Inc()
+	return err
+}
+
 // Read converts the Prometheus prompb.ReadRequest into Timestream queries and return
 // the result set as Prometheus prompb.ReadResponse.
 func (qc *QueryClient) Read(req *prompb.ReadRequest, credentials *credentials.Credentials) (*prompb.ReadResponse, error) {
-	qc.config.Credentials = credentials
-	var err error
-	qc.timestreamQuery, err = initQueryClient(qc.config)
+	return qc.ReadWithContext(context.Background(), req, credentials)
+}
+
+// ReadWithContext is the same as Read, but stops paginating the query results and returns an error once the context
+// is cancelled or its deadline is exceeded, and logs with the request ID carried by the context. A positive read total
+// deadline bounds all the queries and pages of the read request together, and a read exceeding it returns a
+// ReadDeadlineExceededError instead of partial results. If return-partial-reads is enabled, a read whose query fails after
+// some time series were converted, including a read exceeding its deadline, returns these time series instead of the
+// error.
+func (qc *QueryClient) ReadWithContext(ctx context.Context, req *prompb.ReadRequest, credentials *credentials.Credentials) (*prompb.ReadResponse, error) {
+	logger := ContextLogger(ctx, qc.logger)
+	parentCtx := ctx
+	if qc.readTotalDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, qc.readTotalDeadline)
+		defer cancel()
+	}
+	deadlineCtx := ctx
+	qc.client.metricsMutex.RLock()
+	defer qc.client.metricsMutex.RUnlock()
+
+	config := qc.config.Copy()
+	if credentials != nil {
+		config.Credentials = credentials
+	}
+	timestreamQuery, err := initQueryClient(config)
 	if err != nil {
-		LogError(qc.logger, "Unable to construct a new session with the given credentials", err)
+		LogError(logger, "Unable to construct a new session with the given credentials", err)
 		return nil, err
 	}
 
-	queryInputs, isRelatedToRegex, err := qc.buildCommands(req.Queries)
+	queryInputs, isRelatedToRegex, err := qc.buildCommands(logger, req.Queries)
 	if err != nil {
-		LogError(qc.logger, "Error occurred while translating Prometheus query.", err)
+		LogError(logger, "Error occurred while translating Prometheus query.", err)
 		return nil, err
 	}
 
+	if qc.maxReadCost > 0 {
+		cost := qc.estimateReadCost(logger, req.Queries)
+		if cost > qc.maxReadCost {
+			err := errors.NewMaxReadCostError(cost, qc.maxReadCost)
+			LogError(logger, "Read request exceeding the maximum estimated cost.", err)
+			return nil, err
+		}
+		LogDebug(logger, "Estimated the cost of the read request.", "cost", cost, "maxReadCost", qc.maxReadCost)
+	}
+
+	if qc.isMagneticOnly(logger, req.Queries) {
+		LogInfo(logger, "The read request only spans data older than the memory store retention, it will be served by the slower magnetic store.")
+		if qc.magneticReadTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, qc.magneticReadTimeout)
+			defer cancel()
+		}
+	}
+
+	queryPages := timestreamQuery.QueryPages
+	// A context that can never be cancelled, such as of a read without any timeout, has no Done channel.
+	if ctx.Done() != nil {
+		queryPages = func(input *timestreamquery.QueryInput, fn func(*timestreamquery.QueryOutput, bool) bool) error {
+			return timestreamQuery.QueryPagesWithContext(ctx, input, fn)
+		}
+	}
+
 	results := []*prompb.QueryResult{{}}
 	resultSet := results[0]
 
 	begin := time.Now()
 	var queryPageError error
-	for _, queryInput := range queryInputs {
-		queryPageError = qc.timestreamQuery.QueryPages(queryInput,
-			func(page *timestreamquery.QueryOutput, lastPage bool) bool {
-				var convertError error
-				resultSet, convertError = qc.convertToResult(resultSet, page)
-				qc.readRequests.Inc()
-				if convertError != nil {
-					LogError(qc.logger, "Error occurred while converting the Timestream query results to Prometheus QueryResults", err)
-					return false
-				}
-				LogInfo(qc.logger, fmt.Sprintf("Successfully read %d records from database: %s table: %s", len(page.Rows), qc.client.defaultDataBase, qc.client.defaultTable))
-				return true
-			})
+	var convertError error
+	var queryID string
+	destinations := qc.destinations()
+	for i, queryInput := range queryInputs {
+		// buildCommands generates one query per destination for each time filter of a Prometheus query, in the order of the destinations.
+		destination := destinations[i%len(destinations)]
+		for attempt := 0; ; attempt++ {
+			pages := 0
+			release := qc.client.acquire()
+			queryPageError = queryPages(queryInput,
+				func(page *timestreamquery.QueryOutput, lastPage bool) bool {
+					pages++
+					resultSet, convertError = qc.convertToResult(logger, resultSet, page)
+					qc.readRequests.Inc()
+					if page.QueryId != nil {
+						queryID = *page.QueryId
+					}
+					if convertError != nil {
+						LogError(logger, "Error occurred while converting the Timestream query results to Prometheus QueryResults", convertError)
+						return false
+					}
+					LogInfo(logger, fmt.Sprintf("Successfully read %d records from database: %s table: %s", len(page.Rows), destination.database, destination.table))
+					return true
+				})
+			release()
+			// Only retry a query that failed before returning any page, so no results are converted twice.
+			if pages != 0 || attempt >= qc.schemaLagRetries || !isTransientSchemaError(queryPageError) {
+				break
+			}
+			backoff := schemaLagBackoff << attempt
+			LogInfo(logger, fmt.Sprintf("Timestream does not yet recognize a column of the query, retrying the query in %s.", backoff), "error", queryPageError)
+			if !sleepWithContext(ctx, backoff) {
+				break
+			}
+		}
+		if convertError != nil {
+			return nil, convertError
+		}
+		if queryPageError != nil && qc.returnPartialReads && len(resultSet.Timeseries) != 0 {
+			qc.partialReads.Inc()
+			LogWarn(logger, fmt.Sprintf("Error occurred while querying Timestream pages, returning the %d time series read before the error.", len(resultSet.Timeseries)), "error", queryPageError)
+			break
+		}
+		if queryPageError != nil && deadlineCtx.Err() == context.DeadlineExceeded && parentCtx.Err() == nil {
+			LogError(logger, fmt.Sprintf("The read request did not complete within the read total deadline of %s.", qc.readTotalDeadline), queryPageError)
+			return nil, errors.NewReadDeadlineExceededError(qc.readTotalDeadline)
+		}
 		if queryPageError != nil {
 			if requestError, ok := queryPageError.(awserr.RequestFailure); ok && (requestError.StatusCode()/100 == 4) {
-				LogDebug(qc.logger, "The read request failed while retrieving data back from Timestream.", "request", req)
+				LogDebug(logger, "The read request failed while retrieving data back from Timestream.", "request", req)
 			}
 
 			if _, ok := queryPageError.(*timestreamquery.ValidationException); ok && isRelatedToRegex {
-				LogError(qc.logger, "Error occurred due to unsupported query. Please validate the regular expression used in the query. Check the documentation for unsupported RE2 syntax.", queryPageError)
+				LogError(logger, "Error occurred due to unsupported query. Please validate the regular expression used in the query. Check the documentation for unsupported RE2 syntax.", queryPageError)
 				return nil, queryPageError
 			}
 
-			LogError(qc.logger, "Error occurred while querying Timestream pages.", err)
+			LogError(logger, "Error occurred while querying Timestream pages.", err)
 			return nil, queryPageError
 		}
 	}
+	sortSamples(resultSet)
 	duration := time.Since(begin).Seconds()
-	qc.readExecutionTime.Observe(duration)
+	observeWithExemplar(qc.readExecutionTime, duration, prometheus.Labels{"query_id": queryID})
 
 	return &prompb.ReadResponse{
 		Results: results,
 	}, nil
 }
 
-// handleSDKErr parses and logs the error from SDK (if any)
-func (wc *WriteClient) handleSDKErr(req *prompb.WriteRequest, currErr error, errToReturn error) error {
-	requestError, ok := currErr.(awserr.RequestFailure)
-	if !ok {
-        LogError(wc.logger, fmt.Sprintf("Error occurred while ingesting Timestream Records. %d records failed to be written", len(req.Timeseries)), currErr)
-		return errors.NewSDKNonRequestError(currErr)
+// isTransientSchemaError returns true if the error is a ValidationException caused by a column Timestream does not yet
+// recognize, which happens for a short time after a new dimension is first written. Other ValidationExceptions, such
+// as of an unsupported regular expression, are not transient.
+func isTransientSchemaError(err error) bool {
+	validationError, ok := err.(*timestreamquery.ValidationException)
+	return ok && transientSchemaErrorPattern.MatchString(validationError.Message())
+}
+
+// sleepWithContext waits for the duration and returns true, or returns false as soon as the context is done.
+func sleepWithContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
 	}
+}
 
-	if errToReturn == nil {
-		errToReturn = requestError
+// observeWithExemplar observes the value on the histogram with the exemplar labels, which are only exposed when the
+// metrics are scraped in the OpenMetrics format. The exemplar is dropped if a label value is empty or the labels exceed
+// the maximum length of an exemplar.
+func observeWithExemplar(histogram prometheus.Histogram, value float64, exemplar prometheus.Labels) {
+	runes := 0
+	for name, labelValue := range exemplar {
+		if len(labelValue) == 0 {
+			histogram.Observe(value)
+			return
+		}
+		runes += utf8.RuneCountInString(name) + utf8.RuneCountInString(labelValue)
 	}
-	switch requestError.StatusCode() / 100 {
-	case 4:
-		LogDebug(wc.logger, "Error occurred while ingesting data due to invalid write request. Some Prometheus Samples were not ingested into Timestream, please review the write request and check the documentation for troubleshooting.", "request", req)
-	case 5:
-		errToReturn = requestError
-		LogDebug(wc.logger, "Internal server error occurred. Samples will be retried by Prometheus", "request", req)
+
+	observer, ok := histogram.(prometheus.ExemplarObserver)
+	if !ok || runes > prometheus.ExemplarMaxRunes {
+		histogram.Observe(value)
+		return
 	}
-	return errToReturn
+	observer.ObserveWithExemplar(value, exemplar)
 }
 
-// convertToRecords converts a slice of *prompb.TimeSeries to a slice of *timestreamwrite.Record
-func (wc *WriteClient) convertToRecords(series []*prompb.TimeSeries, recordMap recordDestinationMap) (recordDestinationMap, error) {
-	var operationOnLongMetrics longMetricsOperation
-	if wc.failOnLongMetricLabelName {
-		operationOnLongMetrics = func(measureValueName string) (labelOperation, error) {
-			if len(measureValueName) > maxMeasureNameLength {
-				err := errors.NewLongLabelNameError(measureValueName, maxMeasureNameLength)
-				LogError(wc.logger, "fail-on-long-label flag is enabled for long metric name.", err)
-				return failed, err
-			}
-			return unmodified, nil
-		}
-	} else {
-		operationOnLongMetrics = func(measureValueName string) (labelOperation, error) {
-			if len(measureValueName) > maxMeasureNameLength {
-				wc.ignoredSamples.Inc()
-				LogDebug(wc.logger, "fail-on-long-label flag is disabled for metric name. Time series ignored.", "ignoredMeasureName", measureValueName)
-				return ignored, nil
-			}
+// isMagneticOnly returns true if every query only spans data older than the memory store retention.
+func (qc *QueryClient) isMagneticOnly(logger log.Logger, queries []*prompb.Query) bool {
+	if qc.memoryStoreRetention <= 0 || len(queries) == 0 {
+		return false
+	}
 
-			return unmodified, nil
+	memoryStoreStart := timeNow().Add(-qc.memoryStoreRetention).UnixNano() / nanosToMillisConversionRate
+	for _, query := range queries {
+		// Check the time range used in the generated query, which prefers the hints and applies the default lookback.
+		if _, endMs := qc.timeRange(logger, query); endMs >= memoryStoreStart {
+			return false
 		}
 	}
-	return processTimeSeries(wc, operationOnLongMetrics, series, recordMap)
+	return true
 }
 
-// processTimeSeries processes a slice of *prompb.TimeSeries to a slice of *timestreamwrite.Record
-func processTimeSeries(wc *WriteClient, operationOnLongMetrics longMetricsOperation, series []*prompb.TimeSeries, recordMap recordDestinationMap) (recordDestinationMap, error) {
-	for _, timeSeries := range series {
-		var dimensions []*timestreamwrite.Dimension
-		var err error
-		var operation labelOperation
-		var databaseName string
-		var tableName string
-		wc.receivedSamples.Add(float64(len(timeSeries.Samples)))
-
-		metricLabels, measureValueName := convertToMap(timeSeries.Labels)
+// appendMutex serializes the concurrent write requests appending to the dump file and the audit log.
+var appendMutex sync.Mutex
 
-		databaseName = wc.client.defaultDataBase
-		tableName = wc.client.defaultTable
+// appendJSONLine appends the value to the file as a single line of JSON, or writes it to the standard output if the
+// path is stdout.
+func appendJSONLine(path string, value interface{}) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
 
-		if len(databaseName) == 0 {
-			err = errors.NewMissingDatabaseWithWriteError(wc.client.defaultDataBase, timeSeries)
-			return nil, err
-		}
+	appendMutex.Lock()
+	defer appendMutex.Unlock()
 
-		if len(tableName) == 0 {
-			err = errors.NewMissingTableWithWriteError(wc.client.defaultTable, timeSeries)
-			return nil, err
-		}
+	if path == StdoutAuditLog {
+		_, err = os.Stdout.Write(line)
+		return err
+	}
 
-		operation, err = operationOnLongMetrics(measureValueName)
-		switch operation {
-		case failed:
-			return nil, err
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ValidateRetention compares the memory and magnetic store retention periods of the default table, as returned by
+// DescribeTable, with the expected retention periods, and logs a warning for each mismatch. An expected retention of 0
+// is not validated.
+func (wc *WriteClient) ValidateRetention(expectedMemoryRetention time.Duration, expectedMagneticRetention time.Duration) error {
+	if expectedMemoryRetention == 0 && expectedMagneticRetention == 0 {
+		return nil
+	}
+
+	timestreamWrite, err := initWriteClient(wc.config)
+	if err != nil {
+		return err
+	}
+	output, err := timestreamWrite.DescribeTable(&timestreamwrite.DescribeTableInput{
+		DatabaseName: aws.String(wc.client.defaultDataBase),
+		TableName:    aws.String(wc.client.defaultTable),
+	})
+	if err != nil {
+		return err
+	}
+
+	var properties timestreamwrite.RetentionProperties
+	if output.Table != nil && output.Table.RetentionProperties != nil {
+		properties = *output.Table.RetentionProperties
+	}
+	memoryRetention := time.Duration(aws.Int64Value(properties.MemoryStoreRetentionPeriodInHours)) * time.Hour
+	magneticRetention := time.Duration(aws.Int64Value(properties.MagneticStoreRetentionPeriodInDays)) * 24 * time.Hour
+
+	if expectedMemoryRetention != 0 && memoryRetention != expectedMemoryRetention {
+		LogWarn(wc.logger, fmt.Sprintf("The memory store retention of the table %s.%s is %s, but %s is expected.", wc.client.defaultDataBase, wc.client.defaultTable, memoryRetention, expectedMemoryRetention))
+	}
+	if expectedMagneticRetention != 0 && magneticRetention != expectedMagneticRetention {
+		LogWarn(wc.logger, fmt.Sprintf("The magnetic store retention of the table %s.%s is %s, but %s is expected.", wc.client.defaultDataBase, wc.client.defaultTable, magneticRetention, expectedMagneticRetention))
+	}
+	return nil
+}
+
+// CheckReady returns an error if the connector cannot reach Timestream with the configuration of the write client. The
+// region must be set, and if the credentials of the configuration can be retrieved, the endpoints of Timestream must be
+// described with them. Otherwise the write requests carry their own credentials and only the region is checked.
+func (wc *WriteClient) CheckReady(ctx context.Context) error {
+	if len(aws.StringValue(wc.config.Region)) == 0 {
+		return fmt.Errorf("the AWS region is not set")
+	}
+	if wc.config.Credentials == nil {
+		return nil
+	}
+	if _, err := wc.config.Credentials.GetWithContext(ctx); err != nil {
+		LogDebug(wc.logger, "The default credentials are unavailable, skipping the Timestream readiness check.", "error", err)
+		return nil
+	}
+
+	timestreamWrite, err := initWriteClient(wc.config)
+	if err != nil {
+		return err
+	}
+	_, err = timestreamWrite.DescribeEndpointsWithContext(ctx, &timestreamwrite.DescribeEndpointsInput{})
+	return err
+}
+
+// Warmup establishes the given number of connections to Timestream by describing the default table with as many
+// concurrent requests, priming the connection pool of the HTTP transport so the first writes do not pay for the TLS
+// handshakes. The table is described rather than the endpoints, since the write requests are sent to the discovered
+// endpoint. The warmup is skipped if the credentials of the configuration cannot be retrieved, and the first error of
+// the requests is returned.
+func (wc *WriteClient) Warmup(ctx context.Context, connections int) error {
+	if wc.config.Credentials != nil {
+		if _, err := wc.config.Credentials.GetWithContext(ctx); err != nil {
+			LogDebug(wc.logger, "The default credentials are unavailable, skipping the connection warmup.", "error", err)
+			return nil
+		}
+	}
+
+	timestreamWrite, err := initWriteClient(wc.config)
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, connections)
+	for i := 0; i < connections; i++ {
+		go func() {
+			release := wc.client.acquire()
+			defer release()
+			_, err := timestreamWrite.DescribeTableWithContext(ctx, &timestreamwrite.DescribeTableInput{
+				DatabaseName: aws.String(wc.client.defaultDataBase),
+				TableName:    aws.String(wc.client.defaultTable),
+			})
+			errs <- err
+		}()
+	}
+
+	var firstErr error
+	for i := 0; i < connections; i++ {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// dumpRecords appends the converted Records of a write request to the dump file as a single line of JSON.
+func (wc *WriteClient) dumpRecords(recordMap recordDestinationMap) error {
+	return appendJSONLine(wc.dumpRecordsFile, recordMap)
+}
+
+// deadLetter writes the records of a write request failed with an error Prometheus does not retry to a new file in the
+// dead-letter directory. Only the rejected records are written if Timestream rejected some of the records. The file
+// holds the WriteRecords input as JSON, so the records can be replayed with the AWS CLI:
+//
+//	aws timestream-write write-records --cli-input-json file://<file>
+func (wc *WriteClient) deadLetter(logger log.Logger, input *timestreamwrite.WriteRecordsInput, err error) error {
+	failed := &timestreamwrite.WriteRecordsInput{
+		DatabaseName: input.DatabaseName,
+		TableName:    input.TableName,
+		Records:      input.Records,
+	}
+	if rejectedRecordsErr, ok := err.(*timestreamwrite.RejectedRecordsException); ok && len(rejectedRecordsErr.RejectedRecords) != 0 {
+		failed.Records = nil
+		for _, rejectedRecord := range rejectedRecordsErr.RejectedRecords {
+			if index := int(aws.Int64Value(rejectedRecord.RecordIndex)); index < len(input.Records) {
+				failed.Records = append(failed.Records, input.Records[index])
+			}
+		}
+	}
+
+	data, err := json.Marshal(failed)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s-%s.json", time.Now().UnixNano(), aws.StringValue(input.DatabaseName), aws.StringValue(input.TableName))
+	path := filepath.Join(wc.deadLetterDir, name)
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	LogInfo(logger, fmt.Sprintf("Wrote %d failed records to %s.", len(failed.Records), path))
+	return nil
+}
+
+// isRetryable returns true if Prometheus retries the write request failed with the error, which is the case for
+// server errors and throttling.
+func isRetryable(err error) bool {
+	requestError, ok := err.(awserr.RequestFailure)
+	return ok && (requestError.StatusCode()/100 == 5 || requestError.StatusCode() == http.StatusTooManyRequests)
+}
+
+// auditEntry is the receipt of the Records successfully written to a Timestream table.
+type auditEntry struct {
+	Time        string   `json:"time"`
+	Database    string   `json:"database"`
+	Table       string   `json:"table"`
+	RecordCount int      `json:"recordCount"`
+	MetricNames []string `json:"metricNames"`
+	StartTime   int64    `json:"startTime"`
+	EndTime     int64    `json:"endTime"`
+}
+
+// audit appends the receipt of the Records successfully written to the given table to the audit log.
+func (wc *WriteClient) audit(database string, table string, records []*timestreamwrite.Record) error {
+	entry := auditEntry{
+		Time:        time.Now().UTC().Format(time.RFC3339Nano),
+		Database:    database,
+		Table:       table,
+		RecordCount: len(records),
+		StartTime:   math.MaxInt64,
+		EndTime:     math.MinInt64,
+	}
+
+	metricNames := make(map[string]struct{})
+	for _, record := range records {
+		for _, measure := range recordMeasures(record) {
+			metricNames[aws.StringValue(measure.Name)] = struct{}{}
+		}
+		timestamp, err := strconv.ParseInt(aws.StringValue(record.Time), 10, 64)
+		if err != nil {
+			continue
+		}
+		if timestamp < entry.StartTime {
+			entry.StartTime = timestamp
+		}
+		if timestamp > entry.EndTime {
+			entry.EndTime = timestamp
+		}
+	}
+	for name := range metricNames {
+		entry.MetricNames = append(entry.MetricNames, name)
+	}
+	sort.Strings(entry.MetricNames)
+
+	return appendJSONLine(wc.auditLog, entry)
+}
+
+// destinationClient returns the client writing to the table. Tables mapped to an IAM role are written with the
+// credentials of the role, assumed with the credentials of the request; other tables use the given client. The assumed
+// role credentials are cached per role and source access key, and refreshed by the SDK before they expire.
+func (wc *WriteClient) destinationClient(config *aws.Config, timestreamWrite timestreamwriteiface.TimestreamWriteAPI, table string) (timestreamwriteiface.TimestreamWriteAPI, error) {
+	roleARN, ok := wc.writeRoleARNs[table]
+	if !ok {
+		return timestreamWrite, nil
+	}
+
+	var sourceAccessKeyID string
+	if config.Credentials != nil {
+		value, err := config.Credentials.Get()
+		if err != nil {
+			return nil, err
+		}
+		sourceAccessKeyID = value.AccessKeyID
+	}
+
+	wc.roleCredentialsMutex.Lock()
+	defer wc.roleCredentialsMutex.Unlock()
+	key := roleARN + "|" + sourceAccessKeyID
+	roleCredentials, ok := wc.roleCredentials[key]
+	if !ok {
+		var err error
+		if roleCredentials, err = assumeRole(config, roleARN); err != nil {
+			return nil, err
+		}
+		wc.roleCredentials[key] = roleCredentials
+	}
+
+	roleConfig := config.Copy()
+	roleConfig.Credentials = roleCredentials
+	return initWriteClient(roleConfig)
+}
+
+// retryOnAuthFailure retries the WriteRecords request once after Timestream rejected the credentials. If the request
+// has no credentials of its own, the cached credentials of the configured provider chain are expired first, so the retry
+// retrieves them again. The credentials provided by a request cannot be refreshed and are retried as is, which smooths
+// over the rejections while newly rotated credentials propagate.
+func (wc *WriteClient) retryOnAuthFailure(logger log.Logger, timestreamWrite timestreamwriteiface.TimestreamWriteAPI, config *aws.Config, requestCredentials *credentials.Credentials, writeRecordsInput *timestreamwrite.WriteRecordsInput) error {
+	if requestCredentials == nil && config.Credentials != nil {
+		LogInfo(logger, "Timestream rejected the credentials, refreshing the credentials of the provider chain and retrying the write request once.")
+		config.Credentials.Expire()
+	} else {
+		LogInfo(logger, "Timestream rejected the credentials, retrying the write request once.")
+	}
+	release := wc.client.acquire()
+	defer release()
+	_, err := timestreamWrite.WriteRecords(writeRecordsInput)
+	return err
+}
+
+// retryOnMissingDestination creates the database and table of the WriteRecords request after Timestream rejected it
+// for a missing destination, then retries the request once.
+func (wc *WriteClient) retryOnMissingDestination(logger log.Logger, timestreamWrite timestreamwriteiface.TimestreamWriteAPI, writeRecordsInput *timestreamwrite.WriteRecordsInput) error {
+	database := aws.StringValue(writeRecordsInput.DatabaseName)
+	table := aws.StringValue(writeRecordsInput.TableName)
+	if err := wc.createDestination(logger, timestreamWrite, database, table); err != nil {
+		LogError(logger, fmt.Sprintf("Unable to create the database: %s table: %s.", database, table), err)
+		return err
+	}
+
+	LogInfo(logger, fmt.Sprintf("Retrying the write request once after creating the database: %s table: %s.", database, table))
+	release := wc.client.acquire()
+	defer release()
+	_, err := timestreamWrite.WriteRecords(writeRecordsInput)
+	return err
+}
+
+// createDestination creates the given database and table with the configured retention. The creations are serialized
+// so concurrent write requests to the same missing destination create it once, and a destination created concurrently
+// by another connector is treated as created.
+func (wc *WriteClient) createDestination(logger log.Logger, timestreamWrite timestreamwriteiface.TimestreamWriteAPI, database string, table string) error {
+	wc.createdDestinationsMutex.Lock()
+	defer wc.createdDestinationsMutex.Unlock()
+	key := database + "." + table
+	if _, ok := wc.createdDestinations[key]; ok {
+		return nil
+	}
+
+	_, err := timestreamWrite.CreateDatabase(&timestreamwrite.CreateDatabaseInput{
+		DatabaseName: aws.String(database),
+	})
+	if err != nil && !isConflict(err) {
+		return err
+	}
+	if err == nil {
+		LogInfo(logger, fmt.Sprintf("Created the database: %s.", database))
+	}
+
+	createTableInput := &timestreamwrite.CreateTableInput{
+		DatabaseName: aws.String(database),
+		TableName:    aws.String(table),
+	}
+	if wc.memoryStoreRetention > 0 || wc.magneticStoreRetention > 0 {
+		createTableInput.RetentionProperties = &timestreamwrite.RetentionProperties{
+			MemoryStoreRetentionPeriodInHours:  aws.Int64(defaultMemoryStoreRetentionHours),
+			MagneticStoreRetentionPeriodInDays: aws.Int64(defaultMagneticStoreRetentionDays),
+		}
+		if wc.memoryStoreRetention > 0 {
+			createTableInput.RetentionProperties.MemoryStoreRetentionPeriodInHours = aws.Int64(int64(wc.memoryStoreRetention / time.Hour))
+		}
+		if wc.magneticStoreRetention > 0 {
+			createTableInput.RetentionProperties.MagneticStoreRetentionPeriodInDays = aws.Int64(int64(wc.magneticStoreRetention / (24 * time.Hour)))
+		}
+	}
+	_, err = timestreamWrite.CreateTable(createTableInput)
+	if err != nil && !isConflict(err) {
+		return err
+	}
+	if err == nil {
+		LogInfo(logger, fmt.Sprintf("Created the table: %s in database: %s.", table, database))
+	}
+
+	wc.createdDestinations[key] = struct{}{}
+	return nil
+}
+
+// isResourceNotFound returns true if the error is caused by a database or table that does not exist.
+func isResourceNotFound(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == timestreamwrite.ErrCodeResourceNotFoundException
+}
+
+// isConflict returns true if the error is caused by a database or table that already exists.
+func isConflict(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == timestreamwrite.ErrCodeConflictException
+}
+
+// isAuthError returns true if the error is caused by invalid, expired or rotated credentials.
+func isAuthError(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	switch awsErr.Code() {
+	case "ExpiredTokenException", "ExpiredToken", "UnrecognizedClientException", "InvalidClientTokenId", "InvalidSignatureException":
+		return true
+	}
+	return false
+}
+
+// handleSDKErr parses and logs the error from SDK (if any)
+func (wc *WriteClient) handleSDKErr(logger log.Logger, req *prompb.WriteRequest, currErr error, errToReturn error) error {
+	requestError, ok := currErr.(awserr.RequestFailure)
+	if !ok {
+        LogError(logger, fmt.Sprintf("Error occurred while ingesting Timestream Records. %d records failed to be written", len(req.Timeseries)), currErr)
+		return errors.NewSDKNonRequestError(currErr)
+	}
+
+	if rejectedRecordsErr, ok := currErr.(*timestreamwrite.RejectedRecordsException); ok {
+		for _, rejectedRecord := range rejectedRecordsErr.RejectedRecords {
+			wc.rejectedRecords.WithLabelValues(rejectionReason(rejectedRecord)).Inc()
+		}
+	}
+
+	if errToReturn == nil {
+		errToReturn = requestError
+	}
+	switch requestError.StatusCode() / 100 {
+	case 4:
+		LogDebug(logger, "Error occurred while ingesting data due to invalid write request. Some Prometheus Samples were not ingested into Timestream, please review the write request and check the documentation for troubleshooting.", "request", req)
+	case 5:
+		errToReturn = requestError
+		LogDebug(logger, "Internal server error occurred. Samples will be retried by Prometheus", "request", req)
+	}
+	return errToReturn
+}
+
+// rejectionReason maps the free-form reason of a record rejected by Timestream to a stable label value.
+func rejectionReason(rejectedRecord *timestreamwrite.RejectedRecord) string {
+	reason := strings.ToLower(aws.StringValue(rejectedRecord.Reason))
+	switch {
+	case rejectedRecord.ExistingVersion != nil || strings.Contains(reason, "version"):
+		return versionRejectionReason
+	case strings.Contains(reason, "retention") || strings.Contains(reason, "time range") || strings.Contains(reason, "outside"):
+		return retentionRejectionReason
+	case strings.Contains(reason, "duplicate") || strings.Contains(reason, "same dimensions") || strings.Contains(reason, "already exists"):
+		return duplicateRejectionReason
+	case strings.Contains(reason, "exceed") || strings.Contains(reason, "limit"):
+		return limitRejectionReason
+	default:
+		return otherRejectionReason
+	}
+}
+
+// convertToRecords converts a slice of *prompb.TimeSeries to a slice of *timestreamwrite.Record
+func (wc *WriteClient) convertToRecords(logger log.Logger, series []*prompb.TimeSeries, recordMap recordDestinationMap) (recordDestinationMap, error) {
+	var operationOnLongMetrics longMetricsOperation
+	if wc.failOnLongMetricLabelName {
+		operationOnLongMetrics = func(measureValueName string) (labelOperation, error) {
+			if len(measureValueName) > maxMeasureNameLength {
+				err := errors.NewLongLabelNameError(measureValueName, maxMeasureNameLength)
+				LogError(logger, "fail-on-long-label flag is enabled for long metric name.", err)
+				return failed, err
+			}
+			return unmodified, nil
+		}
+	} else {
+		operationOnLongMetrics = func(measureValueName string) (labelOperation, error) {
+			if len(measureValueName) > maxMeasureNameLength {
+				wc.ignoredSamples.Inc()
+				LogDebug(logger, "fail-on-long-label flag is disabled for metric name. Time series ignored.", "ignoredMeasureName", measureValueName)
+				return ignored, nil
+			}
+
+			return unmodified, nil
+		}
+	}
+	return processTimeSeries(wc, logger, operationOnLongMetrics, series, recordMap)
+}
+
+// processTimeSeries processes a slice of *prompb.TimeSeries to a slice of *timestreamwrite.Record
+func processTimeSeries(wc *WriteClient, logger log.Logger, operationOnLongMetrics longMetricsOperation, series []*prompb.TimeSeries, recordMap recordDestinationMap) (recordDestinationMap, error) {
+	for _, timeSeries := range series {
+		var dimensions []*timestreamwrite.Dimension
+		var err error
+		var operation labelOperation
+		var databaseName string
+		var tableName string
+		wc.receivedSamples.Add(float64(len(timeSeries.Samples)))
+
+		metricLabels, measureValueName := convertToMap(timeSeries.Labels, wc.defaultMeasureName, wc.stripNamespace, wc.normalizeMeasureNames)
+		databaseName = destinationLabel(metricLabels, wc.databaseLabel, wc.client.defaultDataBase)
+		tableName = destinationLabel(metricLabels, wc.tableLabel, wc.client.defaultTable)
+
+		// Timestream does not support tagging the ingested records, so the cost tags are written as dimensions, which
+		// overwrite the labels with the same names.
+		for key, value := range wc.costTags {
+			metricLabels[key] = value
+		}
+
+		if len(databaseName) == 0 {
+			err = errors.NewMissingDatabaseWithWriteError(wc.client.defaultDataBase, timeSeries)
+			return nil, err
+		}
+
+		if len(tableName) == 0 {
+			err = errors.NewMissingTableWithWriteError(wc.client.defaultTable, timeSeries)
+			return nil, err
+		}
+
+		operation, err = operationOnLongMetrics(measureValueName)
+		switch operation {
+		case failed:
+			return nil, err
+		case ignored:
+			continue
+		default:
+		}
+
+		dimensions, operation, err = processMetricLabels(logger, metricLabels, measureValueName, operationOnLongMetrics, wc.reservedLabels, wc.instanceID, wc.requiredDimensions, wc.missingDimensions, wc.expandJSONLabel, wc.emitSchemaVersion, wc.infBucketValue, wc.validateLabelNames)
+		switch operation {
+		case failed:
+			return nil, err
+		case ignored:
+			if err != nil {
+				// The time series is missing a required dimension or has an invalid label name.
+				wc.ignoredSamples.Add(float64(len(timeSeries.Samples)))
+				LogDebug(logger, "missing-dimensions or validate-label-names is set to ignore. Time series ignored.", "error", err)
+			}
+			continue
+		default:
+		}
+
+		recordMap[databaseName] = getOrCreateRecordMapEntry(recordMap, databaseName)
+
+		var records []*timestreamwrite.Record
+
+		if recordMap[databaseName][tableName] != nil {
+			records = recordMap[databaseName][tableName]
+		}
+
+		records, err = wc.appendRecords(logger, records, timeSeries, dimensions, measureValueName)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(records) == 0 {
+			LogInfo(logger, "No valid Timestream Records can be ingested.")
+			continue
+		}
+
+		recordMap[databaseName][tableName] = records
+		if wc.cardinalityTracking > 0 {
+			wc.trackMeasureName(logger, databaseName, tableName, measureValueName)
+		}
+	}
+
+	if wc.conflictingRecords == FirstConflictingRecords || wc.conflictingRecords == LastConflictingRecords || wc.conflictingRecords == ErrorConflictingRecords {
+		for _, tableMap := range recordMap {
+			for tableName, records := range tableMap {
+				records, err := wc.resolveConflictingRecords(logger, records)
+				if err != nil {
+					return nil, err
+				}
+				tableMap[tableName] = records
+			}
+		}
+	}
+
+	if wc.numericQuantiles {
+		for _, tableMap := range recordMap {
+			for _, records := range tableMap {
+				for i, record := range records {
+					records[i] = numericQuantileRecord(record)
+				}
+			}
+		}
+	}
+
+	if wc.enableMultiMeasure {
+		for _, tableMap := range recordMap {
+			for tableName, records := range tableMap {
+				tableMap[tableName] = groupMultiMeasureRecords(records)
+			}
+		}
+	}
+	return recordMap, nil
+}
+
+// numericQuantileRecord converts the single-measure Record of a summary quantile into a multi-measure Record holding
+// the sample as the QuantileSampleMeasureName measure and the quantile as the QuantileMeasureName DOUBLE measure. The
+// quantile dimension is kept, since Timestream identifies a Record by its dimensions, time and measure name. Records
+// without a numeric quantile dimension are returned as is.
+func numericQuantileRecord(record *timestreamwrite.Record) *timestreamwrite.Record {
+	if aws.StringValue(record.MeasureValueType) == timestreamwrite.MeasureValueTypeMulti {
+		return record
+	}
+	for _, dimension := range record.Dimensions {
+		if aws.StringValue(dimension.Name) != model.QuantileLabel {
+			continue
+		}
+		quantile, err := strconv.ParseFloat(aws.StringValue(dimension.Value), 64)
+		if err != nil || math.IsNaN(quantile) || math.IsInf(quantile, 0) {
+			return record
+		}
+		return &timestreamwrite.Record{
+			Dimensions:       record.Dimensions,
+			MeasureName:      record.MeasureName,
+			MeasureValueType: aws.String(timestreamwrite.MeasureValueTypeMulti),
+			MeasureValues: []*timestreamwrite.MeasureValue{
+				{Name: aws.String(QuantileSampleMeasureName), Value: record.MeasureValue, Type: record.MeasureValueType},
+				{Name: aws.String(QuantileMeasureName), Value: aws.String(strconv.FormatFloat(quantile, 'f', -1, 64)), Type: aws.String(timestreamwrite.MeasureValueTypeDouble)},
+			},
+			Time:     record.Time,
+			TimeUnit: record.TimeUnit,
+			Version:  record.Version,
+		}
+	}
+	return record
+}
+
+// groupMultiMeasureRecords groups the single-measure Records with the same dimensions and time into multi-measure
+// Records, with a measure named after the measure name of each grouped Record. A Record is grouped into a new
+// multi-measure Record if the existing one already holds a measure with the same name or maxMeasuresPerRecord measures,
+// and the version of a multi-measure Record is the highest version of its grouped Records.
+func groupMultiMeasureRecords(records []*timestreamwrite.Record) []*timestreamwrite.Record {
+	type multiMeasureRecord struct {
+		record       *timestreamwrite.Record
+		measureNames map[string]struct{}
+	}
+
+	groups := make(map[string]*multiMeasureRecord, len(records))
+	grouped := make([]*timestreamwrite.Record, 0, len(records))
+	for _, record := range records {
+		key := dimensionsKey(record.Dimensions) + "|" + aws.StringValue(record.Time)
+		measureName := aws.StringValue(record.MeasureName)
+		group, ok := groups[key]
+		if ok {
+			_, exists := group.measureNames[measureName]
+			ok = !exists && len(group.measureNames) < maxMeasuresPerRecord
+		}
+		if !ok {
+			group = &multiMeasureRecord{
+				record: &timestreamwrite.Record{
+					Dimensions:       record.Dimensions,
+					MeasureName:      aws.String(MultiMeasureName),
+					MeasureValueType: aws.String(timestreamwrite.MeasureValueTypeMulti),
+					Time:             record.Time,
+					TimeUnit:         record.TimeUnit,
+				},
+				measureNames: make(map[string]struct{}),
+			}
+			groups[key] = group
+			grouped = append(grouped, group.record)
+		}
+
+		group.measureNames[measureName] = struct{}{}
+		group.record.MeasureValues = append(group.record.MeasureValues, &timestreamwrite.MeasureValue{
+			Name:  record.MeasureName,
+			Value: record.MeasureValue,
+			Type:  record.MeasureValueType,
+		})
+		if record.Version != nil && aws.Int64Value(record.Version) > aws.Int64Value(group.record.Version) {
+			group.record.Version = record.Version
+		}
+	}
+	return grouped
+}
+
+// destinationLabel removes the label holding the destination database or table of a time series from its labels and
+// returns its value, or the default destination if the label is unset, absent or empty.
+func destinationLabel(metricLabels map[string]string, label string, defaultDestination string) string {
+	if len(label) == 0 {
+		return defaultDestination
+	}
+	value, ok := metricLabels[label]
+	if !ok {
+		return defaultDestination
+	}
+	delete(metricLabels, label)
+	if len(value) == 0 {
+		return defaultDestination
+	}
+	return value
+}
+
+// resolveConflictingRecords resolves the Records of a table with the same dimensions, measure name and time, which
+// Timestream would upsert in an unspecified order. Duplicates with the same measure value are dropped, and for different
+// measure values the first or the last Record is kept, or an error is returned, according to the conflicting-records option.
+func (wc *WriteClient) resolveConflictingRecords(logger log.Logger, records []*timestreamwrite.Record) ([]*timestreamwrite.Record, error) {
+	indexes := make(map[string]int, len(records))
+	resolved := records[:0]
+	for _, record := range records {
+		key := recordKey(record)
+		index, exists := indexes[key]
+		if !exists {
+			indexes[key] = len(resolved)
+			resolved = append(resolved, record)
+			continue
+		}
+
+		wc.ignoredSamples.Inc()
+		existing := resolved[index]
+		if aws.StringValue(existing.MeasureValue) == aws.StringValue(record.MeasureValue) {
+			continue
+		}
+
+		switch wc.conflictingRecords {
+		case ErrorConflictingRecords:
+			err := errors.NewConflictingRecordsError(aws.StringValue(record.MeasureName), aws.StringValue(record.Time))
+			LogError(logger, "The write request contains samples with the same time series and time but different values.", err)
+			return nil, err
+		case LastConflictingRecords:
+			resolved[index] = record
+		}
+		LogDebug(logger, "Resolved samples with the same time series and time but different values.", "measureName", aws.StringValue(record.MeasureName), "time", aws.StringValue(record.Time), "keptValue", aws.StringValue(resolved[index].MeasureValue))
+	}
+	return resolved, nil
+}
+
+// recordKey returns the identity of a Record in Timestream, made of its dimensions, measure name and time.
+func recordKey(record *timestreamwrite.Record) string {
+	return dimensionsKey(record.Dimensions) + "|" + strconv.Quote(aws.StringValue(record.MeasureName)) + "|" + aws.StringValue(record.Time)
+}
+
+// recordMeasures returns the measures of a multi-measure Record, or the single measure of any other Record.
+func recordMeasures(record *timestreamwrite.Record) []*timestreamwrite.MeasureValue {
+	if aws.StringValue(record.MeasureValueType) == timestreamwrite.MeasureValueTypeMulti {
+		return record.MeasureValues
+	}
+	return []*timestreamwrite.MeasureValue{{
+		Name:  record.MeasureName,
+		Value: record.MeasureValue,
+		Type:  record.MeasureValueType,
+	}}
+}
+
+// dimensionsKey returns the identity of a set of dimensions regardless of their order.
+func dimensionsKey(dimensions []*timestreamwrite.Dimension) string {
+	pairs := make([]string, 0, len(dimensions))
+	for _, dimension := range dimensions {
+		pairs = append(pairs, strconv.Quote(aws.StringValue(dimension.Name))+"="+strconv.Quote(aws.StringValue(dimension.Value)))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// processMetricLabels processes metricLabels to a *timestreamwrite.Record. The label named jsonLabel, if set, is first
+// expanded into a label for each key of its JSON object value. The instance ID, if set, is added as the
+// InstanceIDDimension and overwrites a label with the same name, and so does the SchemaVersion as the
+// SchemaVersionDimension if emitSchemaVersion is set. The le label of a +Inf histogram bucket is stored as
+// infBucketValue if set. A time series missing any of the required dimensions fails or is ignored according to
+// missingDimensions, and so does a time series with an invalid label name according to validateLabelNames, which may
+// also sanitize the label name instead; an ignored time series is returned with the reason as the error.
+func processMetricLabels(logger log.Logger, metricLabels map[string]string, measureValueName string, operationOnLongMetrics longMetricsOperation, reservedLabels string, instanceID string, requiredDimensions []string, missingDimensions string, jsonLabel string, emitSchemaVersion bool, infBucketValue string, validateLabelNames string) ([]*timestreamwrite.Dimension, labelOperation, error) {
+	if len(jsonLabel) != 0 {
+		if value, ok := metricLabels[jsonLabel]; ok {
+			if err := expandJSONLabel(metricLabels, jsonLabel, value); err != nil {
+				LogDebug(logger, fmt.Sprintf("The value of the label %s is not a JSON object, the label is kept as is.", jsonLabel), "error", err)
+			}
+		}
+	}
+
+	if len(instanceID) != 0 {
+		metricLabels[InstanceIDDimension] = instanceID
+	}
+
+	if emitSchemaVersion {
+		metricLabels[SchemaVersionDimension] = SchemaVersion
+	}
+
+	if bucket, ok := metricLabels[model.BucketLabel]; ok && len(infBucketValue) != 0 && isInfBucket(bucket) {
+		metricLabels[model.BucketLabel] = infBucketValue
+	}
+
+	for _, dimension := range requiredDimensions {
+		if _, ok := metricLabels[dimension]; !ok {
+			err := errors.NewMissingRequiredDimensionError(measureValueName, dimension)
+			if missingDimensions == FailMissingDimensions {
+				return nil, failed, err
+			}
+			return nil, ignored, err
+		}
+	}
+
+	if validateLabelNames == FailLabelNameValidation || validateLabelNames == SanitizeLabelNameValidation || validateLabelNames == IgnoreLabelNameValidation {
+		if operation, err := validateLabelNameSet(metricLabels, validateLabelNames); operation != unmodified {
+			return nil, operation, err
+		}
+	}
+
+	var operation labelOperation
+	var dimensions []*timestreamwrite.Dimension
+	var err error
+	for name, value := range metricLabels {
+		if strings.HasPrefix(name, reservedLabelPrefix) && isReservedColumnName(strings.TrimPrefix(name, reservedLabelPrefix)) {
+			// The label would be indistinguishable from a renamed reserved label, and be renamed to the reserved name on reads.
+			return nil, failed, errors.NewAmbiguousLabelNameError(name)
+		}
+		if isReservedColumnName(name) {
+			if reservedLabels == FailReservedLabels {
+				return nil, failed, errors.NewReservedLabelNameError(name)
+			}
+			name = reservedLabelPrefix + name
+		}
+
+		// Each label in the metricLabels map contains a characteristic/dimension of the metric, which maps to timestreamwrite.Dimension
+		operation, err = operationOnLongMetrics(name)
+		switch operation {
+		case failed:
+			return nil, operation, err
+		case ignored:
+			return nil, operation, nil
+		default:
+			dimensions = append(dimensions, &timestreamwrite.Dimension{
+				Name:  aws.String(name),
+				Value: aws.String(value),
+			})
+		}
+	}
+	return dimensions, operation, nil
+}
+
+// validateLabelNameSet checks the label names against the Prometheus label name pattern. Invalid label names fail or
+// ignore the time series according to validateLabelNames, or are sanitized by replacing the invalid characters with
+// underscores, which fails the time series if the sanitized name collides with another label.
+func validateLabelNameSet(metricLabels map[string]string, validateLabelNames string) (labelOperation, error) {
+	for name, value := range metricLabels {
+		if labelNamePattern.MatchString(name) {
+			continue
+		}
+		err := errors.NewInvalidLabelNameError(name)
+		switch validateLabelNames {
+		case FailLabelNameValidation:
+			return failed, err
+		case IgnoreLabelNameValidation:
+			return ignored, err
+		}
+		sanitized := invalidLabelNameCharacters.ReplaceAllString(name, "_")
+		if len(sanitized) == 0 || (sanitized[0] >= '0' && sanitized[0] <= '9') {
+			sanitized = "_" + sanitized
+		}
+		if _, exists := metricLabels[sanitized]; exists {
+			return failed, err
+		}
+		delete(metricLabels, name)
+		metricLabels[sanitized] = value
+	}
+	return unmodified, nil
+}
+
+// isBigint returns true if the sample value is integral and within the range of a 64-bit integer, so it is written as
+// a BIGINT measure without loss.
+func isBigint(value float64) bool {
+	return value == math.Trunc(value) && value >= math.MinInt64 && value < math.MaxInt64
+}
+
+// isInfBucket returns true if the value of an le label is the upper bound of the +Inf histogram bucket, such as "+Inf"
+// or "Inf".
+func isInfBucket(value string) bool {
+	bound, err := strconv.ParseFloat(value, 64)
+	return err == nil && math.IsInf(bound, 1)
+}
+
+// isReservedColumnName returns true if the label name collides with a column name reserved by Timestream.
+func isReservedColumnName(name string) bool {
+	switch name {
+	case timeColumnName, measureNameColumnName, measureValuePrefix:
+		return true
+	}
+	return strings.HasPrefix(name, measureValuePrefix+"::")
+}
+
+// expandJSONLabel replaces the label holding a JSON object with a label for each key of the object. String values are
+// used as they are, null values are dropped, and other values, such as numbers or nested objects, are encoded as JSON.
+// The labels of the time series take precedence over the expanded keys of the same name. The labels are left unchanged
+// if the value is not a JSON object.
+func expandJSONLabel(metricLabels map[string]string, name string, value string) error {
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return err
+	}
+
+	delete(metricLabels, name)
+	for key, field := range object {
+		if _, ok := metricLabels[key]; ok || field == nil {
+			continue
+		}
+		if fieldValue, ok := field.(string); ok {
+			metricLabels[key] = fieldValue
+			continue
+		}
+		encoded, err := json.Marshal(field)
+		if err != nil {
+			return err
+		}
+		metricLabels[key] = string(encoded)
+	}
+	return nil
+}
+
+// getOrCreateRecordMapEntry gets record map entry
+func getOrCreateRecordMapEntry(recordMap recordDestinationMap, databaseName string) map[string][]*timestreamwrite.Record {
+	if recordMap[databaseName] == nil {
+		recordMap[databaseName] = make(map[string][]*timestreamwrite.Record)
+	}
+	return recordMap[databaseName]
+}
+
+// convertToMap converts the slice of Labels to a Map and retrieves the measure value name, which is stripped of the
+// stripNamespace prefix, falls back to the defaultMeasureName for time series without a metric name, and is normalized if
+// normalizeMeasureNames is set.
+func convertToMap(labels []*prompb.Label, defaultMeasureName string, stripNamespace string, normalizeMeasureNames bool) (map[string]string, string) {
+	// measureValueName is the Prometheus metric name that maps to MeasureName of a timestreamwrite.Record
+	var measureValueName string
+
+	metric := make(map[string]string, len(labels))
+	for _, label := range labels {
+		metric[label.Name] = label.Value
+	}
+	measureValueName = strings.TrimPrefix(metric[model.MetricNameLabel], stripNamespace)
+	delete(metric, model.MetricNameLabel)
+	if len(measureValueName) == 0 {
+		measureValueName = defaultMeasureName
+	}
+	if normalizeMeasureNames {
+		measureValueName = normalizeMeasureName(measureValueName)
+	}
+
+	return metric, measureValueName
+}
+
+// normalizeMeasureName replaces the colons of a metric name, such as the names of recording rules, with periods. The
+// metric names cannot contain periods, so denormalizeMeasureName restores the metric name on reads.
+func normalizeMeasureName(name string) string {
+	return strings.ReplaceAll(name, ":", ".")
+}
+
+// denormalizeMeasureName restores the metric name of a measure name normalized by normalizeMeasureName.
+func denormalizeMeasureName(name string) string {
+	return strings.ReplaceAll(name, ".", ":")
+}
+
+// appendRecords converts each valid Prometheus Sample to a Timestream Record and append the Record to the given slice of records.
+func (wc *WriteClient) appendRecords(logger log.Logger, records []*timestreamwrite.Record, timeSeries *prompb.TimeSeries, dimensions []*timestreamwrite.Dimension, measureValueName string) ([]*timestreamwrite.Record, error) {
+	var operationOnInvalidSample func(timeSeriesValue float64) (labelOperation, error)
+	if wc.failOnInvalidSample {
+		operationOnInvalidSample = func(timeSeriesValue float64) (labelOperation, error) {
+			if math.IsNaN(timeSeriesValue) || math.IsInf(timeSeriesValue, 0) {
+				// Log and fail on samples with non-finite values.
+				err := errors.NewInvalidSampleValueError(timeSeriesValue)
+				LogError(logger, "Timestream only accepts finite IEEE Standard 754 floating-point precision. Non-finite sample value will fail the program with fail-on-invalid-sample-value enabled.", err, "timeSeries", timeSeries)
+				return failed, err
+			}
+			return unmodified, nil
+		}
+	} else {
+		operationOnInvalidSample = func(timeSeriesValue float64) (labelOperation, error) {
+			if math.IsNaN(timeSeriesValue) || math.IsInf(timeSeriesValue, 0) {
+				// Log and ignore; continue to the next sample.
+				wc.ignoredSamples.Inc()
+				LogDebug(logger, "Timestream only accepts finite IEEE Standard 754 floating point precision. Samples with NaN, Inf and -Inf are ignored.", "timeSeries", timeSeries)
+				return ignored, nil
+			}
+			return unmodified, nil
+		}
+	}
+
+	samples := timeSeries.Samples
+	if wc.maxSamplesPerSeries > 0 && len(samples) > wc.maxSamplesPerSeries {
+		// Only ingest the first samples of a time series carrying more samples than allowed.
+		wc.ignoredSamples.Add(float64(len(samples) - wc.maxSamplesPerSeries))
+		LogDebug(logger, fmt.Sprintf("Time series exceeds the maximum of %d samples per series. %d samples ignored.", wc.maxSamplesPerSeries, len(samples)-wc.maxSamplesPerSeries), "measureName", measureValueName)
+		samples = samples[:wc.maxSamplesPerSeries]
+	}
+
+	if wc.requireOrderedSamples == WarnOrderedSamples || wc.requireOrderedSamples == RejectOrderedSamples {
+		for i := 1; i < len(samples); i++ {
+			if samples[i].Timestamp >= samples[i-1].Timestamp {
+				continue
+			}
+			if wc.requireOrderedSamples == RejectOrderedSamples {
+				err := errors.NewUnorderedSamplesError(measureValueName, samples[i-1].Timestamp, samples[i].Timestamp)
+				LogError(logger, "Samples of the time series are not in ascending timestamp order.", err, "measureName", measureValueName)
+				return records, err
+			}
+			LogWarn(logger, "Samples of the time series are not in ascending timestamp order, check the upstream configuration.", "measureName", measureValueName, "timestamp", samples[i].Timestamp, "previousTimestamp", samples[i-1].Timestamp)
+			break
+		}
+	}
+
+	if wc.duplicateSamples == FirstDuplicateSamples || wc.duplicateSamples == LastDuplicateSamples || wc.duplicateSamples == MaxDuplicateSamples || wc.duplicateSamples == ErrorDuplicateSamples {
+		var err error
+		if samples, err = wc.resolveDuplicateSamples(logger, samples, measureValueName); err != nil {
+			return records, err
+		}
+	}
+
+	var oldestTimestamp, newestTimestamp int64
+	if wc.clampTimestamps {
+		oldestTimestamp, newestTimestamp = wc.timestampBounds()
+	}
+
+	// A measure name holds a single measure value type in a table, so the samples of a boolean metric other than 0 or 1
+	// are ignored rather than written as doubles.
+	isBooleanMetric := wc.booleanMetrics != nil && wc.booleanMetrics.MatchString(measureValueName)
+
+	sampleCount := 0
+	var latestTimestamp int64
+	for _, sample := range samples {
+		// sample.Value is the measured value of a metric which maps to the MeasureValue in timestreamwrite.Record
+		timeSeriesValue := sample.Value
+		operation, err := operationOnInvalidSample(timeSeriesValue)
+
+		switch operation {
+		case failed:
+			return records, err
 		case ignored:
 			continue
 		default:
 		}
 
-		dimensions, operation, err = processMetricLabels(metricLabels, operationOnLongMetrics)
-		switch operation {
-		case failed:
-			return nil, err
-		case ignored:
-			continue
-		default:
+		measureValue := strconv.FormatFloat(timeSeriesValue, 'f', 6, 64)
+		measureValueType := timestreamwrite.MeasureValueTypeDouble
+		if isBooleanMetric {
+			if timeSeriesValue != 0 && timeSeriesValue != 1 {
+				wc.ignoredSamples.Inc()
+				LogDebug(logger, "Samples of a boolean metric other than 0 or 1 are ignored.", "measureName", measureValueName, "value", timeSeriesValue)
+				continue
+			}
+			measureValue = strconv.FormatBool(timeSeriesValue == 1)
+			measureValueType = timestreamwrite.MeasureValueTypeBoolean
+		} else if wc.measureValueType == BigintMeasureValueType || wc.measureValueType == AutoMeasureValueType {
+			if isBigint(timeSeriesValue) {
+				measureValue = strconv.FormatInt(int64(timeSeriesValue), 10)
+				measureValueType = timestreamwrite.MeasureValueTypeBigint
+			} else if wc.measureValueType == BigintMeasureValueType {
+				wc.ignoredSamples.Inc()
+				LogDebug(logger, "Samples not representable as a 64-bit integer are ignored with the bigint measure value type.", "measureName", measureValueName, "value", timeSeriesValue)
+				continue
+			}
+		}
+
+		sampleTimestamp := sample.Timestamp
+		if wc.clampTimestamps {
+			timestamp := sample.Timestamp
+			if timestamp < oldestTimestamp && wc.memoryStoreRetention > 0 {
+				sample.Timestamp = oldestTimestamp
+			} else if timestamp > newestTimestamp {
+				sample.Timestamp = newestTimestamp
+			}
+			if sample.Timestamp != timestamp {
+				wc.clampedSamples.Inc()
+				LogDebug(logger, "Clamped the sample timestamp to the memory store window.", "measureName", measureValueName, "timestamp", timestamp, "clampedTimestamp", sample.Timestamp)
+			}
+		}
+
+		records = append(records, &timestreamwrite.Record{
+			Dimensions:       dimensions,
+			MeasureName:      aws.String(measureValueName),
+			MeasureValue:     aws.String(measureValue),
+			MeasureValueType: aws.String(measureValueType),
+			Time:             aws.String(strconv.FormatInt(sample.Timestamp, 10)),
+			TimeUnit:         aws.String(timestreamwrite.TimeUnitMilliseconds),
+			Version:          wc.recordVersion(sampleTimestamp),
+		})
+
+		if sampleCount == 0 || sample.Timestamp > latestTimestamp {
+			latestTimestamp = sample.Timestamp
+		}
+		sampleCount++
+	}
+
+	if wc.emitSampleCount && sampleCount > 0 {
+		records = wc.appendSampleCountRecord(logger, records, dimensions, measureValueName, sampleCount, latestTimestamp)
+	}
+
+	return records, nil
+}
+
+// countMetricSamples counts the ingested samples of the allowlisted metric names, matched against the measure names as
+// written to Timestream. The other metric names are not counted, which bounds the cardinality of the counter to the
+// allowlist.
+func (wc *WriteClient) countMetricSamples(records []*timestreamwrite.Record) {
+	for _, record := range records {
+		for _, measure := range recordMeasures(record) {
+			metricName := aws.StringValue(measure.Name)
+			if _, ok := wc.perMetricStats[metricName]; ok {
+				wc.metricSamples.WithLabelValues(metricName).Inc()
+			}
+		}
+	}
+}
+
+// trackMeasureName records the measure name as written to the table during the current cardinality tracking window and
+// updates the distinct measures gauge. At most cardinalityTracking measure names are tracked per table, and a warning is
+// logged when a table reaches the limit, which hints at measure names created from unbounded values.
+func (wc *WriteClient) trackMeasureName(logger log.Logger, database string, table string, measureName string) {
+	wc.measureNamesMutex.Lock()
+	defer wc.measureNamesMutex.Unlock()
+
+	now := timeNow()
+	if now.Sub(wc.measureNamesWindowStart) >= cardinalityWindow {
+		wc.measureNames = make(map[string]map[string]struct{})
+		wc.measureNamesWindowStart = now
+		wc.distinctMeasures.Reset()
+	}
+
+	key := database + "." + table
+	names, ok := wc.measureNames[key]
+	if !ok {
+		names = make(map[string]struct{})
+		wc.measureNames[key] = names
+	}
+	if _, ok := names[measureName]; ok || len(names) >= wc.cardinalityTracking {
+		return
+	}
+
+	names[measureName] = struct{}{}
+	wc.distinctMeasures.WithLabelValues(database, table).Set(float64(len(names)))
+	if len(names) == wc.cardinalityTracking {
+		LogWarn(logger, fmt.Sprintf("The table %s has received %d distinct measure names within %s, further measure names are not tracked until the window ends.", key, len(names), cardinalityWindow))
+	}
+}
+
+// ingestedSamples is the number of samples admitted by the ingestion rate limit at a time.
+type ingestedSamples struct {
+	time  time.Time
+	count int
+}
+
+// writeOutcomes is the number of WriteRecords calls succeeded and failed within a second.
+type writeOutcomes struct {
+	time      time.Time
+	succeeded int
+	failed    int
+}
+
+// recordWriteOutcome records the outcome of a WriteRecords call. Only the errors of Timestream itself count as failures,
+// the requests rejected with a 4xx status code other than 429 are caused by the written data or the caller credentials.
+func (wc *WriteClient) recordWriteOutcome(err error) {
+	failed := false
+	if err != nil {
+		requestError, ok := err.(awserr.RequestFailure)
+		failed = !ok || requestError.StatusCode()/100 != 4 || requestError.StatusCode() == http.StatusTooManyRequests
+	}
+
+	wc.writeOutcomesMutex.Lock()
+	defer wc.writeOutcomesMutex.Unlock()
+
+	wc.expireWriteOutcomes()
+	now := timeNow().Truncate(time.Second)
+	if last := len(wc.writeOutcomes) - 1; last < 0 || !wc.writeOutcomes[last].time.Equal(now) {
+		wc.writeOutcomes = append(wc.writeOutcomes, writeOutcomes{time: now})
+	}
+	if failed {
+		wc.writeOutcomes[len(wc.writeOutcomes)-1].failed++
+	} else {
+		wc.writeOutcomes[len(wc.writeOutcomes)-1].succeeded++
+	}
+}
+
+// RecentErrorRatio returns the ratio of the failed WriteRecords calls within the health window, and the number of calls
+// within the window. The ratio is 0 if there were no calls.
+func (wc *WriteClient) RecentErrorRatio() (float64, int) {
+	wc.writeOutcomesMutex.Lock()
+	defer wc.writeOutcomesMutex.Unlock()
+
+	wc.expireWriteOutcomes()
+	succeeded, failed := 0, 0
+	for _, outcomes := range wc.writeOutcomes {
+		succeeded += outcomes.succeeded
+		failed += outcomes.failed
+	}
+	if succeeded+failed == 0 {
+		return 0, 0
+	}
+	return float64(failed) / float64(succeeded+failed), succeeded + failed
+}
+
+// expireWriteOutcomes drops the outcomes of the WriteRecords calls older than the health window.
+func (wc *WriteClient) expireWriteOutcomes() {
+	windowStart := timeNow().Add(-healthWindow)
+	expired := 0
+	for i, outcomes := range wc.writeOutcomes {
+		if outcomes.time.After(windowStart) {
+			break
 		}
+		expired = i + 1
+	}
+	wc.writeOutcomes = wc.writeOutcomes[expired:]
+}
 
-		recordMap[databaseName] = getOrCreateRecordMapEntry(recordMap, databaseName)
+// admitSamples returns true and records the samples of the time series if the rate of the samples received within the
+// ingestion rate window, including these samples, does not exceed the max-ingest-rate. A write request is always
+// admitted when no samples were received within the window, otherwise a single request with more samples than the
+// window allows would never succeed. A rejected write request is returned the duration after which the oldest samples
+// leave the window.
+func (wc *WriteClient) admitSamples(timeSeries []*prompb.TimeSeries) (time.Duration, bool) {
+	count := 0
+	for _, series := range timeSeries {
+		count += len(series.Samples)
+	}
 
-		var records []*timestreamwrite.Record
+	wc.ingestedSamplesMutex.Lock()
+	defer wc.ingestedSamplesMutex.Unlock()
 
-		if recordMap[databaseName][tableName] != nil {
-			records = recordMap[databaseName][tableName]
+	now := timeNow()
+	windowStart := now.Add(-ingestRateWindow)
+	received := 0
+	expired := 0
+	for i, samples := range wc.ingestedSamples {
+		if !samples.time.After(windowStart) {
+			expired = i + 1
+			continue
 		}
+		received += samples.count
+	}
+	wc.ingestedSamples = wc.ingestedSamples[expired:]
 
-		records, err = wc.appendRecords(records, timeSeries, dimensions, measureValueName)
-		if err != nil {
-			return nil, err
-		}
+	if received != 0 && float64(received+count)/ingestRateWindow.Seconds() > float64(wc.maxIngestRate) {
+		return wc.ingestedSamples[0].time.Sub(windowStart), false
+	}
+	if count != 0 {
+		wc.ingestedSamples = append(wc.ingestedSamples, ingestedSamples{time: now, count: count})
+	}
+	return 0, true
+}
 
-		if len(records) == 0 {
-			LogInfo(wc.logger, "No valid Timestream Records can be ingested.")
+// resolveDuplicateSamples resolves the samples of a time series with the same timestamp according to duplicateSamples,
+// keeping a single sample for each timestamp at the position of its first sample. Duplicate samples with the same value
+// are always dropped. The samples of the write request are left unchanged.
+func (wc *WriteClient) resolveDuplicateSamples(logger log.Logger, samples []prompb.Sample, measureValueName string) ([]prompb.Sample, error) {
+	indexes := make(map[int64]int, len(samples))
+	resolved := make([]prompb.Sample, 0, len(samples))
+	for _, sample := range samples {
+		index, exists := indexes[sample.Timestamp]
+		if !exists {
+			indexes[sample.Timestamp] = len(resolved)
+			resolved = append(resolved, sample)
 			continue
 		}
 
-		recordMap[databaseName][tableName] = records
+		wc.droppedDuplicateSamples.Inc()
+		wc.ignoredSamples.Inc()
+		existing := resolved[index]
+		if existing.Value == sample.Value {
+			continue
+		}
 
+		switch wc.duplicateSamples {
+		case ErrorDuplicateSamples:
+			err := errors.NewDuplicateSamplesError(measureValueName, sample.Timestamp)
+			LogError(logger, "The time series contains samples with the same timestamp but different values.", err)
+			return nil, err
+		case LastDuplicateSamples:
+			resolved[index] = sample
+		case MaxDuplicateSamples:
+			if sample.Value > existing.Value {
+				resolved[index] = sample
+			}
+		}
+		LogDebug(logger, "Resolved samples of a time series with the same timestamp but different values.", "measureName", measureValueName, "timestamp", sample.Timestamp, "keptValue", resolved[index].Value)
 	}
-	return recordMap, nil
+	return resolved, nil
 }
 
-// processMetricLabels processes metricLabels to a *timestreamwrite.Record
-func processMetricLabels(metricLabels map[string]string, operationOnLongMetrics longMetricsOperation) ([]*timestreamwrite.Dimension, labelOperation, error) {
-	var operation labelOperation
-	var dimensions []*timestreamwrite.Dimension
-	var err error
-	for name, value := range metricLabels {
-		// Each label in the metricLabels map contains a characteristic/dimension of the metric, which maps to timestreamwrite.Dimension
-		operation, err = operationOnLongMetrics(name)
-		switch operation {
-		case failed:
-			return nil, operation, err
-		case ignored:
-			return nil, operation, nil
-		default:
-			dimensions = append(dimensions, &timestreamwrite.Dimension{
-				Name:  aws.String(name),
-				Value: aws.String(value),
-			})
-		}
-	}
-	return dimensions, operation, nil
+// timestampBounds returns the oldest and the newest timestamps in milliseconds Timestream accepts in the memory store,
+// narrowed by clampMargin so the clamped samples are still accepted once the WriteRecords request is sent.
+func (wc *WriteClient) timestampBounds() (int64, int64) {
+	now := timeNow()
+	oldest := now.Add(-wc.memoryStoreRetention + clampMargin).UnixNano() / nanosToMillisConversionRate
+	newest := now.Add(maxFutureTimestamp - clampMargin).UnixNano() / nanosToMillisConversionRate
+	return oldest, newest
 }
 
-// getOrCreateRecordMapEntry gets record map entry
-func getOrCreateRecordMapEntry(recordMap recordDestinationMap, databaseName string) map[string][]*timestreamwrite.Record {
-	if recordMap[databaseName] == nil {
-		recordMap[databaseName] = make(map[string][]*timestreamwrite.Record)
+// appendSampleCountRecord appends the companion Record with the number of samples ingested for a time series, at the
+// time of its latest sample. The measure value is a double so the count can be read back like any other metric.
+func (wc *WriteClient) appendSampleCountRecord(logger log.Logger, records []*timestreamwrite.Record, dimensions []*timestreamwrite.Dimension, measureValueName string, sampleCount int, timestamp int64) []*timestreamwrite.Record {
+	sampleCountName := measureValueName + SampleCountSuffix
+	if len(sampleCountName) > maxMeasureNameLength {
+		LogDebug(logger, "The sample count measure name exceeds the maximum length supported by Timestream. Sample count ignored.", "measureName", sampleCountName)
+		return records
 	}
-	return recordMap[databaseName]
-}
 
-// convertToMap converts the slice of Labels to a Map and retrieves the measure value name.
-func convertToMap(labels []*prompb.Label) (map[string]string, string) {
-	// measureValueName is the Prometheus metric name that maps to MeasureName of a timestreamwrite.Record
-	var measureValueName string
+	return append(records, &timestreamwrite.Record{
+		Dimensions:       dimensions,
+		MeasureName:      aws.String(sampleCountName),
+		MeasureValue:     aws.String(strconv.FormatFloat(float64(sampleCount), 'f', 6, 64)),
+		MeasureValueType: aws.String(timestreamwrite.MeasureValueTypeDouble),
+		Time:             aws.String(strconv.FormatInt(timestamp, 10)),
+		TimeUnit:         aws.String(timestreamwrite.TimeUnitMilliseconds),
+		Version:          wc.recordVersion(timestamp),
+	})
+}
 
-	metric := make(map[string]string, len(labels))
-	for _, label := range labels {
-		metric[label.Name] = label.Value
+// recordVersion returns the version of a new Record according to the record version strategy, so that the Records
+// ingested later overwrite the existing Records with the same dimensions, measure name and time. The sample timestamp is
+// the timestamp in milliseconds of the sample the Record is converted from, before any clamping.
+func (wc *WriteClient) recordVersion(sampleTimestamp int64) *int64 {
+	switch wc.recordVersionStrategy {
+	case TimestampRecordVersion:
+		return aws.Int64(time.Now().UnixNano())
+	case CounterRecordVersion:
+		return aws.Int64(wc.nextCounterVersion())
+	case SampleRecordVersion:
+		return aws.Int64(sampleVersion(sampleTimestamp, timeNow().UnixNano()/nanosToMillisConversionRate))
+	default:
+		return nil
 	}
-	measureValueName = metric[model.MetricNameLabel]
-	delete(metric, model.MetricNameLabel)
-
-	return metric, measureValueName
 }
 
-// appendRecords converts each valid Prometheus Sample to a Timestream Record and append the Record to the given slice of records.
-func (wc *WriteClient) appendRecords(records []*timestreamwrite.Record, timeSeries *prompb.TimeSeries, dimensions []*timestreamwrite.Dimension, measureValueName string) ([]*timestreamwrite.Record, error) {
-	var operationOnInvalidSample func(timeSeriesValue float64) (labelOperation, error)
-	if wc.failOnInvalidSample {
-		operationOnInvalidSample = func(timeSeriesValue float64) (labelOperation, error) {
-			if math.IsNaN(timeSeriesValue) || math.IsInf(timeSeriesValue, 0) {
-				// Log and fail on samples with non-finite values.
-				err := errors.NewInvalidSampleValueError(timeSeriesValue)
-				LogError(wc.logger, "Timestream only accepts finite IEEE Standard 754 floating-point precision. Non-finite sample value will fail the program with fail-on-invalid-sample-value enabled.", err, "timeSeries", timeSeries)
-				return failed, err
-			}
-			return unmodified, nil
+// nextCounterVersion returns the current time in nanoseconds, or the previous version plus one if the clock has not
+// advanced since, so the versions are strictly increasing within the connector and keep following the wall clock across
+// restarts and concurrent connectors.
+func (wc *WriteClient) nextCounterVersion() int64 {
+	for {
+		previous := atomic.LoadInt64(&wc.versionCounter)
+		version := time.Now().UnixNano()
+		if version <= previous {
+			version = previous + 1
 		}
-	} else {
-		operationOnInvalidSample = func(timeSeriesValue float64) (labelOperation, error) {
-			if math.IsNaN(timeSeriesValue) || math.IsInf(timeSeriesValue, 0) {
-				// Log and ignore; continue to the next sample.
-				wc.ignoredSamples.Inc()
-				LogDebug(wc.logger, "Timestream only accepts finite IEEE Standard 754 floating point precision. Samples with NaN, Inf and -Inf are ignored.", "timeSeries", timeSeries)
-				return ignored, nil
-			}
-			return unmodified, nil
+		if atomic.CompareAndSwapInt64(&wc.versionCounter, previous, version) {
+			return version
 		}
 	}
+}
 
-	for _, sample := range timeSeries.Samples {
-		// sample.Value is the measured value of a metric which maps to the MeasureValue in timestreamwrite.Record
-		timeSeriesValue := sample.Value
-		operation, err := operationOnInvalidSample(timeSeriesValue)
-
-		switch operation {
-		case failed:
-			return records, err
-		case ignored:
-			continue
-		default:
-		}
-
-		records = append(records, &timestreamwrite.Record{
-			Dimensions:       dimensions,
-			MeasureName:      aws.String(measureValueName),
-			MeasureValue:     aws.String(strconv.FormatFloat(timeSeriesValue, 'f', 6, 64)),
-			MeasureValueType: aws.String(timestreamwrite.MeasureValueTypeDouble),
-			Time:             aws.String(strconv.FormatInt(sample.Timestamp, 10)),
-			TimeUnit:         aws.String(timestreamwrite.TimeUnitMilliseconds),
-		})
+// sampleVersion derives the version of a Record from the timestamp of its sample and the ingestion time, both in
+// milliseconds. A sample delivered again by a retried write request is ingested later and carries a higher version, so
+// Timestream keeps the latest delivery; and of the samples clamped to the same time, the newest sample wins. Deliveries
+// more than maxSampleVersionElapsed after the sample carry the same version, which Timestream accepts for a sample
+// delivered again with the same value, but rejects for a different sample clamped to the same time.
+func sampleVersion(sampleTimestamp int64, ingestionTime int64) int64 {
+	elapsed := ingestionTime - sampleTimestamp
+	if elapsed < 0 {
+		elapsed = 0
+	} else if elapsed > maxSampleVersionElapsed {
+		elapsed = maxSampleVersionElapsed
 	}
-
-	return records, nil
+	return sampleTimestamp<<sampleVersionBits | elapsed
 }
 
 // buildCommands builds a list of queries from the given Prometheus queries.
-func (qc *QueryClient) buildCommands(queries []*prompb.Query) ([]*timestreamquery.QueryInput, bool, error) {
+func (qc *QueryClient) buildCommands(logger log.Logger, queries []*prompb.Query) ([]*timestreamquery.QueryInput, bool, error) {
 	var timestreamQueries []*timestreamquery.QueryInput
+	var readQueries []*combinedReadQuery
 	var isRelatedToRegex = false
 	for _, query := range queries {
 		var matcherName string
 		var matchers []string
+		hasMetricName := false
+
+		startMs, endMs := qc.timeRange(logger, query)
+		if readRange := time.Duration(endMs-startMs) * time.Millisecond; qc.maxReadRange > 0 && readRange > qc.maxReadRange {
+			err := errors.NewMaxReadRangeError(readRange, qc.maxReadRange)
+			LogError(logger, "Invalid query exceeding the maximum time range.", err)
+			return nil, isRelatedToRegex, err
+		}
 		for _, matcher := range query.Matchers {
+			matcherValue := matcher.Value
+			regexMatcherName := ""
 			switch matcher.Name {
 			case model.MetricNameLabel:
 				matcherName = measureNameColumnName
+				hasMetricName = true
+				if qc.normalizeMeasureNames {
+					// Match the regular expressions against the metric names restored from the normalized measure names.
+					matcherValue = normalizeMeasureName(matcherValue)
+					regexMatcherName = fmt.Sprintf("REPLACE(%s, '.', ':')", measureNameColumnName)
+				}
+				if len(qc.addNamespace) != 0 {
+					// The reads add the namespace to the measure names, so the metric names are matched against the measure
+					// names with the namespace, or against the measure names alone if the metric name has the namespace.
+					if len(regexMatcherName) == 0 {
+						regexMatcherName = measureNameColumnName
+					}
+					regexMatcherName = fmt.Sprintf("CONCAT('%s', %s)", escapeSQLLiteral(qc.addNamespace), regexMatcherName)
+					if strings.HasPrefix(matcher.Value, qc.addNamespace) {
+						matcherValue = strings.TrimPrefix(matcher.Value, qc.addNamespace)
+						if qc.normalizeMeasureNames {
+							matcherValue = normalizeMeasureName(matcherValue)
+						}
+					} else {
+						matcherName, matcherValue = regexMatcherName, matcher.Value
+					}
+				}
 			default:
+				// The label name is the column of the condition, and cannot be escaped as a string literal.
+				if !labelNamePattern.MatchString(matcher.Name) {
+					err := errors.NewInvalidMatcherNameError(matcher.Name)
+					LogError(logger, "Invalid query with a matcher on an invalid label name.", err)
+					return nil, isRelatedToRegex, err
+				}
 				matcherName = matcher.Name
+				if isReservedColumnName(matcherName) {
+					matcherName = reservedLabelPrefix + matcherName
+				}
+				if matcherName == model.BucketLabel && len(qc.infBucketValue) != 0 && isInfBucket(matcherValue) {
+					matcherValue = qc.infBucketValue
+				}
+			}
+			if len(regexMatcherName) == 0 {
+				regexMatcherName = matcherName
 			}
 
 			switch matcher.Type {
 			case prompb.LabelMatcher_EQ:
-				matchers = append(matchers, fmt.Sprintf("%s = '%s'", matcherName, matcher.Value))
+				matchers = append(matchers, qc.equalityMatcher(matcherName, "=", matcherValue))
 			case prompb.LabelMatcher_NEQ:
-				matchers = append(matchers, fmt.Sprintf("%s != '%s'", matcherName, matcher.Value))
+				matchers = append(matchers, qc.equalityMatcher(matcherName, "!=", matcherValue))
 			case prompb.LabelMatcher_RE:
-				matchers = append(matchers, fmt.Sprintf("REGEXP_LIKE(%s, '%s')", matcherName, matcher.Value))
+				matchers = append(matchers, fmt.Sprintf("REGEXP_LIKE(%s, '%s')", regexMatcherName, escapeSQLLiteral(matcher.Value)))
 				isRelatedToRegex = true
 			case prompb.LabelMatcher_NRE:
-				matchers = append(matchers, fmt.Sprintf("NOT REGEXP_LIKE(%s, '%s')", matcherName, matcher.Value))
+				matchers = append(matchers, fmt.Sprintf("NOT REGEXP_LIKE(%s, '%s')", regexMatcherName, escapeSQLLiteral(matcher.Value)))
 				isRelatedToRegex = true
 			default:
 				err := errors.NewUnknownMatcherError()
-				LogError(qc.logger, "Invalid query with unknown matcher.", err)
+				LogError(logger, "Invalid query with unknown matcher.", err)
+				return nil, isRelatedToRegex, err
+			}
+		}
+
+		if qc.requireMatcher && len(query.Matchers) == 0 {
+			err := errors.NewMissingMatcherError()
+			LogError(logger, "Invalid query without any label matcher.", err)
+			return nil, isRelatedToRegex, err
+		}
+
+		if !hasMetricName && len(query.Matchers) != 0 {
+			switch qc.dimensionOnlyReads {
+			case EmptyDimensionOnlyReads:
+				LogDebug(logger, "Skipping the query without a metric name matcher.", "matchers", fmt.Sprint(query.Matchers))
+				continue
+			case RejectDimensionOnlyReads:
+				err := errors.NewDimensionOnlyReadError()
+				LogError(logger, "Invalid query without a metric name matcher.", err)
 				return nil, isRelatedToRegex, err
 			}
 		}
 
 		if len(qc.client.defaultDataBase) == 0 {
 			err := errors.NewMissingDatabaseError(qc.client.defaultDataBase)
-			LogError(qc.logger, "The database name must be set through the --default-database flag.", err)
+			LogError(logger, "The database name must be set through the --default-database flag.", err)
 			return nil, isRelatedToRegex, err
 		}
 
-		if len(qc.client.defaultTable) == 0 {
+		if len(qc.client.defaultTable) == 0 && len(qc.readTables) == 0 {
 			err := errors.NewMissingTableError(qc.client.defaultTable)
-			LogError(qc.logger, "The table name must set through the --default-table flag.", err)
+			LogError(logger, "The table name must set through the --default-table flag.", err)
 			return nil, isRelatedToRegex, err
 		}
 
-		if query.GetHints() != nil {
-			matchers = append(matchers, fmt.Sprintf("%s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)", timeColumnName, query.GetHints().StartMs/millisToSecConversionRate, query.GetHints().EndMs/millisToSecConversionRate))
-		} else {
-			matchers = append(matchers, fmt.Sprintf("%s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)", timeColumnName, query.StartTimestampMs/millisToSecConversionRate, query.EndTimestampMs/millisToSecConversionRate))
-		}
+		readQueries = qc.combineReadQuery(readQueries, startMs, endMs, strings.Join(matchers, " AND "))
+	}
 
-		timestreamQueries = append(timestreamQueries, &timestreamquery.QueryInput{
-			QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %v", qc.client.defaultDataBase, qc.client.defaultTable, strings.Join(matchers, " AND "))),
-		})
+	for _, readQuery := range readQueries {
+		condition := readQuery.condition()
+		for _, timeFilter := range qc.timeFilters(readQuery.startMs, readQuery.endMs) {
+			queryMatchers := []string{timeFilter}
+			if len(condition) != 0 {
+				queryMatchers = []string{condition, timeFilter}
+			}
+
+			// Each table is queried separately so tables with different dimensions can be read together, the results are merged in convertToResult.
+			for _, destination := range qc.destinations() {
+				queryInput := &timestreamquery.QueryInput{
+					QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %v", destination.database, destination.table, strings.Join(queryMatchers, " AND "))),
+				}
+				if qc.readPageSize > 0 {
+					queryInput.MaxRows = aws.Int64(int64(qc.readPageSize))
+				}
+				LogDebug(logger, "Generated the Timestream query for the read request.", "query", aws.StringValue(queryInput.QueryString))
+				timestreamQueries = append(timestreamQueries, queryInput)
+			}
+		}
 	}
 
 	return timestreamQueries, isRelatedToRegex, nil
 }
 
+// combinedReadQuery is the time range and the conditions of the Prometheus queries combined into the same Timestream
+// queries. Each condition is the conjunction of the matchers of a Prometheus query.
+type combinedReadQuery struct {
+	startMs    int64
+	endMs      int64
+	conditions []string
+}
+
+// combineReadQuery adds the condition of a Prometheus query to the read queries. If combine-read-queries is enabled, the
+// condition is added to the read query with the same time range if any, otherwise a new read query is added.
+func (qc *QueryClient) combineReadQuery(readQueries []*combinedReadQuery, startMs int64, endMs int64, condition string) []*combinedReadQuery {
+	if qc.combineReadQueries {
+		for _, readQuery := range readQueries {
+			if readQuery.startMs == startMs && readQuery.endMs == endMs {
+				readQuery.conditions = append(readQuery.conditions, condition)
+				return readQueries
+			}
+		}
+	}
+	return append(readQueries, &combinedReadQuery{startMs: startMs, endMs: endMs, conditions: []string{condition}})
+}
+
+// condition returns the condition matching the rows of any of the combined Prometheus queries, or an empty string if
+// any of the queries has no matchers and so matches every row. The results of all the queries of a read request are
+// merged by label set in convertToResult, so the combined query returns the same time series as the separate queries.
+func (q *combinedReadQuery) condition() string {
+	if len(q.conditions) == 1 {
+		return q.conditions[0]
+	}
+
+	conditions := make([]string, 0, len(q.conditions))
+	for _, condition := range q.conditions {
+		if len(condition) == 0 {
+			return ""
+		}
+		conditions = append(conditions, "("+condition+")")
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
+
+// equalityMatcher returns the condition of an EQ or NEQ matcher, which compares the lowercase values if case-insensitive
+// matchers are enabled.
+func (qc *QueryClient) equalityMatcher(column string, operator string, value string) string {
+	value = escapeSQLLiteral(value)
+	if qc.caseInsensitive {
+		return fmt.Sprintf("LOWER(%s) %s LOWER('%s')", column, operator, value)
+	}
+	return fmt.Sprintf("%s %s '%s'", column, operator, value)
+}
+
+// escapeSQLLiteral escapes the value to be embedded in a single-quoted string literal of a Timestream query, so a value
+// containing single quotes cannot end the literal early. The other characters, including backslashes, have no special
+// meaning in the string literals.
+func escapeSQLLiteral(value string) string {
+	return strings.ReplaceAll(value, "'", "''")
+}
+
+// timeRange returns the time range of the query in milliseconds, preferring the range in the hints if present. If the
+// range is absent or empty and a default lookback is configured, the range ending at the end of the query, or now if the
+// end is unset, and spanning the default lookback is returned instead.
+func (qc *QueryClient) timeRange(logger log.Logger, query *prompb.Query) (int64, int64) {
+	startMs, endMs := query.StartTimestampMs, query.EndTimestampMs
+	if hints := query.GetHints(); hints != nil {
+		startMs, endMs = hints.StartMs, hints.EndMs
+	}
+
+	if qc.defaultLookback <= 0 || startMs < endMs {
+		return startMs, endMs
+	}
+
+	if endMs == 0 {
+		endMs = timeNow().UnixNano() / nanosToMillisConversionRate
+	}
+	LogDebug(logger, "Applying the default lookback to the query without a time range.", "defaultLookback", qc.defaultLookback)
+	return endMs - qc.defaultLookback.Milliseconds(), endMs
+}
+
+// estimateReadCost returns a heuristic estimate of the cost of the queries, as Timestream does not estimate the data
+// scanned by a query before running it. The cost of each query is the number of hours of its time range for each read
+// destination, multiplied by unselectiveReadCostFactor if the query does not match a single metric name since it scans
+// every measure of the tables, and halved for each other label matched by equality.
+func (qc *QueryClient) estimateReadCost(logger log.Logger, queries []*prompb.Query) float64 {
+	destinations := float64(len(qc.destinations()))
+	var cost float64
+	for _, query := range queries {
+		startMs, endMs := qc.timeRange(logger, query)
+		queryCost := destinations * float64(endMs-startMs) / float64(time.Hour.Milliseconds())
+		hasMetricName := false
+		for _, matcher := range query.Matchers {
+			if matcher.Type != prompb.LabelMatcher_EQ || len(matcher.Value) == 0 {
+				continue
+			}
+			if matcher.Name == model.MetricNameLabel {
+				hasMetricName = true
+			} else {
+				queryCost /= 2
+			}
+		}
+		if !hasMetricName {
+			queryCost *= unselectiveReadCostFactor
+		}
+		cost += queryCost
+	}
+	return cost
+}
+
+// timeFilters returns the time filters of the queries covering the time range in milliseconds. If prefer-recent is
+// enabled and the range crosses the memory store retention, the range is split so the recent data in the memory store is
+// queried first, and the magnetic store is only queried for the remainder of the range.
+func (qc *QueryClient) timeFilters(startMs int64, endMs int64) []string {
+	start, end := startMs/millisToSecConversionRate, endMs/millisToSecConversionRate
+	if qc.preferRecent && qc.memoryStoreRetention > 0 {
+		memoryStoreStart := timeNow().Add(-qc.memoryStoreRetention).Unix()
+		if start < memoryStoreStart && memoryStoreStart <= end {
+			return []string{
+				fmt.Sprintf("%s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)", timeColumnName, memoryStoreStart, end),
+				fmt.Sprintf("%s >= FROM_UNIXTIME(%d) AND %s < FROM_UNIXTIME(%d)", timeColumnName, start, timeColumnName, memoryStoreStart),
+			}
+		}
+	}
+	return []string{fmt.Sprintf("%s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)", timeColumnName, start, end)}
+}
+
+// tables returns the tables to read from, which defaults to the default table.
+func (qc *QueryClient) tables() []string {
+	if len(qc.readTables) != 0 {
+		return qc.readTables
+	}
+	return []string{qc.client.defaultTable}
+}
+
+// readDestination is a table queried by the read requests.
+type readDestination struct {
+	database string
+	table    string
+}
+
+// destinations returns the tables to query, which are the tables of every read database if cross-database reads are
+// enabled, or the tables of the default database otherwise.
+func (qc *QueryClient) destinations() []readDestination {
+	databases := []string{qc.client.defaultDataBase}
+	if qc.crossDatabaseReads && len(qc.readDatabases) != 0 {
+		databases = qc.readDatabases
+	}
+
+	var destinations []readDestination
+	for _, database := range databases {
+		for _, table := range qc.tables() {
+			destinations = append(destinations, readDestination{database: database, table: table})
+		}
+	}
+	return destinations
+}
+
 // convertToResult converts the Timestream QueryOutput to Prometheus QueryResult.
-func (qc *QueryClient) convertToResult(results *prompb.QueryResult, page *timestreamquery.QueryOutput) (*prompb.QueryResult, error) {
+func (qc *QueryClient) convertToResult(logger log.Logger, results *prompb.QueryResult, page *timestreamquery.QueryOutput) (*prompb.QueryResult, error) {
 	var timeSeries []*prompb.TimeSeries
 	rows := page.Rows
 
 	if len(rows) == 0 {
-		LogInfo(qc.logger, "No results returned for the PromQL.")
+		LogInfo(logger, "No results returned for the PromQL.")
 		return results, nil
 	}
 
 	for _, row := range rows {
-		labels, samples, err := qc.constructLabels(row.Data, page.ColumnInfo)
+		labels, samples, err := qc.constructLabels(logger, row.Data, page.ColumnInfo)
 		if err != nil {
-			LogDebug(qc.logger, "Error occurred when constructing Prometheus Labels from Timestream QueryOutput with Row", "row", row)
+			LogDebug(logger, "Error occurred when constructing Prometheus Labels from Timestream QueryOutput with Row", "row", row)
 			return results, err
 		}
+		if qc.nonFiniteReads == SkipNonFiniteReads && (math.IsNaN(samples.Value) || math.IsInf(samples.Value, 0)) {
+			LogDebug(logger, "Skipping the non-finite sample read from Timestream.", "row", row)
+			continue
+		}
+		if qc.readDebugColumns {
+			labels = append(labels, debugColumnLabels(row.Data, page.ColumnInfo)...)
+		}
 		timeSeries = constructTimeSeries(labels, samples, timeSeries)
 	}
 
-	results.Timeseries = append(results.Timeseries, timeSeries...)
+	results.Timeseries = mergeTimeSeries(results.Timeseries, timeSeries)
 	return results, nil
 }
 
+// mergeTimeSeries merges the TimeSeries converted from a page into the current slice of TimeSeries. The samples of a
+// TimeSeries returned by multiple pages or tables are merged into a single TimeSeries, and are sorted by sortSamples
+// once every page has been merged.
+func mergeTimeSeries(currentTimeSeries []*prompb.TimeSeries, newTimeSeries []*prompb.TimeSeries) []*prompb.TimeSeries {
+	for _, timeSeries := range newTimeSeries {
+		anyMatch := false
+		for _, existing := range currentTimeSeries {
+			if compareLabelSets(existing.GetLabels(), timeSeries.GetLabels()) {
+				existing.Samples = append(existing.Samples, timeSeries.Samples...)
+				anyMatch = true
+				break
+			}
+		}
+
+		if !anyMatch {
+			currentTimeSeries = append(currentTimeSeries, timeSeries)
+		}
+	}
+	return currentTimeSeries
+}
+
+// sortSamples sorts the samples of every TimeSeries in the query result by timestamp.
+func sortSamples(results *prompb.QueryResult) {
+	for _, timeSeries := range results.Timeseries {
+		samples := timeSeries.Samples
+		sort.SliceStable(samples, func(i, j int) bool {
+			return samples[i].Timestamp < samples[j].Timestamp
+		})
+	}
+}
+
+// compareLabelSets compares two slices of labels regardless of their order, since tables with different schemas return
+// the dimension columns in different orders.
+func compareLabelSets(labels1 []*prompb.Label, labels2 []*prompb.Label) bool {
+	if len(labels1) != len(labels2) {
+		return false
+	}
+	values := make(map[string]string, len(labels1))
+	for _, label := range labels1 {
+		values[label.Name] = label.Value
+	}
+	for _, label := range labels2 {
+		if value, ok := values[label.Name]; !ok || value != label.Value {
+			return false
+		}
+	}
+	return true
+}
+
 // constructLabels converts the given row to the corresponding Prometheus Label and Sample.
-func (qc *QueryClient) constructLabels(row []*timestreamquery.Datum, metadata []*timestreamquery.ColumnInfo) ([]*prompb.Label, prompb.Sample, error) {
+func (qc *QueryClient) constructLabels(logger log.Logger, row []*timestreamquery.Datum, metadata []*timestreamquery.ColumnInfo) ([]*prompb.Label, prompb.Sample, error) {
 	var labels []*prompb.Label
 	var sample prompb.Sample
+	if len(row) != len(metadata) {
+		err := fmt.Errorf("the row has %d values but the query result has %d columns", len(row), len(metadata))
+		LogError(logger, "Misaligned row retrieved from Timestream", err)
+		return labels, sample, err
+	}
 	for i, datum := range row {
 		if datum.NullValue == nil {
 			column := metadata[i]
+			if column.Name == nil || datum.ScalarValue == nil {
+				err := fmt.Errorf("the value of column %d is not a named scalar value", i)
+				LogError(logger, "Invalid datum type retrieved from Timestream", err)
+				return labels, sample, err
+			}
+			if qc.numericQuantiles && *column.Name == QuantileMeasureName {
+				// The quantile label is restored from the quantile dimension.
+				continue
+			}
+			if strings.HasPrefix(*column.Name, measureValuePrefix+"::") || (qc.numericQuantiles && *column.Name == QuantileSampleMeasureName) {
+				val, err := parseMeasureValue(column, *datum.ScalarValue)
+				if err != nil {
+					LogError(logger, "Invalid datum type retrieved from Timestream", err)
+					return labels, sample, err
+				}
+				sample.Value = val
+				continue
+			}
 			switch *column.Name {
 			case timeColumnName:
 				timestamp, err := time.Parse(timestampLayout, *datum.ScalarValue)
 				if err != nil {
 					err := fmt.Errorf("error occured while parsing '%d' as a timestamp", datum.ScalarValue)
-					LogError(qc.logger, "Invalid datum type retrieved from Timestream", err)
+					LogError(logger, "Invalid datum type retrieved from Timestream", err)
 					return labels, sample, err
 				}
 				sample.Timestamp = timestamp.UnixNano() / nanosToMillisConversionRate
-			case measureValueColumnName:
-				val, err := strconv.ParseFloat(*datum.ScalarValue, 64)
-				if err != nil {
-					err := fmt.Errorf("error occured while parsing '%d' as a float", datum.ScalarValue)
-					LogError(qc.logger, "Invalid datum type retrieved from Timestream", err)
-					return labels, sample, err
-				}
-				sample.Value = val
 			case measureNameColumnName:
+				value := *datum.ScalarValue
+				if qc.normalizeMeasureNames {
+					value = denormalizeMeasureName(value)
+				}
 				labels = append(labels, &prompb.Label{
 					Name:  model.MetricNameLabel,
-					Value: *datum.ScalarValue,
+					Value: qc.addNamespace + value,
 				})
 			default:
+				name := *column.Name
+				// Restore the names of the labels renamed on ingestion for colliding with the reserved column names.
+				if strings.HasPrefix(name, reservedLabelPrefix) && isReservedColumnName(strings.TrimPrefix(name, reservedLabelPrefix)) {
+					name = strings.TrimPrefix(name, reservedLabelPrefix)
+				}
+				value := *datum.ScalarValue
+				if column.Type != nil && aws.StringValue(column.Type.ScalarType) == timestreamquery.ScalarTypeTimestamp {
+					if qc.timestampColumns == DropTimestampColumns {
+						continue
+					}
+					value = qc.formatTimestampColumn(logger, name, value)
+				}
+				// Restore the upper bound of the +Inf histogram buckets stored as the configured value.
+				if name == model.BucketLabel && len(qc.infBucketValue) != 0 && value == qc.infBucketValue {
+					value = "+Inf"
+				}
 				labels = append(labels, &prompb.Label{
-					Name:  *column.Name,
-					Value: *datum.ScalarValue,
+					Name:  name,
+					Value: value,
 				})
 			}
 		}
@@ -626,6 +2881,84 @@ func (qc *QueryClient) constructLabels(row []*timestreamquery.Datum, metadata []
 	return labels, sample, nil
 }
 
+// parseMeasureValue parses the value of a measure value column, such as measure_value::double, according to the scalar
+// type of the column, or to the type in the column name if the query result has no column types, defaulting to a
+// double. Boolean measures, such as of the boolean metrics, are read back as 0 or 1.
+func parseMeasureValue(column *timestreamquery.ColumnInfo, value string) (float64, error) {
+	scalarType := timestreamquery.ScalarTypeDouble
+	if strings.HasPrefix(*column.Name, measureValuePrefix+"::") {
+		scalarType = strings.ToUpper(strings.TrimPrefix(*column.Name, measureValuePrefix+"::"))
+	}
+	if column.Type != nil && column.Type.ScalarType != nil {
+		scalarType = *column.Type.ScalarType
+	}
+
+	switch scalarType {
+	case timestreamquery.ScalarTypeDouble, timestreamquery.ScalarTypeVarchar:
+		val, err := strconv.ParseFloat(value, 64)
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			// Values beyond the range of a float64 are parsed as infinite values.
+			err = nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error occured while parsing '%s' of column %s as a float", value, *column.Name)
+		}
+		return val, nil
+	case timestreamquery.ScalarTypeBigint, timestreamquery.ScalarTypeInteger:
+		val, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("error occured while parsing '%s' of column %s as an integer", value, *column.Name)
+		}
+		return float64(val), nil
+	case timestreamquery.ScalarTypeBoolean:
+		if value == "true" {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("the measure value column %s of type %s is not supported", *column.Name, scalarType)
+}
+
+// formatTimestampColumn formats the value of a timestamp column other than the time column according to the
+// read-timestamp-columns option, as the milliseconds since the epoch or in RFC 3339. A value failing to be parsed is
+// returned as is.
+func (qc *QueryClient) formatTimestampColumn(logger log.Logger, name string, value string) string {
+	if qc.timestampColumns != UnixTimestampColumns && qc.timestampColumns != RFC3339TimestampColumns {
+		return value
+	}
+	timestamp, err := time.Parse(timestampLayout, value)
+	if err != nil {
+		LogDebug(logger, "Unable to parse the value of the timestamp column, the value is read as is.", "column", name, "value", value)
+		return value
+	}
+	if qc.timestampColumns == UnixTimestampColumns {
+		return strconv.FormatInt(timestamp.UnixNano()/nanosToMillisConversionRate, 10)
+	}
+	return timestamp.UTC().Format(time.RFC3339Nano)
+}
+
+// debugColumnLabels returns a meta-label with the raw scalar value of each column of the row other than the time, measure
+// name and measure value columns, such as the dimensions before the reserved label names are restored, or the measure
+// values of other types. The names of the meta-labels are the column names prefixed with debugColumnLabelPrefix, with the
+// characters not allowed in a label name replaced by underscores.
+func debugColumnLabels(row []*timestreamquery.Datum, metadata []*timestreamquery.ColumnInfo) []*prompb.Label {
+	var labels []*prompb.Label
+	for i, datum := range row {
+		if i >= len(metadata) || metadata[i].Name == nil || datum.ScalarValue == nil {
+			continue
+		}
+		switch name := *metadata[i].Name; name {
+		case timeColumnName, measureNameColumnName, measureValueColumnName:
+		default:
+			labels = append(labels, &prompb.Label{
+				Name:  debugColumnLabelPrefix + invalidLabelNameCharacters.ReplaceAllString(name, "_"),
+				Value: *datum.ScalarValue,
+			})
+		}
+	}
+	return labels
+}
+
 // constructTimeSeries constructs a TimeSeries in the query result.
 func constructTimeSeries(labels []*prompb.Label, sample prompb.Sample, currentTimeSeries []*prompb.TimeSeries) []*prompb.TimeSeries {
 	// anyMatch records if the label match one of the labels in current TimeSeries.
@@ -675,7 +3008,7 @@ func (qc QueryClient) Name() string {
 }
 
 // Name gets the name of the write client.
-func (wc WriteClient) Name() string {
+func (wc *WriteClient) Name() string {
 	return "Timestream write client"
 }
 
@@ -689,24 +3022,70 @@ func (c *Client) WriteClient() *WriteClient {
 	return c.writeClient
 }
 
+// RollupClient gets the rollup client, which is nil if rollups are disabled.
+func (c *Client) RollupClient() *RollupClient {
+	return c.rollupClient
+}
+
 // Describe implements prometheus.Collector.
 func (c *Client) Describe(ch chan<- *prometheus.Desc) {
-	ch <- c.writeClient.ignoredSamples.Desc()
-	ch <- c.writeClient.receivedSamples.Desc()
-	ch <- c.writeClient.writeExecutionTime.Desc()
-	ch <- c.writeClient.writeRequests.Desc()
-	ch <- c.queryClient.readRequests.Desc()
-	ch <- c.queryClient.readExecutionTime.Desc()
+	c.metricsMutex.RLock()
+	defer c.metricsMutex.RUnlock()
+	// The clients are created on their first use on AWS Lambda.
+	if c.writeClient != nil {
+		ch <- c.writeClient.ignoredSamples.Desc()
+		ch <- c.writeClient.receivedSamples.Desc()
+		ch <- c.writeClient.clampedSamples.Desc()
+		ch <- c.writeClient.droppedDuplicateSamples.Desc()
+		c.writeClient.distinctMeasures.Describe(ch)
+		c.writeClient.metricSamples.Describe(ch)
+		c.writeClient.rejectedRecords.Describe(ch)
+		ch <- c.writeClient.writeExecutionTime.Desc()
+		ch <- c.writeClient.writeBatchSize.Desc()
+		ch <- c.writeClient.writeRequests.Desc()
+		ch <- c.writeClient.throttledRequests.Desc()
+	}
+	if c.queryClient != nil {
+		ch <- c.queryClient.readRequests.Desc()
+		ch <- c.queryClient.readExecutionTime.Desc()
+		ch <- c.queryClient.partialReads.Desc()
+	}
+	requestAttempts.Describe(ch)
+	ch <- concurrentCalls.Desc()
+	if c.rollupClient != nil {
+		ch <- c.rollupClient.rollupRecords.Desc()
+		ch <- c.rollupClient.lateSamples.Desc()
+	}
 }
 
 // Collect implements prometheus.Collector.
 func (c *Client) Collect(ch chan<- prometheus.Metric) {
-	ch <- c.writeClient.ignoredSamples
-	ch <- c.writeClient.receivedSamples
-	ch <- c.writeClient.writeExecutionTime
-	ch <- c.writeClient.writeRequests
-	ch <- c.queryClient.readRequests
-	ch <- c.queryClient.readExecutionTime
+	c.metricsMutex.RLock()
+	defer c.metricsMutex.RUnlock()
+	if c.writeClient != nil {
+		ch <- c.writeClient.ignoredSamples
+		ch <- c.writeClient.receivedSamples
+		ch <- c.writeClient.clampedSamples
+		ch <- c.writeClient.droppedDuplicateSamples
+		c.writeClient.distinctMeasures.Collect(ch)
+		c.writeClient.metricSamples.Collect(ch)
+		c.writeClient.rejectedRecords.Collect(ch)
+		ch <- c.writeClient.writeExecutionTime
+		ch <- c.writeClient.writeBatchSize
+		ch <- c.writeClient.writeRequests
+		ch <- c.writeClient.throttledRequests
+	}
+	if c.queryClient != nil {
+		ch <- c.queryClient.readRequests
+		ch <- c.queryClient.readExecutionTime
+		ch <- c.queryClient.partialReads
+	}
+	requestAttempts.Collect(ch)
+	ch <- concurrentCalls
+	if c.rollupClient != nil {
+		ch <- c.rollupClient.rollupRecords
+		ch <- c.rollupClient.lateSamples
+	}
 }
 
 // Get the value of a counter
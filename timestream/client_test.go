@@ -15,16 +15,24 @@ and limitations under the License.
 package timestream
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	goErrors "errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/corehandlers"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/private/protocol"
 	"github.com/aws/aws-sdk-go/service/timestreamquery"
 	"github.com/aws/aws-sdk-go/service/timestreamquery/timestreamqueryiface"
 	"github.com/aws/aws-sdk-go/service/timestreamwrite"
 	"github.com/aws/aws-sdk-go/service/timestreamwrite/timestreamwriteiface"
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/google/go-cmp/cmp"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
@@ -32,12 +40,21 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"math"
+	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	"timestream-prometheus-connector/errors"
+
+	prometheusClientModel "github.com/prometheus/client_model/go"
 )
 
 var (
@@ -45,11 +62,24 @@ var (
 	mockUnixTime       = time.Now().UnixNano() / (int64(time.Millisecond) / int64(time.Nanosecond))
 	mockCounter        = prometheus.NewCounter(prometheus.CounterOpts{})
 	mockHistogram      = prometheus.NewHistogram(prometheus.HistogramOpts{})
+	mockCounterVec     = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "mock"}, []string{"reason"})
 	mockEndUnixTime    = mockUnixTime + 30000
 	mockAwsConfigs     = &aws.Config{}
 	mockCredentials    = credentials.AnonymousCredentials
 	startUnixInSeconds = mockUnixTime / millisToSecConversionRate
 	endUnixInSeconds   = mockEndUnixTime / millisToSecConversionRate
+
+	mockWriteClientOptions = WriteClientOptions{
+		FailOnLongMetricLabelName: true,
+		FailOnInvalidSample:       true,
+		RetryOnAuthError:          true,
+		ReservedLabels:            RenameReservedLabels,
+		RecordVersionStrategy:     NoRecordVersion,
+	}
+	mockQueryClientOptions = QueryClientOptions{
+		DimensionOnlyReads: AllowDimensionOnlyReads,
+		NonFiniteReads:     PassNonFiniteReads,
+	}
 )
 
 const (
@@ -86,11 +116,57 @@ func (m *mockTimestreamWriteClient) WriteRecords(input *timestreamwrite.WriteRec
 	return args.Get(0).(*timestreamwrite.WriteRecordsOutput), args.Error(1)
 }
 
+func (m *mockTimestreamWriteClient) DescribeTable(input *timestreamwrite.DescribeTableInput) (*timestreamwrite.DescribeTableOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*timestreamwrite.DescribeTableOutput), args.Error(1)
+}
+
+func (m *mockTimestreamWriteClient) CreateDatabase(input *timestreamwrite.CreateDatabaseInput) (*timestreamwrite.CreateDatabaseOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*timestreamwrite.CreateDatabaseOutput), args.Error(1)
+}
+
+func (m *mockTimestreamWriteClient) CreateTable(input *timestreamwrite.CreateTableInput) (*timestreamwrite.CreateTableOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*timestreamwrite.CreateTableOutput), args.Error(1)
+}
+
+func (m *mockTimestreamWriteClient) DescribeTableWithContext(ctx aws.Context, input *timestreamwrite.DescribeTableInput, opts ...request.Option) (*timestreamwrite.DescribeTableOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*timestreamwrite.DescribeTableOutput), args.Error(1)
+}
+
+func (m *mockTimestreamWriteClient) DescribeEndpointsWithContext(ctx aws.Context, input *timestreamwrite.DescribeEndpointsInput, opts ...request.Option) (*timestreamwrite.DescribeEndpointsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*timestreamwrite.DescribeEndpointsOutput), args.Error(1)
+}
+
 type mockTimestreamQueryClient struct {
 	mock.Mock
 	timestreamqueryiface.TimestreamQueryAPI
 }
 
+func (m *mockTimestreamQueryClient) QueryPagesWithContext(ctx aws.Context, input *timestreamquery.QueryInput, f func(page *timestreamquery.QueryOutput, lastPage bool) bool, opts ...request.Option) error {
+	args := m.Called(ctx, input, f)
+	return args.Error(0)
+}
+
+type mockRetryer struct {
+	mock.Mock
+}
+
+func (m *mockRetryer) RetryRules(r *request.Request) time.Duration {
+	return 0
+}
+
+func (m *mockRetryer) ShouldRetry(r *request.Request) bool {
+	return m.Called(r.RetryCount).Bool(0)
+}
+
+func (m *mockRetryer) MaxRetries() int {
+	return 3
+}
+
 func (m *mockTimestreamQueryClient) QueryPages(input *timestreamquery.QueryInput, f func(page *timestreamquery.QueryOutput, lastPage bool) bool) error {
 	args := m.Called(input, f)
 	return args.Error(0)
@@ -98,7 +174,7 @@ func (m *mockTimestreamQueryClient) QueryPages(input *timestreamquery.QueryInput
 
 func TestClientNewClient(t *testing.T) {
 	client := NewBaseClient(mockDatabaseName, mockTableName)
-	client.NewWriteClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)}, true, true)
+	client.NewWriteClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)}, mockWriteClientOptions)
 
 	assert.NotNil(t, client.writeClient)
 	assert.Equal(t, mockLogger, client.writeClient.logger)
@@ -115,7 +191,7 @@ func TestClientNewQueryClient(t *testing.T) {
 		mock.AnythingOfType(functionType)).Return(nil)
 
 	client := NewBaseClient(mockDatabaseName, mockTableName)
-	client.NewQueryClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)})
+	client.NewQueryClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)}, mockQueryClientOptions)
 
 	assert.NotNil(t, client.queryClient)
 	assert.Equal(t, mockLogger, client.queryClient.logger)
@@ -124,6 +200,90 @@ func TestClientNewQueryClient(t *testing.T) {
 	assert.NotNil(t, queryConfig)
 }
 
+func TestClientResetMetrics(t *testing.T) {
+	client := NewBaseClient(mockDatabaseName, mockTableName)
+	client.NewWriteClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)}, mockWriteClientOptions)
+	client.NewQueryClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)}, mockQueryClientOptions)
+	client.NewRollupClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)}, mockRollupTableName, time.Minute)
+
+	client.writeClient.receivedSamples.Add(10)
+	client.writeClient.writeRequests.Inc()
+	client.writeClient.rejectedRecords.WithLabelValues(duplicateRejectionReason).Inc()
+	client.queryClient.readRequests.Inc()
+	client.rollupClient.rollupRecords.Inc()
+
+	client.ResetMetrics()
+
+	assert.Equal(t, 0, getCounterValue(client.writeClient.receivedSamples))
+	assert.Equal(t, 0, getCounterValue(client.writeClient.writeRequests))
+	assert.Equal(t, 0, getCounterValue(client.queryClient.readRequests))
+	assert.Equal(t, 0, getCounterValue(client.rollupClient.rollupRecords))
+	assert.Equal(t, 0, testCollectorCount(client.writeClient.rejectedRecords))
+}
+
+func TestClientResetMetricsDuringWrites(t *testing.T) {
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
+
+	client := NewBaseClient(mockDatabaseName, mockTableName)
+	client.NewWriteClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)}, mockWriteClientOptions)
+	client.NewQueryClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)}, mockQueryClientOptions)
+
+	const writers, writesPerWriter = 4, 25
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < writesPerWriter; j++ {
+				assert.Nil(t, client.writeClient.Write(createNewRequestTemplate(), mockCredentials))
+			}
+		}()
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(client)
+	for i := 0; i < 10; i++ {
+		client.ResetMetrics()
+		_, err := registry.Gather()
+		assert.Nil(t, err)
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, getCounterValue(client.writeClient.writeRequests), writers*writesPerWriter)
+}
+
+func TestClientCollectWithWriteClientOnly(t *testing.T) {
+	client := NewBaseClient(mockDatabaseName, mockTableName)
+	client.NewWriteClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)}, mockWriteClientOptions)
+	client.writeClient.receivedSamples.Add(10)
+
+	// A connector running without a query client, such as a write-only deployment, only exports the metrics of the
+	// write client.
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registry.Register(client))
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	assert.True(t, names["timestream_connector_received_samples_total"])
+	assert.False(t, names["timestream_connector_read_requests_total"])
+	assert.False(t, names["timestream_connector_read_duration_seconds"])
+}
+
+// testCollectorCount returns the number of metrics currently exported by the collector.
+func testCollectorCount(collector prometheus.Collector) int {
+	channel := make(chan prometheus.Metric, 10)
+	collector.Collect(channel)
+	close(channel)
+	return len(channel)
+}
+
 func TestQueryClientRead(t *testing.T) {
 	response := &prompb.ReadResponse{Results: []*prompb.QueryResult{{}}}
 	request := &prompb.ReadRequest{
@@ -347,6 +507,125 @@ func TestQueryClientRead(t *testing.T) {
 		assert.IsType(t, &errors.MissingTableError{}, err)
 	})
 
+	t.Run("read merging the series of two databases with cross-database reads", func(t *testing.T) {
+		const otherDatabaseName = "otherDB"
+		otherQueryInput := &timestreamquery.QueryInput{
+			QueryString: aws.String(strings.Replace(*queryInput.QueryString, mockDatabaseName+".", otherDatabaseName+".", 1)),
+		}
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPages", queryInput, mock.AnythingOfType(functionType)).Run(func(args mock.Arguments) {
+			args.Get(1).(func(*timestreamquery.QueryOutput, bool) bool)(&timestreamquery.QueryOutput{
+				ColumnInfo: createColumnInfo(),
+				Rows:       []*timestreamquery.Row{{Data: createDatumWithInstance(true, instance, measureValueStr, metricName, timestamp1)}},
+			}, true)
+		}).Return(nil)
+		mockTimestreamQueryClient.On("QueryPages", otherQueryInput, mock.AnythingOfType(functionType)).Run(func(args mock.Arguments) {
+			args.Get(1).(func(*timestreamquery.QueryOutput, bool) bool)(&timestreamquery.QueryOutput{
+				ColumnInfo: createColumnInfo(),
+				Rows: []*timestreamquery.Row{
+					{Data: createDatumWithInstance(true, instance, measureValueStr, metricName, timestamp2)},
+					{Data: createDatumWithJob(true, job, measureValueStr, metricName, timestamp1)},
+				},
+			}, true)
+		}).Return(nil)
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
+
+		c := &Client{
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.readDatabases = []string{mockDatabaseName, otherDatabaseName}
+		c.queryClient.crossDatabaseReads = true
+
+		readResponse, err := c.queryClient.Read(request, mockCredentials)
+		assert.Nil(t, err)
+		mockTimestreamQueryClient.AssertExpectations(t)
+
+		// The series with the same labels in both databases are merged into one series.
+		samplesByLabels := make(map[string]int)
+		for _, timeSeries := range readResponse.Results[0].Timeseries {
+			for _, label := range timeSeries.Labels {
+				if label.Name != model.MetricNameLabel {
+					samplesByLabels[label.Name+"="+label.Value] = len(timeSeries.Samples)
+				}
+			}
+		}
+		assert.Equal(t, map[string]int{model.InstanceLabel + "=" + instance: 2, model.JobLabel + "=" + job: 1}, samplesByLabels)
+	})
+
+	t.Run("read databases ignored without cross-database reads", func(t *testing.T) {
+		c := &Client{
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.readDatabases = []string{"otherDB"}
+
+		assert.Equal(t, []readDestination{{database: mockDatabaseName, table: mockTableName}}, c.queryClient.destinations())
+	})
+
+	t.Run("attach the query ID exemplar to the read latency", func(t *testing.T) {
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPages", queryInput,
+			mock.AnythingOfType(functionType)).Run(func(args mock.Arguments) {
+			args.Get(1).(func(*timestreamquery.QueryOutput, bool) bool)(queryOutput, true)
+		}).Return(nil)
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
+
+		c := &Client{
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.readExecutionTime = prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test"})
+
+		_, err := c.queryClient.Read(request, mockCredentials)
+		assert.Nil(t, err)
+
+		metric := prometheusClientModel.Metric{}
+		assert.Nil(t, c.queryClient.readExecutionTime.Write(&metric))
+		var queryIDs []string
+		for _, bucket := range metric.GetHistogram().GetBucket() {
+			for _, label := range bucket.GetExemplar().GetLabel() {
+				queryIDs = append(queryIDs, label.GetValue())
+			}
+		}
+		assert.Equal(t, []string{"QueryID"}, queryIDs)
+	})
+
+	t.Run("error from a query result misaligned with the columns", func(t *testing.T) {
+		misalignedQueryOutput := &timestreamquery.QueryOutput{
+			ColumnInfo: createColumnInfo()[:3],
+			Rows: []*timestreamquery.Row{
+				{Data: createDatumWithInstance(true, instance, measureValueStr, metricName, timestamp1)},
+			},
+		}
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPages", queryInput,
+			mock.AnythingOfType(functionType)).Run(func(args mock.Arguments) {
+			args.Get(1).(func(*timestreamquery.QueryOutput, bool) bool)(misalignedQueryOutput, true)
+		}).Return(nil)
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
+
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+
+		readResponse, err := c.queryClient.Read(request, mockCredentials)
+		assert.NotNil(t, err)
+		assert.Nil(t, readResponse)
+	})
+
 	t.Run("error from QueryPages()", func(t *testing.T) {
 		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
 		serverError := &timestreamquery.InternalServerException{}
@@ -378,11 +657,96 @@ func TestQueryClientRead(t *testing.T) {
 		}
 		c.queryClient = createNewQueryClientTemplate(c)
 
-		queryResult, err := c.queryClient.convertToResult(&prompb.QueryResult{}, queryOutput)
+		queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, queryOutput)
 		assert.Nil(t, err)
 		assert.Equal(t, createExpectedQueryResult(), queryResult)
 	})
 
+	t.Run("success convert result with the raw columns as meta-labels", func(t *testing.T) {
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.readDebugColumns = true
+
+		debugQueryOutput := &timestreamquery.QueryOutput{
+			ColumnInfo: []*timestreamquery.ColumnInfo{
+				{Name: aws.String("instance")},
+				{Name: aws.String(timeColumnName)},
+				{Name: aws.String(measureNameColumnName)},
+				{Name: aws.String(measureValueColumnName)},
+				{Name: aws.String("label_time")},
+				{Name: aws.String("measure_value::bigint")},
+			},
+			Rows: []*timestreamquery.Row{{
+				Data: []*timestreamquery.Datum{
+					{ScalarValue: aws.String(instance)},
+					{ScalarValue: aws.String(timestamp1)},
+					{ScalarValue: aws.String(metricName)},
+					{ScalarValue: aws.String(measureValueStr)},
+					{ScalarValue: aws.String("1")},
+					{NullValue: aws.Bool(true)},
+				},
+			}},
+		}
+
+		queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, debugQueryOutput)
+		assert.Nil(t, err)
+		assert.Len(t, queryResult.Timeseries, 1)
+		assert.Equal(t, []*prompb.Label{
+			{Name: "instance", Value: instance},
+			{Name: model.MetricNameLabel, Value: metricName},
+			{Name: timeColumnName, Value: "1"},
+			{Name: debugColumnLabelPrefix + "instance", Value: instance},
+			{Name: debugColumnLabelPrefix + "label_time", Value: "1"},
+		}, queryResult.Timeseries[0].Labels)
+
+		// The columns without a valid label name are sanitized.
+		labels := debugColumnLabels(
+			[]*timestreamquery.Datum{{ScalarValue: aws.String("42")}},
+			[]*timestreamquery.ColumnInfo{{Name: aws.String("measure_value::bigint")}})
+		assert.Equal(t, []*prompb.Label{{Name: debugColumnLabelPrefix + "measure_value__bigint", Value: "42"}}, labels)
+	})
+
+	t.Run("success convert result with the measure value columns of other types", func(t *testing.T) {
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+
+		columnInfo := createColumnInfo()
+		columnInfo[2] = &timestreamquery.ColumnInfo{
+			Name: aws.String("measure_value::bigint"),
+			Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeBigint)},
+		}
+		queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, &timestreamquery.QueryOutput{
+			ColumnInfo: columnInfo,
+			Rows: []*timestreamquery.Row{
+				{Data: createDatumWithInstance(true, instance, "9007199254740993", metricName, timestamp1)},
+			},
+		})
+		assert.Nil(t, err)
+		assert.Len(t, queryResult.Timeseries, 1)
+		assert.Equal(t, float64(9007199254740993), queryResult.Timeseries[0].Samples[0].Value)
+
+		// The measure value columns of unsupported types fail the conversion.
+		columnInfo[2] = &timestreamquery.ColumnInfo{
+			Name: aws.String("measure_value::timestamp"),
+			Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeTimestamp)},
+		}
+		_, err = c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, &timestreamquery.QueryOutput{
+			ColumnInfo: columnInfo,
+			Rows: []*timestreamquery.Row{
+				{Data: createDatumWithInstance(true, instance, timestamp1, metricName, timestamp1)},
+			},
+		})
+		assert.NotNil(t, err)
+	})
+
 	t.Run("error from convertToResult with invalid measureValue", func(t *testing.T) {
 		c := &Client{
 			queryClient:     nil,
@@ -391,7 +755,7 @@ func TestQueryClientRead(t *testing.T) {
 		}
 		c.queryClient = createNewQueryClientTemplate(c)
 
-		queryResultWithInvalidValue, err := c.queryClient.convertToResult(&prompb.QueryResult{}, queryOutputWithInvalidMeasureValue)
+		queryResultWithInvalidValue, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, queryOutputWithInvalidMeasureValue)
 		assert.NotNil(t, err)
 		assert.NotNil(t, queryResultWithInvalidValue)
 		assert.Nil(t, queryResultWithInvalidValue.Timeseries)
@@ -405,13 +769,23 @@ func TestQueryClientRead(t *testing.T) {
 		}
 		c.queryClient = createNewQueryClientTemplate(c)
 
-		queryResultWithInvalidTime, err := c.queryClient.convertToResult(&prompb.QueryResult{}, queryOutputWithInvalidTime)
+		queryResultWithInvalidTime, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, queryOutputWithInvalidTime)
 		assert.NotNil(t, err)
 		assert.NotNil(t, queryResultWithInvalidTime)
 		assert.Nil(t, queryResultWithInvalidTime.Timeseries)
 	})
 
-	t.Run("convert result with empty queryOutput", func(t *testing.T) {
+	t.Run("convert result with non-finite measureValues", func(t *testing.T) {
+		nonFiniteQueryOutput := &timestreamquery.QueryOutput{
+			ColumnInfo: createColumnInfo(),
+			Rows: []*timestreamquery.Row{
+				{Data: createDatumWithInstance(true, instance, "NaN", metricName, timestamp1)},
+				{Data: createDatumWithInstance(true, instance, "-Inf", metricName, timestamp2)},
+				{Data: createDatumWithJob(true, job, "1e400", metricName, timestamp1)},
+				{Data: createDatumWithJob(true, job, measureValueStr, metricName, timestamp2)},
+			},
+		}
+
 		c := &Client{
 			writeClient:     nil,
 			defaultDataBase: mockDatabaseName,
@@ -419,13 +793,24 @@ func TestQueryClientRead(t *testing.T) {
 		}
 		c.queryClient = createNewQueryClientTemplate(c)
 
-		emptyQueryOutput := &timestreamquery.QueryOutput{}
-		queryResult, err := c.queryClient.convertToResult(&prompb.QueryResult{}, emptyQueryOutput)
+		c.queryClient.nonFiniteReads = PassNonFiniteReads
+		queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, nonFiniteQueryOutput)
 		assert.Nil(t, err)
-		assert.True(t, cmp.Equal(&prompb.QueryResult{}, queryResult))
+		assert.Len(t, queryResult.Timeseries, 2)
+		assert.True(t, math.IsNaN(queryResult.Timeseries[0].Samples[0].Value))
+		assert.True(t, math.IsInf(queryResult.Timeseries[0].Samples[1].Value, -1))
+		assert.True(t, math.IsInf(queryResult.Timeseries[1].Samples[0].Value, 1))
+		assert.Equal(t, measureValue, queryResult.Timeseries[1].Samples[1].Value)
+
+		c.queryClient.nonFiniteReads = SkipNonFiniteReads
+		queryResult, err = c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, nonFiniteQueryOutput)
+		assert.Nil(t, err)
+		expectedTimeSeries := createExpectedQueryResult().Timeseries[1]
+		expectedTimeSeries.Samples[0].Timestamp = unixTime2
+		assert.Equal(t, []*prompb.TimeSeries{expectedTimeSeries}, queryResult.Timeseries)
 	})
 
-	t.Run("success build command", func(t *testing.T) {
+	t.Run("convert result with empty queryOutput", func(t *testing.T) {
 		c := &Client{
 			writeClient:     nil,
 			defaultDataBase: mockDatabaseName,
@@ -433,12 +818,13 @@ func TestQueryClientRead(t *testing.T) {
 		}
 		c.queryClient = createNewQueryClientTemplate(c)
 
-		buildCommand, _, err := c.queryClient.buildCommands(queryWithMatcherTypes)
+		emptyQueryOutput := &timestreamquery.QueryOutput{}
+		queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, emptyQueryOutput)
 		assert.Nil(t, err)
-		assert.Equal(t, expectedBuildCommand, buildCommand)
+		assert.True(t, cmp.Equal(&prompb.QueryResult{}, queryResult))
 	})
 
-	t.Run("error from buildCommand with unknown matcher type", func(t *testing.T) {
+	t.Run("error from convertToResult with a row misaligned with the columns", func(t *testing.T) {
 		c := &Client{
 			writeClient:     nil,
 			defaultDataBase: mockDatabaseName,
@@ -446,22 +832,18 @@ func TestQueryClientRead(t *testing.T) {
 		}
 		c.queryClient = createNewQueryClientTemplate(c)
 
-		_, err := c.queryClient.Read(requestWithInvalidMatcher, mockCredentials)
-		assert.IsType(t, &errors.UnknownMatcherError{}, err)
-	})
-
-	t.Run("error from queryPages with invalid regex", func(t *testing.T) {
-		validationError := &timestreamquery.ValidationException{
-			RespMetadata: protocol.ResponseMetadata{StatusCode: 400},
-		}
-		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
-		mockTimestreamQueryClient.On("QueryPages", queryInputWithInvalidRegex,
-			mock.AnythingOfType(functionType)).Return(validationError)
-
-		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
-			return mockTimestreamQueryClient, nil
+		misalignedQueryOutput := &timestreamquery.QueryOutput{
+			ColumnInfo: createColumnInfo()[:3],
+			Rows: []*timestreamquery.Row{
+				{Data: createDatumWithInstance(true, instance, measureValueStr, metricName, timestamp2)},
+			},
 		}
+		queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, misalignedQueryOutput)
+		assert.NotNil(t, err)
+		assert.Empty(t, queryResult.Timeseries)
+	})
 
+	t.Run("success build command", func(t *testing.T) {
 		c := &Client{
 			writeClient:     nil,
 			defaultDataBase: mockDatabaseName,
@@ -469,323 +851,3800 @@ func TestQueryClientRead(t *testing.T) {
 		}
 		c.queryClient = createNewQueryClientTemplate(c)
 
-		_, err := c.queryClient.Read(requestWithInvalidRegex, mockCredentials)
-		assert.Equal(t, validationError, err)
-
-		mockTimestreamQueryClient.AssertExpectations(t)
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, queryWithMatcherTypes)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedBuildCommand, buildCommand)
 	})
-}
-
-func TestWriteClientWrite(t *testing.T) {
-	t.Run("success", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
-		expectedInput := createNewWriteRecordsInputTemplate()
-		mockTimestreamWriteClient.On(
-			"WriteRecords",
-			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
-				// Sort the records in the WriteRecordsInput by their time, and sort the Dimension by dimension names.
-				sortRecords(writeInput)
-				sortRecords(expectedInput)
-
-				return reflect.DeepEqual(writeInput, expectedInput)
-			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
-
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
-		}
 
+	t.Run("build command escaping the single quotes of the matcher values", func(t *testing.T) {
 		c := &Client{
-			queryClient:     nil,
+			writeClient:     nil,
 			defaultDataBase: mockDatabaseName,
 			defaultTable:    mockTableName,
 		}
-		c.writeClient = createNewWriteClientTemplate(c)
+		c.queryClient = createNewQueryClientTemplate(c)
 
-		err := c.writeClient.Write(createNewRequestTemplate(), mockCredentials)
+		queries := []*prompb.Query{
+			{
+				Matchers: []*prompb.LabelMatcher{
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.InstanceLabel, "it's-host"),
+					createLabelMatcher(prompb.LabelMatcher_NEQ, model.JobLabel, "x' OR '1'='1"),
+					createLabelMatcher(prompb.LabelMatcher_RE, "path", `C:\\temp\\.*'`),
+					createLabelMatcher(prompb.LabelMatcher_NRE, "query", "100%' --"),
+				},
+				Hints: createReadHints(),
+			},
+		}
+		timeFilter := fmt.Sprintf("%s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)", timeColumnName, startUnixInSeconds, endUnixInSeconds)
+
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, queries)
 		assert.Nil(t, err)
+		assert.Equal(t, []*timestreamquery.QueryInput{
+			{QueryString: aws.String(fmt.Sprintf(`SELECT * FROM %s.%s WHERE %s = '%s' AND instance = 'it''s-host' AND job != 'x'' OR ''1''=''1' AND REGEXP_LIKE(path, 'C:\\temp\\.*''') AND NOT REGEXP_LIKE(query, '100%%'' --') AND %s`,
+				mockDatabaseName, mockTableName, measureNameColumnName, metricName, timeFilter))},
+		}, buildCommand)
 
-		mockTimestreamWriteClient.AssertExpectations(t)
+		c.queryClient.caseInsensitive = true
+		buildCommand, _, err = c.queryClient.buildCommands(mockLogger, queries[:1])
+		assert.Nil(t, err)
+		assert.Contains(t, aws.StringValue(buildCommand[0].QueryString), "LOWER(instance) = LOWER('it''s-host')")
 	})
 
-	t.Run("success writing one timeSeries with more than one sample", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
-
-		expectedInput := createNewWriteRecordsInputTemplate()
-		expectedInput.Records = append(expectedInput.Records, createNewRecordTemplate())
-
-		mockTimestreamWriteClient.On(
-			"WriteRecords",
-			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
-				// Sort the records in the WriteRecordsInput by their time, and sort the Dimension by dimension names.
-				sortRecords(writeInput)
-				sortRecords(expectedInput)
-
-				return reflect.DeepEqual(writeInput, expectedInput)
-			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	t.Run("error from buildCommands with a matcher on an invalid label name", func(t *testing.T) {
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
 
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
+		queries := []*prompb.Query{
+			{
+				Matchers: []*prompb.LabelMatcher{
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+					createLabelMatcher(prompb.LabelMatcher_EQ, "1=1 OR instance", "host"),
+				},
+				Hints: createReadHints(),
+			},
 		}
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, queries)
+		assert.Equal(t, errors.NewInvalidMatcherNameError("1=1 OR instance"), err)
+		assert.Nil(t, buildCommand)
+	})
 
+	t.Run("build command combining queries only differing by matchers", func(t *testing.T) {
 		c := &Client{
-			queryClient:     nil,
+			writeClient:     nil,
 			defaultDataBase: mockDatabaseName,
 			defaultTable:    mockTableName,
 		}
-		c.writeClient = createNewWriteClientTemplate(c)
+		c.queryClient = createNewQueryClientTemplate(c)
 
-		req := createNewRequestTemplate()
-		req.Timeseries[0].Samples = append(req.Timeseries[0].Samples, prompb.Sample{
-			Timestamp: mockUnixTime,
-			Value:     measureValue,
-		})
+		queries := []*prompb.Query{
+			{
+				Matchers: []*prompb.LabelMatcher{
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.JobLabel, job),
+				},
+				Hints: createReadHints(),
+			},
+			{
+				Matchers: []*prompb.LabelMatcher{
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.InstanceLabel, instance),
+				},
+				Hints: createReadHints(),
+			},
+		}
+		timeFilter := fmt.Sprintf("%s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)", timeColumnName, startUnixInSeconds, endUnixInSeconds)
 
-		err := c.writeClient.Write(req, mockCredentials)
+		// The queries are built separately by default.
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, queries)
 		assert.Nil(t, err)
+		assert.Equal(t, []*timestreamquery.QueryInput{
+			{QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %s = '%s' AND job = '%s' AND %s", mockDatabaseName, mockTableName, measureNameColumnName, metricName, job, timeFilter))},
+			{QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %s = '%s' AND instance = '%s' AND %s", mockDatabaseName, mockTableName, measureNameColumnName, metricName, instance, timeFilter))},
+		}, buildCommand)
 
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
-		mockTimestreamWriteClient.AssertExpectations(t)
-	})
+		c.queryClient.combineReadQueries = true
+		buildCommand, _, err = c.queryClient.buildCommands(mockLogger, queries)
+		assert.Nil(t, err)
+		assert.Equal(t, []*timestreamquery.QueryInput{
+			{QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE ((%s = '%s' AND job = '%s') OR (%s = '%s' AND instance = '%s')) AND %s",
+				mockDatabaseName, mockTableName, measureNameColumnName, metricName, job, measureNameColumnName, metricName, instance, timeFilter))},
+		}, buildCommand)
 
-	t.Run("success writing one timeSeries with more than one sample without mapping", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		// A query without matchers matches every row of the time range.
+		buildCommand, _, err = c.queryClient.buildCommands(mockLogger, append(queries, &prompb.Query{Hints: createReadHints()}))
+		assert.Nil(t, err)
+		assert.Equal(t, []*timestreamquery.QueryInput{
+			{QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %s", mockDatabaseName, mockTableName, timeFilter))},
+		}, buildCommand)
 
-		expectedInput := createNewWriteRecordsInputTemplate()
-		expectedInput.Records = append(expectedInput.Records, createNewRecordTemplate())
+		// Queries with different time ranges are not combined.
+		otherRange := &prompb.Query{Matchers: queries[1].Matchers, Hints: &prompb.ReadHints{StartMs: mockUnixTime - 60000, EndMs: mockEndUnixTime}}
+		buildCommand, _, err = c.queryClient.buildCommands(mockLogger, []*prompb.Query{queries[0], otherRange})
+		assert.Nil(t, err)
+		assert.Len(t, buildCommand, 2)
+	})
 
-		mockTimestreamWriteClient.On(
-			"WriteRecords",
-			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
-				// Sort the records in the WriteRecordsInput by their time, and sort the Dimension by dimension names.
-				sortRecords(writeInput)
-				sortRecords(expectedInput)
+	t.Run("read the +Inf histogram bucket with the configured le value", func(t *testing.T) {
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.infBucketValue = "inf"
 
-				return reflect.DeepEqual(writeInput, expectedInput)
-			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		bucketQueries := []*prompb.Query{
+			{
+				Matchers: []*prompb.LabelMatcher{
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.BucketLabel, "+Inf"),
+				},
+				Hints: createReadHints(),
+			},
+		}
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, bucketQueries)
+		assert.Nil(t, err)
+		assert.Equal(t, []*timestreamquery.QueryInput{
+			{QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %s = '%s' AND le = 'inf' AND %s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)",
+				mockDatabaseName, mockTableName, measureNameColumnName, metricName, timeColumnName, startUnixInSeconds, endUnixInSeconds))},
+		}, buildCommand)
 
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
+		bucketQueries[0].Matchers[1] = createLabelMatcher(prompb.LabelMatcher_NEQ, model.BucketLabel, "Inf")
+		buildCommand, _, err = c.queryClient.buildCommands(mockLogger, bucketQueries)
+		assert.Nil(t, err)
+		assert.Contains(t, *buildCommand[0].QueryString, "le != 'inf'")
+
+		bucketOutput := &timestreamquery.QueryOutput{
+			ColumnInfo: append([]*timestreamquery.ColumnInfo{{
+				Name: aws.String(model.BucketLabel),
+				Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeVarchar)},
+			}}, createColumnInfo()[2:]...),
+			Rows: []*timestreamquery.Row{
+				{Data: []*timestreamquery.Datum{{ScalarValue: aws.String("inf")}, {ScalarValue: aws.String(measureValueStr)}, {ScalarValue: aws.String(metricName)}, {ScalarValue: aws.String(timestamp1)}}},
+				{Data: []*timestreamquery.Datum{{ScalarValue: aws.String("0.5")}, {ScalarValue: aws.String(measureValueStr)}, {ScalarValue: aws.String(metricName)}, {ScalarValue: aws.String(timestamp1)}}},
+			},
 		}
+		queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, bucketOutput)
+		assert.Nil(t, err)
+		assert.Len(t, queryResult.Timeseries, 2)
+		assert.Equal(t, &prompb.Label{Name: model.BucketLabel, Value: "+Inf"}, queryResult.Timeseries[0].Labels[0])
+		assert.Equal(t, &prompb.Label{Name: model.BucketLabel, Value: "0.5"}, queryResult.Timeseries[1].Labels[0])
+	})
 
+	t.Run("build command for a query without matchers", func(t *testing.T) {
 		c := &Client{
-			queryClient:     nil,
+			writeClient:     nil,
 			defaultDataBase: mockDatabaseName,
 			defaultTable:    mockTableName,
 		}
-		c.writeClient = createNewWriteClientTemplate(c)
+		c.queryClient = createNewQueryClientTemplate(c)
 
-		reqWithoutMapping := createNewRequestTemplateWithoutMapping()
-		reqWithoutMapping.Timeseries[0].Samples = append(reqWithoutMapping.Timeseries[0].Samples, prompb.Sample{
-			Timestamp: mockUnixTime,
-			Value:     measureValue,
-		})
+		matcherlessQueries := []*prompb.Query{{Hints: createReadHints()}}
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, matcherlessQueries)
+		assert.Nil(t, err)
+		assert.Equal(t, []*timestreamquery.QueryInput{
+			{QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)", mockDatabaseName, mockTableName, timeColumnName, startUnixInSeconds, endUnixInSeconds))},
+		}, buildCommand)
 
-		errWm := c.writeClient.Write(reqWithoutMapping, mockCredentials)
-		assert.Nil(t, errWm)
+		c.queryClient.requireMatcher = true
+		buildCommand, _, err = c.queryClient.buildCommands(mockLogger, matcherlessQueries)
+		assert.IsType(t, &errors.MissingMatcherError{}, err)
+		assert.Nil(t, buildCommand)
 
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
-		mockTimestreamWriteClient.AssertExpectations(t)
+		// Queries with any label matcher are still allowed.
+		buildCommand, _, err = c.queryClient.buildCommands(mockLogger, queryWithMatcherTypes)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedBuildCommand, buildCommand)
 	})
 
-	t.Run("success writing samples to the same destination", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
-
-		expectedInput := createNewWriteRecordsInputTemplate()
-		expectedInput.Records = append(expectedInput.Records, createNewRecordTemplate())
-
-		mockTimestreamWriteClient.On(
-			"WriteRecords",
-			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
-				sortRecords(writeInput)
-				sortRecords(expectedInput)
-				return reflect.DeepEqual(writeInput, expectedInput)
-			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	t.Run("read combining queries only differing by matchers", func(t *testing.T) {
+		combinedInput := &timestreamquery.QueryInput{
+			QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE ((%s = '%s' AND instance = '%s') OR (%s = '%s' AND job = '%s')) AND %s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)",
+				mockDatabaseName, mockTableName, measureNameColumnName, metricName, instance, measureNameColumnName, metricName, job, timeColumnName, startUnixInSeconds, endUnixInSeconds)),
+		}
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPages", combinedInput, mock.AnythingOfType(functionType)).
+			Run(func(args mock.Arguments) {
+				// The rows matched by either query are returned by the combined query once.
+				args.Get(1).(func(*timestreamquery.QueryOutput, bool) bool)(queryOutput, true)
+			}).Return(nil)
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
 
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.combineReadQueries = true
+
+		readRequest := &prompb.ReadRequest{
+			Queries: []*prompb.Query{
+				{
+					Matchers: []*prompb.LabelMatcher{
+						createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+						createLabelMatcher(prompb.LabelMatcher_EQ, model.InstanceLabel, instance),
+					},
+					Hints: createReadHints(),
+				},
+				{
+					Matchers: []*prompb.LabelMatcher{
+						createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+						createLabelMatcher(prompb.LabelMatcher_EQ, model.JobLabel, job),
+					},
+					Hints: createReadHints(),
+				},
+			},
 		}
+		readResponse, err := c.queryClient.Read(readRequest, mockCredentials)
+		assert.Nil(t, err)
+		assert.Equal(t, &prompb.ReadResponse{Results: []*prompb.QueryResult{createExpectedQueryResult()}}, readResponse)
+		mockTimestreamQueryClient.AssertNumberOfCalls(t, "QueryPages", 1)
+	})
 
+	t.Run("error from buildCommands with query exceeding the max read range", func(t *testing.T) {
 		c := &Client{
-			queryClient:     nil,
+			writeClient:     nil,
 			defaultDataBase: mockDatabaseName,
 			defaultTable:    mockTableName,
 		}
-		c.writeClient = createNewWriteClientTemplate(c)
-		req := createNewRequestTemplate()
-		req.Timeseries = append(req.Timeseries, createTimeSeriesTemplate())
+		c.queryClient = createNewQueryClientTemplate(c)
 
-		err := c.writeClient.Write(req, mockCredentials)
+		c.queryClient.maxReadRange = 30 * time.Second
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, queryWithMatcherTypes)
 		assert.Nil(t, err)
+		assert.Equal(t, expectedBuildCommand, buildCommand)
 
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
-		mockTimestreamWriteClient.AssertExpectations(t)
+		c.queryClient.maxReadRange = 29 * time.Second
+		buildCommand, _, err = c.queryClient.buildCommands(mockLogger, queryWithMatcherTypes)
+		assert.IsType(t, &errors.MaxReadRangeError{}, err)
+		assert.Nil(t, buildCommand)
 	})
 
-	t.Run("missing database name in write series", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
-
-		expectedInput := createNewWriteRecordsInputTemplate()
-		expectedInput.Records = append(expectedInput.Records, createNewRecordTemplate())
+	t.Run("build command with zero time range applying the default lookback", func(t *testing.T) {
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.defaultLookback = time.Hour
 
-		mockTimestreamWriteClient.On(
-			"WriteRecords",
-			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
-				sortRecords(writeInput)
-				sortRecords(expectedInput)
-				return reflect.DeepEqual(writeInput, expectedInput)
-			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		oldTimeNow := timeNow
+		defer func() { timeNow = oldTimeNow }()
+		timeNow = func() time.Time { return time.Unix(0, mockEndUnixTime*nanosToMillisConversionRate) }
 
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
+		zeroRangeQueries := []*prompb.Query{
+			{
+				Matchers: []*prompb.LabelMatcher{
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+				},
+			},
 		}
+		lookbackStartInSeconds := (mockEndUnixTime - time.Hour.Milliseconds()) / millisToSecConversionRate
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, zeroRangeQueries)
+		assert.Nil(t, err)
+		assert.Equal(t, []*timestreamquery.QueryInput{
+			{
+				QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %s = '%s' AND %s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)",
+					mockDatabaseName, mockTableName, measureNameColumnName, metricName, timeColumnName, lookbackStartInSeconds, endUnixInSeconds)),
+			},
+		}, buildCommand)
 
+		// Queries with a time range are not affected by the default lookback.
+		buildCommand, _, err = c.queryClient.buildCommands(mockLogger, queryWithMatcherTypes)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedBuildCommand, buildCommand)
+	})
+
+	t.Run("error from buildCommands with default lookback exceeding the max read range", func(t *testing.T) {
 		c := &Client{
-			queryClient:   nil,
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
 		}
-		c.writeClient = createNewWriteClientTemplate(c)
-		req := createNewRequestTemplate()
-		req.Timeseries = append(req.Timeseries, createTimeSeriesTemplate())
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.defaultLookback = 24 * time.Hour
+		c.queryClient.maxReadRange = time.Hour
 
-		err := c.writeClient.Write(req, mockCredentials)
-		expectedErr := errors.NewMissingDatabaseWithWriteError("", createTimeSeriesTemplate())
-		assert.Equal(t, err, expectedErr)
+		zeroRangeQueries := []*prompb.Query{
+			{
+				Matchers: []*prompb.LabelMatcher{
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+				},
+			},
+		}
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, zeroRangeQueries)
+		assert.IsType(t, &errors.MaxReadRangeError{}, err)
+		assert.Nil(t, buildCommand)
 	})
 
-	t.Run("missing table name in write series", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
-
-		expectedInput := createNewWriteRecordsInputTemplate()
-		expectedInput.Records = append(expectedInput.Records, createNewRecordTemplate())
-
-		mockTimestreamWriteClient.On(
-			"WriteRecords",
-			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
-				sortRecords(writeInput)
-				sortRecords(expectedInput)
-				return reflect.DeepEqual(writeInput, expectedInput)
-			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
-
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
+	t.Run("build command logging the generated queries at debug level", func(t *testing.T) {
+		for _, test := range []struct {
+			option       level.Option
+			expectLogged bool
+		}{{level.AllowDebug(), true}, {level.AllowInfo(), false}} {
+			var logs bytes.Buffer
+			c := &Client{
+				writeClient:     nil,
+				defaultDataBase: mockDatabaseName,
+				defaultTable:    mockTableName,
+			}
+			c.queryClient = createNewQueryClientTemplate(c)
+			logger := level.NewFilter(log.NewLogfmtLogger(&logs), test.option)
+
+			buildCommand, _, err := c.queryClient.buildCommands(logger, queryWithMatcherTypes)
+			assert.Nil(t, err)
+			assert.Equal(t, expectedBuildCommand, buildCommand)
+
+			// The logfmt logger quotes the query as it contains spaces.
+			assert.Equal(t, test.expectLogged, strings.Contains(logs.String(), strconv.Quote(*expectedBuildCommand[0].QueryString)))
 		}
+	})
 
+	t.Run("build command with multiple read tables", func(t *testing.T) {
 		c := &Client{
-			queryClient:   nil,
+			writeClient:     nil,
 			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
 		}
-		c.writeClient = createNewWriteClientTemplate(c)
-		req := createNewRequestTemplate()
-		req.Timeseries = append(req.Timeseries, createTimeSeriesTemplate())
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.readTables = []string{mockTableName, "otherTable"}
 
-		err := c.writeClient.Write(req, mockCredentials)
-		expectedErr := errors.NewMissingTableWithWriteError("", createTimeSeriesTemplate())
-		assert.Equal(t, err, expectedErr)
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, queryWithMatcherTypes)
+		assert.Nil(t, err)
+		assert.Equal(t, []*timestreamquery.QueryInput{
+			expectedBuildCommand[0],
+			{
+				QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %s = '%s' AND quantile != '%s' AND REGEXP_LIKE(job, '%s') AND NOT REGEXP_LIKE(instance, '%s') AND %s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)",
+					mockDatabaseName, "otherTable", measureNameColumnName, metricName, quantile, jobRegex, instanceRegex, timeColumnName, startUnixInSeconds, endUnixInSeconds)),
+			},
+		}, buildCommand)
 	})
 
-	t.Run("error from convertToRecords due to missing ingestion database destination", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
-
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
-		}
-
+	t.Run("build command with case-insensitive matchers", func(t *testing.T) {
 		c := &Client{
-			queryClient:   nil,
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
 		}
-		c.writeClient = createNewWriteClientTemplate(c)
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.caseInsensitive = true
 
-		input := createNewRequestTemplate()
-		input.Timeseries[0].Labels = []*prompb.Label{
-			{
-				Name:  model.MetricNameLabel,
-				Value: metricName,
-			},
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, queryWithMatcherTypes)
+		assert.Nil(t, err)
+		assert.Equal(t, []*timestreamquery.QueryInput{
 			{
-				Name:  "label_1",
-				Value: "value_1",
+				QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE LOWER(%s) = LOWER('%s') AND LOWER(quantile) != LOWER('%s') AND REGEXP_LIKE(job, '%s') AND NOT REGEXP_LIKE(instance, '%s') AND %s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)",
+					mockDatabaseName, mockTableName, measureNameColumnName, metricName, quantile, jobRegex, instanceRegex, timeColumnName, startUnixInSeconds, endUnixInSeconds)),
 			},
-		}
-
-		err := c.WriteClient().Write(input, mockCredentials)
-		assert.IsType(t, &errors.MissingDatabaseWithWriteError{}, err)
-
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+		}, buildCommand)
 	})
 
-	t.Run("error from convertToRecords due to missing ingestion table destination", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
-
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
+	t.Run("build command with the read page size", func(t *testing.T) {
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
 		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.readPageSize = 500
+
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, queryWithMatcherTypes)
+		assert.Nil(t, err)
+		assert.Len(t, buildCommand, 1)
+		assert.Equal(t, expectedBuildCommand[0].QueryString, buildCommand[0].QueryString)
+		assert.Equal(t, aws.Int64(500), buildCommand[0].MaxRows)
+	})
 
+	t.Run("build command preferring recent data", func(t *testing.T) {
 		c := &Client{
-			queryClient:   nil,
+			writeClient:     nil,
 			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
 		}
-		c.writeClient = createNewWriteClientTemplate(c)
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.memoryStoreRetention = time.Hour
 
-		input := createNewRequestTemplate()
-		input.Timeseries[0].Labels = []*prompb.Label{
+		oldTimeNow := timeNow
+		defer func() { timeNow = oldTimeNow }()
+		memoryStoreStartInSeconds := startUnixInSeconds + 10
+		timeNow = func() time.Time { return time.Unix(memoryStoreStartInSeconds, 0).Add(time.Hour) }
+
+		// Without prefer-recent, the query is not split.
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, queryWithMatcherTypes)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedBuildCommand, buildCommand)
+
+		c.queryClient.preferRecent = true
+		buildCommand, _, err = c.queryClient.buildCommands(mockLogger, queryWithMatcherTypes)
+		assert.Nil(t, err)
+		assert.Equal(t, []*timestreamquery.QueryInput{
 			{
-				Name:  model.MetricNameLabel,
-				Value: metricName,
+				QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %s = '%s' AND quantile != '%s' AND REGEXP_LIKE(job, '%s') AND NOT REGEXP_LIKE(instance, '%s') AND %s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)",
+					mockDatabaseName, mockTableName, measureNameColumnName, metricName, quantile, jobRegex, instanceRegex, timeColumnName, memoryStoreStartInSeconds, endUnixInSeconds)),
 			},
 			{
-				Name:  "label_1",
-				Value: "value_1",
+				QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %s = '%s' AND quantile != '%s' AND REGEXP_LIKE(job, '%s') AND NOT REGEXP_LIKE(instance, '%s') AND %s >= FROM_UNIXTIME(%d) AND %s < FROM_UNIXTIME(%d)",
+					mockDatabaseName, mockTableName, measureNameColumnName, metricName, quantile, jobRegex, instanceRegex, timeColumnName, startUnixInSeconds, timeColumnName, memoryStoreStartInSeconds)),
 			},
-		}
+		}, buildCommand)
 
-		err := c.WriteClient().Write(input, mockCredentials)
-		assert.IsType(t, &errors.MissingTableWithWriteError{}, err)
+		// Queries within the memory store retention are not split.
+		timeNow = func() time.Time { return time.Unix(startUnixInSeconds, 0).Add(time.Hour) }
+		buildCommand, _, err = c.queryClient.buildCommands(mockLogger, queryWithMatcherTypes)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedBuildCommand, buildCommand)
+	})
+
+	t.Run("merge results from tables with different schemas", func(t *testing.T) {
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+
+		firstTableOutput := &timestreamquery.QueryOutput{
+			ColumnInfo: createColumnInfo(),
+			Rows: []*timestreamquery.Row{
+				{Data: createDatumWithInstance(true, instance, measureValueStr, metricName, timestamp2)},
+			},
+		}
+
+		// The second table only has the instance dimension, and returns its columns in a different order.
+		columnInfo := createColumnInfo()
+		secondTableOutput := &timestreamquery.QueryOutput{
+			ColumnInfo: []*timestreamquery.ColumnInfo{columnInfo[3], columnInfo[2], columnInfo[4], columnInfo[0]},
+			Rows: []*timestreamquery.Row{
+				{
+					Data: []*timestreamquery.Datum{
+						{ScalarValue: aws.String(metricName)},
+						{ScalarValue: aws.String(measureValueStr)},
+						{ScalarValue: aws.String(timestamp1)},
+						{ScalarValue: aws.String(instance)},
+					},
+				},
+			},
+		}
+
+		queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, firstTableOutput)
+		assert.Nil(t, err)
+		queryResult, err = c.queryClient.convertToResult(mockLogger, queryResult, secondTableOutput)
+		assert.Nil(t, err)
+		sortSamples(queryResult)
+
+		expectedTimeSeries := createExpectedQueryResult().Timeseries[0]
+		assert.Equal(t, &prompb.QueryResult{Timeseries: []*prompb.TimeSeries{expectedTimeSeries}}, queryResult)
+	})
+
+	t.Run("build command with dimension-only matchers", func(t *testing.T) {
+		dimensionOnlyQueries := []*prompb.Query{
+			{
+				StartTimestampMs: mockUnixTime,
+				EndTimestampMs:   mockEndUnixTime,
+				Matchers: []*prompb.LabelMatcher{
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.JobLabel, job),
+				},
+				Hints: createReadHints(),
+			},
+		}
+		expectedDimensionOnlyCommand := []*timestreamquery.QueryInput{
+			{
+				QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE job = '%s' AND %s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)",
+					mockDatabaseName, mockTableName, job, timeColumnName, startUnixInSeconds, endUnixInSeconds)),
+			},
+		}
+
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+
+		c.queryClient.dimensionOnlyReads = AllowDimensionOnlyReads
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, dimensionOnlyQueries)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedDimensionOnlyCommand, buildCommand)
+
+		c.queryClient.dimensionOnlyReads = EmptyDimensionOnlyReads
+		buildCommand, _, err = c.queryClient.buildCommands(mockLogger, dimensionOnlyQueries)
+		assert.Nil(t, err)
+		assert.Empty(t, buildCommand)
+
+		c.queryClient.dimensionOnlyReads = RejectDimensionOnlyReads
+		_, _, err = c.queryClient.buildCommands(mockLogger, dimensionOnlyQueries)
+		assert.IsType(t, &errors.DimensionOnlyReadError{}, err)
+
+		buildCommand, _, err = c.queryClient.buildCommands(mockLogger, queryWithMatcherTypes)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedBuildCommand, buildCommand)
+	})
+
+	t.Run("empty result for dimension-only read", func(t *testing.T) {
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
+
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.dimensionOnlyReads = EmptyDimensionOnlyReads
+
+		dimensionOnlyRequest := &prompb.ReadRequest{
+			Queries: []*prompb.Query{
+				{
+					StartTimestampMs: mockUnixTime,
+					EndTimestampMs:   mockEndUnixTime,
+					Matchers: []*prompb.LabelMatcher{
+						createLabelMatcher(prompb.LabelMatcher_EQ, "label_DNE", "value"),
+					},
+					Hints: createReadHints(),
+				},
+			},
+		}
+
+		readResponse, err := c.queryClient.Read(dimensionOnlyRequest, mockCredentials)
+		assert.Nil(t, err)
+		assert.Equal(t, response, readResponse)
+
+		mockTimestreamQueryClient.AssertNumberOfCalls(t, "QueryPages", 0)
+	})
+
+	t.Run("success with magnetic read timeout for old time range", func(t *testing.T) {
+		oldEndTime := mockUnixTime - int64(48*time.Hour/time.Millisecond)
+		oldRequest := &prompb.ReadRequest{
+			Queries: []*prompb.Query{
+				{
+					StartTimestampMs: oldEndTime - 30000,
+					EndTimestampMs:   oldEndTime,
+					Matchers: []*prompb.LabelMatcher{
+						createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+					},
+					Hints: &prompb.ReadHints{StartMs: oldEndTime - 30000, EndMs: oldEndTime},
+				},
+			},
+		}
+
+		begin := time.Now()
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPagesWithContext",
+			mock.MatchedBy(func(ctx aws.Context) bool {
+				deadline, ok := ctx.Deadline()
+				return ok && !deadline.Before(begin.Add(time.Minute)) && !deadline.After(time.Now().Add(time.Minute))
+			}),
+			mock.AnythingOfType("*timestreamquery.QueryInput"),
+			mock.AnythingOfType(functionType)).Return(nil)
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
+
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.memoryStoreRetention = 24 * time.Hour
+		c.queryClient.magneticReadTimeout = time.Minute
+
+		readResponse, err := c.queryClient.Read(oldRequest, mockCredentials)
+		assert.Nil(t, err)
+		assert.Equal(t, response, readResponse)
+
+		mockTimestreamQueryClient.AssertExpectations(t)
+		mockTimestreamQueryClient.AssertNumberOfCalls(t, "QueryPages", 0)
+	})
+
+	t.Run("magnetic-only detection follows the time range of the hints", func(t *testing.T) {
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.memoryStoreRetention = 24 * time.Hour
+
+		oldEndTime := mockUnixTime - int64(48*time.Hour/time.Millisecond)
+		queries := []*prompb.Query{
+			{
+				StartTimestampMs: oldEndTime - 30000,
+				EndTimestampMs:   oldEndTime,
+				Hints:            createReadHints(),
+			},
+		}
+		assert.False(t, c.queryClient.isMagneticOnly(mockLogger, queries))
+
+		queries[0].Hints = nil
+		assert.True(t, c.queryClient.isMagneticOnly(mockLogger, queries))
+
+		oldTimeNow := timeNow
+		defer func() { timeNow = oldTimeNow }()
+		timeNow = func() time.Time { return time.Unix(0, oldEndTime*nanosToMillisConversionRate) }
+		assert.False(t, c.queryClient.isMagneticOnly(mockLogger, queries))
+	})
+
+	t.Run("success without magnetic read timeout for recent time range", func(t *testing.T) {
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPages", queryInput,
+			mock.AnythingOfType(functionType)).Return(nil)
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
+
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.memoryStoreRetention = 24 * time.Hour
+		c.queryClient.magneticReadTimeout = time.Minute
+
+		readResponse, err := c.queryClient.Read(request, mockCredentials)
+		assert.Nil(t, err)
+		assert.Equal(t, response, readResponse)
+
+		mockTimestreamQueryClient.AssertExpectations(t)
+		mockTimestreamQueryClient.AssertNumberOfCalls(t, "QueryPagesWithContext", 0)
+	})
+
+	t.Run("read with context stops paginating once the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		canceledErr := awserr.New("RequestCanceled", "request context canceled", context.Canceled)
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPagesWithContext", ctx, queryInput, mock.AnythingOfType(functionType)).
+			Run(func(args mock.Arguments) {
+				// The first page is read before the context is cancelled.
+				if args.Get(2).(func(*timestreamquery.QueryOutput, bool) bool)(queryOutput, false) {
+					cancel()
+				}
+			}).Return(canceledErr)
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
+
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+
+		readResponse, err := c.queryClient.ReadWithContext(ctx, request, mockCredentials)
+		assert.Nil(t, readResponse)
+		assert.Equal(t, canceledErr, err)
+		assert.Equal(t, context.Canceled, ctx.Err())
+
+		mockTimestreamQueryClient.AssertExpectations(t)
+		mockTimestreamQueryClient.AssertNumberOfCalls(t, "QueryPages", 0)
+	})
+
+	t.Run("read returning the partial results of a failed page", func(t *testing.T) {
+		pageErr := awserr.NewRequestFailure(awserr.New("InternalServerException", "", nil), http.StatusInternalServerError, "")
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPages", queryInput, mock.AnythingOfType(functionType)).
+			Run(func(args mock.Arguments) {
+				// The first page is converted before the second page fails.
+				args.Get(1).(func(*timestreamquery.QueryOutput, bool) bool)(queryOutput, false)
+			}).Return(pageErr)
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
+
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+
+		readResponse, err := c.queryClient.Read(request, mockCredentials)
+		assert.Equal(t, pageErr, err)
+		assert.Nil(t, readResponse)
+
+		c.queryClient.returnPartialReads = true
+		c.queryClient.partialReads = prometheus.NewCounter(prometheus.CounterOpts{Name: "partial_reads"})
+		readResponse, err = c.queryClient.Read(request, mockCredentials)
+		assert.Nil(t, err)
+		assert.Equal(t, &prompb.ReadResponse{Results: []*prompb.QueryResult{createExpectedQueryResult()}}, readResponse)
+		assert.Equal(t, 1, getCounterValue(c.queryClient.partialReads))
+
+		// A read failing before any time series is converted still fails.
+		mockTimestreamQueryClient.ExpectedCalls = nil
+		mockTimestreamQueryClient.On("QueryPages", queryInput, mock.AnythingOfType(functionType)).Return(pageErr)
+		readResponse, err = c.queryClient.Read(request, mockCredentials)
+		assert.Equal(t, pageErr, err)
+		assert.Nil(t, readResponse)
+		assert.Equal(t, 1, getCounterValue(c.queryClient.partialReads))
+	})
+
+	t.Run("multi-page read exceeding the read total deadline", func(t *testing.T) {
+		canceledErr := awserr.New("RequestCanceled", "request context canceled", context.DeadlineExceeded)
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPagesWithContext", mock.Anything, queryInput, mock.AnythingOfType(functionType)).
+			Run(func(args mock.Arguments) {
+				// Every page is fast, but the pagination as a whole outlasts the deadline.
+				ctx := args.Get(0).(aws.Context)
+				fn := args.Get(2).(func(*timestreamquery.QueryOutput, bool) bool)
+				for fn(queryOutput, false) {
+					if !sleepWithContext(ctx, 10*time.Millisecond) {
+						return
+					}
+				}
+			}).Return(canceledErr)
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
+
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.readTotalDeadline = 50 * time.Millisecond
+
+		begin := time.Now()
+		readResponse, err := c.queryClient.Read(request, mockCredentials)
+		assert.Nil(t, readResponse)
+		assert.Equal(t, errors.NewReadDeadlineExceededError(50*time.Millisecond), err)
+		assert.GreaterOrEqual(t, time.Since(begin), 50*time.Millisecond)
+		assert.Less(t, time.Since(begin), 5*time.Second)
+
+		mockTimestreamQueryClient.AssertExpectations(t)
+		mockTimestreamQueryClient.AssertNumberOfCalls(t, "QueryPages", 0)
+	})
+
+	t.Run("error from buildCommand with unknown matcher type", func(t *testing.T) {
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+
+		_, err := c.queryClient.Read(requestWithInvalidMatcher, mockCredentials)
+		assert.IsType(t, &errors.UnknownMatcherError{}, err)
+	})
+
+	t.Run("error from queryPages with invalid regex", func(t *testing.T) {
+		validationError := &timestreamquery.ValidationException{
+			RespMetadata: protocol.ResponseMetadata{StatusCode: 400},
+		}
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPages", queryInputWithInvalidRegex,
+			mock.AnythingOfType(functionType)).Return(validationError)
+
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
+
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+
+		_, err := c.queryClient.Read(requestWithInvalidRegex, mockCredentials)
+		assert.Equal(t, validationError, err)
+
+		mockTimestreamQueryClient.AssertExpectations(t)
+	})
+
+	t.Run("retry on validation error of a column not yet recognized", func(t *testing.T) {
+		defer func(backoff time.Duration) { schemaLagBackoff = backoff }(schemaLagBackoff)
+		schemaLagBackoff = time.Millisecond
+
+		validationError := &timestreamquery.ValidationException{
+			Message_:     aws.String("line 1:8: Column 'region' does not exist"),
+			RespMetadata: protocol.ResponseMetadata{StatusCode: 400},
+		}
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPages", queryInput,
+			mock.AnythingOfType(functionType)).Return(validationError).Once()
+		mockTimestreamQueryClient.On("QueryPages", queryInput,
+			mock.AnythingOfType(functionType)).Return(nil).Once()
+
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
+
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.schemaLagRetries = 3
+
+		_, err := c.queryClient.Read(request, mockCredentials)
+		assert.Nil(t, err)
+
+		mockTimestreamQueryClient.AssertExpectations(t)
+		mockTimestreamQueryClient.AssertNumberOfCalls(t, "QueryPages", 2)
+	})
+
+	t.Run("error after exhausting the retries on validation error of a column not yet recognized", func(t *testing.T) {
+		defer func(backoff time.Duration) { schemaLagBackoff = backoff }(schemaLagBackoff)
+		schemaLagBackoff = time.Millisecond
+
+		validationError := &timestreamquery.ValidationException{
+			Message_:     aws.String("line 1:8: Column 'region' does not exist"),
+			RespMetadata: protocol.ResponseMetadata{StatusCode: 400},
+		}
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPages", queryInput,
+			mock.AnythingOfType(functionType)).Return(validationError)
+
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
+
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.schemaLagRetries = 2
+
+		_, err := c.queryClient.Read(request, mockCredentials)
+		assert.Equal(t, validationError, err)
+
+		mockTimestreamQueryClient.AssertNumberOfCalls(t, "QueryPages", 3)
+	})
+
+	t.Run("no retry on validation error of an invalid regex", func(t *testing.T) {
+		validationError := &timestreamquery.ValidationException{
+			Message_:     aws.String("Invalid regular expression: (?P<login>\\w+)"),
+			RespMetadata: protocol.ResponseMetadata{StatusCode: 400},
+		}
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPages", queryInputWithInvalidRegex,
+			mock.AnythingOfType(functionType)).Return(validationError)
+
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
+		}
+
+		c := &Client{
+			writeClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.schemaLagRetries = 3
+
+		_, err := c.queryClient.Read(requestWithInvalidRegex, mockCredentials)
+		assert.Equal(t, validationError, err)
+
+		mockTimestreamQueryClient.AssertNumberOfCalls(t, "QueryPages", 1)
+	})
+}
+
+func TestWriteClientWrite(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		expectedInput := createNewWriteRecordsInputTemplate()
+		mockTimestreamWriteClient.On(
+			"WriteRecords",
+			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
+				// Sort the records in the WriteRecordsInput by their time, and sort the Dimension by dimension names.
+				sortRecords(writeInput)
+				sortRecords(expectedInput)
+
+				return reflect.DeepEqual(writeInput, expectedInput)
+			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+
+		err := c.writeClient.Write(createNewRequestTemplate(), mockCredentials)
+		assert.Nil(t, err)
+
+		mockTimestreamWriteClient.AssertExpectations(t)
+	})
+
+	t.Run("success writing one timeSeries with more than one sample", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		expectedInput := createNewWriteRecordsInputTemplate()
+		expectedInput.Records = append(expectedInput.Records, createNewRecordTemplate())
+
+		mockTimestreamWriteClient.On(
+			"WriteRecords",
+			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
+				// Sort the records in the WriteRecordsInput by their time, and sort the Dimension by dimension names.
+				sortRecords(writeInput)
+				sortRecords(expectedInput)
+
+				return reflect.DeepEqual(writeInput, expectedInput)
+			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Samples = append(req.Timeseries[0].Samples, prompb.Sample{
+			Timestamp: mockUnixTime,
+			Value:     measureValue,
+		})
+
+		err := c.writeClient.Write(req, mockCredentials)
+		assert.Nil(t, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+		mockTimestreamWriteClient.AssertExpectations(t)
+	})
+
+	t.Run("success writing one timeSeries with more than one sample without mapping", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		expectedInput := createNewWriteRecordsInputTemplate()
+		expectedInput.Records = append(expectedInput.Records, createNewRecordTemplate())
+
+		mockTimestreamWriteClient.On(
+			"WriteRecords",
+			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
+				// Sort the records in the WriteRecordsInput by their time, and sort the Dimension by dimension names.
+				sortRecords(writeInput)
+				sortRecords(expectedInput)
+
+				return reflect.DeepEqual(writeInput, expectedInput)
+			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+
+		reqWithoutMapping := createNewRequestTemplateWithoutMapping()
+		reqWithoutMapping.Timeseries[0].Samples = append(reqWithoutMapping.Timeseries[0].Samples, prompb.Sample{
+			Timestamp: mockUnixTime,
+			Value:     measureValue,
+		})
+
+		errWm := c.writeClient.Write(reqWithoutMapping, mockCredentials)
+		assert.Nil(t, errWm)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+		mockTimestreamWriteClient.AssertExpectations(t)
+	})
+
+	t.Run("success writing samples to the same destination", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		expectedInput := createNewWriteRecordsInputTemplate()
+		expectedInput.Records = append(expectedInput.Records, createNewRecordTemplate())
+
+		mockTimestreamWriteClient.On(
+			"WriteRecords",
+			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
+				sortRecords(writeInput)
+				sortRecords(expectedInput)
+				return reflect.DeepEqual(writeInput, expectedInput)
+			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		req := createNewRequestTemplate()
+		req.Timeseries = append(req.Timeseries, createTimeSeriesTemplate())
+
+		err := c.writeClient.Write(req, mockCredentials)
+		assert.Nil(t, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+		mockTimestreamWriteClient.AssertExpectations(t)
+	})
+
+	t.Run("missing database name in write series", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		expectedInput := createNewWriteRecordsInputTemplate()
+		expectedInput.Records = append(expectedInput.Records, createNewRecordTemplate())
+
+		mockTimestreamWriteClient.On(
+			"WriteRecords",
+			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
+				sortRecords(writeInput)
+				sortRecords(expectedInput)
+				return reflect.DeepEqual(writeInput, expectedInput)
+			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:   nil,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		req := createNewRequestTemplate()
+		req.Timeseries = append(req.Timeseries, createTimeSeriesTemplate())
+
+		err := c.writeClient.Write(req, mockCredentials)
+		expectedErr := errors.NewMissingDatabaseWithWriteError("", createTimeSeriesTemplate())
+		assert.Equal(t, err, expectedErr)
+	})
+
+	t.Run("missing table name in write series", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		expectedInput := createNewWriteRecordsInputTemplate()
+		expectedInput.Records = append(expectedInput.Records, createNewRecordTemplate())
+
+		mockTimestreamWriteClient.On(
+			"WriteRecords",
+			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
+				sortRecords(writeInput)
+				sortRecords(expectedInput)
+				return reflect.DeepEqual(writeInput, expectedInput)
+			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:   nil,
+			defaultDataBase: mockDatabaseName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		req := createNewRequestTemplate()
+		req.Timeseries = append(req.Timeseries, createTimeSeriesTemplate())
+
+		err := c.writeClient.Write(req, mockCredentials)
+		expectedErr := errors.NewMissingTableWithWriteError("", createTimeSeriesTemplate())
+		assert.Equal(t, err, expectedErr)
+	})
+
+	t.Run("error from convertToRecords due to missing ingestion database destination", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:   nil,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+
+		input := createNewRequestTemplate()
+		input.Timeseries[0].Labels = []*prompb.Label{
+			{
+				Name:  model.MetricNameLabel,
+				Value: metricName,
+			},
+			{
+				Name:  "label_1",
+				Value: "value_1",
+			},
+		}
+
+		err := c.WriteClient().Write(input, mockCredentials)
+		assert.IsType(t, &errors.MissingDatabaseWithWriteError{}, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+
+	t.Run("error from convertToRecords due to missing ingestion table destination", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:   nil,
+			defaultDataBase: mockDatabaseName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+
+		input := createNewRequestTemplate()
+		input.Timeseries[0].Labels = []*prompb.Label{
+			{
+				Name:  model.MetricNameLabel,
+				Value: metricName,
+			},
+			{
+				Name:  "label_1",
+				Value: "value_1",
+			},
+		}
+
+		err := c.WriteClient().Write(input, mockCredentials)
+		assert.IsType(t, &errors.MissingTableWithWriteError{}, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+
+	t.Run("error from WriteRecords()", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		expectedInput := createNewWriteRecordsInputTemplate()
+		requestError := &timestreamwrite.ValidationException{
+			RespMetadata: protocol.ResponseMetadata{StatusCode: 404},
+		}
+
+		mockTimestreamWriteClient.On(
+			"WriteRecords",
+			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
+				sortRecords(writeInput)
+				sortRecords(expectedInput)
+				return reflect.DeepEqual(writeInput, expectedInput)
+			})).Return(&timestreamwrite.WriteRecordsOutput{}, requestError)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+
+		err := c.WriteClient().Write(createNewRequestTemplate(), mockCredentials)
+		assert.Equal(t, requestError, err)
+
+		mockTimestreamWriteClient.AssertExpectations(t)
+	})
+
+	t.Run("error from WriteRecords() logged with the request ID of the context", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		requestError := &timestreamwrite.ValidationException{
+			RespMetadata: protocol.ResponseMetadata{StatusCode: 400},
+		}
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, requestError)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		var logs bytes.Buffer
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.logger = log.NewLogfmtLogger(&logs)
+
+		ctx := ContextWithRequestID(context.Background(), "request-1")
+		err := c.WriteClient().WriteWithContext(ctx, createNewRequestTemplate(), mockCredentials)
+		assert.Equal(t, requestError, err)
+
+		lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+		assert.Len(t, lines, 2)
+		for _, line := range lines {
+			assert.Contains(t, line, "request_id=request-1")
+		}
+	})
+
+	t.Run("success retrying once after an auth error", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		authError := awserr.NewRequestFailure(awserr.New("ExpiredTokenException", "The security token included in the request is expired", nil), 400, "requestID")
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, authError).Once()
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil).Once()
+
+		initCount := 0
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			initCount++
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.retryOnAuthError = true
+
+		requestCredentials := credentials.NewStaticCredentials("id", "secret", "")
+		_, _ = requestCredentials.Get()
+		err := c.WriteClient().Write(createNewRequestTemplate(), requestCredentials)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, initCount)
+		assert.False(t, requestCredentials.IsExpired())
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 2)
+	})
+
+	t.Run("success refreshing the provider chain credentials and retrying once after an auth error", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		authError := awserr.NewRequestFailure(awserr.New("ExpiredTokenException", "The security token included in the request is expired", nil), 400, "requestID")
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, authError).Once()
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil).Once()
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		chainCredentials := credentials.NewChainCredentials([]credentials.Provider{&credentials.StaticProvider{Value: credentials.Value{AccessKeyID: "id", SecretAccessKey: "secret"}}})
+		_, _ = chainCredentials.Get()
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.config = &aws.Config{Region: aws.String(mockRegion), Credentials: chainCredentials}
+		c.writeClient.retryOnAuthError = true
+
+		err := c.WriteClient().Write(createNewRequestTemplate(), nil)
+		assert.Nil(t, err)
+		assert.True(t, chainCredentials.IsExpired())
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 2)
+	})
+
+	t.Run("auth error without retrying", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		authError := awserr.NewRequestFailure(awserr.New("UnrecognizedClientException", "The security token included in the request is invalid", nil), 400, "requestID")
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, authError)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.retryOnAuthError = false
+
+		err := c.WriteClient().Write(createNewRequestTemplate(), mockCredentials)
+		assert.Equal(t, authError, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+	})
+
+	t.Run("rejected records counted by reason", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		rejectedRecordsError := &timestreamwrite.RejectedRecordsException{
+			RespMetadata: protocol.ResponseMetadata{StatusCode: 419},
+			RejectedRecords: []*timestreamwrite.RejectedRecord{
+				{Reason: aws.String("A record with the same dimensions, timestamp, and measure name but different measure value already exists."), RecordIndex: aws.Int64(0)},
+				{Reason: aws.String("The record timestamp is outside the time range of the data ingestion window."), RecordIndex: aws.Int64(1)},
+				{Reason: aws.String("The record timestamp is outside the retention duration of the memory store."), RecordIndex: aws.Int64(2)},
+				{Reason: aws.String("A record with a higher version already exists."), ExistingVersion: aws.Int64(2), RecordIndex: aws.Int64(3)},
+				{Reason: aws.String("The number of dimensions exceeds the limit."), RecordIndex: aws.Int64(4)},
+				{Reason: aws.String("Unknown."), RecordIndex: aws.Int64(5)},
+			},
+		}
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, rejectedRecordsError)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		rejectedRecords := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rejected"}, []string{"reason"})
+		c.writeClient.rejectedRecords = rejectedRecords
+
+		err := c.WriteClient().Write(createNewRequestTemplate(), mockCredentials)
+		assert.Equal(t, rejectedRecordsError, err)
+
+		assert.Equal(t, 1, getCounterValue(rejectedRecords.WithLabelValues(duplicateRejectionReason)))
+		assert.Equal(t, 2, getCounterValue(rejectedRecords.WithLabelValues(retentionRejectionReason)))
+		assert.Equal(t, 1, getCounterValue(rejectedRecords.WithLabelValues(versionRejectionReason)))
+		assert.Equal(t, 1, getCounterValue(rejectedRecords.WithLabelValues(limitRejectionReason)))
+		assert.Equal(t, 1, getCounterValue(rejectedRecords.WithLabelValues(otherRejectionReason)))
+	})
+
+	t.Run("valid timeSeries with fail-fast enabled", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		expectedInput := createNewWriteRecordsInputTemplate()
+		mockTimestreamWriteClient.On(
+			"WriteRecords",
+			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
+				sortRecords(writeInput)
+				sortRecords(expectedInput)
+				return reflect.DeepEqual(writeInput, expectedInput)
+			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.failOnInvalidSample = true
+
+		req := createNewRequestTemplate()
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.Nil(t, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+	})
+
+	t.Run("NaN timeSeries with fail-fast enabled", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.failOnInvalidSample = true
+
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Samples[0].Value = math.NaN()
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.IsType(t, &errors.InvalidSampleValueError{}, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+
+	t.Run("NaN timeSeries with fail-fast disabled", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.failOnInvalidSample = false
+
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Samples[0].Value = math.NaN()
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.Nil(t, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+
+	t.Run("Inf timeSeries with fail-fast enabled", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.failOnInvalidSample = true
+
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Samples[0].Value = math.Inf(1)
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.NotNil(t, err)
+
+		req.Timeseries[0].Samples[0].Value = math.Inf(-1)
+		err = c.WriteClient().Write(req, mockCredentials)
+		assert.IsType(t, &errors.InvalidSampleValueError{}, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+
+	t.Run("Inf timeSeries with fail-fast disabled", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.failOnInvalidSample = false
+
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Samples[0].Value = math.Inf(1)
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.Nil(t, err)
+
+		req.Timeseries[0].Samples[0].Value = math.Inf(-1)
+		err = c.WriteClient().Write(req, mockCredentials)
+		assert.Nil(t, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+
+	t.Run("long metric name with fail-fast enabled", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.failOnLongMetricLabelName = true
+
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Labels[0].Value = mockLongMetric
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.IsType(t, &errors.LongLabelNameError{}, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+
+	t.Run("long metric name with fail-fast disabled", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.failOnLongMetricLabelName = false
+
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Labels[0].Value = mockLongMetric
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.Nil(t, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+
+	t.Run("long dimension name with fail-fast enabled", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.failOnLongMetricLabelName = true
+
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Labels[1].Name = mockLongMetric
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.IsType(t, &errors.LongLabelNameError{}, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+
+	t.Run("long dimension name with fail-fast disabled", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.failOnLongMetricLabelName = false
+
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Labels[1].Name = mockLongMetric
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.Nil(t, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+
+	t.Run("time series exceeding the maximum samples per series", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		expectedInput := createNewWriteRecordsInputTemplate()
+		expectedInput.Records = append(expectedInput.Records, createNewRecordTemplate())
+		mockTimestreamWriteClient.On(
+			"WriteRecords",
+			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
+				sortRecords(writeInput)
+				sortRecords(expectedInput)
+				return reflect.DeepEqual(writeInput, expectedInput)
+			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		ignoredSamples := prometheus.NewCounter(prometheus.CounterOpts{})
+		c.writeClient.ignoredSamples = ignoredSamples
+		c.writeClient.maxSamplesPerSeries = 2
+
+		req := createNewRequestTemplate()
+		for i := 0; i < 3; i++ {
+			req.Timeseries[0].Samples = append(req.Timeseries[0].Samples, prompb.Sample{
+				Timestamp: mockUnixTime,
+				Value:     measureValue,
+			})
+		}
+
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, getCounterValue(ignoredSamples))
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+		mockTimestreamWriteClient.AssertExpectations(t)
+	})
+
+	t.Run("samples out of order with require ordered samples set to reject", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.requireOrderedSamples = RejectOrderedSamples
+
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Samples = append(req.Timeseries[0].Samples, prompb.Sample{
+			Timestamp: mockUnixTime - 1,
+			Value:     measureValue,
+		})
+
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.IsType(t, &errors.UnorderedSamplesError{}, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+
+	t.Run("samples out of order with require ordered samples set to warn", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.requireOrderedSamples = WarnOrderedSamples
+
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Samples = append(req.Timeseries[0].Samples, prompb.Sample{
+			Timestamp: mockUnixTime - 1,
+			Value:     measureValue,
+		})
+
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.Nil(t, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+	})
+
+	t.Run("skip the client initialization for a write request with all time series filtered out", func(t *testing.T) {
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			assert.Fail(t, "initWriteClient must not be called")
+			return nil, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+
+		// The time series with non-finite values and a long metric name are ignored.
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Samples[0].Value = math.NaN()
+		longMetricSeries := createTimeSeriesTemplate()
+		longMetricSeries.Labels[0].Value = mockLongMetric
+		req.Timeseries = append(req.Timeseries, longMetricSeries)
+		assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+	})
+
+	t.Run("write with the instance ID dimension", func(t *testing.T) {
+		expectedInput := createNewWriteRecordsInputTemplate()
+		expectedInput.Records[0].Dimensions = append(expectedInput.Records[0].Dimensions, &timestreamwrite.Dimension{
+			Name:  aws.String(InstanceIDDimension),
+			Value: aws.String("connector-1"),
+		})
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+			input := args.Get(0).(*timestreamwrite.WriteRecordsInput)
+			sortRecords(input)
+			sortRecords(expectedInput)
+			assert.Equal(t, expectedInput, input)
+		}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.instanceID = "connector-1"
+
+		// The instance ID overwrites a label with the same name.
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Labels = append(req.Timeseries[0].Labels, &prompb.Label{Name: InstanceIDDimension, Value: "other"})
+		assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+	})
+
+	t.Run("write with the schema version dimension", func(t *testing.T) {
+		expectedInput := createNewWriteRecordsInputTemplate()
+		expectedInput.Records[0].Dimensions = append(expectedInput.Records[0].Dimensions, &timestreamwrite.Dimension{
+			Name:  aws.String(SchemaVersionDimension),
+			Value: aws.String(SchemaVersion),
+		})
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+			input := args.Get(0).(*timestreamwrite.WriteRecordsInput)
+			sortRecords(input)
+			sortRecords(expectedInput)
+			assert.Equal(t, expectedInput, input)
+		}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.emitSchemaVersion = true
+
+		// The schema version overwrites a label with the same name.
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Labels = append(req.Timeseries[0].Labels, &prompb.Label{Name: SchemaVersionDimension, Value: "other"})
+		assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+	})
+
+	t.Run("write with the cost tag dimensions", func(t *testing.T) {
+		expectedInput := createNewWriteRecordsInputTemplate()
+		expectedInput.Records[0].Dimensions = []*timestreamwrite.Dimension{
+			{Name: aws.String("label_1"), Value: aws.String("tagged")},
+			{Name: aws.String("team"), Value: aws.String("observability")},
+		}
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+			input := args.Get(0).(*timestreamwrite.WriteRecordsInput)
+			sortRecords(input)
+			sortRecords(expectedInput)
+			assert.Equal(t, expectedInput, input)
+		}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		// The cost tags overwrite the labels with the same names.
+		c.writeClient.costTags = map[string]string{"team": "observability", "label_1": "tagged"}
+
+		assert.Nil(t, c.WriteClient().Write(createNewRequestTemplate(), mockCredentials))
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+	})
+
+	t.Run("write the +Inf histogram bucket with the configured le value", func(t *testing.T) {
+		var dimensions []map[string]string
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+			for _, record := range args.Get(0).(*timestreamwrite.WriteRecordsInput).Records {
+				recordDimensions := make(map[string]string)
+				for _, dimension := range record.Dimensions {
+					recordDimensions[*dimension.Name] = *dimension.Value
+				}
+				dimensions = append(dimensions, recordDimensions)
+			}
+		}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+
+		// writeBucket writes a time series with the le label and returns its stored value.
+		writeBucket := func(le string) string {
+			dimensions = nil
+			req := createNewRequestTemplate()
+			req.Timeseries[0].Labels = append(req.Timeseries[0].Labels, &prompb.Label{Name: model.BucketLabel, Value: le})
+			assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+			assert.Len(t, dimensions, 1)
+			return dimensions[0][model.BucketLabel]
+		}
+
+		// The le labels are stored as they are by default.
+		assert.Equal(t, "+Inf", writeBucket("+Inf"))
+		assert.Equal(t, "Inf", writeBucket("Inf"))
+
+		c.writeClient.infBucketValue = "inf"
+		assert.Equal(t, "inf", writeBucket("+Inf"))
+		assert.Equal(t, "inf", writeBucket("Inf"))
+		assert.Equal(t, "0.5", writeBucket("0.5"))
+		assert.Equal(t, "-Inf", writeBucket("-Inf"))
+	})
+
+	t.Run("write with the dimensions expanded from a JSON label", func(t *testing.T) {
+		tests := []struct {
+			name               string
+			value              string
+			expectedDimensions map[string]string
+		}{
+			{
+				name:  "JSON object",
+				value: `{"region": "us-east-1", "zone": 2, "canary": true, "owner": null, "label_1": "other"}`,
+				expectedDimensions: map[string]string{
+					"region": "us-east-1",
+					"zone":   "2",
+					"canary": "true",
+				},
+			},
+			{
+				name:               "nested JSON object",
+				value:              `{"build": {"version": "1.0"}}`,
+				expectedDimensions: map[string]string{"build": `{"version":"1.0"}`},
+			},
+			{
+				name:               "invalid JSON",
+				value:              `{"region": `,
+				expectedDimensions: map[string]string{"metadata": `{"region": `},
+			},
+			{
+				name:               "JSON array",
+				value:              `["us-east-1"]`,
+				expectedDimensions: map[string]string{"metadata": `["us-east-1"]`},
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				var writtenRecords []*timestreamwrite.Record
+				mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+				mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+					writtenRecords = args.Get(0).(*timestreamwrite.WriteRecordsInput).Records
+				}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+				initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+					return mockTimestreamWriteClient, nil
+				}
+
+				c := &Client{
+					queryClient:     nil,
+					defaultDataBase: mockDatabaseName,
+					defaultTable:    mockTableName,
+				}
+				c.writeClient = createNewWriteClientTemplate(c)
+				c.writeClient.expandJSONLabel = "metadata"
+
+				req := createNewRequestTemplate()
+				req.Timeseries[0].Labels = append(req.Timeseries[0].Labels, &prompb.Label{Name: "metadata", Value: test.value})
+				assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+				assert.Len(t, writtenRecords, 1)
+
+				dimensions := make(map[string]string)
+				for _, dimension := range writtenRecords[0].Dimensions {
+					dimensions[*dimension.Name] = *dimension.Value
+				}
+				// The labels of the time series take precedence over the expanded keys.
+				expectedDimensions := map[string]string{"label_1": "value_1"}
+				for name, value := range test.expectedDimensions {
+					expectedDimensions[name] = value
+				}
+				assert.Equal(t, expectedDimensions, dimensions)
+			})
+		}
+	})
+
+	t.Run("write with the sample count of each time series", func(t *testing.T) {
+		var writtenRecords []*timestreamwrite.Record
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+			writtenRecords = args.Get(0).(*timestreamwrite.WriteRecordsInput).Records
+		}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.emitSampleCount = true
+
+		// The NaN sample is ignored and not counted.
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Samples = append(req.Timeseries[0].Samples,
+			prompb.Sample{Timestamp: mockUnixTime + 1000, Value: measureValue},
+			prompb.Sample{Timestamp: mockUnixTime + 2000, Value: math.NaN()})
+		assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+
+		assert.Len(t, writtenRecords, 3)
+		sampleCountRecord := writtenRecords[2]
+		assert.Equal(t, metricName+SampleCountSuffix, aws.StringValue(sampleCountRecord.MeasureName))
+		assert.Equal(t, "2.000000", aws.StringValue(sampleCountRecord.MeasureValue))
+		assert.Equal(t, strconv.FormatInt(mockUnixTime+1000, 10), aws.StringValue(sampleCountRecord.Time))
+		assert.Equal(t, writtenRecords[0].Dimensions, sampleCountRecord.Dimensions)
+	})
+
+	t.Run("sample count skipped for a measure name too long with the suffix", func(t *testing.T) {
+		var writtenRecords []*timestreamwrite.Record
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+			writtenRecords = args.Get(0).(*timestreamwrite.WriteRecordsInput).Records
+		}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.emitSampleCount = true
+
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Labels[0].Value = strings.Repeat("a", maxMeasureNameLength)
+		assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+		assert.Len(t, writtenRecords, 1)
+	})
+
+	for _, rejectEmptyWrites := range []bool{false, true} {
+		t.Run(fmt.Sprintf("write request without time series with reject empty writes set to %t", rejectEmptyWrites), func(t *testing.T) {
+			mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+			initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+				return mockTimestreamWriteClient, nil
+			}
+
+			c := &Client{
+				queryClient:     nil,
+				defaultDataBase: mockDatabaseName,
+				defaultTable:    mockTableName,
+			}
+			c.writeClient = createNewWriteClientTemplate(c)
+			c.writeClient.rejectEmptyWrites = rejectEmptyWrites
+
+			err := c.WriteClient().Write(&prompb.WriteRequest{}, mockCredentials)
+			if rejectEmptyWrites {
+				assert.IsType(t, &errors.EmptyWriteRequestError{}, err)
+			} else {
+				assert.Nil(t, err)
+			}
+			mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+		})
+	}
+
+	missingDimensionsTestCases := []struct {
+		missingDimensions string
+		expectedError     error
+		expectedCalls     int
+	}{
+		{IgnoreMissingDimensions, nil, 1},
+		{FailMissingDimensions, &errors.MissingRequiredDimensionError{}, 0},
+	}
+	for _, test := range missingDimensionsTestCases {
+		t.Run(fmt.Sprintf("time series missing a required dimension with missing dimensions set to %s", test.missingDimensions), func(t *testing.T) {
+			mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+			mockTimestreamWriteClient.On("WriteRecords", createNewWriteRecordsInputTemplate()).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+			initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+				return mockTimestreamWriteClient, nil
+			}
+
+			c := &Client{
+				queryClient:     nil,
+				defaultDataBase: mockDatabaseName,
+				defaultTable:    mockTableName,
+			}
+			c.writeClient = createNewWriteClientTemplate(c)
+			c.writeClient.requiredDimensions = []string{"label_1"}
+			c.writeClient.missingDimensions = test.missingDimensions
+			ignoredSamples := prometheus.NewCounter(prometheus.CounterOpts{})
+			c.writeClient.ignoredSamples = ignoredSamples
+
+			// Only the time series of the template has the required dimension.
+			req := createNewRequestTemplate()
+			timeSeries := createTimeSeriesTemplate()
+			timeSeries.Labels[1].Name = "label_2"
+			req.Timeseries = append(req.Timeseries, timeSeries)
+
+			err := c.WriteClient().Write(req, mockCredentials)
+			if test.expectedError != nil {
+				assert.IsType(t, test.expectedError, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, 1, getCounterValue(ignoredSamples))
+			}
+			mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", test.expectedCalls)
+		})
+	}
+
+	conflictingRecordsTestCases := []struct {
+		resolution     string
+		expectedValues []string
+		expectedError  error
+	}{
+		{OffConflictingRecords, []string{"1.000000", "2.000000", "2.000000"}, nil},
+		{FirstConflictingRecords, []string{"1.000000"}, nil},
+		{LastConflictingRecords, []string{"2.000000"}, nil},
+		{ErrorConflictingRecords, nil, &errors.ConflictingRecordsError{}},
+	}
+	for _, test := range conflictingRecordsTestCases {
+		t.Run(fmt.Sprintf("samples with the same labels and time with conflicting records set to %s", test.resolution), func(t *testing.T) {
+			var writtenValues []string
+			mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+			mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+				for _, record := range args.Get(0).(*timestreamwrite.WriteRecordsInput).Records {
+					writtenValues = append(writtenValues, aws.StringValue(record.MeasureValue))
+				}
+			}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+			initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+				return mockTimestreamWriteClient, nil
+			}
+
+			c := &Client{
+				queryClient:     nil,
+				defaultDataBase: mockDatabaseName,
+				defaultTable:    mockTableName,
+			}
+			c.writeClient = createNewWriteClientTemplate(c)
+			c.writeClient.conflictingRecords = test.resolution
+
+			// The conflicting time series list the same labels in a different order.
+			req := &prompb.WriteRequest{}
+			for _, value := range []float64{1, 2, 2} {
+				timeSeries := createTimeSeriesTemplate()
+				timeSeries.Labels = append(timeSeries.Labels, &prompb.Label{Name: "label_2", Value: "value_2"})
+				if value == 2 {
+					timeSeries.Labels[1], timeSeries.Labels[2] = timeSeries.Labels[2], timeSeries.Labels[1]
+				}
+				timeSeries.Samples[0].Value = value
+				req.Timeseries = append(req.Timeseries, timeSeries)
+			}
+
+			err := c.WriteClient().Write(req, mockCredentials)
+			if test.expectedError != nil {
+				assert.IsType(t, test.expectedError, err)
+				mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expectedValues, writtenValues)
+		})
+	}
+
+	t.Run("unknown SDK error", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		unknownSDKErr := errors.NewSDKNonRequestError(goErrors.New(""))
+		mockTimestreamWriteClient.On("WriteRecords", createNewWriteRecordsInputTemplate()).Return(&timestreamwrite.WriteRecordsOutput{}, unknownSDKErr)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+
+		req := createNewRequestTemplate()
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.Equal(t, unknownSDKErr, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+	})
+
+	t.Run("success with default measure name for time series without metric name", func(t *testing.T) {
+		expectedInput := createNewWriteRecordsInputTemplate()
+		expectedInput.Records[0].MeasureName = aws.String("default_measure")
+
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", expectedInput).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.defaultMeasureName = "default_measure"
+
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Labels = req.Timeseries[0].Labels[1:]
+		err := c.WriteClient().Write(req, mockCredentials)
+		assert.Nil(t, err)
+
+		mockTimestreamWriteClient.AssertExpectations(t)
+	})
+}
+
+func TestWriteClientReservedLabelNames(t *testing.T) {
+	createRequestWithTimeLabel := func() *prompb.WriteRequest {
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Labels = append(req.Timeseries[0].Labels, &prompb.Label{Name: timeColumnName, Value: "morning"})
+		return req
+	}
+
+	t.Run("success renaming label named time", func(t *testing.T) {
+		expectedInput := createNewWriteRecordsInputTemplate()
+		expectedInput.Records[0].Dimensions = append(expectedInput.Records[0].Dimensions, &timestreamwrite.Dimension{
+			Name:  aws.String("label_time"),
+			Value: aws.String("morning"),
+		})
+
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On(
+			"WriteRecords",
+			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
+				sortRecords(writeInput)
+				return reflect.DeepEqual(writeInput, expectedInput)
+			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.reservedLabels = RenameReservedLabels
+
+		err := c.WriteClient().Write(createRequestWithTimeLabel(), mockCredentials)
+		assert.Nil(t, err)
+
+		mockTimestreamWriteClient.AssertExpectations(t)
+	})
+
+	t.Run("error from label named time", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.reservedLabels = FailReservedLabels
+
+		err := c.WriteClient().Write(createRequestWithTimeLabel(), mockCredentials)
+		assert.IsType(t, &errors.ReservedLabelNameError{}, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+
+	t.Run("error from label named after a renamed reserved label", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+
+		for _, reservedLabels := range []string{RenameReservedLabels, FailReservedLabels} {
+			c.writeClient.reservedLabels = reservedLabels
+			req := createNewRequestTemplate()
+			req.Timeseries[0].Labels = append(req.Timeseries[0].Labels, &prompb.Label{Name: "label_time", Value: "evening"})
+
+			err := c.WriteClient().Write(req, mockCredentials)
+			assert.IsType(t, &errors.AmbiguousLabelNameError{}, err)
+		}
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+}
+
+func TestQueryClientReservedLabelNames(t *testing.T) {
+	c := &Client{
+		writeClient:     nil,
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.queryClient = createNewQueryClientTemplate(c)
+
+	t.Run("build command with matcher on label named time", func(t *testing.T) {
+		buildCommand, _, err := c.queryClient.buildCommands(mockLogger, []*prompb.Query{
+			{
+				StartTimestampMs: mockUnixTime,
+				EndTimestampMs:   mockEndUnixTime,
+				Matchers: []*prompb.LabelMatcher{
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+					createLabelMatcher(prompb.LabelMatcher_EQ, timeColumnName, "morning"),
+				},
+			},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []*timestreamquery.QueryInput{
+			{
+				QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %s = '%s' AND label_time = 'morning' AND %s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)",
+					mockDatabaseName, mockTableName, measureNameColumnName, metricName, timeColumnName, startUnixInSeconds, endUnixInSeconds)),
+			},
+		}, buildCommand)
+	})
+
+	t.Run("convert result restoring label named time", func(t *testing.T) {
+		columnInfo := createColumnInfo()
+		queryOutput := &timestreamquery.QueryOutput{
+			ColumnInfo: []*timestreamquery.ColumnInfo{
+				{Name: aws.String("label_time"), Type: columnInfo[0].Type},
+				columnInfo[2],
+				columnInfo[3],
+				columnInfo[4],
+			},
+			Rows: []*timestreamquery.Row{
+				{
+					Data: []*timestreamquery.Datum{
+						{ScalarValue: aws.String("morning")},
+						{ScalarValue: aws.String(measureValueStr)},
+						{ScalarValue: aws.String(metricName)},
+						{ScalarValue: aws.String(timestamp1)},
+					},
+				},
+			},
+		}
+
+		queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, queryOutput)
+		assert.Nil(t, err)
+		assert.Equal(t, []*prompb.Label{
+			{Name: timeColumnName, Value: "morning"},
+			{Name: model.MetricNameLabel, Value: metricName},
+		}, queryResult.Timeseries[0].Labels)
+	})
+}
+
+func TestWriteClientAuditLog(t *testing.T) {
+	createWriteClient := func(mockTimestreamWriteClient *mockTimestreamWriteClient) *Client {
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			queryClient:     nil,
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.auditLog = filepath.Join(t.TempDir(), "audit.jsonl")
+		return c
+	}
+
+	t.Run("success writing audit entry", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		c := createWriteClient(mockTimestreamWriteClient)
+
+		req := createNewRequestTemplate()
+		otherTimeSeries := createTimeSeriesTemplate()
+		otherTimeSeries.Labels[0].Value = "other_metric"
+		otherTimeSeries.Samples[0].Timestamp = mockUnixTime + 1000
+		req.Timeseries = append(req.Timeseries, otherTimeSeries)
+		assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+
+		content, err := os.ReadFile(c.writeClient.auditLog)
+		assert.Nil(t, err)
+		var entry auditEntry
+		assert.Nil(t, json.Unmarshal(content, &entry))
+		assert.Equal(t, mockDatabaseName, entry.Database)
+		assert.Equal(t, mockTableName, entry.Table)
+		assert.Equal(t, 2, entry.RecordCount)
+		assert.Equal(t, []string{metricName, "other_metric"}, entry.MetricNames)
+		assert.Equal(t, mockUnixTime, entry.StartTime)
+		assert.Equal(t, mockUnixTime+1000, entry.EndTime)
+	})
+
+	t.Run("no audit entry for failed write", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, goErrors.New("write failed"))
+		c := createWriteClient(mockTimestreamWriteClient)
+
+		assert.NotNil(t, c.WriteClient().Write(createNewRequestTemplate(), mockCredentials))
+
+		_, err := os.Stat(c.writeClient.auditLog)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestWriteClientRecordVersion(t *testing.T) {
+	c := &Client{
+		queryClient:     nil,
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.writeClient = createNewWriteClientTemplate(c)
+	timeSeries := createTimeSeriesTemplate()
+	timeSeries.Samples = append(timeSeries.Samples, prompb.Sample{Timestamp: mockUnixTime + 1000, Value: measureValue})
+
+	t.Run("no version with none strategy", func(t *testing.T) {
+		c.writeClient.recordVersionStrategy = NoRecordVersion
+		records, err := c.writeClient.appendRecords(mockLogger, nil, timeSeries, nil, metricName)
+		assert.Nil(t, err)
+		assert.Len(t, records, 2)
+		assert.Nil(t, records[0].Version)
+		assert.Nil(t, records[1].Version)
+	})
+
+	t.Run("version from ingestion time with timestamp strategy", func(t *testing.T) {
+		c.writeClient.recordVersionStrategy = TimestampRecordVersion
+		begin := time.Now().UnixNano()
+		records, err := c.writeClient.appendRecords(mockLogger, nil, timeSeries, nil, metricName)
+		assert.Nil(t, err)
+		assert.Len(t, records, 2)
+		assert.GreaterOrEqual(t, *records[0].Version, begin)
+		assert.GreaterOrEqual(t, *records[1].Version, *records[0].Version)
+		assert.LessOrEqual(t, *records[1].Version, time.Now().UnixNano())
+	})
+
+	t.Run("version from ingestion time with counter strategy", func(t *testing.T) {
+		c.writeClient.recordVersionStrategy = CounterRecordVersion
+		c.writeClient.versionCounter = 0
+		begin := time.Now().UnixNano()
+		records, err := c.writeClient.appendRecords(mockLogger, nil, timeSeries, nil, metricName)
+		assert.Nil(t, err)
+		assert.Len(t, records, 2)
+		assert.GreaterOrEqual(t, *records[0].Version, begin)
+		assert.Greater(t, *records[1].Version, *records[0].Version)
+	})
+
+	t.Run("strictly increasing version with counter strategy ahead of the clock", func(t *testing.T) {
+		c.writeClient.recordVersionStrategy = CounterRecordVersion
+		ahead := time.Now().Add(time.Hour).UnixNano()
+		c.writeClient.versionCounter = ahead
+		records, err := c.writeClient.appendRecords(mockLogger, nil, timeSeries, nil, metricName)
+		assert.Nil(t, err)
+		assert.Len(t, records, 2)
+		assert.Equal(t, ahead+1, *records[0].Version)
+		assert.Equal(t, ahead+2, *records[1].Version)
+	})
+
+	t.Run("increasing version across retried writes with sample strategy", func(t *testing.T) {
+		oldTimeNow := timeNow
+		defer func() { timeNow = oldTimeNow }()
+		now := time.Unix(0, (mockUnixTime+5000)*nanosToMillisConversionRate)
+		timeNow = func() time.Time { return now }
+
+		c.writeClient.recordVersionStrategy = SampleRecordVersion
+		records, err := c.writeClient.appendRecords(mockLogger, nil, timeSeries, nil, metricName)
+		assert.Nil(t, err)
+		assert.Len(t, records, 2)
+		assert.Equal(t, int64(mockUnixTime)<<sampleVersionBits|5000, *records[0].Version)
+		assert.Equal(t, int64(mockUnixTime+1000)<<sampleVersionBits|4000, *records[1].Version)
+
+		// The write request retried by Prometheus carries higher versions for the same samples.
+		now = now.Add(30 * time.Millisecond)
+		retried, err := c.writeClient.appendRecords(mockLogger, nil, timeSeries, nil, metricName)
+		assert.Nil(t, err)
+		assert.Len(t, retried, 2)
+		assert.Greater(t, *retried[0].Version, *records[0].Version)
+		assert.Greater(t, *retried[1].Version, *records[1].Version)
+		// A newer sample always carries a higher version than an older sample.
+		assert.Greater(t, *records[1].Version, *retried[0].Version)
+	})
+
+	t.Run("version from sample timestamp with capped elapsed time", func(t *testing.T) {
+		assert.Equal(t, int64(mockUnixTime)<<sampleVersionBits, sampleVersion(mockUnixTime, mockUnixTime-1000))
+		assert.Equal(t, int64(mockUnixTime)<<sampleVersionBits|maxSampleVersionElapsed, sampleVersion(mockUnixTime, mockUnixTime+time.Hour.Milliseconds()))
+	})
+}
+
+func TestWriteClientClampTimestamps(t *testing.T) {
+	now := time.Unix(0, mockUnixTime*nanosToMillisConversionRate)
+	oldTimeNow := timeNow
+	defer func() { timeNow = oldTimeNow }()
+	timeNow = func() time.Time { return now }
+
+	c := &Client{
+		queryClient:     nil,
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.writeClient = createNewWriteClientTemplate(c)
+	c.writeClient.clampTimestamps = true
+	c.writeClient.memoryStoreRetention = time.Hour
+
+	oldestTimestamp := now.Add(-time.Hour+clampMargin).UnixNano() / nanosToMillisConversionRate
+	newestTimestamp := now.Add(maxFutureTimestamp-clampMargin).UnixNano() / nanosToMillisConversionRate
+
+	tests := []struct {
+		name              string
+		timestamp         int64
+		expectedTimestamp int64
+		expectedClamped   int
+	}{
+		{
+			name:              "clamp past timestamp to the memory store retention",
+			timestamp:         now.Add(-2*time.Hour).UnixNano() / nanosToMillisConversionRate,
+			expectedTimestamp: oldestTimestamp,
+			expectedClamped:   1,
+		},
+		{
+			name:              "clamp future timestamp to the maximum future time",
+			timestamp:         now.Add(time.Hour).UnixNano() / nanosToMillisConversionRate,
+			expectedTimestamp: newestTimestamp,
+			expectedClamped:   1,
+		},
+		{
+			name:              "keep timestamp within the memory store window",
+			timestamp:         mockUnixTime,
+			expectedTimestamp: mockUnixTime,
+			expectedClamped:   0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c.writeClient.clampedSamples = prometheus.NewCounter(prometheus.CounterOpts{Name: "clamped_samples"})
+			timeSeries := createTimeSeriesTemplate()
+			timeSeries.Samples = []prompb.Sample{{Timestamp: test.timestamp, Value: measureValue}}
+
+			records, err := c.writeClient.appendRecords(mockLogger, nil, timeSeries, nil, metricName)
+			assert.Nil(t, err)
+			assert.Len(t, records, 1)
+			assert.Equal(t, strconv.FormatInt(test.expectedTimestamp, 10), *records[0].Time)
+			assert.Equal(t, test.expectedClamped, getCounterValue(c.writeClient.clampedSamples))
+			// The samples of the write request are left unchanged.
+			assert.Equal(t, test.timestamp, timeSeries.Samples[0].Timestamp)
+		})
+	}
+
+	t.Run("only clamp future timestamp without memory store retention", func(t *testing.T) {
+		c.writeClient.memoryStoreRetention = 0
+		c.writeClient.clampedSamples = prometheus.NewCounter(prometheus.CounterOpts{Name: "clamped_samples"})
+		timestamp := now.Add(-2*time.Hour).UnixNano() / nanosToMillisConversionRate
+		timeSeries := createTimeSeriesTemplate()
+		timeSeries.Samples = []prompb.Sample{{Timestamp: timestamp, Value: measureValue}}
+
+		records, err := c.writeClient.appendRecords(mockLogger, nil, timeSeries, nil, metricName)
+		assert.Nil(t, err)
+		assert.Equal(t, strconv.FormatInt(timestamp, 10), *records[0].Time)
+		assert.Equal(t, 0, getCounterValue(c.writeClient.clampedSamples))
+	})
+}
+
+func TestWriteClientDuplicateSamples(t *testing.T) {
+	c := &Client{
+		queryClient:     nil,
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.writeClient = createNewWriteClientTemplate(c)
+
+	// The samples at mockUnixTime have different values, the samples at mockUnixTime+1 the same value.
+	samples := []prompb.Sample{
+		{Timestamp: mockUnixTime, Value: 2},
+		{Timestamp: mockUnixTime + 1, Value: 1},
+		{Timestamp: mockUnixTime, Value: 3},
+		{Timestamp: mockUnixTime + 1, Value: 1},
+		{Timestamp: mockUnixTime, Value: 1},
+	}
+
+	tests := []struct {
+		name               string
+		duplicateSamples   string
+		expectedValues     []string
+		expectedDuplicates int
+		expectedError      error
+	}{
+		{
+			name:               "write all the samples",
+			duplicateSamples:   OffDuplicateSamples,
+			expectedValues:     []string{"2.000000", "1.000000", "3.000000", "1.000000", "1.000000"},
+			expectedDuplicates: 0,
+		},
+		{
+			name:               "keep the first sample",
+			duplicateSamples:   FirstDuplicateSamples,
+			expectedValues:     []string{"2.000000", "1.000000"},
+			expectedDuplicates: 3,
+		},
+		{
+			name:               "keep the last sample",
+			duplicateSamples:   LastDuplicateSamples,
+			expectedValues:     []string{"1.000000", "1.000000"},
+			expectedDuplicates: 3,
+		},
+		{
+			name:               "keep the largest sample",
+			duplicateSamples:   MaxDuplicateSamples,
+			expectedValues:     []string{"3.000000", "1.000000"},
+			expectedDuplicates: 3,
+		},
+		{
+			name:               "error on samples with different values",
+			duplicateSamples:   ErrorDuplicateSamples,
+			expectedDuplicates: 1,
+			expectedError:      errors.NewDuplicateSamplesError(metricName, mockUnixTime),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c.writeClient.duplicateSamples = test.duplicateSamples
+			c.writeClient.droppedDuplicateSamples = prometheus.NewCounter(prometheus.CounterOpts{Name: "duplicate_samples"})
+			timeSeries := createTimeSeriesTemplate()
+			timeSeries.Samples = append([]prompb.Sample{}, samples...)
+
+			records, err := c.writeClient.appendRecords(mockLogger, nil, timeSeries, nil, metricName)
+			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expectedDuplicates, getCounterValue(c.writeClient.droppedDuplicateSamples))
+			if test.expectedError != nil {
+				return
+			}
+
+			var values []string
+			for _, record := range records {
+				values = append(values, *record.MeasureValue)
+			}
+			assert.Equal(t, test.expectedValues, values)
+			assert.Equal(t, strconv.FormatInt(mockUnixTime, 10), *records[0].Time)
+			// The samples of the write request are left unchanged.
+			assert.Equal(t, samples, timeSeries.Samples)
+		})
+	}
+}
+
+func TestWriteClientValidateRetention(t *testing.T) {
+	oldInitWriteClient := initWriteClient
+	defer func() { initWriteClient = oldInitWriteClient }()
+
+	describeTableInput := &timestreamwrite.DescribeTableInput{
+		DatabaseName: aws.String(mockDatabaseName),
+		TableName:    aws.String(mockTableName),
+	}
+	describeTableOutput := &timestreamwrite.DescribeTableOutput{
+		Table: &timestreamwrite.Table{
+			RetentionProperties: &timestreamwrite.RetentionProperties{
+				MemoryStoreRetentionPeriodInHours:  aws.Int64(12),
+				MagneticStoreRetentionPeriodInDays: aws.Int64(365),
+			},
+		},
+	}
+
+	tests := []struct {
+		name                      string
+		expectedMemoryRetention   time.Duration
+		expectedMagneticRetention time.Duration
+		describeTableError        error
+		expectedError             error
+		expectedWarnings          []string
+	}{
+		{
+			name:                      "matching retention",
+			expectedMemoryRetention:   12 * time.Hour,
+			expectedMagneticRetention: 365 * 24 * time.Hour,
+		},
+		{
+			name:                    "differing memory store retention",
+			expectedMemoryRetention: 24 * time.Hour,
+			expectedWarnings:        []string{"The memory store retention of the table " + mockDatabaseName + "." + mockTableName + " is 12h0m0s, but 24h0m0s is expected."},
+		},
+		{
+			name:                      "differing memory and magnetic store retention",
+			expectedMemoryRetention:   time.Hour,
+			expectedMagneticRetention: 7 * 24 * time.Hour,
+			expectedWarnings: []string{
+				"The memory store retention of the table " + mockDatabaseName + "." + mockTableName + " is 12h0m0s, but 1h0m0s is expected.",
+				"The magnetic store retention of the table " + mockDatabaseName + "." + mockTableName + " is 8760h0m0s, but 168h0m0s is expected.",
+			},
+		},
+		{
+			name:                    "error from DescribeTable()",
+			expectedMemoryRetention: 12 * time.Hour,
+			describeTableError:      &timestreamwrite.ResourceNotFoundException{},
+			expectedError:           &timestreamwrite.ResourceNotFoundException{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+			mockTimestreamWriteClient.On("DescribeTable", describeTableInput).Return(describeTableOutput, test.describeTableError)
+			initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+				return mockTimestreamWriteClient, nil
+			}
+
+			var logs bytes.Buffer
+			c := &Client{
+				defaultDataBase: mockDatabaseName,
+				defaultTable:    mockTableName,
+			}
+			c.writeClient = createNewWriteClientTemplate(c)
+			c.writeClient.logger = log.NewLogfmtLogger(&logs)
+
+			err := c.WriteClient().ValidateRetention(test.expectedMemoryRetention, test.expectedMagneticRetention)
+			assert.Equal(t, test.expectedError, err)
+			mockTimestreamWriteClient.AssertExpectations(t)
+
+			var warnings []string
+			for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+				if len(line) != 0 {
+					warnings = append(warnings, line)
+				}
+			}
+			assert.Len(t, warnings, len(test.expectedWarnings))
+			for i, warning := range test.expectedWarnings {
+				assert.Contains(t, warnings[i], "level=warn")
+				assert.Contains(t, warnings[i], warning)
+			}
+		})
+	}
+
+	t.Run("no validation without expected retention", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+		c := &Client{
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+
+		assert.Nil(t, c.WriteClient().ValidateRetention(0, 0))
+		mockTimestreamWriteClient.AssertNotCalled(t, "DescribeTable", mock.Anything)
+	})
+}
+
+func TestWriteClientCheckReady(t *testing.T) {
+	oldInitWriteClient := initWriteClient
+	defer func() { initWriteClient = oldInitWriteClient }()
+
+	describeEndpointsError := awserr.NewRequestFailure(awserr.New("InternalServerException", "", nil), http.StatusInternalServerError, "")
+	tests := []struct {
+		name                   string
+		config                 *aws.Config
+		describeEndpointsError error
+		expectDescribe         bool
+		expectError            bool
+	}{
+		{
+			name:           "ready describing the endpoints",
+			config:         &aws.Config{Region: aws.String("us-east-1"), Credentials: credentials.NewStaticCredentials("accessKey", "secretKey", "")},
+			expectDescribe: true,
+		},
+		{
+			name:                   "error from DescribeEndpoints()",
+			config:                 &aws.Config{Region: aws.String("us-east-1"), Credentials: credentials.NewStaticCredentials("accessKey", "secretKey", "")},
+			describeEndpointsError: describeEndpointsError,
+			expectDescribe:         true,
+			expectError:            true,
+		},
+		{
+			name:   "ready without default credentials",
+			config: &aws.Config{Region: aws.String("us-east-1"), Credentials: credentials.NewStaticCredentials("", "", "")},
+		},
+		{
+			name:        "error without region",
+			config:      &aws.Config{Credentials: credentials.NewStaticCredentials("accessKey", "secretKey", "")},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+			mockTimestreamWriteClient.On("DescribeEndpointsWithContext", mock.Anything).Return(&timestreamwrite.DescribeEndpointsOutput{}, test.describeEndpointsError)
+			initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+				return mockTimestreamWriteClient, nil
+			}
+			c := &Client{
+				defaultDataBase: mockDatabaseName,
+				defaultTable:    mockTableName,
+			}
+			c.writeClient = createNewWriteClientTemplate(c)
+			c.writeClient.config = test.config
+
+			err := c.WriteClient().CheckReady(context.Background())
+			assert.Equal(t, test.expectError, err != nil)
+			if test.expectDescribe {
+				mockTimestreamWriteClient.AssertNumberOfCalls(t, "DescribeEndpointsWithContext", 1)
+			} else {
+				mockTimestreamWriteClient.AssertNotCalled(t, "DescribeEndpointsWithContext", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestWriteClientWarmup(t *testing.T) {
+	oldInitWriteClient := initWriteClient
+	defer func() { initWriteClient = oldInitWriteClient }()
+
+	config := &aws.Config{Region: aws.String("us-east-1"), Credentials: credentials.NewStaticCredentials("accessKey", "secretKey", "")}
+	expectedInput := &timestreamwrite.DescribeTableInput{DatabaseName: aws.String(mockDatabaseName), TableName: aws.String(mockTableName)}
+
+	t.Run("success describing the table once per connection", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("DescribeTableWithContext", expectedInput).Return(&timestreamwrite.DescribeTableOutput{}, nil)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+		c := &Client{
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.config = config
+
+		assert.Nil(t, c.WriteClient().Warmup(context.Background(), 8))
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "DescribeTableWithContext", 8)
+	})
+
+	t.Run("error from DescribeTable()", func(t *testing.T) {
+		describeTableError := awserr.NewRequestFailure(awserr.New(timestreamwrite.ErrCodeAccessDeniedException, "", nil), http.StatusForbidden, "")
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("DescribeTableWithContext", expectedInput).Return(&timestreamwrite.DescribeTableOutput{}, nil).Times(2)
+		mockTimestreamWriteClient.On("DescribeTableWithContext", expectedInput).Return(&timestreamwrite.DescribeTableOutput{}, describeTableError)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+		c := &Client{
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.config = config
+
+		assert.Equal(t, describeTableError, c.WriteClient().Warmup(context.Background(), 3))
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "DescribeTableWithContext", 3)
+	})
+
+	t.Run("skip without default credentials", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+		c := &Client{
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.config = &aws.Config{Region: aws.String("us-east-1"), Credentials: credentials.NewStaticCredentials("", "", "")}
+
+		assert.Nil(t, c.WriteClient().Warmup(context.Background(), 3))
+		mockTimestreamWriteClient.AssertNotCalled(t, "DescribeTableWithContext", mock.Anything)
+	})
+}
+
+func TestWriteClientDumpRecords(t *testing.T) {
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
+
+	c := &Client{
+		queryClient:     nil,
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.writeClient = createNewWriteClientTemplate(c)
+	c.writeClient.dumpRecordsFile = filepath.Join(t.TempDir(), "records.jsonl")
+
+	assert.Nil(t, c.WriteClient().Write(createNewRequestTemplate(), mockCredentials))
+	assert.Nil(t, c.WriteClient().Write(createNewRequestTemplate(), mockCredentials))
+
+	content, err := os.ReadFile(c.writeClient.dumpRecordsFile)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	assert.Len(t, lines, 2)
+
+	expectedInput := createNewWriteRecordsInputTemplate()
+	for _, line := range lines {
+		var recordMap recordDestinationMap
+		assert.Nil(t, json.Unmarshal([]byte(line), &recordMap))
+		dumpedInput := &timestreamwrite.WriteRecordsInput{Records: recordMap[mockDatabaseName][mockTableName]}
+		sortRecords(dumpedInput)
+		sortRecords(expectedInput)
+		assert.Equal(t, expectedInput.Records, dumpedInput.Records)
+	}
+}
+
+func TestWriteClientDeadLetter(t *testing.T) {
+	// writeWithError writes a request failed with the error and returns the content of the dead-letter directory.
+	writeWithError := func(t *testing.T, writeErr error) (*timestreamwrite.WriteRecordsInput, []os.DirEntry) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, writeErr)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := &Client{
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.writeClient.deadLetterDir = t.TempDir()
+
+		assert.NotNil(t, c.WriteClient().Write(createNewRequestTemplate(), mockCredentials))
+		entries, err := os.ReadDir(c.writeClient.deadLetterDir)
+		assert.Nil(t, err)
+		if len(entries) != 1 {
+			return nil, entries
+		}
+
+		content, err := os.ReadFile(filepath.Join(c.writeClient.deadLetterDir, entries[0].Name()))
+		assert.Nil(t, err)
+		var input timestreamwrite.WriteRecordsInput
+		assert.Nil(t, json.Unmarshal(content, &input))
+		return &input, entries
+	}
+
+	t.Run("write the rejected records", func(t *testing.T) {
+		expectedInput := createNewWriteRecordsInputTemplate()
+		sortRecords(expectedInput)
+		input, entries := writeWithError(t, &timestreamwrite.RejectedRecordsException{
+			RespMetadata:    protocol.ResponseMetadata{StatusCode: 419},
+			RejectedRecords: []*timestreamwrite.RejectedRecord{{Reason: aws.String("Unknown."), RecordIndex: aws.Int64(0)}},
+		})
+
+		assert.Len(t, entries, 1)
+		assert.True(t, strings.HasSuffix(entries[0].Name(), fmt.Sprintf("-%s-%s.json", mockDatabaseName, mockTableName)))
+		assert.Equal(t, mockDatabaseName, aws.StringValue(input.DatabaseName))
+		assert.Equal(t, mockTableName, aws.StringValue(input.TableName))
+		assert.Len(t, input.Records, 1)
+		assert.Contains(t, expectedInput.Records, input.Records[0])
+	})
+
+	t.Run("write all records of a failed request", func(t *testing.T) {
+		expectedInput := createNewWriteRecordsInputTemplate()
+		sortRecords(expectedInput)
+		input, entries := writeWithError(t, awserr.NewRequestFailure(awserr.New("ValidationException", "", nil), http.StatusBadRequest, ""))
+
+		assert.Len(t, entries, 1)
+		sortRecords(input)
+		assert.Equal(t, expectedInput.Records, input.Records)
+	})
+
+	t.Run("skip the errors retried by Prometheus", func(t *testing.T) {
+		_, entries := writeWithError(t, awserr.NewRequestFailure(awserr.New("InternalServerException", "", nil), http.StatusInternalServerError, ""))
+		assert.Empty(t, entries)
+
+		_, entries = writeWithError(t, awserr.NewRequestFailure(awserr.New("ThrottlingException", "", nil), http.StatusTooManyRequests, ""))
+		assert.Empty(t, entries)
+	})
+}
+
+func TestWriteClientBatchSize(t *testing.T) {
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
+
+	c := NewBaseClient(mockDatabaseName, mockTableName)
+	c.NewWriteClient(mockLogger, mockAwsConfigs, mockWriteClientOptions)
+
+	assert.Nil(t, c.writeClient.Write(createNewRequestTemplate(), mockCredentials))
+
+	req := createNewRequestTemplate()
+	for i := 1; i < 3; i++ {
+		req.Timeseries[0].Samples = append(req.Timeseries[0].Samples, prompb.Sample{
+			Timestamp: mockUnixTime + int64(i),
+			Value:     measureValue,
+		})
+	}
+	assert.Nil(t, c.writeClient.Write(req, mockCredentials))
+
+	metric := prometheusClientModel.Metric{}
+	assert.Nil(t, c.writeClient.writeBatchSize.Write(&metric))
+	assert.Equal(t, uint64(2), metric.GetHistogram().GetSampleCount())
+	assert.Equal(t, float64(4), metric.GetHistogram().GetSampleSum())
+}
+
+func TestWriteClientMaxIngestRate(t *testing.T) {
+	now := time.Unix(0, mockUnixTime*nanosToMillisConversionRate)
+	oldTimeNow := timeNow
+	defer func() { timeNow = oldTimeNow }()
+	timeNow = func() time.Time { return now }
+
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
+
+	// A rate of 1 sample per second allows 10 samples within the window.
+	options := mockWriteClientOptions
+	options.MaxIngestRate = 1
+	c := NewBaseClient(mockDatabaseName, mockTableName)
+	c.NewWriteClient(mockLogger, mockAwsConfigs, options)
+
+	// writeSamples writes a time series with the number of samples.
+	writeSamples := func(count int) error {
+		timeSeries := createTimeSeriesTemplate()
+		timeSeries.Samples = nil
+		for i := 0; i < count; i++ {
+			timeSeries.Samples = append(timeSeries.Samples, prompb.Sample{Timestamp: mockUnixTime - int64(i), Value: measureValue})
+		}
+		return c.writeClient.Write(&prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{timeSeries}}, mockCredentials)
+	}
+
+	assert.Nil(t, writeSamples(6))
+	now = now.Add(time.Second)
+	assert.Nil(t, writeSamples(4))
+
+	// The rate is exceeded until the first samples leave the window.
+	now = now.Add(time.Second)
+	err := writeSamples(1)
+	assert.Equal(t, errors.NewIngestRateExceededError(1, 8*time.Second), err)
+	assert.Equal(t, 8*time.Second, err.(*errors.IngestRateExceededError).RetryAfter())
+	assert.Equal(t, 1, getCounterValue(c.writeClient.throttledRequests))
+	mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 2)
+
+	now = now.Add(8 * time.Second)
+	assert.Nil(t, writeSamples(1))
+
+	// A write request is admitted when the window is empty, even with more samples than the window allows.
+	now = now.Add(ingestRateWindow)
+	assert.Nil(t, writeSamples(50))
+	assert.Equal(t, 1, getCounterValue(c.writeClient.throttledRequests))
+	mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 4)
+}
+
+func TestWriteClientCardinalityTracking(t *testing.T) {
+	now := time.Unix(0, mockUnixTime*nanosToMillisConversionRate)
+	oldTimeNow := timeNow
+	defer func() { timeNow = oldTimeNow }()
+	timeNow = func() time.Time { return now }
+
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
+
+	var logs bytes.Buffer
+	options := mockWriteClientOptions
+	options.CardinalityTracking = 3
+	c := NewBaseClient(mockDatabaseName, mockTableName)
+	c.NewWriteClient(log.NewLogfmtLogger(&logs), mockAwsConfigs, options)
+
+	// distinctMeasures returns the value of the gauge for the default table.
+	distinctMeasures := func() float64 {
+		metric := prometheusClientModel.Metric{}
+		assert.Nil(t, c.writeClient.distinctMeasures.WithLabelValues(mockDatabaseName, mockTableName).Write(&metric))
+		return metric.GetGauge().GetValue()
+	}
+	// writeMetrics writes a time series for each of the metric names.
+	writeMetrics := func(names ...string) {
+		req := &prompb.WriteRequest{}
+		for _, name := range names {
+			timeSeries := createTimeSeriesTemplate()
+			timeSeries.Labels[0].Value = name
+			req.Timeseries = append(req.Timeseries, timeSeries)
+		}
+		assert.Nil(t, c.writeClient.Write(req, mockCredentials))
+	}
+
+	writeMetrics("metric_1", "metric_2", "metric_1")
+	assert.Equal(t, float64(2), distinctMeasures())
+	assert.NotContains(t, logs.String(), "level=warn")
+
+	// The gauge stops at the limit, which is reported once.
+	writeMetrics("metric_3", "metric_4", "metric_5")
+	assert.Equal(t, float64(3), distinctMeasures())
+	assert.Equal(t, 1, strings.Count(logs.String(), "level=warn"))
+	assert.Contains(t, logs.String(), "The table "+mockDatabaseName+"."+mockTableName+" has received 3 distinct measure names within 1h0m0s")
+
+	// The tracked measure names are reset once the window ends.
+	now = now.Add(cardinalityWindow)
+	writeMetrics("metric_6")
+	assert.Equal(t, float64(1), distinctMeasures())
+}
+
+func TestObserveWithExemplar(t *testing.T) {
+	// exemplars returns the labels of the exemplars attached to the buckets of the histogram.
+	exemplars := func(histogram prometheus.Histogram) []map[string]string {
+		metric := prometheusClientModel.Metric{}
+		assert.Nil(t, histogram.Write(&metric))
+		var labels []map[string]string
+		for _, bucket := range metric.GetHistogram().GetBucket() {
+			if exemplar := bucket.GetExemplar(); exemplar != nil {
+				exemplarLabels := make(map[string]string)
+				for _, label := range exemplar.GetLabel() {
+					exemplarLabels[label.GetName()] = label.GetValue()
+				}
+				labels = append(labels, exemplarLabels)
+			}
+		}
+		return labels
+	}
+
+	t.Run("attach the table of the write request", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := NewBaseClient(mockDatabaseName, mockTableName)
+		c.NewWriteClient(mockLogger, mockAwsConfigs, mockWriteClientOptions)
+		assert.Nil(t, c.writeClient.Write(createNewRequestTemplate(), mockCredentials))
+
+		assert.Equal(t, []map[string]string{{"table": mockTableName}}, exemplars(c.writeClient.writeExecutionTime))
+	})
+
+	t.Run("drop empty and oversized exemplars", func(t *testing.T) {
+		histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test", Buckets: []float64{1}})
+		observeWithExemplar(histogram, 0.5, prometheus.Labels{"query_id": ""})
+		observeWithExemplar(histogram, 0.5, prometheus.Labels{"query_id": strings.Repeat("a", prometheus.ExemplarMaxRunes)})
+
+		metric := prometheusClientModel.Metric{}
+		assert.Nil(t, histogram.Write(&metric))
+		assert.Equal(t, uint64(2), metric.GetHistogram().GetSampleCount())
+		assert.Empty(t, exemplars(histogram))
+	})
+}
+
+func TestWriteClientWriteRoleARNs(t *testing.T) {
+	const roleARN = "arn:aws:iam::123456789012:role/timestream-writer"
+	sourceCredentials := credentials.NewStaticCredentials("accessKeyID", "secretAccessKey", "")
+	roleCredentials := credentials.NewStaticCredentials("roleAccessKeyID", "roleSecretAccessKey", "roleSessionToken")
+
+	var assumedRoles []string
+	originalAssumeRole := assumeRole
+	defer func() { assumeRole = originalAssumeRole }()
+	assumeRole = func(config *aws.Config, arn string) (*credentials.Credentials, error) {
+		assert.Equal(t, sourceCredentials, config.Credentials)
+		assumedRoles = append(assumedRoles, arn)
+		return roleCredentials, nil
+	}
+
+	var clientCredentials []*credentials.Credentials
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		clientCredentials = append(clientCredentials, config.Credentials)
+		return mockTimestreamWriteClient, nil
+	}
+
+	t.Run("write to a table mapped to a role with the role credentials", func(t *testing.T) {
+		options := mockWriteClientOptions
+		options.WriteRoleARNs = map[string]string{mockTableName: roleARN}
+		c := NewBaseClient(mockDatabaseName, mockTableName)
+		c.NewWriteClient(mockLogger, mockAwsConfigs, options)
+
+		assert.Nil(t, c.writeClient.Write(createNewRequestTemplate(), sourceCredentials))
+		assert.Nil(t, c.writeClient.Write(createNewRequestTemplate(), sourceCredentials))
+
+		// The role is assumed once and its credentials are reused by the following requests.
+		assert.Equal(t, []string{roleARN}, assumedRoles)
+		assert.Equal(t, []*credentials.Credentials{sourceCredentials, roleCredentials, sourceCredentials, roleCredentials}, clientCredentials)
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 2)
+	})
+
+	t.Run("write to a table without a role with the request credentials", func(t *testing.T) {
+		assumedRoles, clientCredentials = nil, nil
+		options := mockWriteClientOptions
+		options.WriteRoleARNs = map[string]string{"otherTable": roleARN}
+		c := NewBaseClient(mockDatabaseName, mockTableName)
+		c.NewWriteClient(mockLogger, mockAwsConfigs, options)
+
+		assert.Nil(t, c.writeClient.Write(createNewRequestTemplate(), sourceCredentials))
+
+		assert.Empty(t, assumedRoles)
+		assert.Equal(t, []*credentials.Credentials{sourceCredentials}, clientCredentials)
+	})
+}
+
+func TestNormalizeMeasureNames(t *testing.T) {
+	const recordingRuleName = "job:http_requests:rate5m"
+	const normalizedName = "job.http_requests.rate5m"
+
+	var writtenMeasureNames []string
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+		for _, record := range args.Get(0).(*timestreamwrite.WriteRecordsInput).Records {
+			writtenMeasureNames = append(writtenMeasureNames, aws.StringValue(record.MeasureName))
+		}
+	}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
+
+	c := &Client{
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.writeClient = createNewWriteClientTemplate(c)
+	c.writeClient.normalizeMeasureNames = true
+	c.queryClient = createNewQueryClientTemplate(c)
+	c.queryClient.normalizeMeasureNames = true
+
+	req := createNewRequestTemplate()
+	req.Timeseries[0].Labels[0].Value = recordingRuleName
+	assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+	assert.Equal(t, []string{normalizedName}, writtenMeasureNames)
+
+	queries := []*prompb.Query{
+		{
+			StartTimestampMs: mockUnixTime,
+			EndTimestampMs:   mockEndUnixTime,
+			Matchers: []*prompb.LabelMatcher{
+				createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, recordingRuleName),
+				createLabelMatcher(prompb.LabelMatcher_RE, model.MetricNameLabel, "job:.*"),
+			},
+		},
+	}
+	buildCommand, _, err := c.queryClient.buildCommands(mockLogger, queries)
+	assert.Nil(t, err)
+	assert.Equal(t, []*timestreamquery.QueryInput{
+		{
+			QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %s = '%s' AND REGEXP_LIKE(REPLACE(%s, '.', ':'), 'job:.*') AND %s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)",
+				mockDatabaseName, mockTableName, measureNameColumnName, normalizedName, measureNameColumnName, timeColumnName, startUnixInSeconds, endUnixInSeconds)),
+		},
+	}, buildCommand)
+
+	queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, &timestreamquery.QueryOutput{
+		ColumnInfo: createColumnInfo(),
+		Rows: []*timestreamquery.Row{
+			{Data: createDatumWithInstance(true, instance, measureValueStr, normalizedName, timestamp1)},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, queryResult.Timeseries, 1)
+	assert.Contains(t, queryResult.Timeseries[0].Labels, &prompb.Label{Name: model.MetricNameLabel, Value: recordingRuleName})
+}
+
+func TestMeasureNameNamespace(t *testing.T) {
+	const namespace = "federated_"
+	// The metric name exceeds the maximum measure name length, unlike the measure name stripped of the namespace.
+	measureName := strings.Repeat("m", maxMeasureNameLength)
+	metricName := namespace + measureName
+
+	var writtenMeasureNames []string
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+		for _, record := range args.Get(0).(*timestreamwrite.WriteRecordsInput).Records {
+			writtenMeasureNames = append(writtenMeasureNames, aws.StringValue(record.MeasureName))
+		}
+	}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
+
+	c := &Client{
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.writeClient = createNewWriteClientTemplate(c)
+	c.writeClient.stripNamespace = namespace
+	c.queryClient = createNewQueryClientTemplate(c)
+	c.queryClient.addNamespace = namespace
+
+	req := createNewRequestTemplate()
+	req.Timeseries[0].Labels[0].Value = metricName
+	assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+	assert.Equal(t, []string{measureName}, writtenMeasureNames)
+
+	queries := []*prompb.Query{
+		{
+			StartTimestampMs: mockUnixTime,
+			EndTimestampMs:   mockEndUnixTime,
+			Matchers: []*prompb.LabelMatcher{
+				createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+				createLabelMatcher(prompb.LabelMatcher_NEQ, model.MetricNameLabel, "up"),
+				createLabelMatcher(prompb.LabelMatcher_RE, model.MetricNameLabel, "federated_.*"),
+			},
+		},
+	}
+	buildCommand, _, err := c.queryClient.buildCommands(mockLogger, queries)
+	assert.Nil(t, err)
+	assert.Equal(t, []*timestreamquery.QueryInput{
+		{
+			QueryString: aws.String(fmt.Sprintf("SELECT * FROM %s.%s WHERE %s = '%s' AND CONCAT('%s', %s) != 'up' AND REGEXP_LIKE(CONCAT('%s', %s), 'federated_.*') AND %s BETWEEN FROM_UNIXTIME(%d) AND FROM_UNIXTIME(%d)",
+				mockDatabaseName, mockTableName, measureNameColumnName, measureName, namespace, measureNameColumnName, namespace, measureNameColumnName, timeColumnName, startUnixInSeconds, endUnixInSeconds)),
+		},
+	}, buildCommand)
+
+	queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, &timestreamquery.QueryOutput{
+		ColumnInfo: createColumnInfo(),
+		Rows: []*timestreamquery.Row{
+			{Data: createDatumWithInstance(true, instance, measureValueStr, measureName, timestamp1)},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, queryResult.Timeseries, 1)
+	assert.Contains(t, queryResult.Timeseries[0].Labels, &prompb.Label{Name: model.MetricNameLabel, Value: metricName})
+}
+
+func TestValidateLabelNames(t *testing.T) {
+	createRequest := func(labelName string) *prompb.WriteRequest {
+		req := createNewRequestTemplate()
+		req.Timeseries[0].Labels = append(req.Timeseries[0].Labels, &prompb.Label{Name: labelName, Value: "value_2"})
+		return req
+	}
+
+	for _, test := range []struct {
+		name               string
+		validateLabelNames string
+		labelName          string
+		expectedDimensions map[string]string
+		expectedError      error
+	}{
+		{
+			name:               "off",
+			validateLabelNames: OffLabelNameValidation,
+			labelName:          "label-2",
+			expectedDimensions: map[string]string{"label_1": "value_1", "label-2": "value_2"},
+		},
+		{
+			name:               "fail",
+			validateLabelNames: FailLabelNameValidation,
+			labelName:          "label-2",
+			expectedError:      errors.NewInvalidLabelNameError("label-2"),
+		},
+		{
+			name:               "sanitize",
+			validateLabelNames: SanitizeLabelNameValidation,
+			labelName:          "label-2",
+			expectedDimensions: map[string]string{"label_1": "value_1", "label_2": "value_2"},
+		},
+		{
+			name:               "sanitize a label name starting with a digit",
+			validateLabelNames: SanitizeLabelNameValidation,
+			labelName:          "2.label",
+			expectedDimensions: map[string]string{"label_1": "value_1", "_2_label": "value_2"},
+		},
+		{
+			name:               "sanitize a label name colliding with another label",
+			validateLabelNames: SanitizeLabelNameValidation,
+			labelName:          "label.1",
+			expectedError:      errors.NewInvalidLabelNameError("label.1"),
+		},
+		{
+			name:               "ignore",
+			validateLabelNames: IgnoreLabelNameValidation,
+			labelName:          "label-2",
+		},
+		{
+			name:               "valid label name",
+			validateLabelNames: FailLabelNameValidation,
+			labelName:          "label_2",
+			expectedDimensions: map[string]string{"label_1": "value_1", "label_2": "value_2"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var dimensions []map[string]string
+			mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+			mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+				for _, record := range args.Get(0).(*timestreamwrite.WriteRecordsInput).Records {
+					recordDimensions := make(map[string]string)
+					for _, dimension := range record.Dimensions {
+						recordDimensions[*dimension.Name] = *dimension.Value
+					}
+					dimensions = append(dimensions, recordDimensions)
+				}
+			}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+			initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+				return mockTimestreamWriteClient, nil
+			}
+
+			c := &Client{
+				defaultDataBase: mockDatabaseName,
+				defaultTable:    mockTableName,
+			}
+			c.writeClient = createNewWriteClientTemplate(c)
+			c.writeClient.validateLabelNames = test.validateLabelNames
+			c.writeClient.ignoredSamples = prometheus.NewCounter(prometheus.CounterOpts{Name: "ignored_samples"})
+
+			err := c.WriteClient().Write(createRequest(test.labelName), mockCredentials)
+			assert.Equal(t, test.expectedError, err)
+			if test.expectedDimensions != nil {
+				assert.Equal(t, []map[string]string{test.expectedDimensions}, dimensions)
+			} else {
+				assert.Empty(t, dimensions)
+			}
+			if test.validateLabelNames == IgnoreLabelNameValidation {
+				assert.Equal(t, 1, getCounterValue(c.writeClient.ignoredSamples))
+			}
+		})
+	}
+}
+
+func TestPerMetricStats(t *testing.T) {
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
+
+	options := mockWriteClientOptions
+	options.PerMetricStats = []string{metricName, "up"}
+	c := NewBaseClient(mockDatabaseName, mockTableName)
+	c.NewWriteClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)}, options)
+
+	// The allowlisted metrics are exposed before any sample is ingested.
+	assert.Equal(t, 2, testCollectorCount(c.writeClient.metricSamples))
+	assert.Equal(t, 0, getCounterValue(c.writeClient.metricSamples.WithLabelValues("up")))
+
+	req := createNewRequestTemplate()
+	req.Timeseries[0].Samples = append(req.Timeseries[0].Samples, prompb.Sample{Timestamp: mockUnixTime + 1, Value: measureValue})
+	otherMetric := createTimeSeriesTemplate()
+	otherMetric.Labels[0].Value = "other_metric"
+	req.Timeseries = append(req.Timeseries, otherMetric)
+	assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+
+	// Only the allowlisted metrics get a counter.
+	assert.Equal(t, 2, testCollectorCount(c.writeClient.metricSamples))
+	assert.Equal(t, 2, getCounterValue(c.writeClient.metricSamples.WithLabelValues(metricName)))
+	assert.Equal(t, 0, getCounterValue(c.writeClient.metricSamples.WithLabelValues("up")))
+
+	// The samples failed to be written are not counted.
+	mockTimestreamWriteClient.ExpectedCalls = nil
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, awserr.NewRequestFailure(awserr.New("InternalServerException", "", nil), http.StatusInternalServerError, ""))
+	assert.NotNil(t, c.WriteClient().Write(createNewRequestTemplate(), mockCredentials))
+	assert.Equal(t, 2, getCounterValue(c.writeClient.metricSamples.WithLabelValues(metricName)))
+}
+
+func TestTimestampColumns(t *testing.T) {
+	columnInfo := []*timestreamquery.ColumnInfo{
+		{Name: aws.String(measureNameColumnName), Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeVarchar)}},
+		{Name: aws.String(timeColumnName), Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeTimestamp)}},
+		{Name: aws.String(measureValueColumnName), Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeDouble)}},
+		{Name: aws.String("deployed_at"), Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeTimestamp)}},
+		{Name: aws.String(model.InstanceLabel), Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeVarchar)}},
+	}
+	queryOutput := &timestreamquery.QueryOutput{
+		ColumnInfo: columnInfo,
+		Rows: []*timestreamquery.Row{
+			{Data: []*timestreamquery.Datum{
+				{ScalarValue: aws.String(metricName)},
+				{ScalarValue: aws.String(timestamp1)},
+				{ScalarValue: aws.String(measureValueStr)},
+				{ScalarValue: aws.String("2020-10-01 15:02:03.123456789")},
+				// A varchar column holding a timestamp is read as is.
+				{ScalarValue: aws.String("2020-10-01 15:02:03.000000000")},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name             string
+		timestampColumns string
+		expectedLabels   []*prompb.Label
+	}{
+		{
+			name:             "raw timestamp columns",
+			timestampColumns: RawTimestampColumns,
+			expectedLabels: []*prompb.Label{
+				{Name: model.MetricNameLabel, Value: metricName},
+				{Name: "deployed_at", Value: "2020-10-01 15:02:03.123456789"},
+				{Name: model.InstanceLabel, Value: "2020-10-01 15:02:03.000000000"},
+			},
+		},
+		{
+			name:             "unix timestamp columns",
+			timestampColumns: UnixTimestampColumns,
+			expectedLabels: []*prompb.Label{
+				{Name: model.MetricNameLabel, Value: metricName},
+				{Name: "deployed_at", Value: "1601564523123"},
+				{Name: model.InstanceLabel, Value: "2020-10-01 15:02:03.000000000"},
+			},
+		},
+		{
+			name:             "rfc3339 timestamp columns",
+			timestampColumns: RFC3339TimestampColumns,
+			expectedLabels: []*prompb.Label{
+				{Name: model.MetricNameLabel, Value: metricName},
+				{Name: "deployed_at", Value: "2020-10-01T15:02:03.123456789Z"},
+				{Name: model.InstanceLabel, Value: "2020-10-01 15:02:03.000000000"},
+			},
+		},
+		{
+			name:             "dropped timestamp columns",
+			timestampColumns: DropTimestampColumns,
+			expectedLabels: []*prompb.Label{
+				{Name: model.MetricNameLabel, Value: metricName},
+				{Name: model.InstanceLabel, Value: "2020-10-01 15:02:03.000000000"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Client{
+				defaultDataBase: mockDatabaseName,
+				defaultTable:    mockTableName,
+			}
+			c.queryClient = createNewQueryClientTemplate(c)
+			c.queryClient.timestampColumns = test.timestampColumns
+
+			queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, queryOutput)
+			assert.Nil(t, err)
+			assert.Len(t, queryResult.Timeseries, 1)
+			assert.ElementsMatch(t, test.expectedLabels, queryResult.Timeseries[0].Labels)
+			assert.Len(t, queryResult.Timeseries[0].Samples, 1)
+		})
+	}
+}
+
+func TestMeasureValueType(t *testing.T) {
+	tests := []struct {
+		name             string
+		measureValueType string
+		expectedTypes    []string
+		expectedValues   []string
+		expectedIgnored  int
+	}{
+		{
+			name:             "double measure value type",
+			measureValueType: DoubleMeasureValueType,
+			expectedTypes:    []string{timestreamwrite.MeasureValueTypeDouble, timestreamwrite.MeasureValueTypeDouble, timestreamwrite.MeasureValueTypeDouble, timestreamwrite.MeasureValueTypeDouble},
+			expectedValues:   []string{"42.000000", "-7.000000", "1.500000", "10000000000000000000.000000"},
+		},
+		{
+			name:             "bigint measure value type",
+			measureValueType: BigintMeasureValueType,
+			expectedTypes:    []string{timestreamwrite.MeasureValueTypeBigint, timestreamwrite.MeasureValueTypeBigint},
+			expectedValues:   []string{"42", "-7"},
+			expectedIgnored:  2,
+		},
+		{
+			name:             "auto measure value type",
+			measureValueType: AutoMeasureValueType,
+			expectedTypes:    []string{timestreamwrite.MeasureValueTypeBigint, timestreamwrite.MeasureValueTypeBigint, timestreamwrite.MeasureValueTypeDouble, timestreamwrite.MeasureValueTypeDouble},
+			expectedValues:   []string{"42", "-7", "1.500000", "10000000000000000000.000000"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var writtenRecords []*timestreamwrite.Record
+			mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+			mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+				writtenRecords = append(writtenRecords, args.Get(0).(*timestreamwrite.WriteRecordsInput).Records...)
+			}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+			initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+				return mockTimestreamWriteClient, nil
+			}
+
+			c := &Client{
+				defaultDataBase: mockDatabaseName,
+				defaultTable:    mockTableName,
+			}
+			c.writeClient = createNewWriteClientTemplate(c)
+			c.writeClient.measureValueType = test.measureValueType
+			c.writeClient.ignoredSamples = prometheus.NewCounter(prometheus.CounterOpts{Name: "ignored_samples"})
+
+			req := createNewRequestTemplate()
+			req.Timeseries[0].Samples = []prompb.Sample{
+				{Timestamp: mockUnixTime, Value: 42},
+				{Timestamp: mockUnixTime + 1, Value: -7},
+				{Timestamp: mockUnixTime + 2, Value: 1.5},
+				// Integral values beyond the range of a 64-bit integer are not written as BIGINT.
+				{Timestamp: mockUnixTime + 3, Value: 1e19},
+			}
+			assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+
+			var types, values []string
+			for _, record := range writtenRecords {
+				types = append(types, aws.StringValue(record.MeasureValueType))
+				values = append(values, aws.StringValue(record.MeasureValue))
+			}
+			assert.Equal(t, test.expectedTypes, types)
+			assert.Equal(t, test.expectedValues, values)
+			assert.Equal(t, test.expectedIgnored, getCounterValue(c.writeClient.ignoredSamples))
+		})
+	}
+
+	t.Run("read the bigint measure values", func(t *testing.T) {
+		c := &Client{
+			defaultDataBase: mockDatabaseName,
+			defaultTable:    mockTableName,
+		}
+		c.queryClient = createNewQueryClientTemplate(c)
+
+		columnInfo := []*timestreamquery.ColumnInfo{
+			{Name: aws.String(measureNameColumnName)},
+			{Name: aws.String(timeColumnName)},
+			{Name: aws.String(measureValueColumnName)},
+			{Name: aws.String("measure_value::bigint")},
+		}
+		queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, &timestreamquery.QueryOutput{
+			ColumnInfo: columnInfo,
+			Rows: []*timestreamquery.Row{
+				{Data: []*timestreamquery.Datum{{ScalarValue: aws.String(metricName)}, {ScalarValue: aws.String(timestamp1)}, {NullValue: aws.Bool(true)}, {ScalarValue: aws.String("42")}}},
+				{Data: []*timestreamquery.Datum{{ScalarValue: aws.String(metricName)}, {ScalarValue: aws.String(timestamp2)}, {ScalarValue: aws.String("1.5")}, {NullValue: aws.Bool(true)}}},
+			},
+		})
+		assert.Nil(t, err)
+		assert.Len(t, queryResult.Timeseries, 1)
+		assert.Equal(t, []prompb.Sample{
+			{Timestamp: queryResult.Timeseries[0].Samples[0].Timestamp, Value: 42},
+			{Timestamp: queryResult.Timeseries[0].Samples[1].Timestamp, Value: 1.5},
+		}, queryResult.Timeseries[0].Samples)
+	})
+}
+
+func TestBooleanMetrics(t *testing.T) {
+	var writtenRecords []*timestreamwrite.Record
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+		writtenRecords = append(writtenRecords, args.Get(0).(*timestreamwrite.WriteRecordsInput).Records...)
+	}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
+
+	c := &Client{
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.writeClient = createNewWriteClientTemplate(c)
+	c.writeClient.booleanMetrics = regexp.MustCompile(`^(?:up|.*_info)$`)
+	c.writeClient.ignoredSamples = prometheus.NewCounter(prometheus.CounterOpts{Name: "ignored_samples"})
+	c.queryClient = createNewQueryClientTemplate(c)
+
+	req := createNewRequestTemplate()
+	req.Timeseries[0].Labels[0].Value = "up"
+	req.Timeseries[0].Samples = []prompb.Sample{
+		{Timestamp: mockUnixTime, Value: 1},
+		{Timestamp: mockUnixTime + 1, Value: 0},
+		{Timestamp: mockUnixTime + 2, Value: 2},
+	}
+	// The metrics not matching the boolean metrics are written as doubles.
+	req.Timeseries = append(req.Timeseries, createTimeSeriesTemplate())
+	assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+
+	assert.Len(t, writtenRecords, 3)
+	assert.Equal(t, timestreamwrite.MeasureValueTypeBoolean, aws.StringValue(writtenRecords[0].MeasureValueType))
+	assert.Equal(t, "true", aws.StringValue(writtenRecords[0].MeasureValue))
+	assert.Equal(t, timestreamwrite.MeasureValueTypeBoolean, aws.StringValue(writtenRecords[1].MeasureValueType))
+	assert.Equal(t, "false", aws.StringValue(writtenRecords[1].MeasureValue))
+	assert.Equal(t, timestreamwrite.MeasureValueTypeDouble, aws.StringValue(writtenRecords[2].MeasureValueType))
+	assert.Equal(t, measureValueStr, aws.StringValue(writtenRecords[2].MeasureValue))
+	assert.Equal(t, 1, getCounterValue(c.writeClient.ignoredSamples))
+
+	columnInfo := []*timestreamquery.ColumnInfo{
+		{Name: aws.String(measureNameColumnName)},
+		{Name: aws.String(timeColumnName)},
+		{Name: aws.String(measureValueColumnName)},
+		{Name: aws.String("measure_value::boolean")},
+	}
+	queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, &timestreamquery.QueryOutput{
+		ColumnInfo: columnInfo,
+		Rows: []*timestreamquery.Row{
+			{Data: []*timestreamquery.Datum{{ScalarValue: aws.String("up")}, {ScalarValue: aws.String(timestamp1)}, {NullValue: aws.Bool(true)}, {ScalarValue: aws.String("true")}}},
+			{Data: []*timestreamquery.Datum{{ScalarValue: aws.String("up")}, {ScalarValue: aws.String(timestamp2)}, {NullValue: aws.Bool(true)}, {ScalarValue: aws.String("false")}}},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, queryResult.Timeseries, 1)
+	assert.Equal(t, []*prompb.Label{{Name: model.MetricNameLabel, Value: "up"}}, queryResult.Timeseries[0].Labels)
+	assert.Len(t, queryResult.Timeseries[0].Samples, 2)
+	assert.Equal(t, float64(1), queryResult.Timeseries[0].Samples[0].Value)
+	assert.Equal(t, float64(0), queryResult.Timeseries[0].Samples[1].Value)
+}
+
+func TestNumericQuantiles(t *testing.T) {
+	var writtenRecords []*timestreamwrite.Record
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+		writtenRecords = append(writtenRecords, args.Get(0).(*timestreamwrite.WriteRecordsInput).Records...)
+	}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
 
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	c := &Client{
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.writeClient = createNewWriteClientTemplate(c)
+	c.writeClient.numericQuantiles = true
+	c.queryClient = createNewQueryClientTemplate(c)
+	c.queryClient.numericQuantiles = true
+
+	req := createNewRequestTemplate()
+	req.Timeseries[0].Labels = append(req.Timeseries[0].Labels, &prompb.Label{Name: model.QuantileLabel, Value: "0.99"})
+	// The time series without a numeric quantile are written as single-measure records.
+	req.Timeseries = append(req.Timeseries, createTimeSeriesTemplate())
+	invalidQuantile := createTimeSeriesTemplate()
+	invalidQuantile.Labels = append(invalidQuantile.Labels, &prompb.Label{Name: model.QuantileLabel, Value: "p99"})
+	req.Timeseries = append(req.Timeseries, invalidQuantile)
+	assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+
+	assert.Len(t, writtenRecords, 3)
+	assert.Equal(t, timestreamwrite.MeasureValueTypeMulti, aws.StringValue(writtenRecords[0].MeasureValueType))
+	assert.Equal(t, metricName, aws.StringValue(writtenRecords[0].MeasureName))
+	assert.Nil(t, writtenRecords[0].MeasureValue)
+	assert.Contains(t, writtenRecords[0].Dimensions, &timestreamwrite.Dimension{Name: aws.String(model.QuantileLabel), Value: aws.String("0.99")})
+	assert.Equal(t, []*timestreamwrite.MeasureValue{
+		{Name: aws.String(QuantileSampleMeasureName), Value: aws.String(measureValueStr), Type: aws.String(timestreamwrite.MeasureValueTypeDouble)},
+		{Name: aws.String(QuantileMeasureName), Value: aws.String("0.99"), Type: aws.String(timestreamwrite.MeasureValueTypeDouble)},
+	}, writtenRecords[0].MeasureValues)
+	assert.Equal(t, timestreamwrite.MeasureValueTypeDouble, aws.StringValue(writtenRecords[1].MeasureValueType))
+	assert.Equal(t, timestreamwrite.MeasureValueTypeDouble, aws.StringValue(writtenRecords[2].MeasureValueType))
+
+	columnInfo := []*timestreamquery.ColumnInfo{
+		{Name: aws.String(model.QuantileLabel), Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeVarchar)}},
+		{Name: aws.String(measureNameColumnName), Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeVarchar)}},
+		{Name: aws.String(timeColumnName), Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeTimestamp)}},
+		{Name: aws.String(measureValueColumnName), Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeDouble)}},
+		{Name: aws.String(QuantileSampleMeasureName), Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeDouble)}},
+		{Name: aws.String(QuantileMeasureName), Type: &timestreamquery.Type{ScalarType: aws.String(timestreamquery.ScalarTypeDouble)}},
+	}
+	queryResult, err := c.queryClient.convertToResult(mockLogger, &prompb.QueryResult{}, &timestreamquery.QueryOutput{
+		ColumnInfo: columnInfo,
+		Rows: []*timestreamquery.Row{
+			{Data: []*timestreamquery.Datum{{ScalarValue: aws.String("0.99")}, {ScalarValue: aws.String(metricName)}, {ScalarValue: aws.String(timestamp1)}, {NullValue: aws.Bool(true)}, {ScalarValue: aws.String(measureValueStr)}, {ScalarValue: aws.String("0.99")}}},
+			{Data: []*timestreamquery.Datum{{NullValue: aws.Bool(true)}, {ScalarValue: aws.String(metricName)}, {ScalarValue: aws.String(timestamp1)}, {ScalarValue: aws.String("2.5")}, {NullValue: aws.Bool(true)}, {NullValue: aws.Bool(true)}}},
+		},
 	})
+	assert.Nil(t, err)
+	assert.Len(t, queryResult.Timeseries, 2)
+	assert.Equal(t, []*prompb.Label{{Name: model.QuantileLabel, Value: "0.99"}, {Name: model.MetricNameLabel, Value: metricName}}, queryResult.Timeseries[0].Labels)
+	assert.Equal(t, measureValue, queryResult.Timeseries[0].Samples[0].Value)
+	assert.Equal(t, []*prompb.Label{{Name: model.MetricNameLabel, Value: metricName}}, queryResult.Timeseries[1].Labels)
+	assert.Equal(t, 2.5, queryResult.Timeseries[1].Samples[0].Value)
+}
 
-	t.Run("error from WriteRecords()", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
-		expectedInput := createNewWriteRecordsInputTemplate()
-		requestError := &timestreamwrite.ValidationException{
-			RespMetadata: protocol.ResponseMetadata{StatusCode: 404},
-		}
+func TestWriteConcurrency(t *testing.T) {
+	validationError := awserr.NewRequestFailure(awserr.New(timestreamwrite.ErrCodeValidationException, "", nil), http.StatusBadRequest, "")
+	internalServerError := awserr.NewRequestFailure(awserr.New(timestreamwrite.ErrCodeInternalServerException, "", nil), http.StatusInternalServerError, "")
+
+	tests := []struct {
+		name                string
+		writeConcurrency    int
+		tableErrors         map[string]error
+		expectedMaxInFlight int32
+		expectedError       error
+	}{
+		{
+			name:                "sequential writes by default",
+			writeConcurrency:    1,
+			expectedMaxInFlight: 1,
+		},
+		{
+			name:                "concurrent writes up to the write concurrency",
+			writeConcurrency:    3,
+			expectedMaxInFlight: 3,
+		},
+		{
+			name:                "server error returned over a client error",
+			writeConcurrency:    3,
+			tableErrors:         map[string]error{"table1": validationError, "table4": internalServerError},
+			expectedMaxInFlight: 3,
+			expectedError:       internalServerError,
+		},
+		{
+			name:                "client error returned",
+			writeConcurrency:    6,
+			tableErrors:         map[string]error{"table2": validationError},
+			expectedMaxInFlight: 6,
+			expectedError:       validationError,
+		},
+	}
 
-		mockTimestreamWriteClient.On(
-			"WriteRecords",
-			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
-				sortRecords(writeInput)
-				sortRecords(expectedInput)
-				return reflect.DeepEqual(writeInput, expectedInput)
-			})).Return(&timestreamwrite.WriteRecordsOutput{}, requestError)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var inFlight, maxInFlight int32
+			mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+			for i := 0; i < 6; i++ {
+				table := fmt.Sprintf("table%d", i)
+				mockTimestreamWriteClient.On("WriteRecords", mock.MatchedBy(func(input *timestreamwrite.WriteRecordsInput) bool {
+					return aws.StringValue(input.TableName) == table
+				})).Run(func(args mock.Arguments) {
+					current := atomic.AddInt32(&inFlight, 1)
+					for {
+						previous := atomic.LoadInt32(&maxInFlight)
+						if current <= previous || atomic.CompareAndSwapInt32(&maxInFlight, previous, current) {
+							break
+						}
+					}
+					time.Sleep(20 * time.Millisecond)
+					atomic.AddInt32(&inFlight, -1)
+				}).Return(&timestreamwrite.WriteRecordsOutput{}, test.tableErrors[table])
+			}
+			initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+				return mockTimestreamWriteClient, nil
+			}
+
+			c := &Client{
+				defaultDataBase: mockDatabaseName,
+				defaultTable:    mockTableName,
+			}
+			c.writeClient = createNewWriteClientTemplate(c)
+			c.writeClient.tableLabel = "table"
+			c.writeClient.writeConcurrency = test.writeConcurrency
+			c.writeClient.writeRequests = prometheus.NewCounter(prometheus.CounterOpts{Name: "write_requests"})
+
+			req := &prompb.WriteRequest{}
+			for i := 0; i < 6; i++ {
+				timeSeries := createTimeSeriesTemplate()
+				timeSeries.Labels = append(timeSeries.Labels, &prompb.Label{Name: "table", Value: fmt.Sprintf("table%d", i)})
+				req.Timeseries = append(req.Timeseries, timeSeries)
+			}
+			err := c.WriteClient().Write(req, mockCredentials)
+			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expectedMaxInFlight, maxInFlight)
+			mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 6)
+			// Each WriteRecords request is still counted.
+			assert.Equal(t, 6, getCounterValue(c.writeClient.writeRequests))
+		})
+	}
+}
 
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
-		}
+func TestDestinationLabels(t *testing.T) {
+	var writtenInputs []*timestreamwrite.WriteRecordsInput
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+		writtenInputs = append(writtenInputs, args.Get(0).(*timestreamwrite.WriteRecordsInput))
+	}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
 
-		c := &Client{
-			queryClient:     nil,
-			defaultDataBase: mockDatabaseName,
-			defaultTable:    mockTableName,
+	c := &Client{
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.writeClient = createNewWriteClientTemplate(c)
+	c.writeClient.databaseLabel = "database"
+	c.writeClient.tableLabel = "table"
+
+	routed := createTimeSeriesTemplate()
+	routed.Labels = append(routed.Labels, &prompb.Label{Name: "database", Value: "otherDatabase"}, &prompb.Label{Name: "table", Value: "otherTable"})
+	tableOnly := createTimeSeriesTemplate()
+	tableOnly.Labels = append(tableOnly.Labels, &prompb.Label{Name: "table", Value: "otherTable"})
+	// The time series with an empty destination label are written to the default destination.
+	emptyTable := createTimeSeriesTemplate()
+	emptyTable.Labels = append(emptyTable.Labels, &prompb.Label{Name: "table", Value: ""})
+	req := &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{routed, tableOnly, createTimeSeriesTemplate(), emptyTable}}
+	assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+
+	writtenRecords := make(map[string][]*timestreamwrite.Record)
+	for _, input := range writtenInputs {
+		destination := aws.StringValue(input.DatabaseName) + "." + aws.StringValue(input.TableName)
+		writtenRecords[destination] = append(writtenRecords[destination], input.Records...)
+	}
+	assert.Len(t, writtenRecords, 3)
+	assert.Len(t, writtenRecords["otherDatabase.otherTable"], 1)
+	assert.Len(t, writtenRecords[mockDatabaseName+".otherTable"], 1)
+	assert.Len(t, writtenRecords[mockDatabaseName+"."+mockTableName], 2)
+
+	// The destination labels are not written as dimensions.
+	for _, records := range writtenRecords {
+		for _, record := range records {
+			assert.Equal(t, createNewRecordTemplate().Dimensions, record.Dimensions)
 		}
-		c.writeClient = createNewWriteClientTemplate(c)
-
-		err := c.WriteClient().Write(createNewRequestTemplate(), mockCredentials)
-		assert.Equal(t, requestError, err)
+	}
+}
 
-		mockTimestreamWriteClient.AssertExpectations(t)
-	})
+func TestAutoCreateDestinations(t *testing.T) {
+	notFoundError := awserr.NewRequestFailure(awserr.New(timestreamwrite.ErrCodeResourceNotFoundException, "The table does not exist.", nil), http.StatusNotFound, "")
+	conflictError := awserr.NewRequestFailure(awserr.New(timestreamwrite.ErrCodeConflictException, "The database already exists.", nil), http.StatusConflict, "")
 
-	t.Run("valid timeSeries with fail-fast enabled", func(t *testing.T) {
+	t.Run("success creating the missing destination then retrying the write", func(t *testing.T) {
 		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
-		expectedInput := createNewWriteRecordsInputTemplate()
-		mockTimestreamWriteClient.On(
-			"WriteRecords",
-			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
-				sortRecords(writeInput)
-				sortRecords(expectedInput)
-				return reflect.DeepEqual(writeInput, expectedInput)
-			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
-
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, notFoundError).Once()
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil).Once()
+		mockTimestreamWriteClient.On("CreateDatabase", &timestreamwrite.CreateDatabaseInput{
+			DatabaseName: aws.String(mockDatabaseName),
+		}).Return(&timestreamwrite.CreateDatabaseOutput{}, conflictError)
+		mockTimestreamWriteClient.On("CreateTable", &timestreamwrite.CreateTableInput{
+			DatabaseName: aws.String(mockDatabaseName),
+			TableName:    aws.String(mockTableName),
+			RetentionProperties: &timestreamwrite.RetentionProperties{
+				MemoryStoreRetentionPeriodInHours:  aws.Int64(12),
+				MagneticStoreRetentionPeriodInDays: aws.Int64(defaultMagneticStoreRetentionDays),
+			},
+		}).Return(&timestreamwrite.CreateTableOutput{}, nil)
 		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
 			return mockTimestreamWriteClient, nil
 		}
@@ -796,18 +4655,27 @@ func TestWriteClientWrite(t *testing.T) {
 			defaultTable:    mockTableName,
 		}
 		c.writeClient = createNewWriteClientTemplate(c)
-		c.writeClient.failOnInvalidSample = true
-
-		req := createNewRequestTemplate()
-		err := c.WriteClient().Write(req, mockCredentials)
-		assert.Nil(t, err)
+		c.writeClient.autoCreateDestinations = true
+		c.writeClient.memoryStoreRetention = 12 * time.Hour
+		c.writeClient.createdDestinations = make(map[string]struct{})
 
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+		assert.Nil(t, c.WriteClient().Write(createNewRequestTemplate(), mockCredentials))
+		mockTimestreamWriteClient.AssertExpectations(t)
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 2)
+
+		// The destination is created once, even if the write requests keep failing until the table is active.
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, notFoundError).Twice()
+		assert.Equal(t, notFoundError, c.WriteClient().Write(createNewRequestTemplate(), mockCredentials))
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 4)
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "CreateDatabase", 1)
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "CreateTable", 1)
 	})
 
-	t.Run("NaN timeSeries with fail-fast enabled", func(t *testing.T) {
+	t.Run("error creating the missing destination", func(t *testing.T) {
 		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
-
+		accessDeniedError := awserr.NewRequestFailure(awserr.New(timestreamwrite.ErrCodeAccessDeniedException, "", nil), http.StatusForbidden, "")
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, notFoundError)
+		mockTimestreamWriteClient.On("CreateDatabase", mock.Anything).Return(&timestreamwrite.CreateDatabaseOutput{}, accessDeniedError)
 		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
 			return mockTimestreamWriteClient, nil
 		}
@@ -818,19 +4686,17 @@ func TestWriteClientWrite(t *testing.T) {
 			defaultTable:    mockTableName,
 		}
 		c.writeClient = createNewWriteClientTemplate(c)
-		c.writeClient.failOnInvalidSample = true
-
-		req := createNewRequestTemplate()
-		req.Timeseries[0].Samples[0].Value = math.NaN()
-		err := c.WriteClient().Write(req, mockCredentials)
-		assert.IsType(t, &errors.InvalidSampleValueError{}, err)
+		c.writeClient.autoCreateDestinations = true
+		c.writeClient.createdDestinations = make(map[string]struct{})
 
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+		assert.Equal(t, accessDeniedError, c.WriteClient().Write(createNewRequestTemplate(), mockCredentials))
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+		mockTimestreamWriteClient.AssertNotCalled(t, "CreateTable", mock.Anything)
 	})
 
-	t.Run("NaN timeSeries with fail-fast disabled", func(t *testing.T) {
+	t.Run("not found error without creating the destination", func(t *testing.T) {
 		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
-
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, notFoundError)
 		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
 			return mockTimestreamWriteClient, nil
 		}
@@ -841,184 +4707,344 @@ func TestWriteClientWrite(t *testing.T) {
 			defaultTable:    mockTableName,
 		}
 		c.writeClient = createNewWriteClientTemplate(c)
-		c.writeClient.failOnInvalidSample = false
-
-		req := createNewRequestTemplate()
-		req.Timeseries[0].Samples[0].Value = math.NaN()
-		err := c.WriteClient().Write(req, mockCredentials)
-		assert.Nil(t, err)
 
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+		assert.Equal(t, notFoundError, c.WriteClient().Write(createNewRequestTemplate(), mockCredentials))
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+		mockTimestreamWriteClient.AssertNotCalled(t, "CreateDatabase", mock.Anything)
 	})
+}
 
-	t.Run("Inf timeSeries with fail-fast enabled", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+func TestMaxReadCost(t *testing.T) {
+	hourQuery := func(matchers ...*prompb.LabelMatcher) *prompb.Query {
+		return &prompb.Query{StartTimestampMs: mockUnixTime, EndTimestampMs: mockUnixTime + time.Hour.Milliseconds(), Matchers: matchers}
+	}
 
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
+	c := &Client{
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.queryClient = createNewQueryClientTemplate(c)
+
+	assert.Equal(t, 1.0, c.queryClient.estimateReadCost(mockLogger, []*prompb.Query{
+		hourQuery(createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName)),
+	}))
+	assert.Equal(t, 0.25, c.queryClient.estimateReadCost(mockLogger, []*prompb.Query{
+		hourQuery(
+			createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+			createLabelMatcher(prompb.LabelMatcher_EQ, model.InstanceLabel, instance),
+			createLabelMatcher(prompb.LabelMatcher_EQ, model.JobLabel, job),
+			createLabelMatcher(prompb.LabelMatcher_RE, model.QuantileLabel, ".*"),
+		),
+	}))
+	// The queries without a single metric name scan every measure of the tables.
+	assert.Equal(t, 10.0, c.queryClient.estimateReadCost(mockLogger, []*prompb.Query{
+		hourQuery(createLabelMatcher(prompb.LabelMatcher_RE, model.MetricNameLabel, metricName+"|up")),
+	}))
+
+	// The cost is summed over the queries and read destinations.
+	c.queryClient.readTables = []string{mockTableName, "other_table"}
+	assert.Equal(t, 4.0, c.queryClient.estimateReadCost(mockLogger, []*prompb.Query{
+		hourQuery(createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName)),
+		hourQuery(createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, "up")),
+	}))
+
+	t.Run("reject the read request over the max read cost", func(t *testing.T) {
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
 		}
 
 		c := &Client{
-			queryClient:     nil,
 			defaultDataBase: mockDatabaseName,
 			defaultTable:    mockTableName,
 		}
-		c.writeClient = createNewWriteClientTemplate(c)
-		c.writeClient.failOnInvalidSample = true
-
-		req := createNewRequestTemplate()
-		req.Timeseries[0].Samples[0].Value = math.Inf(1)
-		err := c.WriteClient().Write(req, mockCredentials)
-		assert.NotNil(t, err)
-
-		req.Timeseries[0].Samples[0].Value = math.Inf(-1)
-		err = c.WriteClient().Write(req, mockCredentials)
-		assert.IsType(t, &errors.InvalidSampleValueError{}, err)
-
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.maxReadCost = 4
+
+		readResponse, err := c.queryClient.Read(&prompb.ReadRequest{Queries: []*prompb.Query{
+			hourQuery(createLabelMatcher(prompb.LabelMatcher_EQ, model.JobLabel, job)),
+		}}, mockCredentials)
+		assert.IsType(t, &errors.MaxReadCostError{}, err)
+		assert.Nil(t, readResponse)
+		mockTimestreamQueryClient.AssertNotCalled(t, "QueryPages", mock.Anything, mock.Anything)
 	})
 
-	t.Run("Inf timeSeries with fail-fast disabled", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
-
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
+	t.Run("success reading within the max read cost", func(t *testing.T) {
+		mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+		mockTimestreamQueryClient.On("QueryPages", mock.Anything, mock.Anything).Return(nil)
+		initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+			return mockTimestreamQueryClient, nil
 		}
 
 		c := &Client{
-			queryClient:     nil,
 			defaultDataBase: mockDatabaseName,
 			defaultTable:    mockTableName,
 		}
-		c.writeClient = createNewWriteClientTemplate(c)
-		c.writeClient.failOnInvalidSample = false
-
-		req := createNewRequestTemplate()
-		req.Timeseries[0].Samples[0].Value = math.Inf(1)
-		err := c.WriteClient().Write(req, mockCredentials)
-		assert.Nil(t, err)
+		c.queryClient = createNewQueryClientTemplate(c)
+		c.queryClient.maxReadCost = 5
 
-		req.Timeseries[0].Samples[0].Value = math.Inf(-1)
-		err = c.WriteClient().Write(req, mockCredentials)
+		_, err := c.queryClient.Read(&prompb.ReadRequest{Queries: []*prompb.Query{
+			hourQuery(
+				createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+				createLabelMatcher(prompb.LabelMatcher_EQ, model.JobLabel, job),
+			),
+		}}, mockCredentials)
 		assert.Nil(t, err)
-
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+		mockTimestreamQueryClient.AssertNumberOfCalls(t, "QueryPages", 1)
 	})
+}
 
-	t.Run("long metric name with fail-fast enabled", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+func TestMultiMeasureRecords(t *testing.T) {
+	var writtenRecords []*timestreamwrite.Record
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+		writtenRecords = append(writtenRecords, args.Get(0).(*timestreamwrite.WriteRecordsInput).Records...)
+	}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
 
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
-		}
+	c := &Client{
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.writeClient = createNewWriteClientTemplate(c)
+	c.writeClient.enableMultiMeasure = true
+
+	req := createNewRequestTemplate()
+	req.Timeseries[0].Samples = append(req.Timeseries[0].Samples, prompb.Sample{Timestamp: mockUnixTime + 1, Value: measureValue})
+	// A metric with the same labels and timestamp is grouped into the same multi-measure record.
+	otherMetric := createTimeSeriesTemplate()
+	otherMetric.Labels[0].Value = "other_metric"
+	otherMetric.Samples[0].Value = 2
+	// A metric with different labels is written to a separate multi-measure record.
+	otherLabels := createTimeSeriesTemplate()
+	otherLabels.Labels[1].Value = "value_2"
+	// A sample of a metric already grouped at the same time is written to a separate multi-measure record.
+	duplicate := createTimeSeriesTemplate()
+	duplicate.Samples[0].Value = 3
+	req.Timeseries = append(req.Timeseries, otherMetric, otherLabels, duplicate)
+	assert.Nil(t, c.WriteClient().Write(req, mockCredentials))
+
+	assert.Len(t, writtenRecords, 4)
+	for _, record := range writtenRecords {
+		assert.Equal(t, MultiMeasureName, aws.StringValue(record.MeasureName))
+		assert.Equal(t, timestreamwrite.MeasureValueTypeMulti, aws.StringValue(record.MeasureValueType))
+		assert.Nil(t, record.MeasureValue)
+	}
 
-		c := &Client{
-			queryClient:     nil,
-			defaultDataBase: mockDatabaseName,
-			defaultTable:    mockTableName,
-		}
-		c.writeClient = createNewWriteClientTemplate(c)
-		c.writeClient.failOnLongMetricLabelName = true
+	assert.Equal(t, strconv.FormatInt(mockUnixTime, 10), aws.StringValue(writtenRecords[0].Time))
+	assert.Equal(t, []*timestreamwrite.MeasureValue{
+		{Name: aws.String(metricName), Value: aws.String(measureValueStr), Type: aws.String(timestreamwrite.MeasureValueTypeDouble)},
+		{Name: aws.String("other_metric"), Value: aws.String("2.000000"), Type: aws.String(timestreamwrite.MeasureValueTypeDouble)},
+	}, writtenRecords[0].MeasureValues)
 
-		req := createNewRequestTemplate()
-		req.Timeseries[0].Labels[0].Value = mockLongMetric
-		err := c.WriteClient().Write(req, mockCredentials)
-		assert.IsType(t, &errors.LongLabelNameError{}, err)
+	assert.Equal(t, strconv.FormatInt(mockUnixTime+1, 10), aws.StringValue(writtenRecords[1].Time))
+	assert.Len(t, writtenRecords[1].MeasureValues, 1)
 
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
-	})
+	assert.Equal(t, "value_2", aws.StringValue(writtenRecords[2].Dimensions[0].Value))
+	assert.Len(t, writtenRecords[2].MeasureValues, 1)
 
-	t.Run("long metric name with fail-fast disabled", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	assert.Equal(t, strconv.FormatInt(mockUnixTime, 10), aws.StringValue(writtenRecords[3].Time))
+	assert.Equal(t, []*timestreamwrite.MeasureValue{
+		{Name: aws.String(metricName), Value: aws.String("3.000000"), Type: aws.String(timestreamwrite.MeasureValueTypeDouble)},
+	}, writtenRecords[3].MeasureValues)
+}
 
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
-		}
+func TestGroupMultiMeasureRecordsLimit(t *testing.T) {
+	var records []*timestreamwrite.Record
+	for i := 0; i <= maxMeasuresPerRecord; i++ {
+		record := createNewRecordTemplate()
+		record.MeasureName = aws.String(fmt.Sprintf("metric_%d", i))
+		record.Version = aws.Int64(int64(i))
+		records = append(records, record)
+	}
 
-		c := &Client{
-			queryClient:     nil,
-			defaultDataBase: mockDatabaseName,
-			defaultTable:    mockTableName,
-		}
-		c.writeClient = createNewWriteClientTemplate(c)
-		c.writeClient.failOnLongMetricLabelName = false
+	grouped := groupMultiMeasureRecords(records)
+	assert.Len(t, grouped, 2)
+	assert.Len(t, grouped[0].MeasureValues, maxMeasuresPerRecord)
+	assert.Equal(t, int64(maxMeasuresPerRecord-1), aws.Int64Value(grouped[0].Version))
+	assert.Len(t, grouped[1].MeasureValues, 1)
+	assert.Equal(t, fmt.Sprintf("metric_%d", maxMeasuresPerRecord), aws.StringValue(grouped[1].MeasureValues[0].Name))
+}
 
-		req := createNewRequestTemplate()
-		req.Timeseries[0].Labels[0].Value = mockLongMetric
-		err := c.WriteClient().Write(req, mockCredentials)
-		assert.Nil(t, err)
+func TestWriteClientRecentErrorRatio(t *testing.T) {
+	oldTimeNow := timeNow
+	defer func() { timeNow = oldTimeNow }()
+	now := time.Unix(startUnixInSeconds, 0)
+	timeNow = func() time.Time { return now }
+
+	serverError := awserr.NewRequestFailure(awserr.New("InternalServerException", "", nil), http.StatusInternalServerError, "")
+	throttlingError := awserr.NewRequestFailure(awserr.New("ThrottlingException", "", nil), http.StatusTooManyRequests, "")
+	validationError := awserr.NewRequestFailure(awserr.New("ValidationException", "", nil), http.StatusBadRequest, "")
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, serverError).Once()
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, throttlingError).Once()
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, validationError).Once()
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
 
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
-	})
+	c := &Client{
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.writeClient = createNewWriteClientTemplate(c)
 
-	t.Run("long dimension name with fail-fast enabled", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	errorRatio, writes := c.writeClient.RecentErrorRatio()
+	assert.Equal(t, float64(0), errorRatio)
+	assert.Equal(t, 0, writes)
 
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
-		}
+	// The server errors and the throttling count as failures, unlike the requests rejected for their data.
+	for i := 0; i < 4; i++ {
+		c.WriteClient().Write(createNewRequestTemplate(), mockCredentials)
+	}
+	errorRatio, writes = c.writeClient.RecentErrorRatio()
+	assert.Equal(t, 0.5, errorRatio)
+	assert.Equal(t, 4, writes)
+
+	now = now.Add(healthWindow / 2)
+	c.WriteClient().Write(createNewRequestTemplate(), mockCredentials)
+	errorRatio, writes = c.writeClient.RecentErrorRatio()
+	assert.Equal(t, 0.4, errorRatio)
+	assert.Equal(t, 5, writes)
+
+	// The outcomes older than the health window are dropped.
+	now = now.Add(healthWindow / 2)
+	errorRatio, writes = c.writeClient.RecentErrorRatio()
+	assert.Equal(t, float64(0), errorRatio)
+	assert.Equal(t, 1, writes)
+}
 
-		c := &Client{
-			queryClient:     nil,
-			defaultDataBase: mockDatabaseName,
-			defaultTable:    mockTableName,
+func TestClientLimitConcurrency(t *testing.T) {
+	var inProgress, maxInProgress int32
+	trackConcurrency := func(args mock.Arguments) {
+		current := atomic.AddInt32(&inProgress, 1)
+		for {
+			max := atomic.LoadInt32(&maxInProgress)
+			if current <= max || atomic.CompareAndSwapInt32(&maxInProgress, max, current) {
+				break
+			}
 		}
-		c.writeClient = createNewWriteClientTemplate(c)
-		c.writeClient.failOnLongMetricLabelName = true
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&inProgress, -1)
+	}
 
-		req := createNewRequestTemplate()
-		req.Timeseries[0].Labels[1].Name = mockLongMetric
-		err := c.WriteClient().Write(req, mockCredentials)
-		assert.IsType(t, &errors.LongLabelNameError{}, err)
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(trackConcurrency).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
+	mockTimestreamQueryClient := new(mockTimestreamQueryClient)
+	mockTimestreamQueryClient.On("QueryPages", mock.Anything, mock.Anything).Run(trackConcurrency).Return(nil)
+	initQueryClient = func(config *aws.Config) (timestreamqueryiface.TimestreamQueryAPI, error) {
+		return mockTimestreamQueryClient, nil
+	}
 
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
-	})
+	c := &Client{
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.LimitConcurrency(2)
+	readRequest := &prompb.ReadRequest{
+		Queries: []*prompb.Query{
+			{
+				StartTimestampMs: mockUnixTime,
+				EndTimestampMs:   mockEndUnixTime,
+				Matchers: []*prompb.LabelMatcher{
+					createLabelMatcher(prompb.LabelMatcher_EQ, model.MetricNameLabel, metricName),
+				},
+			},
+		},
+	}
 
-	t.Run("long dimension name with fail-fast disabled", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			writeClient := createNewWriteClientTemplate(c)
+			writeClient.config = &aws.Config{}
+			assert.Nil(t, writeClient.Write(createNewRequestTemplate(), mockCredentials))
+		}()
+		go func() {
+			defer wg.Done()
+			queryClient := createNewQueryClientTemplate(c)
+			queryClient.config = &aws.Config{}
+			_, err := queryClient.Read(readRequest, mockCredentials)
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
 
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
-		}
+	assert.Equal(t, int32(2), maxInProgress)
+	assert.Empty(t, c.semaphore)
+	mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 5)
+	mockTimestreamQueryClient.AssertNumberOfCalls(t, "QueryPages", 5)
+}
 
-		c := &Client{
-			queryClient:     nil,
-			defaultDataBase: mockDatabaseName,
-			defaultTable:    mockTableName,
-		}
-		c.writeClient = createNewWriteClientTemplate(c)
-		c.writeClient.failOnLongMetricLabelName = false
+func TestClientLimitRate(t *testing.T) {
+	var calls []time.Time
+	var callsMutex sync.Mutex
+	mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+	mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+		callsMutex.Lock()
+		defer callsMutex.Unlock()
+		calls = append(calls, time.Now())
+	}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+	initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+		return mockTimestreamWriteClient, nil
+	}
 
-		req := createNewRequestTemplate()
-		req.Timeseries[0].Labels[1].Name = mockLongMetric
-		err := c.WriteClient().Write(req, mockCredentials)
-		assert.Nil(t, err)
+	c := &Client{
+		defaultDataBase: mockDatabaseName,
+		defaultTable:    mockTableName,
+	}
+	c.LimitRate(50)
+	c.writeClient = createNewWriteClientTemplate(c)
+	c.writeClient.config = &aws.Config{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, c.writeClient.Write(createNewRequestTemplate(), mockCredentials))
+		}()
+	}
+	wg.Wait()
 
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
-	})
+	// At 50 calls per second, the 6 concurrent calls are spaced by 20ms, spanning 100ms less the scheduling delays.
+	assert.Len(t, calls, 6)
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Before(calls[j]) })
+	assert.GreaterOrEqual(t, calls[5].Sub(calls[0]), 80*time.Millisecond)
+}
 
-	t.Run("unknown SDK error", func(t *testing.T) {
-		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
-		unknownSDKErr := errors.NewSDKNonRequestError(goErrors.New(""))
-		mockTimestreamWriteClient.On("WriteRecords", createNewWriteRecordsInputTemplate()).Return(&timestreamwrite.WriteRecordsOutput{}, unknownSDKErr)
+func TestRequestAttempts(t *testing.T) {
+	retryer := new(mockRetryer)
+	retryer.On("ShouldRetry", 0).Return(true)
+	retryer.On("ShouldRetry", 1).Return(true)
 
-		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
-			return mockTimestreamWriteClient, nil
+	handlers := request.Handlers{}
+	handlers.Send.PushBack(func(r *request.Request) {
+		if r.RetryCount < 2 {
+			r.Error = awserr.New(timestreamwrite.ErrCodeThrottlingException, "", nil)
 		}
+	})
+	handlers.AfterRetry.PushBackNamed(corehandlers.AfterRetryHandler)
+	handlers.Complete.PushBackNamed(recordAttempts)
 
-		c := &Client{
-			queryClient:     nil,
-			defaultDataBase: mockDatabaseName,
-			defaultTable:    mockTableName,
-		}
-		c.writeClient = createNewWriteClientTemplate(c)
+	req := request.New(aws.Config{SleepDelay: func(time.Duration) {}}, metadata.ClientInfo{}, handlers, retryer,
+		&request.Operation{Name: "MockOperation"}, nil, nil)
+	assert.Nil(t, req.Send())
 
-		req := createNewRequestTemplate()
-		err := c.WriteClient().Write(req, mockCredentials)
-		assert.Equal(t, unknownSDKErr, err)
+	metric := prometheusClientModel.Metric{}
+	assert.Nil(t, requestAttempts.WithLabelValues("MockOperation").(prometheus.Histogram).Write(&metric))
+	assert.Equal(t, uint64(1), metric.GetHistogram().GetSampleCount())
+	assert.Equal(t, float64(3), metric.GetHistogram().GetSampleSum())
 
-		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
-	})
+	retryer.AssertExpectations(t)
 }
 
 // sortRecords sorts the slice of Record in the WriteRecordsInput by time, and sorts the slice of Dimension by dimension names.
@@ -1099,13 +5125,17 @@ func createNewWriteRecordsInputTemplate() *timestreamwrite.WriteRecordsInput {
 // createNewWriteClientTemplate creates a template of WriteClient pointer for unit tests.
 func createNewWriteClientTemplate(c *Client) *WriteClient {
 	return &WriteClient{
-		client:             c,
-		logger:             mockLogger,
-		ignoredSamples:     mockCounter,
-		receivedSamples:    mockCounter,
-		writeRequests:      mockCounter,
-		writeExecutionTime: mockHistogram,
-		config:             mockAwsConfigs,
+		client:                  c,
+		logger:                  mockLogger,
+		ignoredSamples:          mockCounter,
+		receivedSamples:         mockCounter,
+		clampedSamples:          mockCounter,
+		droppedDuplicateSamples: mockCounter,
+		rejectedRecords:         mockCounterVec,
+		writeRequests:           mockCounter,
+		writeExecutionTime:      mockHistogram,
+		writeBatchSize:          mockHistogram,
+		config:                  mockAwsConfigs,
 	}
 }
 
@@ -0,0 +1,156 @@
+/*
+Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with
+the License. A copy of the License is located at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+// This file publishes the metrics of the connector to Amazon CloudWatch in the embedded metric format, for deployments
+// such as AWS Lambda where no Prometheus scrapes the connector.
+package timestream
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	prometheusClientModel "github.com/prometheus/client_model/go"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CloudWatchNamespace is the CloudWatch namespace the metrics of the connector are published to.
+const CloudWatchNamespace = "PrometheusConnector"
+
+// CloudWatchPublisher writes the metrics of a collector to a writer in the CloudWatch embedded metric format, one JSON
+// line per metric and label set. CloudWatch Logs extracts the metrics from the lines written to the standard output of
+// an AWS Lambda function. Counters and the sums and counts of histograms are published as the increase since the
+// previous publication, so the Sum statistic in CloudWatch is meaningful; gauges are published as they are.
+type CloudWatchPublisher struct {
+	collector prometheus.Collector
+	writer    io.Writer
+	previous  map[string]float64
+	mutex     sync.Mutex
+}
+
+// cloudWatchMetric is a single value of a metric published to CloudWatch.
+type cloudWatchMetric struct {
+	name   string
+	unit   string
+	value  float64
+	labels []*prometheusClientModel.LabelPair
+	delta  bool
+}
+
+// NewCloudWatchPublisher creates a publisher of the metrics of the collector to the writer.
+func NewCloudWatchPublisher(collector prometheus.Collector, writer io.Writer) *CloudWatchPublisher {
+	return &CloudWatchPublisher{
+		collector: collector,
+		writer:    writer,
+		previous:  make(map[string]float64),
+	}
+}
+
+// Publish writes the current metrics of the collector to the writer, with the given timestamp in milliseconds.
+func (p *CloudWatchPublisher) Publish(timestamp int64) error {
+	// The metrics of the collector may change over time, such as when the clients are created on their first use, so
+	// a new registry is used for every publication.
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(p.collector); err != nil {
+		return err
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, family := range families {
+		for _, metric := range cloudWatchMetrics(family) {
+			value := metric.value
+			if metric.delta {
+				key := metricKey(metric.name, metric.labels)
+				increase := value - p.previous[key]
+				p.previous[key] = value
+				// A counter lower than at the previous publication has been reset.
+				if increase >= 0 {
+					value = increase
+				}
+			}
+			if err := p.write(metric, value, timestamp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// write writes a single metric value as a JSON line in the embedded metric format.
+func (p *CloudWatchPublisher) write(metric cloudWatchMetric, value float64, timestamp int64) error {
+	document := map[string]interface{}{}
+	dimensions := []string{}
+	for _, label := range metric.labels {
+		document[label.GetName()] = label.GetValue()
+		dimensions = append(dimensions, label.GetName())
+	}
+	document[metric.name] = value
+	document["_aws"] = map[string]interface{}{
+		"Timestamp": timestamp,
+		"CloudWatchMetrics": []interface{}{
+			map[string]interface{}{
+				"Namespace":  CloudWatchNamespace,
+				"Dimensions": [][]string{dimensions},
+				"Metrics":    []interface{}{map[string]string{"Name": metric.name, "Unit": metric.unit}},
+			},
+		},
+	}
+
+	line, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	_, err = p.writer.Write(append(line, '\n'))
+	return err
+}
+
+// cloudWatchMetrics converts a Prometheus metric family to the values published to CloudWatch. A histogram is published
+// as its sum and count.
+func cloudWatchMetrics(family *prometheusClientModel.MetricFamily) []cloudWatchMetric {
+	var metrics []cloudWatchMetric
+	name := family.GetName()
+	for _, metric := range family.GetMetric() {
+		labels := metric.GetLabel()
+		switch family.GetType() {
+		case prometheusClientModel.MetricType_COUNTER:
+			metrics = append(metrics, cloudWatchMetric{name: name, unit: "Count", value: metric.GetCounter().GetValue(), labels: labels, delta: true})
+		case prometheusClientModel.MetricType_GAUGE:
+			metrics = append(metrics, cloudWatchMetric{name: name, unit: "None", value: metric.GetGauge().GetValue(), labels: labels})
+		case prometheusClientModel.MetricType_HISTOGRAM:
+			sumUnit := "None"
+			if strings.HasSuffix(name, "_seconds") {
+				sumUnit = "Seconds"
+			}
+			metrics = append(metrics,
+				cloudWatchMetric{name: name + "_sum", unit: sumUnit, value: metric.GetHistogram().GetSampleSum(), labels: labels, delta: true},
+				cloudWatchMetric{name: name + "_count", unit: "Count", value: float64(metric.GetHistogram().GetSampleCount()), labels: labels, delta: true})
+		}
+	}
+	return metrics
+}
+
+// metricKey identifies a metric and its label set across publications.
+func metricKey(name string, labels []*prometheusClientModel.LabelPair) string {
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		pairs = append(pairs, label.GetName()+"="+label.GetValue())
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,95 @@
+/*
+Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with
+the License. A copy of the License is located at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+// This file contains unit tests for cloudwatch.go.
+package timestream
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const publishTimestamp = int64(1601564522000)
+
+// publishedDocuments publishes the metrics of the client and returns the published documents by metric name.
+func publishedDocuments(t *testing.T, publisher *CloudWatchPublisher, output *bytes.Buffer) map[string]map[string]interface{} {
+	output.Reset()
+	assert.Nil(t, publisher.Publish(publishTimestamp))
+
+	documents := make(map[string]map[string]interface{})
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var document map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &document))
+		metrics := document["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, CloudWatchNamespace, metrics["Namespace"])
+		name := metrics["Metrics"].([]interface{})[0].(map[string]interface{})["Name"].(string)
+		key := name
+		if reason, ok := document["reason"]; ok {
+			key += "/" + reason.(string)
+		}
+		documents[key] = document
+	}
+	return documents
+}
+
+func TestCloudWatchPublisherPublish(t *testing.T) {
+	t.Run("publish the metrics of the created clients only", func(t *testing.T) {
+		client := NewBaseClient(mockDatabaseName, mockTableName)
+		client.NewWriteClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)}, mockWriteClientOptions)
+		var output bytes.Buffer
+		publisher := NewCloudWatchPublisher(client, &output)
+
+		documents := publishedDocuments(t, publisher, &output)
+		assert.Contains(t, documents, "timestream_connector_received_samples_total")
+		assert.Contains(t, documents, "timestream_connector_write_duration_seconds_sum")
+		assert.Contains(t, documents, "timestream_connector_write_duration_seconds_count")
+		assert.NotContains(t, documents, "timestream_connector_read_requests_total")
+		assert.Equal(t, float64(publishTimestamp), documents["timestream_connector_received_samples_total"]["_aws"].(map[string]interface{})["Timestamp"])
+	})
+
+	t.Run("publish the increase of the counters since the previous publication", func(t *testing.T) {
+		client := NewBaseClient(mockDatabaseName, mockTableName)
+		client.NewWriteClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)}, mockWriteClientOptions)
+		client.NewQueryClient(mockLogger, &aws.Config{Region: aws.String(mockRegion)}, mockQueryClientOptions)
+		var output bytes.Buffer
+		publisher := NewCloudWatchPublisher(client, &output)
+
+		client.writeClient.receivedSamples.Add(10)
+		client.writeClient.rejectedRecords.WithLabelValues(duplicateRejectionReason).Add(2)
+		client.queryClient.readRequests.Inc()
+		documents := publishedDocuments(t, publisher, &output)
+		assert.Equal(t, float64(10), documents["timestream_connector_received_samples_total"]["timestream_connector_received_samples_total"])
+		assert.Equal(t, float64(1), documents["timestream_connector_read_requests_total"]["timestream_connector_read_requests_total"])
+
+		// The labels of a metric are published as the dimensions.
+		rejected := documents["timestream_connector_rejected_records_total/"+duplicateRejectionReason]
+		assert.Equal(t, float64(2), rejected["timestream_connector_rejected_records_total"])
+		dimensions := rejected["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})["Dimensions"]
+		assert.Equal(t, []interface{}{[]interface{}{"reason"}}, dimensions)
+
+		client.writeClient.receivedSamples.Add(5)
+		documents = publishedDocuments(t, publisher, &output)
+		assert.Equal(t, float64(5), documents["timestream_connector_received_samples_total"]["timestream_connector_received_samples_total"])
+		assert.Equal(t, float64(0), documents["timestream_connector_read_requests_total"]["timestream_connector_read_requests_total"])
+
+		// The counters reset to a lower value are published as they are.
+		client.ResetMetrics()
+		client.writeClient.receivedSamples.Add(3)
+		documents = publishedDocuments(t, publisher, &output)
+		assert.Equal(t, float64(3), documents["timestream_connector_received_samples_total"]["timestream_connector_received_samples_total"])
+	})
+}
@@ -0,0 +1,303 @@
+/*
+Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with
+the License. A copy of the License is located at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+// This file maintains in-memory aggregation windows for the Records ingested by the write client, and periodically
+// flushes the averaged rollup Records to a separate Amazon Timestream table.
+package timestream
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/timestreamwrite"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxRecordsPerWriteRequest = 100
+
+// rollupSourceTableDimension is the dimension of the rollup Records naming the source table of the samples, set for the
+// tables other than the default table, whose rollup Records would otherwise collide in the rollup table.
+const rollupSourceTableDimension = "source_table"
+
+// rollupWindow stores the running aggregation of a single time series within one rollup window, and the latest
+// credentials of the write requests aggregated into it, which the rollup Record is written with.
+type rollupWindow struct {
+	database    string
+	table       string
+	credentials *credentials.Credentials
+	dimensions  []*timestreamwrite.Dimension
+	measureName string
+	start       int64
+	sum         float64
+	count       int
+}
+
+type RollupClient struct {
+	client        *Client
+	config        *aws.Config
+	logger        log.Logger
+	table         string
+	windowMs      int64
+	mutex         sync.Mutex
+	windows       map[string]*rollupWindow
+	flushedUntil  int64
+	rollupRecords prometheus.Counter
+	lateSamples   prometheus.Counter
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+// NewRollupClient creates a new rollup client writing the aggregated Records to the given table.
+func (c *Client) NewRollupClient(logger log.Logger, configs *aws.Config, table string, window time.Duration) {
+	c.rollupClient = &RollupClient{
+		client:       c,
+		logger:       logger,
+		config:       configs,
+		table:        table,
+		windowMs:     window.Milliseconds(),
+		windows:      make(map[string]*rollupWindow),
+		flushedUntil: math.MinInt64,
+	}
+	c.rollupClient.createMetrics()
+}
+
+// createMetrics creates the metrics collected from the rollup client.
+func (rc *RollupClient) createMetrics() {
+	rc.rollupRecords = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "timestream_connector_rollup_records_total",
+			Help: "The total number of aggregated rollup records sent to Timestream.",
+		},
+	)
+	rc.lateSamples = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "timestream_connector_rollup_late_samples_total",
+			Help: "The total number of samples not aggregated into a rollup record because their rollup window was already flushed.",
+		},
+	)
+}
+
+// Start periodically flushes the rollup windows that have closed until Stop is called.
+func (rc *RollupClient) Start() {
+	rc.stop = make(chan struct{})
+	rc.done = make(chan struct{})
+	ticker := time.NewTicker(time.Duration(rc.windowMs) * time.Millisecond)
+
+	go func() {
+		defer close(rc.done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rc.flush(time.Now().UnixNano() / nanosToMillisConversionRate)
+			case <-rc.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic flush and flushes every remaining rollup window, including the windows still open.
+func (rc *RollupClient) Stop() error {
+	if rc.stop != nil {
+		close(rc.stop)
+		<-rc.done
+		rc.stop = nil
+	}
+	return rc.flush(math.MaxInt64)
+}
+
+// add aggregates the Records successfully ingested into the given database and table into their rollup windows. The
+// windows are kept separately for each credentials identity, so the aggregates are written with the identity of their
+// samples.
+func (rc *RollupClient) add(database string, table string, records []*timestreamwrite.Record, credentials *credentials.Credentials) {
+	identity := credentialsIdentity(credentials)
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	for _, record := range records {
+		timestamp, err := strconv.ParseInt(aws.StringValue(record.Time), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		start := timestamp - timestamp%rc.windowMs
+		for _, measure := range recordMeasures(record) {
+			value, err := strconv.ParseFloat(aws.StringValue(measure.Value), 64)
+			if err != nil {
+				continue
+			}
+			if start <= rc.flushedUntil {
+				// The window was already written, aggregating the sample would create a second record for the window.
+				rc.lateSamples.Inc()
+				continue
+			}
+			key := rollupKey(identity, database, table, aws.StringValue(measure.Name), record.Dimensions, start)
+			window, ok := rc.windows[key]
+			if !ok {
+				window = &rollupWindow{
+					database:    database,
+					table:       table,
+					dimensions:  record.Dimensions,
+					measureName: aws.StringValue(measure.Name),
+					start:       start,
+				}
+				rc.windows[key] = window
+			}
+			// The credentials of the latest write request are the least likely to have expired by the flush.
+			window.credentials = credentials
+			window.sum += value
+			window.count++
+		}
+	}
+}
+
+// flush writes the average of every rollup window ending one window or more before the cutoff in milliseconds to
+// Timestream. Each window is held for one extra window after it ends so that late samples are still aggregated, and the
+// windows failed to be written are kept for the next flush.
+func (rc *RollupClient) flush(cutoff int64) error {
+	rc.mutex.Lock()
+	closed := make(map[string][]*rollupWindow)
+	for key, window := range rc.windows {
+		if window.start > cutoff-2*rc.windowMs {
+			continue
+		}
+		destination := credentialsIdentity(window.credentials) + "\xff" + window.database
+		closed[destination] = append(closed[destination], window)
+		delete(rc.windows, key)
+	}
+	if cutoff-2*rc.windowMs > rc.flushedUntil {
+		rc.flushedUntil = cutoff - 2*rc.windowMs
+	}
+	rc.mutex.Unlock()
+
+	if len(closed) == 0 {
+		return nil
+	}
+
+	rc.client.metricsMutex.RLock()
+	defer rc.client.metricsMutex.RUnlock()
+
+	var sdkErr error
+	for _, windows := range closed {
+		// The windows of a destination share the database and the credentials identity.
+		database := windows[0].database
+		config := rc.config.Copy()
+		if windows[0].credentials != nil {
+			config.Credentials = windows[0].credentials
+		}
+		timestreamWrite, err := initWriteClient(config)
+		if err != nil {
+			LogError(rc.logger, "Unable to construct a new session for the rollup client.", err)
+			rc.restore(windows)
+			sdkErr = err
+			continue
+		}
+
+		for begin := 0; begin < len(windows); begin += maxRecordsPerWriteRequest {
+			end := begin + maxRecordsPerWriteRequest
+			if end > len(windows) {
+				end = len(windows)
+			}
+			records := make([]*timestreamwrite.Record, 0, end-begin)
+			for _, window := range windows[begin:end] {
+				records = append(records, &timestreamwrite.Record{
+					Dimensions:       rc.rollupDimensions(window),
+					MeasureName:      aws.String(window.measureName),
+					MeasureValue:     aws.String(strconv.FormatFloat(window.sum/float64(window.count), 'f', 6, 64)),
+					MeasureValueType: aws.String(timestreamwrite.MeasureValueTypeDouble),
+					Time:             aws.String(strconv.FormatInt(window.start, 10)),
+					TimeUnit:         aws.String(timestreamwrite.TimeUnitMilliseconds),
+				})
+			}
+			release := rc.client.acquire()
+			_, err = timestreamWrite.WriteRecords(&timestreamwrite.WriteRecordsInput{
+				DatabaseName: aws.String(database),
+				TableName:    aws.String(rc.table),
+				Records:      records,
+			})
+			release()
+			if err != nil {
+				LogError(rc.logger, fmt.Sprintf("Error occurred while ingesting rollup records. %d records will be retried on the next flush", end-begin), err)
+				rc.restore(windows[begin:end])
+				sdkErr = err
+				continue
+			}
+			rc.rollupRecords.Add(float64(end - begin))
+			LogDebug(rc.logger, fmt.Sprintf("Successfully wrote %d rollup records to database: %s table: %s", end-begin, database, rc.table))
+		}
+	}
+
+	return sdkErr
+}
+
+// restore puts the rollup windows failed to be written back, merging them with the windows aggregated in the meantime.
+func (rc *RollupClient) restore(windows []*rollupWindow) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	for _, window := range windows {
+		key := rollupKey(credentialsIdentity(window.credentials), window.database, window.table, window.measureName, window.dimensions, window.start)
+		if existing, ok := rc.windows[key]; ok {
+			existing.sum += window.sum
+			existing.count += window.count
+			continue
+		}
+		rc.windows[key] = window
+	}
+}
+
+// rollupDimensions returns the dimensions of the rollup Record of the window, with the source table if it is not the
+// default table.
+func (rc *RollupClient) rollupDimensions(window *rollupWindow) []*timestreamwrite.Dimension {
+	if window.table == rc.client.defaultTable {
+		return window.dimensions
+	}
+	dimensions := make([]*timestreamwrite.Dimension, 0, len(window.dimensions)+1)
+	dimensions = append(dimensions, window.dimensions...)
+	return append(dimensions, &timestreamwrite.Dimension{
+		Name:  aws.String(rollupSourceTableDimension),
+		Value: aws.String(window.table),
+	})
+}
+
+// rollupKey builds a key uniquely identifying the rollup window of a time series written with a credentials identity.
+func rollupKey(identity string, database string, table string, measureName string, dimensions []*timestreamwrite.Dimension, start int64) string {
+	pairs := make([]string, 0, len(dimensions))
+	for _, dimension := range dimensions {
+		pairs = append(pairs, aws.StringValue(dimension.Name)+"="+aws.StringValue(dimension.Value))
+	}
+	sort.Strings(pairs)
+	return fmt.Sprintf("%s\xff%s\xff%s\xff%s\xff%s\xff%d", identity, database, table, measureName, strings.Join(pairs, "\xff"), start)
+}
+
+// credentialsIdentity returns the access key ID of the credentials, or an empty string for the credentials of the
+// client configuration or the credentials failed to be retrieved.
+func credentialsIdentity(awsCredentials *credentials.Credentials) string {
+	if awsCredentials == nil {
+		return ""
+	}
+	value, err := awsCredentials.Get()
+	if err != nil {
+		return ""
+	}
+	return value.AccessKeyID
+}
@@ -0,0 +1,257 @@
+/*
+Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with
+the License. A copy of the License is located at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+// This file contains unit tests for rollup.go.
+package timestream
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/timestreamwrite"
+	"github.com/aws/aws-sdk-go/service/timestreamwrite/timestreamwriteiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	mockRollupTableName = "promRollup"
+	windowStart         = int64(1601564520000)
+)
+
+func TestRollupClientAggregation(t *testing.T) {
+	t.Run("success flushing windows closed for a full window only", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		expectedInput := &timestreamwrite.WriteRecordsInput{
+			DatabaseName: aws.String(mockDatabaseName),
+			TableName:    aws.String(mockRollupTableName),
+			Records:      []*timestreamwrite.Record{createRollupRecord("2.000000", windowStart)},
+		}
+		mockTimestreamWriteClient.On("WriteRecords", expectedInput).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := NewBaseClient(mockDatabaseName, mockTableName)
+		c.NewRollupClient(mockLogger, &aws.Config{}, mockRollupTableName, time.Minute)
+		c.rollupClient.add(mockDatabaseName, mockTableName, []*timestreamwrite.Record{
+			createSampleRecord(1, windowStart),
+			createSampleRecord(2, windowStart+20000),
+			createSampleRecord(3, windowStart+59999),
+			createSampleRecord(10, windowStart+60000),
+		}, mockCredentials)
+
+		err := c.rollupClient.flush(windowStart + 119999)
+		assert.Nil(t, err)
+		assert.Len(t, c.rollupClient.windows, 2)
+
+		err = c.rollupClient.flush(windowStart + 120000)
+		assert.Nil(t, err)
+		assert.Len(t, c.rollupClient.windows, 1)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+		mockTimestreamWriteClient.AssertExpectations(t)
+	})
+
+	t.Run("success flushing every window on stop", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On(
+			"WriteRecords",
+			mock.MatchedBy(func(writeInput *timestreamwrite.WriteRecordsInput) bool {
+				sortRecords(writeInput)
+				return reflect.DeepEqual(writeInput.Records, []*timestreamwrite.Record{
+					createRollupRecord("1.500000", windowStart),
+					createRollupRecord("10.000000", windowStart+60000),
+				})
+			})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := NewBaseClient(mockDatabaseName, mockTableName)
+		c.NewRollupClient(mockLogger, &aws.Config{}, mockRollupTableName, time.Minute)
+		c.rollupClient.Start()
+		c.rollupClient.add(mockDatabaseName, mockTableName, []*timestreamwrite.Record{
+			createSampleRecord(1, windowStart),
+			createSampleRecord(2, windowStart+30000),
+			createSampleRecord(10, windowStart+60000),
+		}, mockCredentials)
+
+		err := c.rollupClient.Stop()
+		assert.Nil(t, err)
+		assert.Empty(t, c.rollupClient.windows)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+	})
+
+	t.Run("late samples of a flushed window are dropped", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := NewBaseClient(mockDatabaseName, mockTableName)
+		c.NewRollupClient(mockLogger, &aws.Config{}, mockRollupTableName, time.Minute)
+		c.rollupClient.add(mockDatabaseName, mockTableName, []*timestreamwrite.Record{createSampleRecord(1, windowStart)}, mockCredentials)
+
+		err := c.rollupClient.flush(windowStart + 120000)
+		assert.Nil(t, err)
+
+		c.rollupClient.add(mockDatabaseName, mockTableName, []*timestreamwrite.Record{
+			createSampleRecord(2, windowStart+30000),
+			createSampleRecord(3, windowStart+60000),
+		}, mockCredentials)
+		assert.Len(t, c.rollupClient.windows, 1)
+		assert.Equal(t, 1, getCounterValue(c.rollupClient.lateSamples))
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+	})
+
+	t.Run("windows failed to be written are kept for the next flush", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, errors.New("error")).Once()
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil).Once()
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := NewBaseClient(mockDatabaseName, mockTableName)
+		c.NewRollupClient(mockLogger, &aws.Config{}, mockRollupTableName, time.Minute)
+		c.rollupClient.add(mockDatabaseName, mockTableName, []*timestreamwrite.Record{createSampleRecord(1, windowStart)}, mockCredentials)
+
+		err := c.rollupClient.flush(windowStart + 120000)
+		assert.NotNil(t, err)
+		assert.Len(t, c.rollupClient.windows, 1)
+		assert.Equal(t, 0, getCounterValue(c.rollupClient.rollupRecords))
+
+		err = c.rollupClient.flush(windowStart + 180000)
+		assert.Nil(t, err)
+		assert.Empty(t, c.rollupClient.windows)
+		assert.Equal(t, 1, getCounterValue(c.rollupClient.rollupRecords))
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 2)
+	})
+
+	t.Run("separate windows for different dimensions", func(t *testing.T) {
+		c := NewBaseClient(mockDatabaseName, mockTableName)
+		c.NewRollupClient(mockLogger, &aws.Config{}, mockRollupTableName, time.Minute)
+
+		otherRecord := createSampleRecord(5, windowStart)
+		otherRecord.Dimensions = []*timestreamwrite.Dimension{{Name: aws.String("label_1"), Value: aws.String("value_2")}}
+		c.rollupClient.add(mockDatabaseName, mockTableName, []*timestreamwrite.Record{createSampleRecord(1, windowStart), otherRecord}, mockCredentials)
+
+		assert.Len(t, c.rollupClient.windows, 2)
+	})
+
+	t.Run("separate windows written with the credentials of each identity", func(t *testing.T) {
+		writtenValues := make(map[string][]string)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			value, err := config.Credentials.Get()
+			assert.Nil(t, err)
+			mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+			mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Run(func(args mock.Arguments) {
+				for _, record := range args.Get(0).(*timestreamwrite.WriteRecordsInput).Records {
+					writtenValues[value.AccessKeyID] = append(writtenValues[value.AccessKeyID], aws.StringValue(record.MeasureValue))
+				}
+			}).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := NewBaseClient(mockDatabaseName, mockTableName)
+		c.NewRollupClient(mockLogger, &aws.Config{}, mockRollupTableName, time.Minute)
+		c.rollupClient.add(mockDatabaseName, mockTableName, []*timestreamwrite.Record{createSampleRecord(1, windowStart)}, credentials.NewStaticCredentials("tenant1", "secret1", ""))
+		c.rollupClient.add(mockDatabaseName, mockTableName, []*timestreamwrite.Record{createSampleRecord(3, windowStart)}, credentials.NewStaticCredentials("tenant2", "secret2", ""))
+		c.rollupClient.add(mockDatabaseName, mockTableName, []*timestreamwrite.Record{createSampleRecord(5, windowStart)}, credentials.NewStaticCredentials("tenant2", "secret2", ""))
+		assert.Len(t, c.rollupClient.windows, 2)
+
+		err := c.rollupClient.flush(windowStart + 120000)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string][]string{"tenant1": {"1.000000"}, "tenant2": {"4.000000"}}, writtenValues)
+	})
+
+	t.Run("separate windows for identical series of different tables", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		otherTableRecord := createRollupRecord("3.000000", windowStart)
+		otherTableRecord.Dimensions = append(append([]*timestreamwrite.Dimension{}, otherTableRecord.Dimensions...),
+			&timestreamwrite.Dimension{Name: aws.String(rollupSourceTableDimension), Value: aws.String("otherTable")})
+		mockTimestreamWriteClient.On("WriteRecords", mock.MatchedBy(func(input *timestreamwrite.WriteRecordsInput) bool {
+			return assert.ElementsMatch(t, []*timestreamwrite.Record{createRollupRecord("1.000000", windowStart), otherTableRecord}, input.Records)
+		})).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := NewBaseClient(mockDatabaseName, mockTableName)
+		c.NewRollupClient(mockLogger, &aws.Config{}, mockRollupTableName, time.Minute)
+		c.rollupClient.add(mockDatabaseName, mockTableName, []*timestreamwrite.Record{createSampleRecord(1, windowStart)}, mockCredentials)
+		c.rollupClient.add(mockDatabaseName, "otherTable", []*timestreamwrite.Record{createSampleRecord(3, windowStart)}, mockCredentials)
+		assert.Len(t, c.rollupClient.windows, 2)
+
+		err := c.rollupClient.flush(windowStart + 120000)
+		assert.Nil(t, err)
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 1)
+	})
+
+	t.Run("no write when no window is closed", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := NewBaseClient(mockDatabaseName, mockTableName)
+		c.NewRollupClient(mockLogger, &aws.Config{}, mockRollupTableName, time.Minute)
+		c.rollupClient.add(mockDatabaseName, mockTableName, []*timestreamwrite.Record{createSampleRecord(1, windowStart)}, mockCredentials)
+
+		err := c.rollupClient.flush(windowStart + 119999)
+		assert.Nil(t, err)
+
+		mockTimestreamWriteClient.AssertNumberOfCalls(t, "WriteRecords", 0)
+	})
+
+	t.Run("write client feeds successfully ingested records to the rollup client", func(t *testing.T) {
+		mockTimestreamWriteClient := new(mockTimestreamWriteClient)
+		mockTimestreamWriteClient.On("WriteRecords", mock.Anything).Return(&timestreamwrite.WriteRecordsOutput{}, nil)
+		initWriteClient = func(config *aws.Config) (timestreamwriteiface.TimestreamWriteAPI, error) {
+			return mockTimestreamWriteClient, nil
+		}
+
+		c := NewBaseClient(mockDatabaseName, mockTableName)
+		c.writeClient = createNewWriteClientTemplate(c)
+		c.NewRollupClient(mockLogger, &aws.Config{}, mockRollupTableName, time.Minute)
+
+		err := c.writeClient.Write(createNewRequestTemplate(), mockCredentials)
+		assert.Nil(t, err)
+		assert.Len(t, c.rollupClient.windows, 1)
+	})
+}
+
+// createSampleRecord creates a raw Record with the default dimensions for rollup unit tests.
+func createSampleRecord(value float64, timestamp int64) *timestreamwrite.Record {
+	record := createNewRecordTemplate()
+	record.MeasureValue = aws.String(strconv.FormatFloat(value, 'f', 6, 64))
+	record.Time = aws.String(strconv.FormatInt(timestamp, 10))
+	return record
+}
+
+// createRollupRecord creates the expected rollup Record for the default dimensions.
+func createRollupRecord(value string, timestamp int64) *timestreamwrite.Record {
+	record := createNewRecordTemplate()
+	record.MeasureValue = aws.String(value)
+	record.Time = aws.String(strconv.FormatInt(timestamp, 10))
+	return record
+}
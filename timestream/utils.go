@@ -15,6 +15,7 @@ and limitations under the License.
 package timestream
 
 import (
+	"context"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 )
@@ -30,7 +31,35 @@ func LogDebug(logger log.Logger, message string, keyvals ...interface{}) {
 	level.Debug(logger).Log(append([]interface{}{"message", message}, keyvals...)...)
 }
 
+// LogWarn logs at WARN level with the given message and any additional key-value pairs.
+func LogWarn(logger log.Logger, message string, keyvals ...interface{}) {
+	level.Warn(logger).Log(append([]interface{}{"message", message}, keyvals...)...)
+}
+
 // LogInfo logs at INFO level with the given message and any additional key-value pairs.
 func LogInfo(logger log.Logger, message string, keyvals ...interface{}) {
 	level.Info(logger).Log(append([]interface{}{"message", message}, keyvals...)...)
 }
+
+// requestIDKey is the context key of the ID of the request being served.
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of the context carrying the request ID.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request ID carried by the context, or an empty string if there is none.
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// ContextLogger returns a logger adding the request ID carried by the context to every log line, or the logger itself
+// if the context does not carry a request ID.
+func ContextLogger(ctx context.Context, logger log.Logger) log.Logger {
+	if requestID := RequestID(ctx); len(requestID) != 0 {
+		return log.With(logger, "request_id", requestID)
+	}
+	return logger
+}